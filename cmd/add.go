@@ -17,7 +17,7 @@ var addJobName string
 func init() {
 	rootCmd.AddCommand(addCmd)
 	addCmd.AddCommand(addJobCmd)
-	addJobCmd.Flags().StringVar(&addJobTemplate, "template", "", "Job template: web, mysql, or files")
+	addJobCmd.Flags().StringVar(&addJobTemplate, "template", "", "Job template: web, mysql, postgres, or files")
 	addJobCmd.Flags().StringVar(&addJobName, "name", "", "Job name (required with --template)")
 }
 
@@ -45,7 +45,7 @@ func runAddJobTemplate(cmd *cobra.Command) error {
 	}
 	job := config.JobTemplate(addJobTemplate, addJobName)
 	if job == nil {
-		return fmt.Errorf("unknown template %q (use: web, mysql, files)", addJobTemplate)
+		return fmt.Errorf("unknown template %q (use: web, mysql, postgres, files)", addJobTemplate)
 	}
 	return addJobToConfig(cmd, job)
 }
@@ -61,8 +61,8 @@ func runAddJobInteractive(cmd *cobra.Command) error {
 			return fmt.Errorf("job %q already exists", jobName)
 		}
 	}
-	fmt.Println("Available templates: web (nginx+letsencrypt), mysql, files")
-	tpl := strings.ToLower(strings.TrimSpace(prompt(reader, "Template (web/mysql/files) or Enter for custom", "web")))
+	fmt.Println("Available templates: web (nginx+letsencrypt), mysql, postgres, files")
+	tpl := strings.ToLower(strings.TrimSpace(prompt(reader, "Template (web/mysql/postgres/files) or Enter for custom", "web")))
 	if tpl == "" {
 		tpl = "web"
 	}
@@ -75,7 +75,8 @@ func runAddJobInteractive(cmd *cobra.Command) error {
 				Include: strings.Split(strings.TrimSpace(prompt(reader, "Paths to include (comma-separated)", "/var/backup")), ","),
 			},
 			Schedule:  &config.ScheduleConfig{Period: "day", Times: 1, JitterMinutes: 15},
-			Retention: &config.RetentionConfig{Days: 7},
+			Retention: &config.RetentionConfig{KeepDaily: 7},
+			Chunking:  config.DefaultChunking(),
 		}
 		for i, p := range job.Paths.Include {
 			job.Paths.Include[i] = strings.TrimSpace(p)
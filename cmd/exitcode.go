@@ -0,0 +1,87 @@
+package cmd
+
+import (
+	"errors"
+
+	"VelBackuper/internal/collector"
+	"VelBackuper/internal/engine"
+	"VelBackuper/internal/hook"
+	"VelBackuper/internal/lock"
+	"VelBackuper/internal/restore"
+	"VelBackuper/internal/s3"
+)
+
+// Exit codes documented in docs/exit-codes.md.
+const (
+	ExitOK         = 0
+	ExitConfig     = 1
+	ExitS3         = 2
+	ExitMySQL      = 3 // any database dump: MySQL, PostgreSQL or a dump command
+	ExitFilesystem = 4
+	ExitLock       = 5
+	ExitRestore    = 6
+	ExitPrune      = 7
+	ExitVerify     = 8
+	ExitHook       = 9
+)
+
+// exitError attaches a process exit code to an error returned by a command.
+type exitError struct {
+	code int
+	err  error
+}
+
+func (e *exitError) Error() string {
+	return e.err.Error()
+}
+
+func (e *exitError) Unwrap() error {
+	return e.err
+}
+
+func withExitCode(code int, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &exitError{code: code, err: err}
+}
+
+// exitCode returns the exit code for err. A code set with withExitCode wins; otherwise the
+// typed errors of the internal packages are looked for in the chain, the operation (lock,
+// prune, restore, hook) before the source (MySQL, filesystem) before S3, since a failed prune or
+// restore usually wraps the S3 error that caused it. Other errors map to ExitConfig.
+func exitCode(err error) int {
+	if err == nil {
+		return ExitOK
+	}
+	var (
+		ee *exitError
+		le *lock.Error
+		ge *engine.Error
+		re *restore.Error
+		he *hook.Error
+		ce *collector.Error
+		se *s3.Error
+	)
+	switch {
+	case errors.As(err, &ee):
+		return ee.code
+	case errors.As(err, &le):
+		return ExitLock
+	case errors.As(err, &ge) && ge.Op == engine.OpPrune:
+		return ExitPrune
+	case errors.As(err, &re):
+		return ExitRestore
+	case errors.As(err, &he):
+		return ExitHook
+	case errors.As(err, &ce):
+		switch ce.Source {
+		case collector.CollectorMySQL, collector.CollectorPostgres, collector.CollectorCommands:
+			return ExitMySQL
+		}
+		return ExitFilesystem
+	case errors.As(err, &se):
+		return ExitS3
+	}
+	return ExitConfig
+}
@@ -0,0 +1,54 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"VelBackuper/internal/collector"
+	"VelBackuper/internal/engine"
+	"VelBackuper/internal/hook"
+	"VelBackuper/internal/lock"
+	"VelBackuper/internal/restore"
+	"VelBackuper/internal/s3"
+)
+
+func TestExitCode(t *testing.T) {
+	cause := errors.New("boom")
+	s3Err := &s3.Error{Op: "PutObject", Key: "archives/web/x.tar.zst", Err: cause}
+
+	tests := []struct {
+		name string
+		err  error
+		want int
+	}{
+		{"nil", nil, ExitOK},
+		{"untyped", cause, ExitConfig},
+		{"s3", fmt.Errorf("write manifest: %w", s3Err), ExitS3},
+		{"mysql", &collector.Error{Source: collector.CollectorMySQL, Err: cause}, ExitMySQL},
+		{"postgres", &collector.Error{Source: collector.CollectorPostgres, Err: cause}, ExitMySQL},
+		{"commands", &collector.Error{Source: collector.CollectorCommands, Err: cause}, ExitMySQL},
+		{"filesystem", &collector.Error{Source: collector.CollectorFilesystem, Err: cause}, ExitFilesystem},
+		{"presets", &collector.Error{Source: collector.CollectorPresets, Err: cause}, ExitFilesystem},
+		{"lock", fmt.Errorf("job web: %w", &lock.Error{Lock: "web.lock", Err: cause}), ExitLock},
+		{"restore", &restore.Error{Job: "web", Point: "20240101000000", Err: s3Err}, ExitRestore},
+		{"prune", &engine.Error{Op: engine.OpPrune, Job: "web", Err: s3Err}, ExitPrune},
+		{"hook", fmt.Errorf("job web: %w", &hook.Error{Phase: hook.PhasePre, Hook: "flush", Err: cause}), ExitHook},
+		{"explicit", withExitCode(ExitVerify, s3Err), ExitVerify},
+
+		// A backup is classified by its cause: the source that failed or S3.
+		{"backup of mysql", &engine.Error{Op: engine.OpBackup, Job: "web", Err: fmt.Errorf("upload archive: read part: %w",
+			&collector.Error{Source: collector.CollectorMySQL, Err: cause})}, ExitMySQL},
+		{"backup to s3", &engine.Error{Op: engine.OpBackup, Job: "web", Err: s3Err}, ExitS3},
+		// A lock that fails on S3 is a lock error, and so is a prune that cannot get its lock.
+		{"lock on s3", &lock.Error{Lock: "locks/web.lock", Err: s3Err}, ExitLock},
+		{"prune locked", &engine.Error{Op: engine.OpPrune, Job: "web", Err: &lock.Error{Err: cause}}, ExitLock},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := exitCode(tt.err); got != tt.want {
+				t.Errorf("exitCode(%v) = %d, want %d", tt.err, got, tt.want)
+			}
+		})
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"context"
 	"fmt"
 	"os"
+	"os/exec"
 	"path/filepath"
 	"runtime"
 	"strings"
@@ -106,6 +107,7 @@ func runInit(cmd *cobra.Command, args []string) error {
 	hasApache := pathExists("/etc/apache2") || pathExists("/etc/httpd")
 	hasLetsEncrypt := pathExists("/etc/letsencrypt")
 	hasMySQL := mysqlAvailable()
+	hasPostgres := postgresAvailable()
 
 	if hasNginx || hasApache || hasLetsEncrypt {
 		presets := &config.PresetsConfig{}
@@ -125,7 +127,8 @@ func runInit(cmd *cobra.Command, args []string) error {
 				Enabled:   true,
 				Presets:   presets,
 				Schedule:  &config.ScheduleConfig{Period: "day", Times: 2, JitterMinutes: 15},
-				Retention: &config.RetentionConfig{Days: 7},
+				Retention: &config.RetentionConfig{KeepDaily: 7},
+				Chunking:  config.DefaultChunking(),
 			})
 		}
 	}
@@ -135,6 +138,11 @@ func runInit(cmd *cobra.Command, args []string) error {
 		jobs = append(jobs, *config.JobTemplate("mysql", jobName))
 	}
 
+	if hasPostgres && confirm(reader, "Add PostgreSQL backup job?", true) {
+		jobName := prompt(reader, "PostgreSQL job name", "postgres")
+		jobs = append(jobs, *config.JobTemplate("postgres", jobName))
+	}
+
 	if len(jobs) == 0 || confirm(reader, "Add a custom files job?", false) {
 		jobName := prompt(reader, "Files job name", "files")
 		pathsStr := prompt(reader, "Paths to include (comma-separated)", "/var/backup")
@@ -152,7 +160,8 @@ func runInit(cmd *cobra.Command, args []string) error {
 			Enabled:   true,
 			Paths:     &config.PathsConfig{Include: include},
 			Schedule:  &config.ScheduleConfig{Period: "day", Times: 1, JitterMinutes: 15},
-			Retention: &config.RetentionConfig{Days: 7},
+			Retention: &config.RetentionConfig{KeepDaily: 7},
+			Chunking:  config.DefaultChunking(),
 		})
 	}
 
@@ -222,6 +231,19 @@ func mysqlAvailable() bool {
 	return false
 }
 
+func postgresAvailable() bool {
+	if runtime.GOOS != "linux" {
+		return false
+	}
+	if _, err := exec.LookPath("pg_dump"); err == nil {
+		return true
+	}
+	if _, err := os.Stat("/var/run/postgresql"); err == nil {
+		return true
+	}
+	return false
+}
+
 func confirm(reader *bufio.Reader, msg string, defaultYes bool) bool {
 	def := "y"
 	if !defaultYes {
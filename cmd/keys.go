@@ -0,0 +1,23 @@
+package cmd
+
+import (
+	"context"
+
+	"VelBackuper/internal/config"
+	"VelBackuper/internal/crypt"
+	incrEngine "VelBackuper/internal/engine/incremental"
+	"VelBackuper/internal/s3"
+)
+
+// keyringFromConfig builds the Keyring of cfg. In incremental mode its keys are derived from
+// the repository salt, which create writes if the repository has none yet (backups only).
+func keyringFromConfig(ctx context.Context, cfg *config.Config, client *s3.Client, create bool) (*crypt.Keyring, error) {
+	keys, err := crypt.New(cfg.Encryption)
+	if err != nil || cfg.Mode != config.ModeIncremental {
+		return keys, err
+	}
+	if err := incrEngine.UnlockKeyring(ctx, client, keys, create); err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
@@ -8,6 +8,8 @@ import (
 	"time"
 
 	"VelBackuper/internal/config"
+	archiveEngine "VelBackuper/internal/engine/archive"
+	"VelBackuper/internal/notifier"
 	"VelBackuper/internal/s3"
 
 	"github.com/spf13/cobra"
@@ -47,15 +49,16 @@ func runList(cmd *cobra.Command, args []string) error {
 	defer cancel()
 
 	client, err := s3.New(ctx, s3.Options{
-		Endpoint:                cfg.S3.Endpoint,
-		Region:                  cfg.S3.Region,
-		AccessKey:               cfg.S3.AccessKey,
-		SecretKey:               cfg.S3.SecretKey,
-		Bucket:                  cfg.S3.Bucket,
-		Prefix:                  cfg.S3.Prefix,
-		PathStyle:               config.S3PathStyle(cfg.S3),
-		DisableRequestChecksums: config.S3DisableRequestChecksums(cfg.S3),
-		InsecureSkipVerify:      cfg.S3.TLS != nil && cfg.S3.TLS.InsecureSkipVerify,
+		Endpoint:                 cfg.S3.Endpoint,
+		Region:                   cfg.S3.Region,
+		AccessKey:                cfg.S3.AccessKey,
+		SecretKey:                cfg.S3.SecretKey,
+		Bucket:                   cfg.S3.Bucket,
+		Prefix:                   cfg.S3.Prefix,
+		PathStyle:                config.S3PathStyle(cfg.S3),
+		DisableRequestChecksums:  config.S3DisableRequestChecksums(cfg.S3),
+		DisableConditionalWrites: !config.S3ConditionalWrites(cfg.S3),
+		InsecureSkipVerify:       cfg.S3.TLS != nil && cfg.S3.TLS.InsecureSkipVerify,
 	})
 	if err != nil {
 		return err
@@ -85,20 +88,26 @@ func runList(cmd *cobra.Command, args []string) error {
 				continue
 			}
 			var timestamps []string
+			manifestKeys := make(map[string]string)
 			for _, k := range keys {
 				if strings.HasSuffix(k, ".json") {
 					ts := strings.TrimSuffix(k[strings.LastIndex(k, "/")+1:], ".json")
 					if len(ts) == 14 {
 						timestamps = append(timestamps, ts)
+						manifestKeys[ts] = k
 					}
 				}
 			}
 			sort.Sort(sort.Reverse(sort.StringSlice(timestamps)))
 			for _, ts := range timestamps {
+				summary := ""
+				if m, err := archiveEngine.ReadManifestByKey(ctx, client, manifestKeys[ts]); err == nil {
+					summary = manifestSummary(m)
+				}
 				if t, err := time.Parse("20060102150405", ts); err == nil {
-					cmd.Printf("  %s  %s\n", ts, t.Format("2006-01-02 15:04:05"))
+					cmd.Printf("  %s  %s%s\n", ts, t.Format("2006-01-02 15:04:05"), summary)
 				} else {
-					cmd.Printf("  %s\n", ts)
+					cmd.Printf("  %s%s\n", ts, summary)
 				}
 			}
 			if len(timestamps) == 0 {
@@ -135,3 +144,30 @@ func runList(cmd *cobra.Command, args []string) error {
 	}
 	return nil
 }
+
+// manifestSummary formats a manifest's size, file count and format for list output.
+// Manifests written before sizes were recorded show only their format.
+func manifestSummary(m *archiveEngine.Manifest) string {
+	var parts []string
+	if m.Size > 0 {
+		size := notifier.FormatBytes(m.Size)
+		if m.UncompressedSize > 0 {
+			size += " (" + notifier.FormatBytes(m.UncompressedSize) + " uncompressed)"
+		}
+		parts = append(parts, size)
+	}
+	if m.FileCount > 0 {
+		parts = append(parts, fmt.Sprintf("%d files", m.FileCount))
+	}
+	if m.Format != "" {
+		format := m.Format
+		if m.Encrypted {
+			format += ", encrypted"
+		}
+		parts = append(parts, format)
+	}
+	if len(parts) == 0 {
+		return ""
+	}
+	return "  " + strings.Join(parts, "  ")
+}
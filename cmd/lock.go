@@ -0,0 +1,260 @@
+package cmd
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"VelBackuper/internal/config"
+	"VelBackuper/internal/lock"
+	"VelBackuper/internal/s3"
+
+	"github.com/spf13/cobra"
+)
+
+var lockJob string
+var lockRepository bool
+var lockForce bool
+
+func init() {
+	rootCmd.AddCommand(lockCmd)
+	lockCmd.AddCommand(lockListCmd, lockShowCmd, lockBreakCmd)
+	for _, c := range []*cobra.Command{lockShowCmd, lockBreakCmd} {
+		c.Flags().StringVar(&lockJob, "job", "", "Job whose lock to inspect")
+		c.Flags().BoolVar(&lockRepository, "repository", false, "Use the repository-wide lock held by garbage collection")
+	}
+	lockBreakCmd.Flags().BoolVar(&lockForce, "force", false, "Break the lock without asking for confirmation")
+}
+
+var lockCmd = &cobra.Command{
+	Use:   "lock",
+	Short: "Inspect and break job locks",
+	Long: `Inspect and break the locks that keep runs of a job apart.
+
+Each run holds an S3 lock (locks/<job>.lock, incremental mode) and a local flock on
+<lock dir>/<job>.lock. prune holds the repository lock while it deletes chunks. A run that
+dies leaves its S3 lock behind until it goes stale after 30 minutes; "lock break" removes it
+sooner. Local locks are released by the kernel when the process exits.`,
+}
+
+var lockListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List held locks with their owner and age",
+	RunE:  runLockList,
+}
+
+var lockShowCmd = &cobra.Command{
+	Use:   "show",
+	Short: "Show the locks of one job",
+	RunE:  runLockShow,
+}
+
+var lockBreakCmd = &cobra.Command{
+	Use:   "break",
+	Short: "Remove the S3 lock of a job after a crashed run",
+	RunE:  runLockBreak,
+}
+
+func runLockList(cmd *cobra.Command, args []string) error {
+	ctx := context.Background()
+	cfg, client, err := loadLockConfig(ctx)
+	if err != nil {
+		return err
+	}
+
+	tw := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "NAME\tSCOPE\tSTATE\tAGE\tOWNER")
+	if client != nil {
+		locks, err := lock.ListS3Locks(ctx, client)
+		if err != nil {
+			return err
+		}
+		for i := range locks {
+			l := &locks[i]
+			fmt.Fprintf(tw, "%s\ts3\t%s\t%s\t%s\n", l.Name, s3LockState(l), l.Age().Round(time.Second), describeS3Owner(l))
+		}
+	}
+	for _, j := range cfg.Jobs {
+		st, err := lock.InspectLocal("", j.Name)
+		if err != nil {
+			fmt.Fprintf(tw, "%s\tlocal\tunknown\t-\t%v\n", j.Name, err)
+			continue
+		}
+		if !st.Running {
+			continue
+		}
+		age, owner := "-", "unknown"
+		if st.Owner != nil {
+			age = time.Since(st.Owner.Started).Round(time.Second).String()
+			owner = st.Owner.String()
+		}
+		fmt.Fprintf(tw, "%s\tlocal\trunning\t%s\t%s\n", j.Name, age, owner)
+	}
+	return tw.Flush()
+}
+
+func runLockShow(cmd *cobra.Command, args []string) error {
+	ctx := context.Background()
+	if err := checkLockTarget(); err != nil {
+		return err
+	}
+	_, client, err := loadLockConfig(ctx)
+	if err != nil {
+		return err
+	}
+
+	if client != nil {
+		info, err := lock.InspectS3Lock(ctx, client, lockJob)
+		if err != nil {
+			return err
+		}
+		if info == nil {
+			cmd.Println("S3 lock: not held")
+		} else {
+			printS3Lock(cmd, info)
+		}
+	}
+	if lockJob != "" {
+		st, err := lock.InspectLocal("", lockJob)
+		switch {
+		case err != nil:
+			cmd.Printf("Local lock: unknown (%v)\n", err)
+		case !st.Running:
+			cmd.Println("Local lock: not held")
+		case st.Owner != nil:
+			cmd.Printf("Local lock: held by %s\n", st.Owner)
+		default:
+			cmd.Println("Local lock: held")
+		}
+	}
+	return nil
+}
+
+func runLockBreak(cmd *cobra.Command, args []string) error {
+	ctx := context.Background()
+	if err := checkLockTarget(); err != nil {
+		return err
+	}
+	cfg, client, err := loadLockConfig(ctx)
+	if err != nil {
+		return err
+	}
+	if client == nil {
+		return fmt.Errorf("s3 configuration is required")
+	}
+
+	info, err := lock.InspectS3Lock(ctx, client, lockJob)
+	if err != nil {
+		return err
+	}
+	if info == nil {
+		cmd.Println("S3 lock is not held; nothing to break")
+		return nil
+	}
+	printS3Lock(cmd, info)
+	if lockJob != "" {
+		if st, err := lock.InspectLocal("", lockJob); err == nil && st.Running {
+			cmd.Println("Warning: a run of this job is still in progress on this host")
+		}
+	}
+	if !lockForce && !confirm(bufio.NewReader(os.Stdin), "Break this lock?", false) {
+		cmd.Println("Aborted")
+		return nil
+	}
+
+	if err := lock.BreakS3Lock(ctx, client, info); err != nil {
+		return err
+	}
+	cmd.Printf("Broke lock %s\n", info.Key)
+
+	if notif := NotifierFromConfig(cfg, func(msg string) { cmd.PrintErrln("Warning:", msg) }); notif != nil {
+		host, _ := os.Hostname()
+		msg := fmt.Sprintf("Lock %s (%s) was broken manually on %s", info.Key, describeS3Owner(info), host)
+		_ = notif.NotifyWarning(ctx, info.Name, "", msg)
+	}
+	return nil
+}
+
+// checkLockTarget validates that exactly one of --job and --repository was given.
+func checkLockTarget() error {
+	if (lockJob == "") == !lockRepository {
+		return fmt.Errorf("specify either --job <name> or --repository")
+	}
+	return nil
+}
+
+// loadLockConfig loads the config and, if S3 is configured, an S3 client.
+func loadLockConfig(ctx context.Context) (*config.Config, *s3.Client, error) {
+	v, err := config.Load(false)
+	if err != nil {
+		return nil, nil, err
+	}
+	cfg, err := config.Unmarshal(v)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := config.Validate(cfg); err != nil {
+		return nil, nil, err
+	}
+	if lockJob != "" {
+		var found bool
+		for _, j := range cfg.Jobs {
+			if j.Name == lockJob {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, nil, fmt.Errorf("job %q not found", lockJob)
+		}
+	}
+	if cfg.S3 == nil {
+		return cfg, nil, nil
+	}
+	client, err := s3.New(ctx, s3.Options{
+		Endpoint:                 cfg.S3.Endpoint,
+		Region:                   cfg.S3.Region,
+		AccessKey:                cfg.S3.AccessKey,
+		SecretKey:                cfg.S3.SecretKey,
+		Bucket:                   cfg.S3.Bucket,
+		Prefix:                   cfg.S3.Prefix,
+		PathStyle:                config.S3PathStyle(cfg.S3),
+		DisableRequestChecksums:  config.S3DisableRequestChecksums(cfg.S3),
+		DisableConditionalWrites: !config.S3ConditionalWrites(cfg.S3),
+		InsecureSkipVerify:       cfg.S3.TLS != nil && cfg.S3.TLS.InsecureSkipVerify,
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return cfg, client, nil
+}
+
+func printS3Lock(cmd *cobra.Command, info *lock.S3LockInfo) {
+	cmd.Printf("S3 lock: %s (%s)\n", info.Key, s3LockState(info))
+	if o := info.Owner; o != nil {
+		cmd.Printf("  Host:      %s\n", o.Host)
+		cmd.Printf("  PID:       %d\n", o.PID)
+		cmd.Printf("  Run ID:    %s\n", o.RunID)
+		cmd.Printf("  Started:   %s\n", o.Started.Local().Format(time.RFC3339))
+	} else {
+		cmd.Println("  Owner:     unknown (written by an older version)")
+	}
+	cmd.Printf("  Last write: %s (%s ago)\n", info.Modified.Local().Format(time.RFC3339), info.Age().Round(time.Second))
+}
+
+func s3LockState(info *lock.S3LockInfo) string {
+	if info.Stale(s3LockTTL) {
+		return "stale"
+	}
+	return "held"
+}
+
+func describeS3Owner(info *lock.S3LockInfo) string {
+	if info.Owner == nil {
+		return "unknown"
+	}
+	return info.Owner.String()
+}
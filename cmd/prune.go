@@ -2,12 +2,16 @@ package cmd
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
+	"strings"
+	"text/tabwriter"
 	"time"
 
 	"VelBackuper/internal/config"
 	archiveEngine "VelBackuper/internal/engine/archive"
 	incrEngine "VelBackuper/internal/engine/incremental"
+	"VelBackuper/internal/notifier"
 	"VelBackuper/internal/s3"
 
 	"github.com/spf13/cobra"
@@ -16,12 +20,16 @@ import (
 var pruneJob string
 var pruneAll bool
 var pruneDryRun bool
+var pruneJSON bool
+var pruneGracePeriod time.Duration
 
 func init() {
 	rootCmd.AddCommand(pruneCmd)
 	pruneCmd.Flags().StringVar(&pruneJob, "job", "", "Prune only this job by name")
 	pruneCmd.Flags().BoolVar(&pruneAll, "all", false, "Prune all enabled jobs")
 	pruneCmd.Flags().BoolVar(&pruneDryRun, "dry-run", false, "Show what would be pruned without deleting")
+	pruneCmd.Flags().BoolVar(&pruneJSON, "json", false, "Print the plan as JSON instead of a table")
+	pruneCmd.Flags().DurationVar(&pruneGracePeriod, "grace-period", incrEngine.DefaultGCGracePeriod, "Never delete unreferenced chunks younger than this (incremental mode)")
 }
 
 var pruneCmd = &cobra.Command{
@@ -50,22 +58,27 @@ func runPrune(cmd *cobra.Command, args []string) error {
 	}
 
 	s3Client, err := s3.New(ctx, s3.Options{
-		Endpoint:                cfg.S3.Endpoint,
-		Region:                  cfg.S3.Region,
-		AccessKey:               cfg.S3.AccessKey,
-		SecretKey:               cfg.S3.SecretKey,
-		Bucket:                  cfg.S3.Bucket,
-		Prefix:                  cfg.S3.Prefix,
-		PathStyle:               config.S3PathStyle(cfg.S3),
-		DisableRequestChecksums: config.S3DisableRequestChecksums(cfg.S3),
-		InsecureSkipVerify:      cfg.S3.TLS != nil && cfg.S3.TLS.InsecureSkipVerify,
+		Endpoint:                 cfg.S3.Endpoint,
+		Region:                   cfg.S3.Region,
+		AccessKey:                cfg.S3.AccessKey,
+		SecretKey:                cfg.S3.SecretKey,
+		Bucket:                   cfg.S3.Bucket,
+		Prefix:                   cfg.S3.Prefix,
+		PathStyle:                config.S3PathStyle(cfg.S3),
+		DisableRequestChecksums:  config.S3DisableRequestChecksums(cfg.S3),
+		DisableConditionalWrites: !config.S3ConditionalWrites(cfg.S3),
+		InsecureSkipVerify:       cfg.S3.TLS != nil && cfg.S3.TLS.InsecureSkipVerify,
 	})
 	if err != nil {
 		return err
 	}
 
+	keys, err := keyringFromConfig(ctx, cfg, s3Client, false)
+	if err != nil {
+		return err
+	}
+
 	notif := NotifierFromConfig(cfg, func(msg string) { cmd.PrintErrln("Warning:", msg) })
-	now := time.Now().UTC()
 
 	var jobs []config.JobConfig
 	if pruneAll {
@@ -88,42 +101,172 @@ func runPrune(cmd *cobra.Command, args []string) error {
 		return fmt.Errorf("either --job or --all must be specified")
 	}
 
-	for _, job := range jobs {
-		if job.Retention == nil {
-			continue
-		}
-		switch cfg.Mode {
-		case config.ModeArchive:
-			if pruneDryRun {
-				cmd.Printf("Would apply archive retention for job %s\n", job.Name)
+	var plans []prunePlan
+	switch cfg.Mode {
+	case config.ModeArchive:
+		for _, job := range jobs {
+			if job.Retention == nil {
 				continue
 			}
-			deleted, err := archiveEngine.ApplyRetention(ctx, s3Client, job.Name, job.Retention, now)
+			res, err := archiveEngine.ApplyRetention(ctx, s3Client, job.Name, job.Retention, archiveEngine.RetentionOptions{DryRun: pruneDryRun})
 			if err != nil {
-				return fmt.Errorf("archive prune for job %s: %w", job.Name, err)
+				return err
 			}
-			cmd.Printf("Pruned %d archive backups for job %s\n", deleted, job.Name)
-			if notif != nil && deleted > 0 {
-				_ = notif.NotifyPrune(ctx, job.Name, 0, deleted)
+			plan := prunePlan{Job: job.Name, DryRun: pruneDryRun}
+			plan.addDecisions(res.Decisions)
+			plan.addObjects("manifest", res.Manifests)
+			plan.addObjects("archive", res.Archives)
+			plan.BytesReclaimed = res.BytesReclaimed
+			if !pruneDryRun && notif != nil && res.Deleted > 0 {
+				_ = notif.NotifyPrune(ctx, job.Name, len(res.Decisions)-res.Deleted, res.Deleted)
 			}
-		case config.ModeIncremental:
-			if pruneDryRun {
-				cmd.Printf("Would prune incremental snapshots/objects for job %s\n", job.Name)
-				continue
-			}
-			res, err := incrEngine.Prune(ctx, s3Client, job.Name, job.Retention, now, incrEngine.DefaultHashPrefixLen)
-			if err != nil {
-				return fmt.Errorf("incremental prune for job %s: %w", job.Name, err)
+			plans = append(plans, plan)
+		}
+	case config.ModeIncremental:
+		// Retention of every job is applied first and the shared object store is swept once,
+		// so chunks freed by any of the jobs are found and orphans are counted once.
+		var pruneJobs []incrEngine.PruneJob
+		for _, job := range jobs {
+			if job.Retention != nil {
+				pruneJobs = append(pruneJobs, incrEngine.PruneJob{Name: job.Name, Retention: job.Retention})
 			}
-			deleted := res.DeletedSnapshots + res.DeletedIndexes + res.DeletedObjects
-			cmd.Printf("Pruned job %s: %d snapshots, %d indexes, %d objects\n", job.Name, res.DeletedSnapshots, res.DeletedIndexes, res.DeletedObjects)
-			if notif != nil && deleted > 0 {
-				_ = notif.NotifyPrune(ctx, job.Name, 0, deleted)
+		}
+		if len(pruneJobs) == 0 {
+			break
+		}
+		res, err := incrEngine.PruneAllWithS3Lock(ctx, s3Client, pruneJobs, incrEngine.PruneOptions{
+			HashPrefixLen: incrEngine.DefaultHashPrefixLen,
+			Keyring:       keys,
+			DryRun:        pruneDryRun,
+			GracePeriod:   pruneGracePeriod,
+			Progress:      gcProgressPrinter(cmd),
+		}, s3LockTTL)
+		if err != nil {
+			return err
+		}
+		for _, jr := range res.Jobs {
+			plan := prunePlan{Job: jr.Job, DryRun: pruneDryRun}
+			plan.addDecisions(jr.Decisions)
+			plan.addObjects("snapshot", jr.Snapshots)
+			plan.addObjects("index", jr.Indexes)
+			plan.BytesReclaimed = jr.BytesReclaimed
+			deleted := jr.DeletedSnapshots + jr.DeletedIndexes
+			if !pruneDryRun && notif != nil && deleted > 0 {
+				_ = notif.NotifyPrune(ctx, jr.Job, len(jr.Decisions)-jr.DeletedSnapshots, deleted)
 			}
-		default:
-			return config.ErrInvalidMode
+			plans = append(plans, plan)
+		}
+		sweep := prunePlan{Repository: true, DryRun: pruneDryRun, BytesReclaimed: res.Sweep.BytesReclaimed, RecentChunks: res.Sweep.RecentObjects}
+		sweep.addObjects("chunk", res.Sweep.Objects)
+		if !pruneDryRun && notif != nil && res.Sweep.DeletedObjects > 0 {
+			_ = notif.NotifyPrune(ctx, repositoryPlanName, 0, res.Sweep.DeletedObjects)
+		}
+		plans = append(plans, sweep)
+	default:
+		return config.ErrInvalidMode
+	}
+	if !pruneJSON {
+		for _, plan := range plans {
+			printPrunePlan(cmd, plan)
 		}
 	}
 
+	if pruneJSON {
+		enc := json.NewEncoder(cmd.OutOrStdout())
+		enc.SetIndent("", "  ")
+		return enc.Encode(plans)
+	}
 	return nil
 }
+
+// repositoryPlanName names the orphan chunk sweep in output and notifications.
+const repositoryPlanName = "repository"
+
+// prunePlan is what prune deleted for one job or, with --dry-run, would delete. In
+// incremental mode the chunks no snapshot references any more are swept once for all jobs
+// and reported in a plan of their own, with Repository set and no Job.
+type prunePlan struct {
+	Job            string          `json:"job,omitempty"`
+	Repository     bool            `json:"repository,omitempty"`
+	DryRun         bool            `json:"dry_run"`
+	Decisions      []pruneDecision `json:"decisions"`
+	Objects        []prunedObject  `json:"objects"`
+	BytesReclaimed int64           `json:"bytes_reclaimed"`
+	// RecentChunks counts unreferenced chunks kept because they are within the grace period.
+	RecentChunks int `json:"recent_chunks,omitempty"`
+}
+
+type pruneDecision struct {
+	Point  string `json:"point"`
+	Keep   bool   `json:"keep"`
+	Reason string `json:"reason"`
+}
+
+type prunedObject struct {
+	Kind string `json:"kind"`
+	Key  string `json:"key"`
+	Size int64  `json:"size"`
+}
+
+func (p *prunePlan) addDecisions(decisions []config.RetentionDecision) {
+	for _, d := range decisions {
+		p.Decisions = append(p.Decisions, pruneDecision{Point: d.Time.Format("20060102150405"), Keep: d.Keep, Reason: d.Reason()})
+	}
+}
+
+func (p *prunePlan) addObjects(kind string, objects []s3.ObjectInfo) {
+	for _, o := range objects {
+		p.Objects = append(p.Objects, prunedObject{Kind: kind, Key: o.Key, Size: o.Size})
+	}
+}
+
+// gcProgressPrinter reports GC progress on stderr when a phase starts and then at most every
+// few seconds, so it does not mix with a JSON plan on stdout.
+func gcProgressPrinter(cmd *cobra.Command) func(incrEngine.GCProgress) {
+	var phase string
+	var last time.Time
+	return func(p incrEngine.GCProgress) {
+		if p.Phase == phase && time.Since(last) < 5*time.Second {
+			return
+		}
+		phase, last = p.Phase, time.Now()
+		switch p.Phase {
+		case incrEngine.GCPhaseMark:
+			cmd.PrintErrf("  Marking live chunks: %d/%d snapshots\n", p.Marked, p.MarkTotal)
+		case incrEngine.GCPhaseSweep:
+			cmd.PrintErrf("  Sweeping: %d chunks scanned, %d deleted\n", p.Scanned, p.Deleted)
+		}
+	}
+}
+
+// printPrunePlan prints why each backup point is kept or dropped, then the objects deleted.
+func printPrunePlan(cmd *cobra.Command, p prunePlan) {
+	verb := "Deleted"
+	if p.DryRun {
+		verb = "Would delete"
+	}
+	if p.Repository {
+		cmd.Printf("Repository: %s %d orphan chunks, %s\n", strings.ToLower(verb), len(p.Objects), notifier.FormatBytes(p.BytesReclaimed))
+	} else {
+		cmd.Printf("Job %s: %s %d objects, %s\n", p.Job, strings.ToLower(verb), len(p.Objects), notifier.FormatBytes(p.BytesReclaimed))
+	}
+	for _, d := range p.Decisions {
+		verdict := "drop"
+		if d.Keep {
+			verdict = "keep"
+		}
+		cmd.Printf("  %s  %s  %s\n", verdict, d.Point, d.Reason)
+	}
+	if p.RecentChunks > 0 {
+		cmd.Printf("  %d unreferenced chunks are within the grace period and kept\n", p.RecentChunks)
+	}
+	if len(p.Objects) == 0 {
+		return
+	}
+	tw := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "  KIND\tSIZE\tKEY")
+	for _, o := range p.Objects {
+		fmt.Fprintf(tw, "  %s\t%s\t%s\n", o.Kind, notifier.FormatBytes(o.Size), o.Key)
+	}
+	_ = tw.Flush()
+}
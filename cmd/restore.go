@@ -1,26 +1,172 @@
 package cmd
 
 import (
+	"context"
+	"fmt"
+
+	"VelBackuper/internal/config"
+	"VelBackuper/internal/crypt"
+	archiveEngine "VelBackuper/internal/engine/archive"
+	incrEngine "VelBackuper/internal/engine/incremental"
+	"VelBackuper/internal/restore"
+	"VelBackuper/internal/s3"
+
 	"github.com/spf13/cobra"
 )
 
 var restoreJob string
 var restorePoint string
 var restoreTarget string
+var restoreMysqlOnly bool
+var restoreDryRun bool
+var restoreVerifyChunks bool
+var restorePaths []string
+var restoreNumericOwner bool
+var restoreNoOwner bool
 
 func init() {
 	rootCmd.AddCommand(restoreCmd)
 	restoreCmd.Flags().StringVar(&restoreJob, "job", "", "Job name to restore from (required)")
-	restoreCmd.Flags().StringVar(&restorePoint, "point", "", "Backup ID or snapshot timestamp to restore (required)")
+	restoreCmd.Flags().StringVar(&restorePoint, "point", "", "Backup ID or snapshot timestamp to restore, or \"latest\" (required)")
 	restoreCmd.Flags().StringVar(&restoreTarget, "target", "", "Target directory to restore into (required)")
+	restoreCmd.Flags().BoolVar(&restoreMysqlOnly, "mysql-only", false, "Restore only MySQL dump entries (archive mode)")
+	restoreCmd.Flags().BoolVar(&restoreDryRun, "dry-run", false, "Read the backup without writing files")
+	restoreCmd.Flags().BoolVar(&restoreVerifyChunks, "verify-chunks", false, "Verify the BLAKE3 hash of every chunk (incremental mode)")
+	restoreCmd.Flags().StringArrayVar(&restorePaths, "path", nil, "Restore only this file or directory, as stored in the snapshot (repeatable; incremental mode)")
+	restoreCmd.Flags().BoolVar(&restoreNumericOwner, "numeric-owner", false, "Restore the stored uid and gid instead of looking up the stored user and group names")
+	restoreCmd.Flags().BoolVar(&restoreNoOwner, "no-owner", false, "Do not restore file owners, even when running as root")
 }
 
 var restoreCmd = &cobra.Command{
 	Use:   "restore",
 	Short: "Restore from a backup or snapshot",
+	Long:  "Restore a backup (archive) or snapshot (incremental) of a job into a target directory. --point takes a timestamp as shown by 'list', or \"latest\" for the newest one. Modes, mtimes, extended attributes and ACLs are restored; owners too when running as root.",
 	RunE:  runRestore,
 }
 
 func runRestore(cmd *cobra.Command, args []string) error {
+	ctx := context.Background()
+
+	if restoreJob == "" || restorePoint == "" || restoreTarget == "" {
+		return fmt.Errorf("--job, --point and --target are required")
+	}
+	if restoreNumericOwner && restoreNoOwner {
+		return fmt.Errorf("--numeric-owner and --no-owner cannot be combined")
+	}
+
+	v, err := config.Load(false)
+	if err != nil {
+		return err
+	}
+	cfg, err := config.Unmarshal(v)
+	if err != nil {
+		return err
+	}
+	if err := config.Validate(cfg); err != nil {
+		return err
+	}
+	if cfg.S3 == nil {
+		return fmt.Errorf("s3 configuration is required")
+	}
+
+	var found bool
+	for _, j := range cfg.Jobs {
+		if j.Name == restoreJob {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return fmt.Errorf("job %q not found", restoreJob)
+	}
+
+	switch cfg.Mode {
+	case config.ModeArchive:
+		if restoreVerifyChunks || len(restorePaths) > 0 {
+			return fmt.Errorf("--verify-chunks and --path are only supported in incremental mode")
+		}
+	case config.ModeIncremental:
+		if restoreMysqlOnly {
+			return fmt.Errorf("--mysql-only is only supported in archive mode")
+		}
+	default:
+		return config.ErrInvalidMode
+	}
+
+	s3Client, err := s3.New(ctx, s3.Options{
+		Endpoint:                 cfg.S3.Endpoint,
+		Region:                   cfg.S3.Region,
+		AccessKey:                cfg.S3.AccessKey,
+		SecretKey:                cfg.S3.SecretKey,
+		Bucket:                   cfg.S3.Bucket,
+		Prefix:                   cfg.S3.Prefix,
+		PathStyle:                config.S3PathStyle(cfg.S3),
+		DisableRequestChecksums:  config.S3DisableRequestChecksums(cfg.S3),
+		DisableConditionalWrites: !config.S3ConditionalWrites(cfg.S3),
+		InsecureSkipVerify:       cfg.S3.TLS != nil && cfg.S3.TLS.InsecureSkipVerify,
+	})
+	if err != nil {
+		return err
+	}
+
+	keys, err := keyringFromConfig(ctx, cfg, s3Client, false)
+	if err != nil {
+		return err
+	}
+
+	notif := NotifierFromConfig(cfg, func(msg string) { cmd.PrintErrln("Warning:", msg) })
+
+	point, err := restoreOne(ctx, cmd, cfg.Mode, s3Client, keys)
+	if err != nil {
+		if notif != nil {
+			_ = notif.NotifyError(ctx, restoreJob, point, fmt.Errorf("restore: %w", err))
+		}
+		return withExitCode(ExitRestore, err)
+	}
+
+	if restoreDryRun {
+		cmd.Printf("Dry run: backup %s of job %q is readable; nothing was written\n", point, restoreJob)
+		return nil
+	}
+	cmd.Printf("Restored backup %s of job %q into %s\n", point, restoreJob, restoreTarget)
+	if notif != nil {
+		_ = notif.NotifyRestore(ctx, restoreJob, point, restoreTarget)
+	}
 	return nil
 }
+
+// restoreOne resolves --point and restores it. It returns the resolved point ID, which is
+// set as soon as it is known so that failures can be reported against it.
+func restoreOne(ctx context.Context, cmd *cobra.Command, mode string, client *s3.Client, keys *crypt.Keyring) (string, error) {
+	switch mode {
+	case config.ModeArchive:
+		m, err := archiveEngine.ResolveManifest(ctx, client, restoreJob, restorePoint)
+		if err != nil {
+			return restorePoint, err
+		}
+		cmd.Printf("Restoring %s ...\n", m.Key)
+		err = restore.RestoreArchive(ctx, client, m, restoreTarget, restore.ArchiveRestoreOptions{
+			MysqlOnly:    restoreMysqlOnly,
+			DryRun:       restoreDryRun,
+			NumericOwner: restoreNumericOwner,
+			NoOwner:      restoreNoOwner,
+			Keyring:      keys,
+		})
+		return m.Timestamp, err
+	default:
+		ts, err := incrEngine.ResolveSnapshot(ctx, client, restoreJob, restorePoint)
+		if err != nil {
+			return restorePoint, err
+		}
+		cmd.Printf("Restoring snapshot %s ...\n", ts)
+		err = restore.RestoreIncremental(ctx, client, restoreJob, ts, restoreTarget, restore.IncrementalRestoreOptions{
+			DryRun:       restoreDryRun,
+			VerifyChunks: restoreVerifyChunks,
+			Paths:        restorePaths,
+			NumericOwner: restoreNumericOwner,
+			NoOwner:      restoreNoOwner,
+			Keyring:      keys,
+		})
+		return ts, err
+	}
+}
@@ -12,7 +12,7 @@ var rootCmd = &cobra.Command{
 
 func Execute() int {
 	if err := rootCmd.Execute(); err != nil {
-		return 1
+		return exitCode(err)
 	}
-	return 0
+	return ExitOK
 }
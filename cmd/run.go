@@ -2,15 +2,23 @@ package cmd
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"text/tabwriter"
 	"time"
 
 	"VelBackuper/internal/collector"
 	"VelBackuper/internal/config"
+	"VelBackuper/internal/crypt"
 	archiveEngine "VelBackuper/internal/engine/archive"
 	incrEngine "VelBackuper/internal/engine/incremental"
+	"VelBackuper/internal/hook"
+	"VelBackuper/internal/lock"
 	"VelBackuper/internal/notifier"
 	"VelBackuper/internal/s3"
 
@@ -19,11 +27,15 @@ import (
 
 var runJob string
 var runAll bool
+var runParallel int
+var runKeepGoing bool
 
 func init() {
 	rootCmd.AddCommand(runCmd)
 	runCmd.Flags().StringVar(&runJob, "job", "", "Run only this job by name")
 	runCmd.Flags().BoolVar(&runAll, "all", false, "Run all enabled jobs")
+	runCmd.Flags().IntVar(&runParallel, "parallel", 1, "Run up to this many jobs at once")
+	runCmd.Flags().BoolVar(&runKeepGoing, "keep-going", false, "Run the remaining jobs after one fails")
 }
 
 var runCmd = &cobra.Command{
@@ -52,15 +64,16 @@ func runRun(cmd *cobra.Command, args []string) error {
 	}
 
 	s3Client, err := s3.New(ctx, s3.Options{
-		Endpoint:                cfg.S3.Endpoint,
-		Region:                  cfg.S3.Region,
-		AccessKey:               cfg.S3.AccessKey,
-		SecretKey:               cfg.S3.SecretKey,
-		Bucket:                  cfg.S3.Bucket,
-		Prefix:                  cfg.S3.Prefix,
-		PathStyle:               config.S3PathStyle(cfg.S3),
-		DisableRequestChecksums: config.S3DisableRequestChecksums(cfg.S3),
-		InsecureSkipVerify:      cfg.S3.TLS != nil && cfg.S3.TLS.InsecureSkipVerify,
+		Endpoint:                 cfg.S3.Endpoint,
+		Region:                   cfg.S3.Region,
+		AccessKey:                cfg.S3.AccessKey,
+		SecretKey:                cfg.S3.SecretKey,
+		Bucket:                   cfg.S3.Bucket,
+		Prefix:                   cfg.S3.Prefix,
+		PathStyle:                config.S3PathStyle(cfg.S3),
+		DisableRequestChecksums:  config.S3DisableRequestChecksums(cfg.S3),
+		DisableConditionalWrites: !config.S3ConditionalWrites(cfg.S3),
+		InsecureSkipVerify:       cfg.S3.TLS != nil && cfg.S3.TLS.InsecureSkipVerify,
 	})
 	if err != nil {
 		return err
@@ -94,6 +107,11 @@ func runRun(cmd *cobra.Command, args []string) error {
 		return fmt.Errorf("specify --job <name> or --all")
 	}
 
+	keys, err := keyringFromConfig(ctx, cfg, s3Client, true)
+	if err != nil {
+		return err
+	}
+
 	notif := NotifierFromConfig(cfg, func(msg string) { cmd.PrintErrln("Warning:", msg) })
 
 	host, _ := os.Hostname()
@@ -101,99 +119,340 @@ func runRun(cmd *cobra.Command, args []string) error {
 		host = "localhost"
 	}
 
-	for i, job := range jobs {
-		cmd.Printf("[%d/%d] Running job %q ...\n", i+1, len(jobs), job.Name)
+	env := &jobEnv{mode: cfg.Mode, client: s3Client, keys: keys, notif: notif, host: host, runID: lock.NewRunID()}
+	return runJobs(ctx, cmd, jobs, runParallel, runKeepGoing, func(ctx context.Context, logf func(string, ...any), job *config.JobConfig) jobResult {
+		return runLockedJob(ctx, logf, env, job)
+	})
+}
 
-		c := collector.CollectorFromJobConfig(&job)
-		if c == nil {
-			cmd.Printf("  Skipped: no sources (mysql/presets/paths) configured for job %q\n", job.Name)
-			continue
+// runJobs runs jobs through run, up to parallel at once, and returns an error carrying the
+// exit code of the first failed job. Unless keepGoing, no job is started after one failed;
+// those are reported as not run.
+func runJobs(ctx context.Context, cmd *cobra.Command, jobs []config.JobConfig, parallel int, keepGoing bool, run func(ctx context.Context, logf func(string, ...any), job *config.JobConfig) jobResult) error {
+	parallel = max(parallel, 1)
+	results := make([]jobResult, len(jobs))
+	for i := range jobs {
+		results[i] = jobResult{Job: jobs[i].Name, Status: jobNotRun}
+	}
+	var (
+		outMu   sync.Mutex
+		stop    atomic.Bool
+		wg      sync.WaitGroup
+		workers = make(chan struct{}, parallel)
+	)
+	for i := range jobs {
+		workers <- struct{}{}
+		if stop.Load() {
+			break
 		}
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			defer func() { <-workers }()
+			job := &jobs[i]
+			logf := cmd.Printf
+			if parallel > 1 {
+				logf = func(format string, args ...any) {
+					outMu.Lock()
+					defer outMu.Unlock()
+					cmd.Printf("[%s] "+format, append([]any{job.Name}, args...)...)
+				}
+			}
+			logf("[%d/%d] Running job %q ...\n", i+1, len(jobs), job.Name)
+			results[i] = run(ctx, logf, job)
+			if results[i].Status == jobFailed && !keepGoing {
+				stop.Store(true)
+			}
+		}(i)
+	}
+	wg.Wait()
 
-		start := time.Now()
-		err := runOneJob(ctx, cmd, cfg.Mode, &job, c, s3Client, notif, host, start)
-		duration := time.Since(start)
-		if err != nil {
-			cmd.Printf("  Failed after %s: %v\n", duration.Round(time.Second), err)
-			return err
+	if len(jobs) > 1 {
+		printRunSummary(cmd, results)
+	}
+	var failed []string
+	code := ExitOK
+	for _, r := range results {
+		if r.Status != jobFailed {
+			continue
+		}
+		failed = append(failed, r.Job)
+		if code == ExitOK {
+			code = exitCode(r.Err)
 		}
-		cmd.Printf("  OK in %s\n", duration.Round(time.Second))
 	}
-
+	if len(failed) == 1 && len(jobs) == 1 {
+		return results[0].Err
+	}
+	if len(failed) > 0 {
+		return withExitCode(code, fmt.Errorf("%d of %d jobs failed: %s", len(failed), len(jobs), strings.Join(failed, ", ")))
+	}
 	cmd.Println("All jobs completed successfully.")
 	return nil
 }
 
-func runOneJob(ctx context.Context, cmd *cobra.Command, mode string, job *config.JobConfig, c *collector.CompositeCollector, client *s3.Client, notif notifier.Notifier, host string, start time.Time) error {
-	if notif != nil {
-		_ = notif.NotifyStart(ctx, job.Name, "")
+// Job states in the run summary.
+const (
+	jobOK      = "ok"
+	jobFailed  = "failed"
+	jobSkipped = "skipped"
+	jobNotRun  = "not run"
+)
+
+// jobResult is one row of the run summary.
+type jobResult struct {
+	Job      string
+	Status   string
+	Duration time.Duration
+	Size     int64
+	Err      error
+}
+
+// jobEnv holds what every job of one run shares.
+type jobEnv struct {
+	mode   string
+	client *s3.Client
+	keys   *crypt.Keyring
+	notif  notifier.Notifier
+	host   string
+	runID  string
+}
+
+// runLockedJob runs one job under its local lock and reports the outcome.
+func runLockedJob(ctx context.Context, logf func(string, ...any), env *jobEnv, job *config.JobConfig) jobResult {
+	res := jobResult{Job: job.Name}
+	c := collector.CollectorFromJobConfig(job)
+	if c == nil {
+		logf("  Skipped: no sources (mysql/postgres/commands/presets/paths) configured for job %q\n", job.Name)
+		res.Status = jobSkipped
+		return res
+	}
+
+	// The local lock keeps a scheduled run and a manual one of the same job apart on
+	// this host; the kernel releases it if we crash.
+	local, err := lock.NewLocal(lock.LocalOptions{Name: job.Name, RunID: env.runID})
+	if err == nil {
+		err = local.Acquire(ctx)
 	}
+	if err != nil {
+		logf("  Failed: %v\n", err)
+		res.Status, res.Err = jobFailed, fmt.Errorf("job %s: %w", job.Name, err)
+		return res
+	}
+	defer func() { _ = local.Release(context.Background()) }()
 
-	switch mode {
+	start := time.Now()
+	res.Size, err = runOneJob(ctx, logf, env, job, c, start)
+	res.Duration = time.Since(start)
+	if err != nil {
+		logf("  Failed after %s: %v\n", res.Duration.Round(time.Second), err)
+		res.Status, res.Err = jobFailed, err
+		return res
+	}
+	logf("  OK in %s\n", res.Duration.Round(time.Second))
+	res.Status = jobOK
+	return res
+}
+
+// printRunSummary prints one row per job after a run of several jobs.
+func printRunSummary(cmd *cobra.Command, results []jobResult) {
+	cmd.Println()
+	tw := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "JOB\tSTATUS\tDURATION\tSIZE\tERROR")
+	for _, r := range results {
+		duration, size, errMsg := "-", "-", ""
+		if r.Status == jobOK || r.Status == jobFailed {
+			duration = r.Duration.Round(time.Second).String()
+		}
+		if r.Status == jobOK {
+			size = notifier.FormatBytes(r.Size)
+		}
+		if r.Err != nil {
+			// Hook output and tool stderr can span lines; keep one row per job.
+			errMsg = strings.Join(strings.Fields(r.Err.Error()), " ")
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", r.Job, r.Status, duration, size, errMsg)
+	}
+	_ = tw.Flush()
+}
+
+// runOneJob backs up one job, running its hooks around the backup, and returns the size of
+// the backup: the stored archive in archive mode, the data chunked in incremental mode.
+func runOneJob(ctx context.Context, logf func(string, ...any), env *jobEnv, job *config.JobConfig, c *collector.CompositeCollector, start time.Time) (int64, error) {
+	if env.notif != nil {
+		_ = env.notif.NotifyStart(ctx, job.Name, "")
+	}
+
+	var pre, post, onError []hook.Hook
+	if job.Hooks != nil {
+		pre, post, onError = hook.FromConfig(job.Hooks.Pre), hook.FromConfig(job.Hooks.Post), hook.FromConfig(job.Hooks.OnError)
+	}
+	info := hook.Info{Job: job.Name, Mode: env.mode, Status: hook.StatusRunning}
+
+	abort := config.HookAbortsOnPreFailure(job.Hooks)
+	if err := runHooks(ctx, logf, hook.PhasePre, pre, info, abort); err != nil {
+		if abort {
+			if env.notif != nil {
+				_ = env.notif.NotifyError(ctx, job.Name, "", err)
+			}
+			info.Status, info.Err = hook.StatusFailed, err
+			runFailureHooks(ctx, logf, env, job, onError, info)
+			return 0, err
+		}
+		if env.notif != nil {
+			_ = env.notif.NotifyWarning(ctx, job.Name, "", err.Error())
+		}
+	}
+
+	var (
+		backupID string
+		size     int64
+		err      error
+	)
+	switch env.mode {
 	case config.ModeArchive:
-		return runArchiveJob(ctx, cmd, job, c, client, notif, host, start)
+		backupID, size, err = runArchiveJob(ctx, logf, job, c, env.client, env.keys, env.notif, env.host, start)
 	case config.ModeIncremental:
-		return runIncrementalJob(ctx, cmd, job, c, client, notif, start)
+		backupID, size, err = runIncrementalJob(ctx, logf, job, c, env.client, env.keys, env.notif, start)
 	default:
-		return config.ErrInvalidMode
+		err = config.ErrInvalidMode
+	}
+
+	info.BackupID, info.Status, info.Err = backupID, hook.StatusSuccess, err
+	if err != nil {
+		info.Status = hook.StatusFailed
+	}
+	// A post hook that fails after a good backup fails the job: it usually undoes what a pre
+	// hook did, and a site left in maintenance mode must not go unnoticed.
+	if perr := runHooks(ctx, logf, hook.PhasePost, post, info, false); perr != nil && err == nil {
+		if env.notif != nil {
+			_ = env.notif.NotifyError(ctx, job.Name, backupID, perr)
+		}
+		err = perr
+		info.Status, info.Err = hook.StatusFailed, err
+	}
+	if err != nil {
+		runFailureHooks(ctx, logf, env, job, onError, info)
+		return 0, err
+	}
+	return size, nil
+}
+
+// runHooks runs hooks in order and logs their output. It returns the errors of the hooks that
+// failed; with stopOnError it does not run the hooks after the first failure.
+func runHooks(ctx context.Context, logf func(string, ...any), phase string, hooks []hook.Hook, info hook.Info, stopOnError bool) error {
+	var errs []error
+	for _, h := range hooks {
+		logf("  Running %s hook %q ...\n", phase, h.DisplayName())
+		out, err := hook.Run(ctx, phase, h, info)
+		if out != "" {
+			logf("    %s\n", strings.ReplaceAll(out, "\n", "\n    "))
+		}
+		if err != nil {
+			logf("  Hook failed: %v\n", err)
+			errs = append(errs, err)
+			if stopOnError {
+				break
+			}
+		}
 	}
+	return errors.Join(errs...)
 }
 
-func runArchiveJob(ctx context.Context, cmd *cobra.Command, job *config.JobConfig, c *collector.CompositeCollector, client *s3.Client, notif notifier.Notifier, host string, start time.Time) error {
-	stream, err := archiveEngine.Stream(ctx, c, job.Name, archiveEngine.FormatGzip, 6)
+// runFailureHooks runs the on_error hooks of a failed job. Their own failures are reported as
+// warnings so that the job keeps the error that made it fail.
+func runFailureHooks(ctx context.Context, logf func(string, ...any), env *jobEnv, job *config.JobConfig, hooks []hook.Hook, info hook.Info) {
+	if err := runHooks(ctx, logf, hook.PhaseOnError, hooks, info, false); err != nil && env.notif != nil {
+		_ = env.notif.NotifyWarning(ctx, job.Name, info.BackupID, err.Error())
+	}
+}
+
+// runArchiveJob uploads one archive of the job and returns its backup ID and stored size.
+func runArchiveJob(ctx context.Context, logf func(string, ...any), job *config.JobConfig, c *collector.CompositeCollector, client *s3.Client, keys *crypt.Keyring, notif notifier.Notifier, host string, start time.Time) (string, int64, error) {
+	compression := archiveEngine.CompressionFromConfig(job.Compression)
+	stream, stats, err := archiveEngine.Stream(ctx, c, job.Name, compression)
 	if err != nil {
 		if notif != nil {
 			_ = notif.NotifyError(ctx, job.Name, "", err)
 		}
-		return fmt.Errorf("stream: %w", err)
+		return "", 0, fmt.Errorf("stream: %w", err)
 	}
 
-	cmd.Printf("  Uploading archive ...\n")
-	archiveKey, backupID, err := archiveEngine.Upload(ctx, client, job.Name, archiveEngine.FormatGzip, stream, archiveEngine.UploadOptions{PartSizeMB: 5})
+	logf("  Uploading archive ...\n")
+	up, err := archiveEngine.Upload(ctx, client, job.Name, compression.Format, stream, archiveEngine.UploadOptions{PartSizeMB: 5, Keyring: keys})
 	if err != nil {
 		if notif != nil {
-			_ = notif.NotifyError(ctx, job.Name, backupID, err)
+			_ = notif.NotifyError(ctx, job.Name, up.BackupID, err)
 		}
-		return fmt.Errorf("upload: %w", err)
+		return "", 0, err
 	}
 
-	if err := archiveEngine.WriteManifest(ctx, client, archiveEngine.Manifest{
-		Job: job.Name, Timestamp: backupID, Key: archiveKey, Size: 0, Host: host, Format: "tar.gz",
-	}); err != nil {
-		return fmt.Errorf("write manifest: %w", err)
+	m := archiveEngine.Manifest{
+		Job: job.Name, Timestamp: up.BackupID, Key: up.Key, Size: up.Size, Host: host, Format: compression.Format.ManifestFormat(),
+		Encrypted:        keys.Enabled(),
+		UncompressedSize: stats.UncompressedSize(),
+		SHA256:           up.SHA256,
+		BLAKE3:           up.BLAKE3,
+		FileCount:        stats.FileCount(),
+		Sources:          c.SourceBytes(),
 	}
-	if err := archiveEngine.WriteLatest(ctx, client, job.Name, backupID, archiveKey); err != nil {
-		return fmt.Errorf("write latest: %w", err)
+	if err := archiveEngine.WriteManifest(ctx, client, m); err != nil {
+		return "", 0, fmt.Errorf("write manifest: %w", err)
 	}
+	if err := archiveEngine.WriteLatest(ctx, client, job.Name, up.BackupID, up.Key); err != nil {
+		return "", 0, fmt.Errorf("write latest: %w", err)
+	}
+	logf("  Stored %s (%s uncompressed, %d files)\n", notifier.FormatBytes(m.Size), notifier.FormatBytes(m.UncompressedSize), m.FileCount)
 
 	if notif != nil {
-		_ = notif.NotifySuccess(ctx, job.Name, backupID, time.Since(start), 0)
+		_ = notif.NotifySuccess(ctx, job.Name, up.BackupID, time.Since(start), notifier.BackupStats{
+			Size:             m.Size,
+			UncompressedSize: m.UncompressedSize,
+			FileCount:        m.FileCount,
+			SHA256:           m.SHA256,
+		})
 	}
-	return nil
+	return up.BackupID, m.Size, nil
 }
 
-func runIncrementalJob(ctx context.Context, cmd *cobra.Command, job *config.JobConfig, c *collector.CompositeCollector, client *s3.Client, notif notifier.Notifier, start time.Time) error {
+// s3LockTTL is how long an S3 lock is honoured before it is considered left behind by a
+// crashed process.
+const s3LockTTL = 30 * time.Minute
+
+// runIncrementalJob chunks and uploads one snapshot of the job and returns its backup ID and
+// the size of the data chunked.
+func runIncrementalJob(ctx context.Context, logf func(string, ...any), job *config.JobConfig, c *collector.CompositeCollector, client *s3.Client, keys *crypt.Keyring, notif notifier.Notifier, start time.Time) (string, int64, error) {
+	collectCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
 	pr, pw := io.Pipe()
+	collected := make(chan struct{})
 	go func() {
-		defer pw.Close()
-		if err := c.Collect(ctx, job.Name, pw); err != nil {
-			_ = pw.CloseWithError(err)
-		}
+		defer close(collected)
+		_ = pw.CloseWithError(c.Collect(collectCtx, job.Name, pw))
 	}()
 
-	cmd.Printf("  Chunking and uploading ...\n")
+	logf("  Chunking and uploading ...\n")
 	opts := incrEngine.RunOptions{
+		Chunker:       incrEngine.ChunkerFromConfig(job.Chunking, incrEngine.ChunkSizeMin),
 		ChunkSize:     incrEngine.ChunkSizeMin,
 		Concurrency:   4,
 		HashPrefixLen: incrEngine.DefaultHashPrefixLen,
+		Keyring:       keys,
 		Notifier:      notif,
 		StrictNotify:  false,
 	}
-	lockTTL := 30 * time.Minute
-	backupID, _, _, err := incrEngine.RunWithS3Lock(ctx, client, job.Name, pr, opts, lockTTL)
+	backupID, idx, _, err := incrEngine.RunWithS3Lock(ctx, client, job.Name, pr, opts, s3LockTTL)
+	// A backup that stopped before the end of the stream leaves the collector blocked on the
+	// pipe: unblock it, stop the dump tools under it and wait for it to return.
+	cancel()
+	_ = pr.CloseWithError(err)
+	<-collected
 	if err != nil {
-		return fmt.Errorf("incremental: %w", err)
+		return "", 0, err
 	}
-	_ = backupID
-	return nil
+	var size int64
+	for _, ch := range idx.Chunks {
+		size += ch.Size
+	}
+	return backupID, size, nil
 }
@@ -0,0 +1,159 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"VelBackuper/internal/collector"
+	"VelBackuper/internal/config"
+	"VelBackuper/internal/s3"
+
+	"github.com/spf13/cobra"
+)
+
+// stubJobs returns a runJobs callback that fails the jobs in fail with their error, and the
+// names of the jobs it was called for.
+func stubJobs(fail map[string]error) (func(context.Context, func(string, ...any), *config.JobConfig) jobResult, func() []string) {
+	var (
+		mu  sync.Mutex
+		ran []string
+	)
+	run := func(_ context.Context, _ func(string, ...any), job *config.JobConfig) jobResult {
+		mu.Lock()
+		ran = append(ran, job.Name)
+		mu.Unlock()
+		if err := fail[job.Name]; err != nil {
+			return jobResult{Job: job.Name, Status: jobFailed, Err: err}
+		}
+		return jobResult{Job: job.Name, Status: jobOK, Size: 1024}
+	}
+	return run, func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string(nil), ran...)
+	}
+}
+
+func testJobs(names ...string) []config.JobConfig {
+	jobs := make([]config.JobConfig, len(names))
+	for i, n := range names {
+		jobs[i] = config.JobConfig{Name: n, Enabled: true}
+	}
+	return jobs
+}
+
+func testCommand() (*cobra.Command, *bytes.Buffer) {
+	var out bytes.Buffer
+	cmd := &cobra.Command{}
+	cmd.SetOut(&out)
+	cmd.SetErr(&out)
+	return cmd, &out
+}
+
+// summaryStatus returns the STATUS column of job's row in the run summary.
+func summaryStatus(out, job string) string {
+	for _, line := range strings.Split(out, "\n") {
+		if strings.HasPrefix(line, job+" ") {
+			fields := strings.Fields(strings.TrimPrefix(line, job))
+			if len(fields) >= 2 && fields[0] == "not" {
+				return "not run"
+			}
+			if len(fields) > 0 {
+				return fields[0]
+			}
+		}
+	}
+	return ""
+}
+
+func TestRunJobs_ParallelBoundsConcurrency(t *testing.T) {
+	var active, peak atomic.Int32
+	run := func(_ context.Context, _ func(string, ...any), job *config.JobConfig) jobResult {
+		n := active.Add(1)
+		for {
+			p := peak.Load()
+			if n <= p || peak.CompareAndSwap(p, n) {
+				break
+			}
+		}
+		time.Sleep(20 * time.Millisecond)
+		active.Add(-1)
+		return jobResult{Job: job.Name, Status: jobOK}
+	}
+	cmd, out := testCommand()
+	if err := runJobs(context.Background(), cmd, testJobs("a", "b", "c", "d", "e", "f"), 2, false, run); err != nil {
+		t.Fatal(err)
+	}
+	if p := peak.Load(); p != 2 {
+		t.Errorf("at most %d jobs ran at once, want 2", p)
+	}
+	if !strings.Contains(out.String(), "[c] [3/6] Running job \"c\"") {
+		t.Errorf("parallel output is not prefixed with the job name:\n%s", out)
+	}
+}
+
+func TestRunJobs_StopsAfterFailureWithoutKeepGoing(t *testing.T) {
+	s3Err := &s3.Error{Op: "PutObject", Key: "archives/b/x.tar.zst", Err: errors.New("boom")}
+	run, ran := stubJobs(map[string]error{"b": s3Err})
+	cmd, out := testCommand()
+
+	err := runJobs(context.Background(), cmd, testJobs("a", "b", "c", "d"), 1, false, run)
+	if got := strings.Join(ran(), " "); got != "a b" {
+		t.Errorf("ran %q, want a and b only", got)
+	}
+	if err == nil || !strings.Contains(err.Error(), "1 of 4 jobs failed: b") {
+		t.Fatalf("runJobs = %v", err)
+	}
+	if code := exitCode(err); code != ExitS3 {
+		t.Errorf("exit code %d, want %d from the failed job", code, ExitS3)
+	}
+	for job, want := range map[string]string{"a": jobOK, "b": jobFailed, "c": jobNotRun, "d": jobNotRun} {
+		if got := summaryStatus(out.String(), job); got != want {
+			t.Errorf("summary status of %s = %q, want %q:\n%s", job, got, want, out)
+		}
+	}
+}
+
+func TestRunJobs_KeepGoing(t *testing.T) {
+	mysqlErr := &collector.Error{Source: collector.CollectorMySQL, Err: errors.New("access denied")}
+	s3Err := &s3.Error{Op: "PutObject", Key: "archives/d/x.tar.zst", Err: errors.New("boom")}
+	run, ran := stubJobs(map[string]error{"b": mysqlErr, "d": s3Err})
+	cmd, out := testCommand()
+
+	err := runJobs(context.Background(), cmd, testJobs("a", "b", "c", "d"), 1, true, run)
+	if got := strings.Join(ran(), " "); got != "a b c d" {
+		t.Errorf("ran %q, want every job", got)
+	}
+	if err == nil || !strings.Contains(err.Error(), "2 of 4 jobs failed: b, d") {
+		t.Fatalf("runJobs = %v", err)
+	}
+	if code := exitCode(err); code != ExitMySQL {
+		t.Errorf("exit code %d, want %d of the first failed job", code, ExitMySQL)
+	}
+	if got := summaryStatus(out.String(), "c"); got != jobOK {
+		t.Errorf("summary status of c = %q, want %q:\n%s", got, jobOK, out)
+	}
+	if !strings.Contains(out.String(), "access denied") {
+		t.Errorf("summary lacks the error of b:\n%s", out)
+	}
+}
+
+func TestRunJobs_SingleJobReturnsItsError(t *testing.T) {
+	s3Err := &s3.Error{Op: "PutObject", Key: "archives/a/x.tar.zst", Err: errors.New("boom")}
+	run, _ := stubJobs(map[string]error{"a": s3Err})
+	cmd, out := testCommand()
+
+	err := runJobs(context.Background(), cmd, testJobs("a"), 1, false, run)
+	if !errors.Is(err, s3Err) {
+		t.Fatalf("runJobs = %v, want the job's own error", err)
+	}
+	if strings.Contains(out.String(), "STATUS") {
+		t.Errorf("summary printed for a single job:\n%s", out)
+	}
+}
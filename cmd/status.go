@@ -2,13 +2,13 @@ package cmd
 
 import (
 	"context"
-	"os"
-	"path/filepath"
-	"strings"
+	"fmt"
 	"time"
 
 	"VelBackuper/internal/config"
 	archiveEngine "VelBackuper/internal/engine/archive"
+	incrEngine "VelBackuper/internal/engine/incremental"
+	"VelBackuper/internal/lock"
 	"VelBackuper/internal/s3"
 	"VelBackuper/internal/schedule"
 
@@ -22,7 +22,7 @@ func init() {
 var statusCmd = &cobra.Command{
 	Use:   "status",
 	Short: "List jobs with status (last run, next run, enabled)",
-	Long:  "Shows each job: enabled/disabled, last backup time (from S3), next scheduled run, and whether a run is in progress (lock).",
+	Long:  "Shows each job: enabled/disabled, last backup time (from S3), next scheduled run, and whether a run is in progress (running with the holder's PID and start time, or idle), read from the job's local lock.",
 	RunE:  runStatus,
 }
 
@@ -47,15 +47,16 @@ func runStatus(cmd *cobra.Command, args []string) error {
 	if cfg.S3 != nil {
 		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 		s3Client, err = s3.New(ctx, s3.Options{
-			Endpoint:                cfg.S3.Endpoint,
-			Region:                  cfg.S3.Region,
-			AccessKey:               cfg.S3.AccessKey,
-			SecretKey:               cfg.S3.SecretKey,
-			Bucket:                  cfg.S3.Bucket,
-			Prefix:                  cfg.S3.Prefix,
-			PathStyle:               config.S3PathStyle(cfg.S3),
-			DisableRequestChecksums: config.S3DisableRequestChecksums(cfg.S3),
-			InsecureSkipVerify:      cfg.S3.TLS != nil && cfg.S3.TLS.InsecureSkipVerify,
+			Endpoint:                 cfg.S3.Endpoint,
+			Region:                   cfg.S3.Region,
+			AccessKey:                cfg.S3.AccessKey,
+			SecretKey:                cfg.S3.SecretKey,
+			Bucket:                   cfg.S3.Bucket,
+			Prefix:                   cfg.S3.Prefix,
+			PathStyle:                config.S3PathStyle(cfg.S3),
+			DisableRequestChecksums:  config.S3DisableRequestChecksums(cfg.S3),
+			DisableConditionalWrites: !config.S3ConditionalWrites(cfg.S3),
+			InsecureSkipVerify:       cfg.S3.TLS != nil && cfg.S3.TLS.InsecureSkipVerify,
 		})
 		cancel()
 		if err != nil {
@@ -78,7 +79,7 @@ func runStatus(cmd *cobra.Command, args []string) error {
 					lastRun = ts
 				}
 			} else if cfg.Mode == config.ModeIncremental {
-				if ts := latestIncrementalSnapshot(context.Background(), s3Client, j.Name); ts != "" {
+				if ts, err := incrEngine.LatestSnapshot(context.Background(), s3Client, j.Name); err == nil && ts != "" {
 					if t, err := time.Parse("20060102150405", ts); err == nil {
 						lastRun = t.Format("2006-01-02 15:04")
 					} else {
@@ -96,49 +97,22 @@ func runStatus(cmd *cobra.Command, args []string) error {
 			}
 		}
 
-		inProgress := ""
-		if j.Enabled && lockFileExists(j.Name) {
-			inProgress = " [running]"
-		}
-
-		cmd.Printf("  %s: %s  last=%s  next=%s%s\n", j.Name, state, lastRun, nextRun, inProgress)
+		cmd.Printf("  %s: %s  last=%s  next=%s  %s\n", j.Name, state, lastRun, nextRun, runState(j.Name))
 	}
 	return nil
 }
 
-func latestIncrementalSnapshot(ctx context.Context, client *s3.Client, job string) string {
-	prefix := s3.SnapshotsPrefixForJob(job)
-	keys, err := client.ListObjects(ctx, prefix, 50)
-	if err != nil || len(keys) == 0 {
-		return ""
-	}
-	var best string
-	for _, k := range keys {
-		// key like snapshots/job/20250226120000.json
-		ts := strings.TrimSuffix(filepath.Base(k), ".json")
-		if len(ts) == 14 && (best == "" || ts > best) {
-			best = ts
-		}
-	}
-	return best
-}
-
-func lockFileExists(jobName string) bool {
-	dir := "/var/run/velbackuper"
-	if d := os.Getenv("VELBACKUPER_LOCK_DIR"); d != "" {
-		dir = d
-	}
-	path := filepath.Join(dir, jobName+".lock")
-	f, err := os.Open(path)
+// runState reports from the job's local lock whether a run is in progress, and by whom.
+func runState(jobName string) string {
+	st, err := lock.InspectLocal("", jobName)
 	if err != nil {
-		return false
+		return "state=unknown (" + err.Error() + ")"
 	}
-	defer f.Close()
-	// If we can open it, check if it's locked (optional: flock would tell us)
-	// On Unix, we could try flock; for simplicity we just report "running" if file exists and is recent (e.g. modified < 30 min)
-	info, err := f.Stat()
-	if err != nil {
-		return false
+	if !st.Running {
+		return "state=idle"
+	}
+	if st.Owner == nil {
+		return "state=running"
 	}
-	return time.Since(info.ModTime()) < 35*time.Minute
+	return fmt.Sprintf("state=running (pid %d, since %s)", st.Owner.PID, st.Owner.Started.Local().Format("2006-01-02 15:04:05"))
 }
@@ -0,0 +1,215 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+
+	"VelBackuper/internal/config"
+	"VelBackuper/internal/crypt"
+	archiveEngine "VelBackuper/internal/engine/archive"
+	incrEngine "VelBackuper/internal/engine/incremental"
+	"VelBackuper/internal/notifier"
+	"VelBackuper/internal/restore"
+	"VelBackuper/internal/s3"
+
+	"github.com/spf13/cobra"
+)
+
+var verifyJob string
+var verifyPoint string
+var verifyDeep bool
+
+func init() {
+	rootCmd.AddCommand(verifyCmd)
+	verifyCmd.Flags().StringVar(&verifyJob, "job", "", "Job name to verify (required)")
+	verifyCmd.Flags().StringVar(&verifyPoint, "point", "", "Backup ID or snapshot timestamp to verify, or \"latest\" (default: all)")
+	verifyCmd.Flags().BoolVar(&verifyDeep, "deep", false, "Download every chunk and check its hash (incremental mode)")
+}
+
+var verifyCmd = &cobra.Command{
+	Use:   "verify",
+	Short: "Check that backups can be restored",
+	Long: `Check that the backups of a job can be restored, without writing any files.
+
+Archive mode downloads each backup, decrypts and decompresses it, reads every tar entry and
+compares the size, SHA-256 and file count with the manifest. Incremental mode checks that
+every chunk referenced by each snapshot exists; --deep also downloads the chunks and checks
+their hashes. Without --point every backup of the job is verified.`,
+	RunE: runVerify,
+}
+
+func runVerify(cmd *cobra.Command, args []string) error {
+	ctx := context.Background()
+
+	if verifyJob == "" {
+		return fmt.Errorf("--job is required")
+	}
+
+	v, err := config.Load(false)
+	if err != nil {
+		return err
+	}
+	cfg, err := config.Unmarshal(v)
+	if err != nil {
+		return err
+	}
+	if err := config.Validate(cfg); err != nil {
+		return err
+	}
+	if cfg.S3 == nil {
+		return fmt.Errorf("s3 configuration is required")
+	}
+
+	var found bool
+	for _, j := range cfg.Jobs {
+		if j.Name == verifyJob {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return fmt.Errorf("job %q not found", verifyJob)
+	}
+	if verifyDeep && cfg.Mode == config.ModeArchive {
+		return fmt.Errorf("--deep is only supported in incremental mode; archive verification always reads the whole backup")
+	}
+
+	s3Client, err := s3.New(ctx, s3.Options{
+		Endpoint:                 cfg.S3.Endpoint,
+		Region:                   cfg.S3.Region,
+		AccessKey:                cfg.S3.AccessKey,
+		SecretKey:                cfg.S3.SecretKey,
+		Bucket:                   cfg.S3.Bucket,
+		Prefix:                   cfg.S3.Prefix,
+		PathStyle:                config.S3PathStyle(cfg.S3),
+		DisableRequestChecksums:  config.S3DisableRequestChecksums(cfg.S3),
+		DisableConditionalWrites: !config.S3ConditionalWrites(cfg.S3),
+		InsecureSkipVerify:       cfg.S3.TLS != nil && cfg.S3.TLS.InsecureSkipVerify,
+	})
+	if err != nil {
+		return err
+	}
+
+	keys, err := keyringFromConfig(ctx, cfg, s3Client, false)
+	if err != nil {
+		return err
+	}
+
+	notif := NotifierFromConfig(cfg, func(msg string) { cmd.PrintErrln("Warning:", msg) })
+
+	var failed []string
+	switch cfg.Mode {
+	case config.ModeArchive:
+		failed, err = verifyArchives(ctx, cmd, s3Client, keys)
+	case config.ModeIncremental:
+		failed, err = verifySnapshots(ctx, cmd, s3Client, keys)
+	default:
+		return config.ErrInvalidMode
+	}
+	if err != nil {
+		notifyVerifyFailure(ctx, notif, verifyPoint, err)
+		return err
+	}
+	if len(failed) > 0 {
+		err := fmt.Errorf("%d backup(s) of job %q failed verification: %v", len(failed), verifyJob, failed)
+		notifyVerifyFailure(ctx, notif, failed[0], err)
+		return withExitCode(ExitVerify, err)
+	}
+	cmd.Printf("All checked backups of job %q are restorable\n", verifyJob)
+	return nil
+}
+
+// verifyArchives verifies the selected archive backups and returns the IDs that failed.
+func verifyArchives(ctx context.Context, cmd *cobra.Command, client *s3.Client, keys *crypt.Keyring) ([]string, error) {
+	points := []string{verifyPoint}
+	if verifyPoint == "" {
+		var err error
+		if points, err = archiveEngine.ListBackups(ctx, client, verifyJob); err != nil {
+			return nil, fmt.Errorf("list backups for job %s: %w", verifyJob, err)
+		}
+		if len(points) == 0 {
+			return nil, fmt.Errorf("job %s has no backups", verifyJob)
+		}
+	}
+
+	var failed []string
+	for _, point := range points {
+		m, err := archiveEngine.ResolveManifest(ctx, client, verifyJob, point)
+		if err != nil {
+			cmd.Printf("  %s  FAILED\n    %v\n", point, err)
+			failed = append(failed, point)
+			continue
+		}
+		rep, err := restore.VerifyArchive(ctx, client, m, keys)
+		if err != nil {
+			return failed, err
+		}
+		if rep.OK() {
+			cmd.Printf("  %s  OK  %s, %d files\n", rep.Timestamp, notifier.FormatBytes(rep.Size), rep.FileCount)
+			continue
+		}
+		cmd.Printf("  %s  FAILED\n", rep.Timestamp)
+		for _, p := range rep.Problems {
+			cmd.Printf("    %s\n", p)
+		}
+		failed = append(failed, rep.Timestamp)
+	}
+	return failed, nil
+}
+
+// verifySnapshots verifies the selected snapshots and returns those that failed.
+func verifySnapshots(ctx context.Context, cmd *cobra.Command, client *s3.Client, keys *crypt.Keyring) ([]string, error) {
+	var points []string
+	if verifyPoint != "" {
+		ts, err := incrEngine.ResolveSnapshot(ctx, client, verifyJob, verifyPoint)
+		if err != nil {
+			return nil, err
+		}
+		points = []string{ts}
+	} else {
+		var err error
+		if points, err = incrEngine.ListSnapshots(ctx, client, verifyJob); err != nil {
+			return nil, fmt.Errorf("list snapshots for job %s: %w", verifyJob, err)
+		}
+		if len(points) == 0 {
+			return nil, fmt.Errorf("job %s has no snapshots", verifyJob)
+		}
+	}
+
+	check := "existence"
+	if verifyDeep {
+		check = "content"
+	}
+	cmd.Printf("Checking %s of chunks for %d snapshot(s) of job %s ...\n", check, len(points), verifyJob)
+	rep, err := restore.VerifyIncremental(ctx, client, verifyJob, points, restore.VerifyOptions{
+		Deep:    verifyDeep,
+		Keyring: keys,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	failed := rep.Failed()
+	bad := make(map[string]bool, len(failed))
+	for _, ts := range failed {
+		bad[ts] = true
+	}
+	for _, ts := range rep.Snapshots {
+		if bad[ts] {
+			cmd.Printf("  %s  FAILED\n", ts)
+		} else {
+			cmd.Printf("  %s  OK\n", ts)
+		}
+	}
+	cmd.Printf("Checked %d chunks, %d problem(s)\n", rep.Chunks, len(rep.Problems))
+	for _, p := range rep.Problems {
+		cmd.Printf("    %s\n", p)
+	}
+	return failed, nil
+}
+
+func notifyVerifyFailure(ctx context.Context, notif notifier.Notifier, point string, err error) {
+	if notif != nil {
+		_ = notif.NotifyError(ctx, verifyJob, point, fmt.Errorf("verify: %w", err))
+	}
+}
@@ -1,10 +1,39 @@
 package collector
 
 import (
+	"archive/tar"
 	"context"
 	"io"
 )
 
+// Collector writes a job's data as a tar stream to w.
 type Collector interface {
 	Collect(ctx context.Context, jobName string, w io.Writer) error
 }
+
+// TarCollector adds its entries to a tar archive shared with the job's other sources.
+// CompositeCollector uses it so that a job produces one archive that standard tar can extract.
+type TarCollector interface {
+	CollectTar(ctx context.Context, jobName string, tw *tar.Writer) error
+}
+
+// Named is implemented by collectors that report a source name (see the Collector* constants).
+// CompositeCollector uses it to attribute bytes to sources.
+type Named interface {
+	Name() string
+}
+
+// Error is returned by CompositeCollector when one of its sources fails. Source is the
+// source name (one of the Collector* constants for the built-in collectors).
+type Error struct {
+	Source string
+	Err    error
+}
+
+func (e *Error) Error() string {
+	return e.Source + ": " + e.Err.Error()
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
@@ -0,0 +1,45 @@
+package collector
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// stderrLimit is how much of a command's stderr is kept for its error message.
+const stderrLimit = 4096
+
+// stderrBuffer keeps the first stderrLimit bytes written to it and discards the rest, so a
+// chatty command cannot grow it without bound.
+type stderrBuffer struct {
+	buf bytes.Buffer
+}
+
+func (b *stderrBuffer) Write(p []byte) (int, error) {
+	if room := stderrLimit - b.buf.Len(); room > 0 {
+		b.buf.Write(p[:min(len(p), room)])
+	}
+	return len(p), nil
+}
+
+func (b *stderrBuffer) String() string {
+	return strings.TrimSpace(b.buf.String())
+}
+
+// commandError adds what a failed command wrote to stderr to err. If stderr is empty, the
+// stderr captured by exec.Cmd.Output is used.
+func commandError(err error, stderr string) error {
+	var ee *exec.ExitError
+	if stderr == "" && errors.As(err, &ee) {
+		stderr = strings.TrimSpace(string(ee.Stderr))
+	}
+	if len(stderr) > stderrLimit {
+		stderr = stderr[:stderrLimit]
+	}
+	if stderr == "" {
+		return err
+	}
+	return fmt.Errorf("%w: %s", err, stderr)
+}
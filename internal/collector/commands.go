@@ -0,0 +1,150 @@
+package collector
+
+import (
+	"archive/tar"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"slices"
+	"time"
+
+	"VelBackuper/internal/proc"
+)
+
+// CommandsEntryDir is the directory of the archive holding the output of dump commands.
+const CommandsEntryDir = "commands"
+
+// commandWaitDelay is how long a dump command's pipes are still read after it was killed.
+const commandWaitDelay = 5 * time.Second
+
+type CommandOpts struct {
+	Name              string // entry name under commands/
+	Command           string // run with /bin/sh -c
+	Timeout           time.Duration
+	User              string   // run as this user; empty = current user
+	Env               []string // KEY=value, added to the environment
+	ExpectedExitCodes []int    // empty = 0 only
+	SpoolDir          string   // stdout is staged here; empty = os.TempDir()
+}
+
+// CommandCollector stores the stdout of one command as a single entry.
+type CommandCollector struct {
+	opts CommandOpts
+}
+
+func NewCommandCollector(opts CommandOpts) *CommandCollector {
+	return &CommandCollector{opts: opts}
+}
+
+func (c *CommandCollector) Name() string { return c.opts.Name }
+
+func (c *CommandCollector) Collect(ctx context.Context, jobName string, w io.Writer) error {
+	return collectTar(ctx, c, jobName, w)
+}
+
+// CollectTar stores the command's stdout as commands/<name>. A failure names the command and
+// includes what it wrote to stderr.
+func (c *CommandCollector) CollectTar(ctx context.Context, jobName string, tw *tar.Writer) error {
+	runCtx := ctx
+	if c.opts.Timeout > 0 {
+		var cancel context.CancelFunc
+		runCtx, cancel = context.WithTimeout(ctx, c.opts.Timeout)
+		defer cancel()
+	}
+
+	var stderr stderrBuffer
+	err := writeSpooledEntry(ctx, tw, c.opts.SpoolDir, CommandsEntryDir+"/"+c.opts.Name, func(w io.Writer) error {
+		cmd := exec.CommandContext(runCtx, "/bin/sh", "-c", c.opts.Command)
+		cmd.Env = os.Environ()
+		cmd.Stdout = w
+		cmd.Stderr = &stderr
+		cmd.WaitDelay = commandWaitDelay
+		if err := runAsUser(cmd, c.opts.User); err != nil {
+			return err
+		}
+		// After the user's HOME, USER and LOGNAME, so that the configured env wins.
+		cmd.Env = append(cmd.Env, c.opts.Env...)
+		// Kill the shell's whole process group on timeout so no dump tool is left running.
+		proc.KillGroupOnCancel(cmd)
+		return c.checkExit(cmd.Run())
+	})
+	if err == nil {
+		return nil
+	}
+	switch {
+	case ctx.Err() != nil:
+		return ctx.Err()
+	case errors.Is(runCtx.Err(), context.DeadlineExceeded):
+		err = fmt.Errorf("timed out after %s", c.opts.Timeout)
+	}
+	return fmt.Errorf("%s: %w", c.opts.Name, commandError(err, stderr.String()))
+}
+
+// checkExit accepts an exit with one of the expected codes.
+func (c *CommandCollector) checkExit(err error) error {
+	var ee *exec.ExitError
+	if !errors.As(err, &ee) {
+		return err
+	}
+	expected := c.opts.ExpectedExitCodes
+	if len(expected) == 0 {
+		expected = []int{0}
+	}
+	if code := ee.ExitCode(); code >= 0 && slices.Contains(expected, code) {
+		return nil
+	}
+	return err
+}
+
+// CommandsCollector runs a job's dump commands in order. The commands are kept in a Registry
+// by entry name, so each name is stored once.
+type CommandsCollector struct {
+	commands *Registry
+	order    []string
+}
+
+func NewCommandsCollector(opts ...CommandOpts) *CommandsCollector {
+	c := &CommandsCollector{commands: NewRegistry()}
+	for _, o := range opts {
+		if _, ok := c.commands.Get(o.Name); !ok {
+			c.order = append(c.order, o.Name)
+		}
+		c.commands.Register(o.Name, NewCommandCollector(o))
+	}
+	return c
+}
+
+func (c *CommandsCollector) Name() string { return CollectorCommands }
+
+// Command returns the collector of the command whose output is stored as commands/<name>.
+func (c *CommandsCollector) Command(name string) (*CommandCollector, bool) {
+	col, ok := c.commands.Get(name)
+	if !ok {
+		return nil, false
+	}
+	return col.(*CommandCollector), true
+}
+
+func (c *CommandsCollector) Collect(ctx context.Context, jobName string, w io.Writer) error {
+	return collectTar(ctx, c, jobName, w)
+}
+
+func (c *CommandsCollector) CollectTar(ctx context.Context, jobName string, tw *tar.Writer) error {
+	for _, name := range c.order {
+		cmd, _ := c.Command(name)
+		if err := cmd.CollectTar(ctx, jobName, tw); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+var (
+	_ Collector    = (*CommandCollector)(nil)
+	_ TarCollector = (*CommandCollector)(nil)
+	_ Collector    = (*CommandsCollector)(nil)
+	_ TarCollector = (*CommandsCollector)(nil)
+)
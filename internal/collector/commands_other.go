@@ -0,0 +1,16 @@
+//go:build !unix
+
+package collector
+
+import (
+	"errors"
+	"os/exec"
+)
+
+// runAsUser only supports running as the current user here.
+func runAsUser(cmd *exec.Cmd, username string) error {
+	if username != "" {
+		return errors.New("running a command as another user is not supported on this platform")
+	}
+	return nil
+}
@@ -0,0 +1,97 @@
+package collector
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"os/user"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCommandsCollector_StoresStdoutPerCommand(t *testing.T) {
+	c := NewCommandsCollector(
+		CommandOpts{Name: "redis.rdb", Command: "printf 'REDIS0011'; echo progress >&2"},
+		CommandOpts{Name: "env.txt", Command: `echo "$DUMP_TARGET"`, Env: []string{"DUMP_TARGET=ldap"}},
+	)
+	entries := collectEntries(t, c)
+	if len(entries) != 2 {
+		t.Fatalf("entries = %v, want commands/redis.rdb and commands/env.txt", entryNames(entries))
+	}
+	if got := entries["commands/redis.rdb"]; got != "REDIS0011" {
+		t.Errorf("redis.rdb = %q, want stdout only", got)
+	}
+	if got := entries["commands/env.txt"]; got != "ldap\n" {
+		t.Errorf("env.txt = %q", got)
+	}
+}
+
+func TestCommandsCollector_SpoolDir(t *testing.T) {
+	dir := t.TempDir()
+	c := NewCommandsCollector(CommandOpts{Name: "spooled", Command: "ls " + dir, SpoolDir: dir})
+	if got := collectEntries(t, c)["commands/spooled"]; !strings.HasPrefix(got, "velbackuper-spool-") {
+		t.Errorf("spooled = %q, want the spool file listed in spool_dir", got)
+	}
+
+	missing := NewCommandsCollector(CommandOpts{Name: "spooled", Command: "true", SpoolDir: dir + "/missing"})
+	if err := missing.Collect(context.Background(), "job", io.Discard); err == nil || !strings.Contains(err.Error(), "create spool file") {
+		t.Fatalf("Collect = %v, want a spool file error", err)
+	}
+}
+
+func TestCommandsCollector_ExpectedExitCodes(t *testing.T) {
+	ok := NewCommandsCollector(CommandOpts{Name: "partial", Command: "echo data; exit 4", ExpectedExitCodes: []int{0, 4}})
+	if got := collectEntries(t, ok)["commands/partial"]; got != "data\n" {
+		t.Errorf("partial = %q", got)
+	}
+
+	failing := NewCommandsCollector(CommandOpts{Name: "ldap.ldif", Command: "echo 'ldap_bind: Invalid credentials (49)' >&2; exit 49"})
+	err := failing.Collect(context.Background(), "job", io.Discard)
+	if err == nil || !strings.Contains(err.Error(), "ldap.ldif") || !strings.Contains(err.Error(), "Invalid credentials") {
+		t.Fatalf("Collect = %v, want the entry name and stderr in the error", err)
+	}
+}
+
+func TestCommandsCollector_Timeout(t *testing.T) {
+	c := NewCommandsCollector(CommandOpts{Name: "slow", Command: "sleep 10", Timeout: 100 * time.Millisecond})
+	start := time.Now()
+	err := c.Collect(context.Background(), "job", io.Discard)
+	if err == nil || !strings.Contains(err.Error(), "timed out after 100ms") {
+		t.Fatalf("Collect = %v, want a timeout", err)
+	}
+	if d := time.Since(start); d > 5*time.Second {
+		t.Errorf("Collect took %s", d)
+	}
+}
+
+func TestCommandsCollector_ErrorIsAttributedToCommands(t *testing.T) {
+	cc := NewCompositeCollector(NewCommandsCollector(CommandOpts{Name: "consul.snap", Command: "exit 1"}))
+	err := cc.Collect(context.Background(), "job", io.Discard)
+	var ce *Error
+	if !errors.As(err, &ce) || ce.Source != CollectorCommands {
+		t.Fatalf("Collect = %v, want an *Error from %s", err, CollectorCommands)
+	}
+}
+
+func TestCommandsCollector_EnvOverridesUserDefaults(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("running a command as a user needs root")
+	}
+	u, err := user.Current()
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := NewCommandsCollector(CommandOpts{Name: "env.txt", Command: `echo "$HOME $USER"`, User: u.Username, Env: []string{"HOME=/srv/dumps"}})
+	if got, want := collectEntries(t, c)["commands/env.txt"], "/srv/dumps "+u.Username+"\n"; got != want {
+		t.Errorf("env.txt = %q, want %q: the configured HOME and the user's USER", got, want)
+	}
+}
+
+func TestCommandsCollector_UnknownUser(t *testing.T) {
+	c := NewCommandsCollector(CommandOpts{Name: "x", Command: "true", User: "no-such-user-velbackuper"})
+	if err := c.Collect(context.Background(), "job", io.Discard); err == nil {
+		t.Fatal("Collect with an unknown user should fail")
+	}
+}
@@ -1,12 +1,19 @@
 package collector
 
 import (
+	"archive/tar"
 	"context"
+	"fmt"
 	"io"
+	"sync"
 )
 
 type CompositeCollector struct {
 	collectors []Collector
+	spoolDir   string // for the output of collectors that are not TarCollectors
+
+	mu          sync.Mutex
+	sourceBytes map[string]int64
 }
 
 func NewCompositeCollector(collectors ...Collector) *CompositeCollector {
@@ -19,13 +26,68 @@ func NewCompositeCollector(collectors ...Collector) *CompositeCollector {
 	return &CompositeCollector{collectors: c}
 }
 
+// Collect writes all sources into one tar archive on w. Sources implementing TarCollector
+// add their entries directly; the output of any other Collector is stored as a single entry
+// named after the source. A failing source stops the archive and is reported as an *Error.
 func (c *CompositeCollector) Collect(ctx context.Context, jobName string, w io.Writer) error {
-	for _, col := range c.collectors {
-		if err := col.Collect(ctx, jobName, w); err != nil {
-			return err
+	c.mu.Lock()
+	c.sourceBytes = make(map[string]int64, len(c.collectors))
+	c.mu.Unlock()
+
+	cw := &countingWriter{w: w}
+	tw := tar.NewWriter(cw)
+	for i, col := range c.collectors {
+		name := sourceName(col, i)
+		before := cw.n
+		var err error
+		if tc, ok := col.(TarCollector); ok {
+			err = tc.CollectTar(ctx, jobName, tw)
+		} else {
+			err = writeSpooledEntry(ctx, tw, c.spoolDir, name, func(w io.Writer) error {
+				return col.Collect(ctx, jobName, w)
+			})
+		}
+		if err == nil {
+			err = tw.Flush() // pad the last entry so it is counted for this source
+		}
+		c.mu.Lock()
+		c.sourceBytes[name] += cw.n - before
+		c.mu.Unlock()
+		if err != nil {
+			return &Error{Source: name, Err: err}
 		}
 	}
-	return nil
+	return tw.Close()
+}
+
+// SourceBytes returns the tar bytes (headers and padded content) each source wrote during
+// the last Collect, keyed by source name (e.g. "mysql", "filesystem").
+func (c *CompositeCollector) SourceBytes() map[string]int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	out := make(map[string]int64, len(c.sourceBytes))
+	for k, v := range c.sourceBytes {
+		out[k] = v
+	}
+	return out
+}
+
+func sourceName(col Collector, i int) string {
+	if n, ok := col.(Named); ok {
+		return n.Name()
+	}
+	return fmt.Sprintf("source%d", i+1)
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
 }
 
 var _ Collector = (*CompositeCollector)(nil)
@@ -1,9 +1,14 @@
 package collector
 
 import (
+	"archive/tar"
 	"bytes"
 	"context"
+	"errors"
 	"io"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 )
 
@@ -34,8 +39,66 @@ func TestCompositeCollector_RunsAll(t *testing.T) {
 	if runOrder[0] != 1 || runOrder[1] != 2 || runOrder[2] != 3 {
 		t.Errorf("run order = %v", runOrder)
 	}
-	if buf.String() != "123" {
-		t.Errorf("output = %q, want 123", buf.String())
+	entries := readTarEntries(t, &buf)
+	want := map[string]string{"source1": "1", "source2": "2", "source3": "3"}
+	if len(entries) != len(want) {
+		t.Fatalf("entries = %v, want %v", entries, want)
+	}
+	for name, body := range want {
+		if entries[name] != body {
+			t.Errorf("entry %s = %q, want %q", name, entries[name], body)
+		}
+	}
+}
+
+func TestCompositeCollector_TarCollectorsShareArchive(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "a.txt"), []byte("hello"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	fs := NewFilesystemCollector(PathsOpts{Include: []string{dir}})
+	dump := namedCollector{name: CollectorMySQL, Collector: &funcCollector{
+		fn: func(ctx context.Context, jobName string, w io.Writer) error {
+			_, err := io.WriteString(w, "CREATE TABLE t (id int);\n")
+			return err
+		},
+	}}
+	comp := NewCompositeCollector(dump, fs)
+	var buf bytes.Buffer
+	if err := comp.Collect(context.Background(), "job", &buf); err != nil {
+		t.Fatal(err)
+	}
+	// A single tar reader must see every entry: the archive has exactly one end marker.
+	entries := readTarEntries(t, &buf)
+	if entries[CollectorMySQL] != "CREATE TABLE t (id int);\n" {
+		t.Errorf("mysql entry = %q", entries[CollectorMySQL])
+	}
+	fileName := strings.TrimPrefix(filepath.ToSlash(filepath.Join(dir, "a.txt")), "/")
+	if entries[fileName] != "hello" {
+		t.Errorf("entries = %v, want %s", entries, fileName)
+	}
+}
+
+func readTarEntries(t *testing.T, r io.Reader) map[string]string {
+	t.Helper()
+	tr := tar.NewReader(r)
+	out := make(map[string]string)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return out
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		body, err := io.ReadAll(tr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		out[hdr.Name] = string(body)
 	}
 }
 
@@ -56,6 +119,24 @@ func TestCompositeCollector_EmptyNoOp(t *testing.T) {
 	}
 }
 
+func TestCompositeCollector_ErrorNamesSource(t *testing.T) {
+	failed := errors.New("mysqldump exited with status 2")
+	comp := NewCompositeCollector(
+		&funcCollector{fn: func(context.Context, string, io.Writer) error { return nil }},
+		namedCollector{name: CollectorMySQL, Collector: &funcCollector{
+			fn: func(context.Context, string, io.Writer) error { return failed },
+		}},
+	)
+	err := comp.Collect(context.Background(), "job", io.Discard)
+	var ce *Error
+	if !errors.As(err, &ce) || ce.Source != CollectorMySQL {
+		t.Fatalf("Collect = %v, want an *Error for source %q", err, CollectorMySQL)
+	}
+	if !errors.Is(err, failed) {
+		t.Errorf("Collect = %v, does not wrap the source error", err)
+	}
+}
+
 type funcCollector struct {
 	fn func(context.Context, string, io.Writer) error
 }
@@ -63,3 +144,28 @@ type funcCollector struct {
 func (f *funcCollector) Collect(ctx context.Context, jobName string, w io.Writer) error {
 	return f.fn(ctx, jobName, w)
 }
+
+func TestCompositeCollector_SourceBytes(t *testing.T) {
+	write := func(n int) Collector {
+		return &funcCollector{fn: func(ctx context.Context, jobName string, w io.Writer) error {
+			_, err := w.Write(bytes.Repeat([]byte{'x'}, n))
+			return err
+		}}
+	}
+	comp := NewCompositeCollector(namedCollector{name: CollectorMySQL, Collector: write(10)}, write(3))
+	if err := comp.Collect(context.Background(), "job", io.Discard); err != nil {
+		t.Fatal(err)
+	}
+	got := comp.SourceBytes()
+	// Each source is one 512-byte header plus its content padded to a 512-byte block.
+	if got[CollectorMySQL] != 1024 || got["source2"] != 1024 || len(got) != 2 {
+		t.Errorf("SourceBytes = %v, want mysql=1024 source2=1024", got)
+	}
+}
+
+type namedCollector struct {
+	name string
+	Collector
+}
+
+func (n namedCollector) Name() string { return n.name }
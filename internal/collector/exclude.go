@@ -0,0 +1,197 @@
+package collector
+
+import (
+	"bufio"
+	"errors"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// IgnoreFileName is the per-directory exclude file. Its patterns use the .gitignore syntax and
+// apply to the directory it is in and everything below.
+const IgnoreFileName = ".velbackupignore"
+
+// excludeRule is one gitignore-style pattern. Patterns without a slash (other than a trailing
+// one) match a name at any depth below base; others are anchored at base. A trailing slash
+// matches directories only, "**" matches any number of directories, and "!" re-includes what
+// an earlier pattern excluded.
+type excludeRule struct {
+	base    string // absolute, slash-separated directory the pattern is relative to
+	re      *regexp.Regexp
+	negate  bool
+	dirOnly bool
+}
+
+// parseExcludeRule parses one pattern relative to base. Blank lines and # comments yield no
+// rule.
+func parseExcludeRule(base, pattern string) (excludeRule, bool) {
+	pattern = strings.TrimRight(pattern, " \t\r")
+	if pattern == "" || strings.HasPrefix(pattern, "#") {
+		return excludeRule{}, false
+	}
+	r := excludeRule{base: filepath.ToSlash(base)}
+	if strings.HasPrefix(pattern, "!") {
+		r.negate = true
+		pattern = pattern[1:]
+	} else if strings.HasPrefix(pattern, `\!`) || strings.HasPrefix(pattern, `\#`) {
+		pattern = pattern[1:]
+	}
+	if strings.HasSuffix(pattern, "/") {
+		r.dirOnly = true
+		pattern = strings.TrimRight(pattern, "/")
+	}
+	if pattern == "" {
+		return excludeRule{}, false
+	}
+	expr := globRegexp(strings.TrimPrefix(pattern, "/"))
+	if !strings.Contains(pattern, "/") {
+		expr = "(?:.*/)?" + expr
+	}
+	r.re = regexp.MustCompile("^" + expr + "$")
+	return r, true
+}
+
+// configExcludeRules turns the exclude patterns of a job into rules for the include root. A
+// pattern starting with / is anchored at the filesystem root, so absolute paths keep excluding
+// that path and everything below it; other patterns are relative to root.
+func configExcludeRules(root string, patterns []string) []excludeRule {
+	var rules []excludeRule
+	for _, p := range patterns {
+		base := root
+		if strings.HasPrefix(filepath.ToSlash(p), "/") {
+			base = "/"
+		}
+		if r, ok := parseExcludeRule(base, filepath.ToSlash(p)); ok {
+			rules = append(rules, r)
+		}
+	}
+	return rules
+}
+
+// readIgnoreFile returns the rules of the ignore file in dir, if there is one.
+func readIgnoreFile(dir string) ([]excludeRule, error) {
+	f, err := os.Open(filepath.Join(dir, IgnoreFileName))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var rules []excludeRule
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		if r, ok := parseExcludeRule(dir, sc.Text()); ok {
+			rules = append(rules, r)
+		}
+	}
+	return rules, sc.Err()
+}
+
+func (r excludeRule) match(path string, isDir bool) bool {
+	if r.dirOnly && !isDir {
+		return false
+	}
+	path = filepath.ToSlash(path)
+	var rel string
+	if r.base == "/" {
+		rel = strings.TrimPrefix(path, "/")
+	} else {
+		var ok bool
+		if rel, ok = strings.CutPrefix(path, r.base+"/"); !ok {
+			return false
+		}
+	}
+	return r.re.MatchString(rel)
+}
+
+// excludedByRules reports whether the last rule matching path excludes it.
+func excludedByRules(rules []excludeRule, path string, isDir bool) bool {
+	excluded := false
+	for _, r := range rules {
+		if r.match(path, isDir) {
+			excluded = !r.negate
+		}
+	}
+	return excluded
+}
+
+// globRegexp translates a slash-separated glob into a regular expression: * and ? do not match
+// a slash, [...] is a character class ([!...] negated), \ quotes the next character, and **
+// as a whole path element matches any number of directories.
+func globRegexp(glob string) string {
+	var b strings.Builder
+	for i := 0; i < len(glob); i++ {
+		c := glob[i]
+		switch c {
+		case '*':
+			if i+1 < len(glob) && glob[i+1] == '*' {
+				end := i + 2
+				if i == 0 || glob[i-1] == '/' {
+					if end == len(glob) {
+						b.WriteString(".*")
+						i = end - 1
+						continue
+					}
+					if glob[end] == '/' {
+						b.WriteString("(?:.*/)?")
+						i = end
+						continue
+					}
+				}
+				i++
+			}
+			b.WriteString("[^/]*")
+		case '?':
+			b.WriteString("[^/]")
+		case '[':
+			end := classEnd(glob, i)
+			if end < 0 {
+				b.WriteString(`\[`)
+				continue
+			}
+			b.WriteByte('[')
+			class := glob[i+1 : end]
+			if strings.HasPrefix(class, "!") {
+				b.WriteByte('^')
+				class = class[1:]
+			}
+			for j := 0; j < len(class); j++ {
+				if ch := class[j]; ch == '\\' || ch == '[' || ch == ']' {
+					b.WriteByte('\\')
+				}
+				b.WriteByte(class[j])
+			}
+			b.WriteByte(']')
+			i = end
+		case '\\':
+			if i+1 < len(glob) {
+				i++
+			}
+			b.WriteString(regexp.QuoteMeta(string(glob[i])))
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	return b.String()
+}
+
+// classEnd returns the index of the ] closing the class opened at glob[open], or -1. A ]
+// right after [ or [! is part of the class.
+func classEnd(glob string, open int) int {
+	i := open + 1
+	if i < len(glob) && (glob[i] == '!' || glob[i] == '^') {
+		i++
+	}
+	if i < len(glob) && glob[i] == ']' {
+		i++
+	}
+	for ; i < len(glob); i++ {
+		if glob[i] == ']' {
+			return i
+		}
+	}
+	return -1
+}
@@ -3,16 +3,27 @@ package collector
 import (
 	"archive/tar"
 	"context"
+	"errors"
+	"fmt"
 	"io"
 	"os"
 	"path/filepath"
+	"regexp"
 	"strings"
 )
 
 type PathsOpts struct {
-	Include        []string
-	Exclude        []string
-	FollowSymlinks bool
+	Include []string
+	// Exclude holds gitignore-style patterns; see configExcludeRules. IgnoreFileName files in
+	// the walked directories add their own.
+	Exclude []string
+	// ExcludeRegex holds regular expressions matched against the absolute path.
+	ExcludeRegex []string
+	// ExcludeIfPresent skips directories that contain a file of one of these names.
+	ExcludeIfPresent []string
+	MaxFileSize      int64 // skip regular files larger than this many bytes; 0 = no limit
+	OneFileSystem    bool  // do not descend into directories on another filesystem than their include root
+	FollowSymlinks   bool
 }
 
 type FilesystemCollector struct {
@@ -23,13 +34,34 @@ func NewFilesystemCollector(opts PathsOpts) *FilesystemCollector {
 	return &FilesystemCollector{opts: opts}
 }
 
+func (c *FilesystemCollector) Name() string { return CollectorFilesystem }
+
 func (c *FilesystemCollector) Collect(ctx context.Context, jobName string, w io.Writer) error {
 	if len(c.opts.Include) == 0 {
 		return nil
 	}
-	tw := tar.NewWriter(w)
-	defer tw.Close()
+	return collectTar(ctx, c, jobName, w)
+}
+
+// walkState is what the walk of the include roots carries along.
+type walkState struct {
+	regex  []*regexp.Regexp
+	device uint64            // device of the include root, with OneFileSystem
+	links  map[fileID]string // entry name of the first path of each hard-linked file
+}
+
+// paxXattrPrefix starts the PAX record of an extended attribute, as written by GNU tar and bsdtar.
+const paxXattrPrefix = "SCHILY.xattr."
 
+func (c *FilesystemCollector) CollectTar(ctx context.Context, jobName string, tw *tar.Writer) error {
+	st := walkState{links: make(map[fileID]string)}
+	for _, expr := range c.opts.ExcludeRegex {
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return fmt.Errorf("exclude_regex %q: %w", expr, err)
+		}
+		st.regex = append(st.regex, re)
+	}
 	for _, root := range c.opts.Include {
 		root = filepath.Clean(root)
 		if root == "" || root == "." {
@@ -39,14 +71,35 @@ func (c *FilesystemCollector) Collect(ctx context.Context, jobName string, w io.
 		if err != nil {
 			return err
 		}
-		if err := c.walk(ctx, tw, absRoot, absRoot); err != nil {
+		if c.opts.OneFileSystem {
+			info, err := os.Stat(absRoot)
+			if err != nil {
+				return err
+			}
+			st.device, _ = deviceOf(info)
+		}
+		if skip, err := c.hasMarker(absRoot); err != nil {
+			return err
+		} else if skip {
+			continue
+		}
+		if err := c.walk(ctx, tw, &st, configExcludeRules(absRoot, c.opts.Exclude), absRoot); err != nil {
 			return err
 		}
 	}
 	return nil
 }
 
-func (c *FilesystemCollector) walk(ctx context.Context, tw *tar.Writer, root, dir string) error {
+// walk stores the entries of dir. rules are the exclude rules of the config and of the ignore
+// files above dir; those of dir's own ignore file are added here.
+func (c *FilesystemCollector) walk(ctx context.Context, tw *tar.Writer, st *walkState, rules []excludeRule, dir string) error {
+	own, err := readIgnoreFile(dir)
+	if err != nil {
+		return err
+	}
+	if len(own) > 0 {
+		rules = append(rules[:len(rules):len(rules)], own...)
+	}
 	entries, err := os.ReadDir(dir)
 	if err != nil {
 		return err
@@ -66,116 +119,134 @@ func (c *FilesystemCollector) walk(ctx context.Context, tw *tar.Writer, root, di
 		if tarName == "" || strings.HasPrefix(tarName, "..") {
 			continue
 		}
-		if c.excluded(full) {
-			if e.IsDir() {
-				continue
-			}
-			continue
-		}
 
 		info, err := e.Info()
 		if err != nil {
 			return err
 		}
-		mode := info.Mode()
-
-		if mode&os.ModeSymlink != 0 {
+		link, metaPath := "", full
+		if info.Mode()&os.ModeSymlink != 0 {
 			if !c.opts.FollowSymlinks {
-				link, err := os.Readlink(full)
-				if err != nil {
+				if link, err = os.Readlink(full); err != nil {
 					return err
 				}
-				hdr, err := tar.FileInfoHeader(info, link)
-				if err != nil {
+			} else {
+				if metaPath, err = filepath.EvalSymlinks(full); err != nil {
 					return err
 				}
-				hdr.Name = tarName
-				if err := tw.WriteHeader(hdr); err != nil {
+				if info, err = os.Stat(metaPath); err != nil {
 					return err
 				}
-				continue
 			}
+		}
+		if info.Mode()&(os.ModeSocket|os.ModeIrregular) != 0 {
+			continue // sockets cannot be archived
+		}
 
-			target, err := filepath.EvalSymlinks(full)
-			if err != nil {
+		if c.excluded(st, rules, absFull, info) {
+			continue
+		}
+		if info.IsDir() {
+			if skip, err := c.hasMarker(full); err != nil {
 				return err
+			} else if skip {
+				continue
 			}
-			info, err = os.Stat(target)
-			if err != nil {
+		}
+
+		// The header carries owner, mode and mtime; FIFOs and devices are stored as headers alone.
+		hdr, err := tar.FileInfoHeader(info, link)
+		if err != nil {
+			return err
+		}
+		hdr.Name = tarName
+		xattrs, err := readXattrs(metaPath)
+		if err != nil {
+			return fmt.Errorf("read xattrs of %s: %w", full, err)
+		}
+		for name, value := range xattrs {
+			if hdr.PAXRecords == nil {
+				hdr.PAXRecords = make(map[string]string, len(xattrs))
+			}
+			hdr.PAXRecords[paxXattrPrefix+name] = value
+		}
+		switch {
+		case info.IsDir():
+			hdr.Name += "/"
+			if err := tw.WriteHeader(hdr); err != nil {
 				return err
 			}
-			if info.IsDir() {
-				hdr, err := tar.FileInfoHeader(info, "")
-				if err != nil {
-					return err
-				}
-				hdr.Name = tarName + "/"
-				if err := tw.WriteHeader(hdr); err != nil {
-					return err
+			if c.opts.OneFileSystem {
+				// A mount point is stored, but not what is mounted on it.
+				if dev, ok := deviceOf(info); ok && dev != st.device {
+					continue
 				}
-				if err := c.walk(ctx, tw, root, full); err != nil {
-					return err
+			}
+			if err := c.walk(ctx, tw, st, rules, full); err != nil {
+				return err
+			}
+		case info.Mode().IsRegular():
+			if id, nlink, ok := inodeOf(info); ok && nlink > 1 {
+				if first, seen := st.links[id]; seen {
+					hdr.Typeflag, hdr.Linkname, hdr.Size = tar.TypeLink, first, 0
+					if err := tw.WriteHeader(hdr); err != nil {
+						return err
+					}
+					continue
 				}
-				continue
+				st.links[id] = tarName
 			}
-			mode = info.Mode()
-		}
-
-		if info.IsDir() {
-			hdr, err := tar.FileInfoHeader(info, "")
+			if err := tw.WriteHeader(hdr); err != nil {
+				return err
+			}
+			f, err := os.Open(full)
 			if err != nil {
 				return err
 			}
-			hdr.Name = tarName + "/"
-			if err := tw.WriteHeader(hdr); err != nil {
+			_, err = io.Copy(tw, f)
+			f.Close()
+			if err != nil {
 				return err
 			}
-			if err := c.walk(ctx, tw, root, full); err != nil {
+		default:
+			if err := tw.WriteHeader(hdr); err != nil {
 				return err
 			}
-			continue
-		}
-
-		if !info.Mode().IsRegular() {
-			continue
-		}
-		hdr, err := tar.FileInfoHeader(info, "")
-		if err != nil {
-			return err
-		}
-		hdr.Name = tarName
-		if err := tw.WriteHeader(hdr); err != nil {
-			return err
-		}
-		f, err := os.Open(full)
-		if err != nil {
-			return err
-		}
-		_, err = io.Copy(tw, f)
-		f.Close()
-		if err != nil {
-			return err
 		}
 	}
 	return nil
 }
 
-func (c *FilesystemCollector) excluded(path string) bool {
-	path = filepath.Clean(path)
-	for _, ex := range c.opts.Exclude {
-		ex = filepath.Clean(ex)
-		if ex == "" {
-			continue
+// excluded reports whether the entry at path is left out by the exclude patterns, the
+// regular expressions or the size limit.
+func (c *FilesystemCollector) excluded(st *walkState, rules []excludeRule, path string, info os.FileInfo) bool {
+	if excludedByRules(rules, path, info.IsDir()) {
+		return true
+	}
+	slashPath := filepath.ToSlash(path)
+	for _, re := range st.regex {
+		if re.MatchString(slashPath) {
+			return true
 		}
-		absEx, err := filepath.Abs(ex)
-		if err != nil {
-			continue
+	}
+	return c.opts.MaxFileSize > 0 && info.Mode().IsRegular() && info.Size() > c.opts.MaxFileSize
+}
+
+// hasMarker reports whether dir contains one of the ExcludeIfPresent files.
+func (c *FilesystemCollector) hasMarker(dir string) (bool, error) {
+	for _, name := range c.opts.ExcludeIfPresent {
+		_, err := os.Lstat(filepath.Join(dir, name))
+		if err == nil {
+			return true, nil
 		}
-		if path == absEx || strings.HasPrefix(path, absEx+string(filepath.Separator)) {
-			return true
+		if !errors.Is(err, os.ErrNotExist) {
+			return false, err
 		}
 	}
-	return false
+	return false, nil
 }
 
-var _ Collector = (*FilesystemCollector)(nil)
+var (
+	_ Collector    = (*FilesystemCollector)(nil)
+	_ TarCollector = (*FilesystemCollector)(nil)
+)
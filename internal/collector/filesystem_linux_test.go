@@ -0,0 +1,69 @@
+//go:build linux
+
+package collector
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestFilesystemCollector_SpecialFilesAndMetadata(t *testing.T) {
+	dir := t.TempDir()
+	writeTree(t, dir, map[string]string{"cert.pem": "PEM"})
+	if err := os.Link(filepath.Join(dir, "cert.pem"), filepath.Join(dir, "cert-link.pem")); err != nil {
+		t.Fatal(err)
+	}
+	if err := unix.Mkfifo(filepath.Join(dir, "pipe"), 0o640); err != nil {
+		t.Fatal(err)
+	}
+	xattrs := true
+	if err := unix.Setxattr(filepath.Join(dir, "cert.pem"), "user.velbackuper", []byte("v\x00alue"), 0); errors.Is(err, unix.ENOTSUP) {
+		xattrs = false
+	} else if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := NewFilesystemCollector(PathsOpts{Include: []string{dir}}).Collect(context.Background(), "job", &buf); err != nil {
+		t.Fatal(err)
+	}
+	prefix := strings.TrimPrefix(filepath.ToSlash(dir), "/") + "/"
+	headers := make(map[string]*tar.Header)
+	tr := tar.NewReader(&buf)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		headers[strings.TrimPrefix(hdr.Name, prefix)] = hdr
+	}
+
+	// ReadDir returns names sorted, so cert-link.pem is stored first and cert.pem links to it.
+	first, second := headers["cert-link.pem"], headers["cert.pem"]
+	if first == nil || second == nil || first.Typeflag != tar.TypeReg || second.Typeflag != tar.TypeLink || second.Linkname != first.Name {
+		t.Fatalf("hard link not stored as a link: %+v / %+v", first, second)
+	}
+	if h := headers["pipe"]; h == nil || h.Typeflag != tar.TypeFifo || h.Mode&0o777 != 0o640 {
+		t.Errorf("FIFO header = %+v", h)
+	}
+	if h := headers["cert-link.pem"]; h.Uid != os.Getuid() || h.ModTime.IsZero() {
+		t.Errorf("owner or mtime missing: uid %d, mtime %v", h.Uid, h.ModTime)
+	}
+	if xattrs {
+		if got := first.PAXRecords["SCHILY.xattr.user.velbackuper"]; got != "v\x00alue" {
+			t.Errorf("xattr record = %q", got)
+		}
+	}
+}
@@ -0,0 +1,17 @@
+//go:build !unix
+
+package collector
+
+import "os"
+
+// deviceOf is not known here, so one_file_system has no effect.
+func deviceOf(info os.FileInfo) (uint64, bool) {
+	return 0, false
+}
+
+type fileID struct{}
+
+// inodeOf is not known here, so hard links are stored as separate copies.
+func inodeOf(info os.FileInfo) (fileID, uint64, bool) {
+	return fileID{}, 0, false
+}
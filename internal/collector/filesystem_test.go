@@ -0,0 +1,138 @@
+package collector
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// writeTree creates files (with "/"-separated names relative to dir) holding their contents.
+func writeTree(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, body := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(body), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// relativeEntries returns the names of the entries below dir, relative to it and sorted.
+func relativeEntries(t *testing.T, dir string, c Collector) []string {
+	t.Helper()
+	prefix := strings.TrimPrefix(filepath.ToSlash(dir), "/") + "/"
+	var names []string
+	for name := range collectEntries(t, c) {
+		names = append(names, strings.TrimPrefix(name, prefix))
+	}
+	slices.Sort(names)
+	return names
+}
+
+func TestFilesystemCollector_ExcludePatterns(t *testing.T) {
+	dir := t.TempDir()
+	writeTree(t, dir, map[string]string{
+		"app/index.js":                      "x",
+		"app/node_modules/lib/index.js":     "x",
+		"app/debug.log":                     "x",
+		"app/keep.log":                      "x",
+		"app/cache/page.html":               "x",
+		"app/src/cache":                     "a file, not a directory",
+		"app/tmp/a/b/c.tmp":                 "x",
+		"app/vendor/node_modules/x/y.js":    "x",
+		"absolute/secret.key":               "x",
+		"absolute/public.pem":               "x",
+		"regex/2024-01-01.sql.gz":           "x",
+		"regex/readme.txt":                  "x",
+		"ignored-by-file/.velbackupignore":  "# build output\n*.o\n/only-here.txt\n",
+		"ignored-by-file/main.o":            "x",
+		"ignored-by-file/main.c":            "x",
+		"ignored-by-file/only-here.txt":     "x",
+		"ignored-by-file/sub/only-here.txt": "x",
+		"ignored-by-file/sub/util.o":        "x",
+	})
+	c := NewFilesystemCollector(PathsOpts{
+		Include: []string{dir},
+		Exclude: []string{
+			"**/node_modules", "*.log", "!keep.log", "cache/", "app/tmp/**",
+			filepath.ToSlash(filepath.Join(dir, "absolute", "secret.key")),
+		},
+		ExcludeRegex: []string{`\.sql\.gz$`},
+	})
+	got := relativeEntries(t, dir, c)
+	want := []string{
+		"absolute/", "absolute/public.pem",
+		"app/", "app/index.js", "app/keep.log", "app/src/", "app/src/cache", "app/tmp/", "app/vendor/",
+		"ignored-by-file/", "ignored-by-file/.velbackupignore", "ignored-by-file/main.c",
+		"ignored-by-file/sub/", "ignored-by-file/sub/only-here.txt",
+		"regex/", "regex/readme.txt",
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("entries:\n got %v\nwant %v", got, want)
+	}
+}
+
+func TestFilesystemCollector_MarkerAndSizeLimit(t *testing.T) {
+	dir := t.TempDir()
+	writeTree(t, dir, map[string]string{
+		"small.txt":          "12345",
+		"big.bin":            strings.Repeat("x", 2048),
+		"scratch/.nobackup":  "",
+		"scratch/huge.img":   "x",
+		"projects/a/main.go": "x",
+	})
+	other := t.TempDir()
+	writeTree(t, other, map[string]string{".nobackup": "", "x": "x"})
+
+	c := NewFilesystemCollector(PathsOpts{
+		Include:          []string{dir, other},
+		ExcludeIfPresent: []string{".nobackup"},
+		MaxFileSize:      1024,
+		OneFileSystem:    true,
+	})
+	got := relativeEntries(t, dir, c)
+	want := []string{"projects/", "projects/a/", "projects/a/main.go", "small.txt"}
+	if !slices.Equal(got, want) {
+		t.Errorf("entries:\n got %v\nwant %v", got, want)
+	}
+}
+
+func TestGlobRegexp(t *testing.T) {
+	tests := []struct {
+		pattern string
+		match   []string
+		noMatch []string
+	}{
+		{"*.log", []string{"a.log", "x/y/a.log"}, []string{"a.log.1", "log"}},
+		{"/build", []string{"build"}, []string{"x/build"}},
+		{"docs/*.md", []string{"docs/a.md"}, []string{"docs/x/a.md", "x/docs/a.md"}},
+		{"**/tmp", []string{"tmp", "a/b/tmp"}, []string{"tmpx"}},
+		{"a/**/b", []string{"a/b", "a/x/b", "a/x/y/b"}, []string{"ab", "x/a/b"}},
+		{"logs/**", []string{"logs/a", "logs/a/b"}, []string{"logs"}},
+		{"file[0-9].txt", []string{"file1.txt"}, []string{"filea.txt"}},
+		{"file[!0-9].txt", []string{"filea.txt"}, []string{"file1.txt"}},
+		{`\#notes`, []string{"#notes"}, nil},
+		{"a?c", []string{"abc"}, []string{"a/c"}},
+	}
+	for _, tt := range tests {
+		r, ok := parseExcludeRule("/base", tt.pattern)
+		if !ok {
+			t.Fatalf("parseExcludeRule(%q) gave no rule", tt.pattern)
+		}
+		for _, p := range tt.match {
+			if !r.match("/base/"+p, false) {
+				t.Errorf("%q does not match %q", tt.pattern, p)
+			}
+		}
+		for _, p := range tt.noMatch {
+			if r.match("/base/"+p, false) {
+				t.Errorf("%q matches %q", tt.pattern, p)
+			}
+		}
+	}
+}
@@ -0,0 +1,31 @@
+//go:build unix
+
+package collector
+
+import (
+	"os"
+	"syscall"
+)
+
+// deviceOf returns the device of the filesystem info was read from.
+func deviceOf(info os.FileInfo) (uint64, bool) {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, false
+	}
+	return uint64(st.Dev), true
+}
+
+// fileID identifies a file across hard links.
+type fileID struct {
+	dev, ino uint64
+}
+
+// inodeOf returns the identity of the file info was read from and its link count.
+func inodeOf(info os.FileInfo) (fileID, uint64, bool) {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return fileID{}, 0, false
+	}
+	return fileID{dev: uint64(st.Dev), ino: uint64(st.Ino)}, uint64(st.Nlink), true
+}
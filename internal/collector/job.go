@@ -1,52 +1,110 @@
 package collector
 
 import (
-	"time"
-
 	"VelBackuper/internal/config"
 )
 
-// CollectorFromJobConfig builds a CompositeCollector from a job config (MySQL + Presets + Paths).
+// CollectorFromJobConfig builds a CompositeCollector from a job config (MySQL + PostgreSQL + Commands + Presets + Paths).
 // Returns nil if the job has no sources configured.
 func CollectorFromJobConfig(job *config.JobConfig) *CompositeCollector {
 	var collectors []Collector
 
 	if job.MySQL != nil && job.MySQL.Enabled {
+		m := job.MySQL
 		opts := MySQLOpts{
-			DumpAll:           job.MySQL.DumpAll,
-			ExcludeSystem:     job.MySQL.ExcludeSystem,
-			OneFilePerDB:      job.MySQL.OneFilePerDB,
+			DumpAll:           m.DumpAll,
+			ExcludeSystem:     m.ExcludeSystem,
+			OneFilePerDB:      m.OneFilePerDB,
+			Databases:         m.Databases,
+			ExcludeDatabases:  m.ExcludeDatabases,
+			ExcludeTables:     m.ExcludeTables,
+			Host:              m.Host,
+			Port:              m.Port,
+			User:              m.User,
+			PasswordFile:      m.PasswordFile,
+			Socket:            m.Socket,
+			DefaultsFile:      m.DefaultsFile,
 			SingleTransaction: true,
 			Routines:          true,
 			Events:            true,
-			Timeout:           30 * time.Minute,
+			Timeout:           config.MySQLTimeout(m),
+			SpoolDir:          job.SpoolDir,
+		}
+		if m.TLS != nil {
+			opts.TLS = MySQLTLSOpts{Mode: m.TLS.Mode, CA: m.TLS.CA, Cert: m.TLS.Cert, Key: m.TLS.Key}
 		}
-		if job.MySQL.Options != nil {
-			opts.SingleTransaction = job.MySQL.Options.SingleTransaction
-			opts.Routines = job.MySQL.Options.Routines
-			opts.Events = job.MySQL.Options.Events
+		if m.Options != nil {
+			opts.SingleTransaction = m.Options.SingleTransaction
+			opts.Routines = m.Options.Routines
+			opts.Events = m.Options.Events
 		}
 		collectors = append(collectors, NewMySQLCollector(opts))
 	}
 
+	if job.Postgres != nil && job.Postgres.Enabled {
+		p := job.Postgres
+		collectors = append(collectors, NewPostgresCollector(PostgresOpts{
+			Globals:          p.Globals,
+			Databases:        p.Databases,
+			ExcludeDatabases: p.ExcludeDatabases,
+			Host:             p.Host,
+			Port:             p.Port,
+			User:             p.User,
+			PasswordFile:     p.PasswordFile,
+			Socket:           p.Socket,
+			Timeout:          config.PostgresTimeout(p),
+			SpoolDir:         job.SpoolDir,
+		}))
+	}
+
+	if len(job.Commands) > 0 {
+		opts := make([]CommandOpts, 0, len(job.Commands))
+		for i := range job.Commands {
+			c := &job.Commands[i]
+			opts = append(opts, CommandOpts{
+				Name:              c.Name,
+				Command:           c.Command,
+				Timeout:           config.CommandTimeout(c),
+				User:              c.User,
+				Env:               c.Env,
+				ExpectedExitCodes: c.ExpectedExitCodes,
+				SpoolDir:          job.SpoolDir,
+			})
+		}
+		collectors = append(collectors, NewCommandsCollector(opts...))
+	}
+
 	if job.Presets != nil && (job.Presets.Nginx || job.Presets.Apache || job.Presets.LetsEncrypt) {
+		p := job.Presets
 		collectors = append(collectors, NewPresetsCollector(PresetsOpts{
-			Nginx:       job.Presets.Nginx,
-			Apache:      job.Presets.Apache,
-			LetsEncrypt: job.Presets.LetsEncrypt,
+			Nginx:            p.Nginx,
+			Apache:           p.Apache,
+			LetsEncrypt:      p.LetsEncrypt,
+			Exclude:          p.Exclude,
+			ExcludeRegex:     p.ExcludeRegex,
+			ExcludeIfPresent: p.ExcludeIfPresent,
+			MaxFileSize:      config.MaxFileSizeBytes(p.MaxFileSizeMB),
+			OneFileSystem:    p.OneFileSystem,
 		}))
 	}
 
 	if job.Paths != nil && len(job.Paths.Include) > 0 {
+		p := job.Paths
 		collectors = append(collectors, NewFilesystemCollector(PathsOpts{
-			Include:        job.Paths.Include,
-			Exclude:        job.Paths.Exclude,
-			FollowSymlinks: job.Paths.FollowSymlinks,
+			Include:          p.Include,
+			Exclude:          p.Exclude,
+			ExcludeRegex:     p.ExcludeRegex,
+			ExcludeIfPresent: p.ExcludeIfPresent,
+			MaxFileSize:      config.MaxFileSizeBytes(p.MaxFileSizeMB),
+			OneFileSystem:    p.OneFileSystem,
+			FollowSymlinks:   p.FollowSymlinks,
 		}))
 	}
 
 	if len(collectors) == 0 {
 		return nil
 	}
-	return NewCompositeCollector(collectors...)
+	c := NewCompositeCollector(collectors...)
+	c.spoolDir = job.SpoolDir
+	return c
 }
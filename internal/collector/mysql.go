@@ -1,6 +1,7 @@
 package collector
 
 import (
+	"archive/tar"
 	"bufio"
 	"context"
 	"fmt"
@@ -8,6 +9,7 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -15,15 +17,35 @@ import (
 var defaultExcludeSystem = []string{"information_schema", "performance_schema", "sys"}
 
 type MySQLOpts struct {
-	DumpAll           bool
-	ExcludeSystem     bool
-	OneFilePerDB      bool
-	Socket            string // optional; auto-detect if empty
-	DefaultsFile      string // e.g. ~/.my.cnf
+	DumpAll       bool
+	ExcludeSystem bool
+	OneFilePerDB  bool
+	// Databases, if set, are dumped instead of all databases.
+	Databases        []string
+	ExcludeDatabases []string
+	ExcludeTables    []string // db.table
+
+	Host         string // connect over TCP; empty = local socket
+	Port         int
+	User         string
+	PasswordFile string
+	Socket       string // optional; auto-detect if empty and Host is not set
+	DefaultsFile string // e.g. ~/.my.cnf
+	TLS          MySQLTLSOpts
+
 	SingleTransaction bool
 	Routines          bool
 	Events            bool
 	Timeout           time.Duration
+	SpoolDir          string // dumps are staged here; empty = os.TempDir()
+}
+
+// MySQLTLSOpts configures TLS to the server. Mode is one of the config.MySQLTLS* modes.
+type MySQLTLSOpts struct {
+	Mode string
+	CA   string
+	Cert string
+	Key  string
 }
 
 type MySQLCollector struct {
@@ -34,7 +56,21 @@ func NewMySQLCollector(opts MySQLOpts) *MySQLCollector {
 	return &MySQLCollector{opts: opts}
 }
 
+func (c *MySQLCollector) Name() string { return CollectorMySQL }
+
+// MySQLEntryDir is the directory of the archive holding SQL dumps.
+const MySQLEntryDir = "mysql"
+
+// mysqlAllDatabasesEntry names the single dump written when OneFilePerDB is off.
+const mysqlAllDatabasesEntry = "all-databases"
+
 func (c *MySQLCollector) Collect(ctx context.Context, jobName string, w io.Writer) error {
+	return collectTar(ctx, c, jobName, w)
+}
+
+// CollectTar stores the dump as mysql/<db>.sql per database when OneFilePerDB is set,
+// otherwise as a single mysql/all-databases.sql.
+func (c *MySQLCollector) CollectTar(ctx context.Context, jobName string, tw *tar.Writer) error {
 	mysqldump, err := exec.LookPath("mysqldump")
 	if err != nil {
 		return fmt.Errorf("mysqldump not found: %w", err)
@@ -47,9 +83,21 @@ func (c *MySQLCollector) Collect(ctx context.Context, jobName string, w io.Write
 		defer cancel()
 	}
 
+	conn, cleanup, err := c.connectionArgs()
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+
 	var databases []string
-	if c.opts.DumpAll && c.opts.ExcludeSystem {
-		databases, err = c.listDatabases(runCtx)
+	switch {
+	case len(c.opts.Databases) > 0:
+		databases = c.filterDatabases(c.opts.Databases, false)
+		if len(databases) == 0 {
+			return nil
+		}
+	case c.opts.OneFilePerDB || (c.opts.DumpAll && (c.opts.ExcludeSystem || len(c.opts.ExcludeDatabases) > 0)):
+		databases, err = c.listDatabases(runCtx, conn)
 		if err != nil {
 			return fmt.Errorf("list databases: %w", err)
 		}
@@ -58,29 +106,144 @@ func (c *MySQLCollector) Collect(ctx context.Context, jobName string, w io.Write
 		}
 	}
 
-	args := c.buildArgs(databases)
-	cmd := exec.CommandContext(runCtx, mysqldump, args...)
-	cmd.Stdout = w
-	cmd.Stderr = io.Discard
+	if !c.opts.OneFilePerDB {
+		return c.dump(runCtx, tw, mysqldump, mysqlAllDatabasesEntry, c.buildArgs(conn, databases))
+	}
+	for _, db := range databases {
+		if err := c.dump(runCtx, tw, mysqldump, db, c.buildArgs(conn, []string{db})); err != nil {
+			return err
+		}
+	}
+	return nil
+}
 
-	if err := cmd.Run(); err != nil {
-		if runCtx.Err() != nil {
-			return runCtx.Err()
+// dump runs mysqldump with args and stores its output as mysql/<name>.sql.
+func (c *MySQLCollector) dump(ctx context.Context, tw *tar.Writer, mysqldump, name string, args []string) error {
+	entry := MySQLEntryDir + "/" + name + ".sql"
+	var stderr stderrBuffer
+	err := writeSpooledEntry(ctx, tw, c.opts.SpoolDir, entry, func(w io.Writer) error {
+		cmd := exec.CommandContext(ctx, mysqldump, args...)
+		cmd.Stdout = w
+		cmd.Stderr = &stderr
+		return cmd.Run()
+	})
+	if err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
 		}
-		return fmt.Errorf("mysqldump: %w", err)
+		return fmt.Errorf("mysqldump %s: %w", name, commandError(err, stderr.String()))
 	}
 	return nil
 }
 
-func (c *MySQLCollector) buildArgs(databases []string) []string {
-	var args []string
+// connectionArgs returns the arguments that tell mysql and mysqldump where and how to
+// connect. Credentials, host and TLS settings go into a private option file, removed by
+// cleanup, so that the password is not visible in the process list.
+func (c *MySQLCollector) connectionArgs() (args []string, cleanup func(), err error) {
+	cleanup = func() {}
+	o := c.opts
+	if o.Host == "" && o.Port == 0 && o.User == "" && o.PasswordFile == "" && o.TLS == (MySQLTLSOpts{}) {
+		if o.DefaultsFile != "" {
+			args = append(args, "--defaults-extra-file="+expandHome(o.DefaultsFile))
+		}
+		if socket := c.socket(); socket != "" {
+			args = append(args, "--socket="+socket)
+		}
+		return args, cleanup, nil
+	}
 
-	if c.opts.DefaultsFile != "" {
-		args = append(args, "--defaults-extra-file="+expandHome(c.opts.DefaultsFile))
+	body, err := c.optionFile()
+	if err != nil {
+		return nil, cleanup, err
+	}
+	f, err := os.CreateTemp("", "velbackuper-mysql-*.cnf")
+	if err != nil {
+		return nil, cleanup, fmt.Errorf("create option file: %w", err)
+	}
+	cleanup = func() { _ = os.Remove(f.Name()) }
+	_, err = f.WriteString(body)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		cleanup()
+		return nil, func() {}, fmt.Errorf("write option file: %w", err)
 	}
-	if socket := c.socket(); socket != "" {
-		args = append(args, "--socket="+socket)
+	return []string{"--defaults-extra-file=" + f.Name()}, cleanup, nil
+}
+
+// optionFile renders the [client] option group for the configured connection. A configured
+// defaults file is included first so that these settings override it. Options only one of
+// MySQL and MariaDB knows are prefixed with loose- so that the other ignores them.
+func (c *MySQLCollector) optionFile() (string, error) {
+	o := c.opts
+	var b strings.Builder
+	if o.DefaultsFile != "" {
+		if strings.ContainsAny(o.DefaultsFile, "\r\n") {
+			return "", fmt.Errorf("mysql defaults_file must not contain a line break")
+		}
+		fmt.Fprintf(&b, "!include %s\n", expandHome(o.DefaultsFile))
 	}
+	b.WriteString("[client]\n")
+	// Values are double-quoted with \ and " escaped. A value with a line break is refused
+	// rather than written, so that it can never end up cut short.
+	var badOption string
+	option := func(name, value string) {
+		if strings.ContainsAny(value, "\r\n") {
+			if badOption == "" {
+				badOption = name
+			}
+			return
+		}
+		fmt.Fprintf(&b, "%s=\"%s\"\n", name, optionValueEscaper.Replace(value))
+	}
+	if o.User != "" {
+		option("user", o.User)
+	}
+	if o.PasswordFile != "" {
+		data, err := os.ReadFile(expandHome(o.PasswordFile))
+		if err != nil {
+			return "", fmt.Errorf("read password file: %w", err)
+		}
+		option("password", strings.TrimRight(string(data), "\r\n"))
+	}
+	if o.Host != "" {
+		option("host", o.Host)
+		option("protocol", "tcp")
+	} else if socket := c.socket(); socket != "" {
+		option("socket", socket)
+	}
+	if o.Port > 0 {
+		option("port", strconv.Itoa(o.Port))
+	}
+	if o.TLS.Mode != "" {
+		mode := strings.ToUpper(o.TLS.Mode)
+		option("loose-ssl-mode", mode)
+		switch mode {
+		case "DISABLED":
+			option("loose-ssl", "FALSE")
+		case "REQUIRED":
+			option("loose-ssl", "TRUE")
+		case "VERIFY_CA", "VERIFY_IDENTITY":
+			option("loose-ssl", "TRUE")
+			option("loose-ssl-verify-server-cert", "TRUE")
+		}
+	}
+	for _, f := range []struct{ name, path string }{{"ssl-ca", o.TLS.CA}, {"ssl-cert", o.TLS.Cert}, {"ssl-key", o.TLS.Key}} {
+		if f.path != "" {
+			option(f.name, expandHome(f.path))
+		}
+	}
+	if badOption != "" {
+		return "", fmt.Errorf("mysql %s must not contain a line break", badOption)
+	}
+	return b.String(), nil
+}
+
+var optionValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`)
+
+func (c *MySQLCollector) buildArgs(conn, databases []string) []string {
+	args := append([]string(nil), conn...)
 	if c.opts.SingleTransaction {
 		args = append(args, "--single-transaction")
 	}
@@ -91,8 +254,11 @@ func (c *MySQLCollector) buildArgs(databases []string) []string {
 		args = append(args, "--events")
 	}
 	args = append(args, "--no-tablespaces")
+	for _, t := range c.opts.ExcludeTables {
+		args = append(args, "--ignore-table="+t)
+	}
 
-	if c.opts.DumpAll {
+	if c.opts.DumpAll || len(databases) > 0 {
 		if len(databases) > 0 {
 			args = append(args, "--databases")
 			args = append(args, databases...)
@@ -104,37 +270,55 @@ func (c *MySQLCollector) buildArgs(databases []string) []string {
 	return args
 }
 
-func (c *MySQLCollector) listDatabases(ctx context.Context) ([]string, error) {
+// listDatabases returns the server's databases that filterDatabases keeps.
+func (c *MySQLCollector) listDatabases(ctx context.Context, conn []string) ([]string, error) {
 	mysql, err := exec.LookPath("mysql")
 	if err != nil {
 		return nil, fmt.Errorf("mysql not found: %w", err)
 	}
-	var args []string
-	if c.opts.DefaultsFile != "" {
-		args = append(args, "--defaults-extra-file="+expandHome(c.opts.DefaultsFile))
-	}
-	if socket := c.socket(); socket != "" {
-		args = append(args, "--socket="+socket)
-	}
-	args = append(args, "-N", "-e", "SELECT schema_name FROM information_schema.schemata")
+	args := append(append([]string(nil), conn...), "-N", "-e", "SELECT schema_name FROM information_schema.schemata")
 	cmd := exec.CommandContext(ctx, mysql, args...)
 	out, err := cmd.Output()
 	if err != nil {
+		return nil, commandError(err, "")
+	}
+	var list []string
+	sc := bufio.NewScanner(strings.NewReader(string(out)))
+	for sc.Scan() {
+		if db := strings.TrimSpace(sc.Text()); db != "" {
+			list = append(list, db)
+		}
+	}
+	if err := sc.Err(); err != nil {
 		return nil, err
 	}
+	return c.filterDatabases(list, true), nil
+}
+
+// filterDatabases drops ExcludeDatabases from databases. Listed databases also lose
+// information_schema and performance_schema, which are never dumped (mysqldump
+// --all-databases skips them too), and with ExcludeSystem sys as well.
+func (c *MySQLCollector) filterDatabases(databases []string, listed bool) []string {
 	exclude := make(map[string]bool)
-	for _, db := range defaultExcludeSystem {
+	if listed {
+		exclude["information_schema"] = true
+		exclude["performance_schema"] = true
+		if c.opts.ExcludeSystem {
+			for _, db := range defaultExcludeSystem {
+				exclude[db] = true
+			}
+		}
+	}
+	for _, db := range c.opts.ExcludeDatabases {
 		exclude[db] = true
 	}
 	var list []string
-	sc := bufio.NewScanner(strings.NewReader(string(out)))
-	for sc.Scan() {
-		db := strings.TrimSpace(sc.Text())
-		if db != "" && !exclude[db] {
+	for _, db := range databases {
+		if !exclude[db] {
 			list = append(list, db)
 		}
 	}
-	return list, sc.Err()
+	return list
 }
 
 func expandHome(path string) string {
@@ -169,4 +353,7 @@ func (c *MySQLCollector) socket() string {
 	return ""
 }
 
-var _ Collector = (*MySQLCollector)(nil)
+var (
+	_ Collector    = (*MySQLCollector)(nil)
+	_ TarCollector = (*MySQLCollector)(nil)
+)
@@ -0,0 +1,170 @@
+package collector
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// fakeMySQLTools puts mysqldump and mysql scripts first on PATH. mysqldump prints the option
+// file it was given and its arguments, then fails with $FAKE_MYSQLDUMP_FAIL on stderr if set;
+// mysql lists a fixed set of databases.
+func fakeMySQLTools(t *testing.T) {
+	t.Helper()
+	dir := t.TempDir()
+	scripts := map[string]string{
+		"mysqldump": `#!/bin/sh
+for a in "$@"; do
+	case "$a" in --defaults-extra-file=*) cat "${a#--defaults-extra-file=}" ;; esac
+	echo "arg $a"
+done
+if [ -n "$FAKE_MYSQLDUMP_FAIL" ]; then echo "$FAKE_MYSQLDUMP_FAIL" >&2; exit 2; fi
+`,
+		"mysql": `#!/bin/sh
+printf 'information_schema\nperformance_schema\nsys\nshop\nblog\nscratch\n'
+`,
+	}
+	for name, body := range scripts {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(body), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+	t.Setenv("FAKE_MYSQLDUMP_FAIL", "")
+}
+
+func collectEntries(t *testing.T, c Collector) map[string]string {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := c.Collect(context.Background(), "job", &buf); err != nil {
+		t.Fatal(err)
+	}
+	entries := make(map[string]string)
+	tr := tar.NewReader(&buf)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return entries
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := io.ReadAll(tr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		entries[hdr.Name] = string(body)
+	}
+}
+
+func TestMySQLCollector_RemoteConnectionUsesOptionFile(t *testing.T) {
+	fakeMySQLTools(t)
+	passFile := filepath.Join(t.TempDir(), "mysql.pass")
+	if err := os.WriteFile(passFile, []byte("s3cret\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	c := NewMySQLCollector(MySQLOpts{
+		DumpAll:      true,
+		Host:         "db.example.com",
+		Port:         3307,
+		User:         "backup",
+		PasswordFile: passFile,
+		TLS:          MySQLTLSOpts{Mode: "verify_identity", CA: "/etc/ssl/ca.pem"},
+	})
+	dump := collectEntries(t, c)["mysql/all-databases.sql"]
+
+	for _, want := range []string{`user="backup"`, `password="s3cret"`, `host="db.example.com"`, `port="3307"`,
+		`protocol="tcp"`, `loose-ssl-mode="VERIFY_IDENTITY"`, `ssl-ca="/etc/ssl/ca.pem"`, "arg --all-databases"} {
+		if !strings.Contains(dump, want) {
+			t.Errorf("dump does not contain %s:\n%s", want, dump)
+		}
+	}
+	for _, line := range strings.Split(dump, "\n") {
+		if strings.HasPrefix(line, "arg ") && strings.Contains(line, "s3cret") {
+			t.Errorf("password passed on the command line: %s", line)
+		}
+		if strings.HasPrefix(line, "arg --defaults-extra-file=") {
+			if _, err := os.Stat(strings.TrimPrefix(line, "arg --defaults-extra-file=")); !os.IsNotExist(err) {
+				t.Errorf("option file was not removed (stat: %v)", err)
+			}
+		}
+	}
+}
+
+func TestMySQLCollector_OptionFileEscapesValues(t *testing.T) {
+	passFile := filepath.Join(t.TempDir(), "mysql.pass")
+	if err := os.WriteFile(passFile, []byte(`pa"ss\word`+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	c := NewMySQLCollector(MySQLOpts{Host: "db.example.com", User: `o"brien`, PasswordFile: passFile})
+	body, err := c.optionFile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{`password="pa\"ss\\word"` + "\n", `user="o\"brien"` + "\n"} {
+		if !strings.Contains(body, want) {
+			t.Errorf("option file does not contain %s:\n%s", want, body)
+		}
+	}
+
+	if err := os.WriteFile(passFile, []byte("line1\nline2\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.optionFile(); err == nil || !strings.Contains(err.Error(), "password") || strings.Contains(err.Error(), "line1") {
+		t.Errorf("optionFile() err = %v, want a line break error naming the password option only", err)
+	}
+}
+
+func TestMySQLCollector_FiltersDatabasesAndTables(t *testing.T) {
+	fakeMySQLTools(t)
+	c := NewMySQLCollector(MySQLOpts{
+		DumpAll:          true,
+		ExcludeSystem:    true,
+		OneFilePerDB:     true,
+		ExcludeDatabases: []string{"scratch"},
+		ExcludeTables:    []string{"shop.sessions"},
+		Socket:           "/run/mysqld/mysqld.sock",
+	})
+	entries := collectEntries(t, c)
+	if len(entries) != 2 || entries["mysql/shop.sql"] == "" || entries["mysql/blog.sql"] == "" {
+		t.Fatalf("entries = %v, want mysql/shop.sql and mysql/blog.sql", entryNames(entries))
+	}
+	shop := entries["mysql/shop.sql"]
+	for _, want := range []string{"arg --socket=/run/mysqld/mysqld.sock", "arg --ignore-table=shop.sessions", "arg --databases\narg shop\n"} {
+		if !strings.Contains(shop, want) {
+			t.Errorf("shop dump does not contain %q:\n%s", want, shop)
+		}
+	}
+}
+
+func TestMySQLCollector_ExplicitDatabases(t *testing.T) {
+	fakeMySQLTools(t)
+	c := NewMySQLCollector(MySQLOpts{Databases: []string{"shop", "blog"}, ExcludeDatabases: []string{"blog"}})
+	dump := collectEntries(t, c)["mysql/all-databases.sql"]
+	if !strings.Contains(dump, "arg --databases\narg shop\n") || strings.Contains(dump, "arg blog") {
+		t.Errorf("dump args:\n%s", dump)
+	}
+}
+
+func TestMySQLCollector_ErrorIncludesStderr(t *testing.T) {
+	fakeMySQLTools(t)
+	t.Setenv("FAKE_MYSQLDUMP_FAIL", "mysqldump: Got error: 1045: Access denied for user 'backup'")
+	c := NewMySQLCollector(MySQLOpts{DumpAll: true})
+	err := c.Collect(context.Background(), "job", io.Discard)
+	if err == nil || !strings.Contains(err.Error(), "Access denied for user 'backup'") {
+		t.Fatalf("Collect = %v, want the mysqldump stderr in the error", err)
+	}
+}
+
+func entryNames(m map[string]string) []string {
+	var out []string
+	for k := range m {
+		out = append(out, k)
+	}
+	return out
+}
@@ -0,0 +1,212 @@
+package collector
+
+import (
+	"archive/tar"
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type PostgresOpts struct {
+	// Globals also dumps roles and tablespaces with pg_dumpall --globals-only.
+	Globals bool
+	// Databases, if set, are dumped instead of every database that accepts connections.
+	Databases        []string
+	ExcludeDatabases []string
+
+	Host         string // connect over TCP; empty = local socket
+	Port         int
+	User         string
+	PasswordFile string
+	Socket       string // socket directory; empty = libpq default
+	Timeout      time.Duration
+	SpoolDir     string // dumps are staged here; empty = os.TempDir()
+}
+
+type PostgresCollector struct {
+	opts PostgresOpts
+}
+
+func NewPostgresCollector(opts PostgresOpts) *PostgresCollector {
+	return &PostgresCollector{opts: opts}
+}
+
+func (c *PostgresCollector) Name() string { return CollectorPostgres }
+
+// PostgresEntryDir is the directory of the archive holding PostgreSQL dumps.
+const PostgresEntryDir = "postgres"
+
+// postgresGlobalsEntry names the pg_dumpall --globals-only output.
+const postgresGlobalsEntry = "globals.sql"
+
+func (c *PostgresCollector) Collect(ctx context.Context, jobName string, w io.Writer) error {
+	return collectTar(ctx, c, jobName, w)
+}
+
+// CollectTar stores each database as postgres/<db>.dump (pg_dump custom format, restore with
+// pg_restore) and, with Globals, roles and tablespaces as postgres/globals.sql.
+func (c *PostgresCollector) CollectTar(ctx context.Context, jobName string, tw *tar.Writer) error {
+	pgDump, err := exec.LookPath("pg_dump")
+	if err != nil {
+		return fmt.Errorf("pg_dump not found: %w", err)
+	}
+
+	runCtx := ctx
+	if c.opts.Timeout > 0 {
+		var cancel context.CancelFunc
+		runCtx, cancel = context.WithTimeout(ctx, c.opts.Timeout)
+		defer cancel()
+	}
+
+	env, cleanup, err := c.environment()
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+	conn := c.connectionArgs()
+
+	if c.opts.Globals {
+		pgDumpall, err := exec.LookPath("pg_dumpall")
+		if err != nil {
+			return fmt.Errorf("pg_dumpall not found: %w", err)
+		}
+		args := append(append([]string(nil), conn...), "--globals-only")
+		if err := c.dump(runCtx, tw, pgDumpall, postgresGlobalsEntry, env, args); err != nil {
+			return err
+		}
+	}
+
+	databases := c.opts.Databases
+	if len(databases) == 0 {
+		if databases, err = c.listDatabases(runCtx, conn, env); err != nil {
+			return fmt.Errorf("list databases: %w", err)
+		}
+	}
+	for _, db := range c.filterDatabases(databases) {
+		args := append(append([]string(nil), conn...), "--format=custom", db)
+		if err := c.dump(runCtx, tw, pgDump, db+".dump", env, args); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// dump runs a dump tool with args and stores its output as postgres/<entry>.
+func (c *PostgresCollector) dump(ctx context.Context, tw *tar.Writer, tool, entry string, env, args []string) error {
+	var stderr stderrBuffer
+	err := writeSpooledEntry(ctx, tw, c.opts.SpoolDir, PostgresEntryDir+"/"+entry, func(w io.Writer) error {
+		cmd := exec.CommandContext(ctx, tool, args...)
+		cmd.Env = env
+		cmd.Stdout = w
+		cmd.Stderr = &stderr
+		return cmd.Run()
+	})
+	if err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return fmt.Errorf("%s %s: %w", filepath.Base(tool), entry, commandError(err, stderr.String()))
+	}
+	return nil
+}
+
+// connectionArgs returns the libpq connection arguments shared by pg_dump, pg_dumpall and
+// psql. -w makes them fail instead of prompting when a password is needed but not known.
+func (c *PostgresCollector) connectionArgs() []string {
+	args := []string{"-w"}
+	switch {
+	case c.opts.Host != "":
+		args = append(args, "-h", c.opts.Host)
+	case c.opts.Socket != "":
+		args = append(args, "-h", c.opts.Socket)
+	}
+	if c.opts.Port > 0 {
+		args = append(args, "-p", strconv.Itoa(c.opts.Port))
+	}
+	if c.opts.User != "" {
+		args = append(args, "-U", c.opts.User)
+	}
+	return args
+}
+
+// environment returns the environment for the dump tools. The password is handed over in a
+// private pgpass file named by PGPASSFILE, removed by cleanup, rather than on the command
+// line or in PGPASSWORD.
+func (c *PostgresCollector) environment() (env []string, cleanup func(), err error) {
+	cleanup = func() {}
+	env = os.Environ()
+	if c.opts.PasswordFile == "" {
+		return env, cleanup, nil
+	}
+	data, err := os.ReadFile(expandHome(c.opts.PasswordFile))
+	if err != nil {
+		return nil, cleanup, fmt.Errorf("read password file: %w", err)
+	}
+	password := strings.TrimRight(string(data), "\r\n")
+	password = strings.NewReplacer(`\`, `\\`, `:`, `\:`).Replace(password)
+
+	f, err := os.CreateTemp("", "velbackuper-pgpass-*")
+	if err != nil {
+		return nil, cleanup, fmt.Errorf("create pgpass file: %w", err)
+	}
+	cleanup = func() { _ = os.Remove(f.Name()) }
+	_, err = fmt.Fprintf(f, "*:*:*:*:%s\n", password)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		cleanup()
+		return nil, func() {}, fmt.Errorf("write pgpass file: %w", err)
+	}
+	return append(env, "PGPASSFILE="+f.Name()), cleanup, nil
+}
+
+// listDatabases returns the databases that accept connections, leaving out the templates.
+func (c *PostgresCollector) listDatabases(ctx context.Context, conn, env []string) ([]string, error) {
+	psql, err := exec.LookPath("psql")
+	if err != nil {
+		return nil, fmt.Errorf("psql not found: %w", err)
+	}
+	args := append(append([]string(nil), conn...), "-At", "-d", "postgres",
+		"-c", "SELECT datname FROM pg_database WHERE datallowconn AND NOT datistemplate ORDER BY datname")
+	cmd := exec.CommandContext(ctx, psql, args...)
+	cmd.Env = env
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, commandError(err, "")
+	}
+	var list []string
+	sc := bufio.NewScanner(strings.NewReader(string(out)))
+	for sc.Scan() {
+		if db := strings.TrimSpace(sc.Text()); db != "" {
+			list = append(list, db)
+		}
+	}
+	return list, sc.Err()
+}
+
+func (c *PostgresCollector) filterDatabases(databases []string) []string {
+	exclude := make(map[string]bool, len(c.opts.ExcludeDatabases))
+	for _, db := range c.opts.ExcludeDatabases {
+		exclude[db] = true
+	}
+	var list []string
+	for _, db := range databases {
+		if !exclude[db] {
+			list = append(list, db)
+		}
+	}
+	return list
+}
+
+var (
+	_ Collector    = (*PostgresCollector)(nil)
+	_ TarCollector = (*PostgresCollector)(nil)
+)
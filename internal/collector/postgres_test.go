@@ -0,0 +1,102 @@
+package collector
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// fakePostgresTools puts pg_dump, pg_dumpall and psql scripts first on PATH. The dump tools
+// print the pgpass file they were given and their arguments; pg_dump fails with
+// $FAKE_PG_DUMP_FAIL on stderr if set. psql lists a fixed set of databases.
+func fakePostgresTools(t *testing.T) {
+	t.Helper()
+	dir := t.TempDir()
+	dumpScript := `#!/bin/sh
+[ -n "$PGPASSFILE" ] && cat "$PGPASSFILE"
+echo "PGPASSFILE=$PGPASSFILE"
+for a in "$@"; do echo "arg $a"; done
+`
+	scripts := map[string]string{
+		"pg_dump": dumpScript + `if [ -n "$FAKE_PG_DUMP_FAIL" ]; then echo "$FAKE_PG_DUMP_FAIL" >&2; exit 1; fi
+`,
+		"pg_dumpall": dumpScript,
+		"psql": `#!/bin/sh
+printf 'app\nanalytics\nscratch\n'
+`,
+	}
+	for name, body := range scripts {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(body), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+	t.Setenv("PGPASSFILE", "")
+	t.Setenv("FAKE_PG_DUMP_FAIL", "")
+}
+
+func TestPostgresCollector_DumpsEachDatabaseAndGlobals(t *testing.T) {
+	fakePostgresTools(t)
+	c := NewPostgresCollector(PostgresOpts{
+		Globals:          true,
+		ExcludeDatabases: []string{"scratch"},
+		Socket:           "/var/run/postgresql",
+		User:             "postgres",
+	})
+	entries := collectEntries(t, c)
+	if len(entries) != 3 {
+		t.Fatalf("entries = %v, want globals, app and analytics", entryNames(entries))
+	}
+	if g := entries["postgres/globals.sql"]; !strings.Contains(g, "arg --globals-only") {
+		t.Errorf("globals entry:\n%s", g)
+	}
+	app := entries["postgres/app.dump"]
+	for _, want := range []string{"arg -w\n", "arg -h\narg /var/run/postgresql\n", "arg -U\narg postgres\n", "arg --format=custom\narg app\n"} {
+		if !strings.Contains(app, want) {
+			t.Errorf("app dump does not contain %q:\n%s", want, app)
+		}
+	}
+	if entries["postgres/analytics.dump"] == "" {
+		t.Error("analytics was not dumped")
+	}
+}
+
+func TestPostgresCollector_PasswordFileViaPGPASSFILE(t *testing.T) {
+	fakePostgresTools(t)
+	passFile := filepath.Join(t.TempDir(), "pg.pass")
+	if err := os.WriteFile(passFile, []byte("pa:ss\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	c := NewPostgresCollector(PostgresOpts{Databases: []string{"app"}, Host: "db.example.com", Port: 5433, PasswordFile: passFile})
+	dump := collectEntries(t, c)["postgres/app.dump"]
+
+	if !strings.Contains(dump, `*:*:*:*:pa\:ss`) {
+		t.Errorf("pgpass file not passed or not escaped:\n%s", dump)
+	}
+	if strings.Contains(dump, "arg pa:ss") {
+		t.Error("password passed on the command line")
+	}
+	if !strings.Contains(dump, "arg -h\narg db.example.com\narg -p\narg 5433\n") {
+		t.Errorf("connection args:\n%s", dump)
+	}
+	for _, line := range strings.Split(dump, "\n") {
+		if path, ok := strings.CutPrefix(line, "PGPASSFILE="); ok {
+			if _, err := os.Stat(path); !os.IsNotExist(err) {
+				t.Errorf("pgpass file was not removed (stat: %v)", err)
+			}
+		}
+	}
+}
+
+func TestPostgresCollector_ErrorIncludesStderr(t *testing.T) {
+	fakePostgresTools(t)
+	t.Setenv("FAKE_PG_DUMP_FAIL", `pg_dump: error: connection to server failed: FATAL:  password authentication failed for user "backup"`)
+	c := NewPostgresCollector(PostgresOpts{Databases: []string{"app"}})
+	err := c.Collect(context.Background(), "job", io.Discard)
+	if err == nil || !strings.Contains(err.Error(), "password authentication failed") {
+		t.Fatalf("Collect = %v, want the pg_dump stderr in the error", err)
+	}
+}
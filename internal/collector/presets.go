@@ -1,6 +1,7 @@
 package collector
 
 import (
+	"archive/tar"
 	"context"
 	"io"
 	"os"
@@ -19,6 +20,13 @@ type PresetsOpts struct {
 	Nginx       bool
 	Apache      bool
 	LetsEncrypt bool
+
+	// Filters applied to the preset directories, as in PathsOpts.
+	Exclude          []string
+	ExcludeRegex     []string
+	ExcludeIfPresent []string
+	MaxFileSize      int64
+	OneFileSystem    bool
 }
 
 type PresetsCollector struct {
@@ -29,17 +37,30 @@ func NewPresetsCollector(opts PresetsOpts) *PresetsCollector {
 	return &PresetsCollector{opts: opts}
 }
 
+func (c *PresetsCollector) Name() string { return CollectorPresets }
+
 func (c *PresetsCollector) Collect(ctx context.Context, jobName string, w io.Writer) error {
+	if len(c.includedPaths()) == 0 {
+		return nil
+	}
+	return collectTar(ctx, c, jobName, w)
+}
+
+func (c *PresetsCollector) CollectTar(ctx context.Context, jobName string, tw *tar.Writer) error {
 	include := c.includedPaths()
 	if len(include) == 0 {
 		return nil
 	}
 	fs := NewFilesystemCollector(PathsOpts{
-		Include:        include,
-		Exclude:        nil,
-		FollowSymlinks: false,
+		Include:          include,
+		Exclude:          c.opts.Exclude,
+		ExcludeRegex:     c.opts.ExcludeRegex,
+		ExcludeIfPresent: c.opts.ExcludeIfPresent,
+		MaxFileSize:      c.opts.MaxFileSize,
+		OneFileSystem:    c.opts.OneFileSystem,
+		FollowSymlinks:   false,
 	})
-	return fs.Collect(ctx, jobName, w)
+	return fs.CollectTar(ctx, jobName, tw)
 }
 
 func (c *PresetsCollector) includedPaths() []string {
@@ -76,4 +97,7 @@ func pathExists(p string) bool {
 	return err == nil
 }
 
-var _ Collector = (*PresetsCollector)(nil)
+var (
+	_ Collector    = (*PresetsCollector)(nil)
+	_ TarCollector = (*PresetsCollector)(nil)
+)
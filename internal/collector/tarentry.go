@@ -0,0 +1,62 @@
+package collector
+
+import (
+	"archive/tar"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// collectTar runs c against a tar writer of its own on w, for standalone use of a TarCollector.
+func collectTar(ctx context.Context, c TarCollector, jobName string, w io.Writer) error {
+	tw := tar.NewWriter(w)
+	if err := c.CollectTar(ctx, jobName, tw); err != nil {
+		_ = tw.Close()
+		return err
+	}
+	return tw.Close()
+}
+
+// writeSpooledEntry adds a regular file named name whose content is produced by write. A tar
+// header needs the size up front, so the content is spooled to a temporary file in dir first
+// (empty = os.TempDir()); dir needs as much free space as the largest entry.
+func writeSpooledEntry(ctx context.Context, tw *tar.Writer, dir, name string, write func(w io.Writer) error) error {
+	f, err := os.CreateTemp(dir, "velbackuper-spool-*")
+	if err != nil {
+		return fmt.Errorf("create spool file: %w", err)
+	}
+	defer func() {
+		_ = f.Close()
+		_ = os.Remove(f.Name())
+	}()
+
+	if err := write(f); err != nil {
+		return err
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	size, err := f.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	hdr := &tar.Header{
+		Name:     name,
+		Typeflag: tar.TypeReg,
+		Mode:     0o600,
+		Size:     size,
+		ModTime:  time.Now(),
+	}
+	if err := tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	if _, err := io.CopyN(tw, f, size); err != nil {
+		return fmt.Errorf("write %s: %w", name, err)
+	}
+	return nil
+}
@@ -7,6 +7,8 @@ type SourceItem struct {
 
 const (
 	CollectorMySQL      = "mysql"
+	CollectorPostgres   = "postgres"
+	CollectorCommands   = "commands"
 	CollectorFilesystem = "filesystem"
 	CollectorPresets    = "presets"
 )
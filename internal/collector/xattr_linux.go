@@ -0,0 +1,61 @@
+//go:build linux
+
+package collector
+
+import (
+	"errors"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// readXattrs returns the extended attributes of path, not following a symlink. POSIX ACLs are
+// among them, as system.posix_acl_access and system.posix_acl_default. A filesystem without
+// xattr support has none.
+func readXattrs(path string) (map[string]string, error) {
+	list, err := xattrBuffer(func(buf []byte) (int, error) { return unix.Llistxattr(path, buf) })
+	if errors.Is(err, unix.ENOTSUP) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var attrs map[string]string
+	for _, name := range strings.Split(strings.TrimRight(string(list), "\x00"), "\x00") {
+		if name == "" {
+			continue
+		}
+		value, err := xattrBuffer(func(buf []byte) (int, error) { return unix.Lgetxattr(path, name, buf) })
+		if errors.Is(err, unix.ENODATA) {
+			continue // removed since it was listed
+		}
+		if err != nil {
+			return nil, err
+		}
+		if attrs == nil {
+			attrs = make(map[string]string)
+		}
+		attrs[name] = string(value)
+	}
+	return attrs, nil
+}
+
+// xattrBuffer calls get with a buffer of the size it reports, retrying if the value grew in
+// between.
+func xattrBuffer(get func([]byte) (int, error)) ([]byte, error) {
+	for {
+		n, err := get(nil)
+		if err != nil || n == 0 {
+			return nil, err
+		}
+		buf := make([]byte, n)
+		n, err = get(buf)
+		if errors.Is(err, unix.ERANGE) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		return buf[:n], nil
+	}
+}
@@ -0,0 +1,8 @@
+//go:build !linux
+
+package collector
+
+// readXattrs is only implemented on Linux; elsewhere no extended attributes are stored.
+func readXattrs(path string) (map[string]string, error) {
+	return nil, nil
+}
@@ -1,6 +1,10 @@
 package config
 
-import "github.com/spf13/viper"
+import (
+	"time"
+
+	"github.com/spf13/viper"
+)
 
 const (
 	ModeArchive     = "archive"
@@ -12,6 +16,7 @@ type Config struct {
 	S3            *S3Config            `mapstructure:"s3" yaml:"s3,omitempty"`
 	Jobs          []JobConfig          `mapstructure:"jobs" yaml:"jobs"`
 	Notifications *NotificationsConfig `mapstructure:"notifications" yaml:"notifications,omitempty"`
+	Encryption    *EncryptionConfig    `mapstructure:"encryption" yaml:"encryption,omitempty"`
 }
 
 type S3Config struct {
@@ -24,6 +29,7 @@ type S3Config struct {
 	PathStyle               *bool      `mapstructure:"path_style" yaml:"path_style,omitempty"`                               // true = path-style (MinIO), false = virtual-hosted; nil = true
 	DisableRequestChecksums *bool      `mapstructure:"disable_request_checksums" yaml:"disable_request_checksums,omitempty"` // true = compat Ceph/some S3 backends; nil = false
 	TLS                     *TLSConfig `mapstructure:"tls" yaml:"tls,omitempty"`
+	ConditionalWrites       *bool      `mapstructure:"conditional_writes" yaml:"conditional_writes,omitempty"` // false = backend lacks If-None-Match/If-Match, locks fall back to put-and-verify; nil = true
 }
 
 type TLSConfig struct {
@@ -33,21 +39,63 @@ type TLSConfig struct {
 }
 
 type JobConfig struct {
-	Name      string           `mapstructure:"name" yaml:"name"`
-	Enabled   bool             `mapstructure:"enabled" yaml:"enabled"`
-	MySQL     *MySQLJobConfig  `mapstructure:"mysql" yaml:"mysql,omitempty"`
-	Presets   *PresetsConfig   `mapstructure:"presets" yaml:"presets,omitempty"`
-	Paths     *PathsConfig     `mapstructure:"paths" yaml:"paths,omitempty"`
-	Schedule  *ScheduleConfig  `mapstructure:"schedule" yaml:"schedule,omitempty"`
-	Retention *RetentionConfig `mapstructure:"retention" yaml:"retention,omitempty"`
+	Name        string             `mapstructure:"name" yaml:"name"`
+	Enabled     bool               `mapstructure:"enabled" yaml:"enabled"`
+	MySQL       *MySQLJobConfig    `mapstructure:"mysql" yaml:"mysql,omitempty"`
+	Postgres    *PostgresJobConfig `mapstructure:"postgres" yaml:"postgres,omitempty"`
+	Commands    []CommandConfig    `mapstructure:"commands" yaml:"commands,omitempty"`
+	Presets     *PresetsConfig     `mapstructure:"presets" yaml:"presets,omitempty"`
+	Paths       *PathsConfig       `mapstructure:"paths" yaml:"paths,omitempty"`
+	Schedule    *ScheduleConfig    `mapstructure:"schedule" yaml:"schedule,omitempty"`
+	Retention   *RetentionConfig   `mapstructure:"retention" yaml:"retention,omitempty"`
+	Chunking    *ChunkingConfig    `mapstructure:"chunking" yaml:"chunking,omitempty"`       // incremental mode only
+	Compression *CompressionConfig `mapstructure:"compression" yaml:"compression,omitempty"` // archive mode only
+	Hooks       *HooksConfig       `mapstructure:"hooks" yaml:"hooks,omitempty"`
+	// SpoolDir holds database and command dumps while they are written, because a tar entry
+	// needs its size up front. It needs room for the largest single dump; empty = os.TempDir().
+	SpoolDir string `mapstructure:"spool_dir" yaml:"spool_dir,omitempty"`
 }
 
+// MySQLJobConfig selects what mysqldump backs up and how it connects. Without host the
+// local socket is used (socket, or auto-detected); user, password_file and tls are written
+// to a private option file so the password never appears on the command line.
 type MySQLJobConfig struct {
-	Enabled       bool              `mapstructure:"enabled" yaml:"enabled"`
-	DumpAll       bool              `mapstructure:"dump_all" yaml:"dump_all"`
-	ExcludeSystem bool              `mapstructure:"exclude_system" yaml:"exclude_system"`
-	OneFilePerDB  bool              `mapstructure:"one_file_per_db" yaml:"one_file_per_db"`
-	Options       *MySQLDumpOptions `mapstructure:"options" yaml:"options,omitempty"`
+	Enabled       bool `mapstructure:"enabled" yaml:"enabled"`
+	DumpAll       bool `mapstructure:"dump_all" yaml:"dump_all"`
+	ExcludeSystem bool `mapstructure:"exclude_system" yaml:"exclude_system"`
+	OneFilePerDB  bool `mapstructure:"one_file_per_db" yaml:"one_file_per_db"`
+
+	Host         string          `mapstructure:"host" yaml:"host,omitempty"`
+	Port         int             `mapstructure:"port" yaml:"port,omitempty"` // omit = 3306
+	User         string          `mapstructure:"user" yaml:"user,omitempty"`
+	PasswordFile string          `mapstructure:"password_file" yaml:"password_file,omitempty"` // file holding the password
+	Socket       string          `mapstructure:"socket" yaml:"socket,omitempty"`               // omit = auto-detect
+	DefaultsFile string          `mapstructure:"defaults_file" yaml:"defaults_file,omitempty"` // e.g. ~/.my.cnf
+	TLS          *MySQLTLSConfig `mapstructure:"tls" yaml:"tls,omitempty"`
+
+	Databases        []string `mapstructure:"databases" yaml:"databases,omitempty"`                 // dump only these; overrides dump_all
+	ExcludeDatabases []string `mapstructure:"exclude_databases" yaml:"exclude_databases,omitempty"` // never dump these
+	ExcludeTables    []string `mapstructure:"exclude_tables" yaml:"exclude_tables,omitempty"`       // db.table
+
+	TimeoutMinutes int               `mapstructure:"timeout_minutes" yaml:"timeout_minutes,omitempty"` // omit = 30
+	Options        *MySQLDumpOptions `mapstructure:"options" yaml:"options,omitempty"`
+}
+
+// MySQL TLS modes, as in the MySQL client's --ssl-mode.
+const (
+	MySQLTLSDisabled       = "disabled"
+	MySQLTLSPreferred      = "preferred"
+	MySQLTLSRequired       = "required"
+	MySQLTLSVerifyCA       = "verify_ca"
+	MySQLTLSVerifyIdentity = "verify_identity"
+)
+
+// MySQLTLSConfig configures TLS for connections to a remote server.
+type MySQLTLSConfig struct {
+	Mode string `mapstructure:"mode" yaml:"mode"`           // disabled | preferred | required | verify_ca | verify_identity
+	CA   string `mapstructure:"ca" yaml:"ca,omitempty"`     // CA certificate file
+	Cert string `mapstructure:"cert" yaml:"cert,omitempty"` // client certificate file
+	Key  string `mapstructure:"key" yaml:"key,omitempty"`   // client key file
 }
 
 type MySQLDumpOptions struct {
@@ -56,16 +104,86 @@ type MySQLDumpOptions struct {
 	Events            bool `mapstructure:"events" yaml:"events"`
 }
 
+// PostgresJobConfig selects what pg_dump backs up and how it connects. Without host the
+// local socket directory is used (socket, or the libpq default). Each database is dumped in
+// custom format; globals adds roles and tablespaces from pg_dumpall --globals-only.
+type PostgresJobConfig struct {
+	Enabled bool `mapstructure:"enabled" yaml:"enabled"`
+	Globals bool `mapstructure:"globals" yaml:"globals"`
+
+	Host         string `mapstructure:"host" yaml:"host,omitempty"`
+	Port         int    `mapstructure:"port" yaml:"port,omitempty"` // omit = 5432
+	User         string `mapstructure:"user" yaml:"user,omitempty"`
+	PasswordFile string `mapstructure:"password_file" yaml:"password_file,omitempty"` // file holding the password
+	Socket       string `mapstructure:"socket" yaml:"socket,omitempty"`               // socket directory, e.g. /var/run/postgresql
+
+	Databases        []string `mapstructure:"databases" yaml:"databases,omitempty"`                 // omit = every database that accepts connections
+	ExcludeDatabases []string `mapstructure:"exclude_databases" yaml:"exclude_databases,omitempty"` // never dump these
+
+	TimeoutMinutes int `mapstructure:"timeout_minutes" yaml:"timeout_minutes,omitempty"` // omit = 30
+}
+
+// What a failing pre hook does to its job.
+const (
+	HookFailureAbort    = "abort"
+	HookFailureContinue = "continue"
+)
+
+// HooksConfig lists commands run around a job's backup: pre before the sources are read,
+// post after the backup whether it succeeded or not (but not when a pre hook aborted the job),
+// and on_error when the job failed. Each list runs in order.
+type HooksConfig struct {
+	Pre          []HookConfig `mapstructure:"pre" yaml:"pre,omitempty"`
+	Post         []HookConfig `mapstructure:"post" yaml:"post,omitempty"`
+	OnError      []HookConfig `mapstructure:"on_error" yaml:"on_error,omitempty"`
+	OnPreFailure string       `mapstructure:"on_pre_failure" yaml:"on_pre_failure,omitempty"` // abort | continue; omit = abort
+}
+
+// HookConfig is one hook command, run with /bin/sh -c.
+type HookConfig struct {
+	Name           string   `mapstructure:"name" yaml:"name,omitempty"` // shown in logs; omit = the command
+	Command        string   `mapstructure:"command" yaml:"command"`
+	TimeoutSeconds int      `mapstructure:"timeout_seconds" yaml:"timeout_seconds,omitempty"` // omit = 300
+	Env            []string `mapstructure:"env" yaml:"env,omitempty"`                         // KEY=value
+}
+
+// CommandConfig is a dump command whose stdout is stored in the archive as commands/<name>,
+// e.g. redis-cli --rdb - or mongodump --archive. The command is run with /bin/sh -c.
+type CommandConfig struct {
+	Name              string   `mapstructure:"name" yaml:"name"` // entry name, e.g. redis.rdb
+	Command           string   `mapstructure:"command" yaml:"command"`
+	TimeoutMinutes    int      `mapstructure:"timeout_minutes" yaml:"timeout_minutes,omitempty"`         // omit = 30
+	User              string   `mapstructure:"user" yaml:"user,omitempty"`                               // run as this user (needs root); omit = current user
+	Env               []string `mapstructure:"env" yaml:"env,omitempty"`                                 // KEY=value
+	ExpectedExitCodes []int    `mapstructure:"expected_exit_codes" yaml:"expected_exit_codes,omitempty"` // omit = [0]
+}
+
+// PresetsConfig selects well-known directories. The exclude options work as in PathsConfig.
 type PresetsConfig struct {
 	Nginx       bool `mapstructure:"nginx" yaml:"nginx"`
 	Apache      bool `mapstructure:"apache" yaml:"apache"`
 	LetsEncrypt bool `mapstructure:"letsencrypt" yaml:"letsencrypt"`
+
+	Exclude          []string `mapstructure:"exclude" yaml:"exclude,omitempty"`
+	ExcludeRegex     []string `mapstructure:"exclude_regex" yaml:"exclude_regex,omitempty"`
+	ExcludeIfPresent []string `mapstructure:"exclude_if_present" yaml:"exclude_if_present,omitempty"`
+	MaxFileSizeMB    int      `mapstructure:"max_file_size_mb" yaml:"max_file_size_mb,omitempty"`
+	OneFileSystem    bool     `mapstructure:"one_file_system" yaml:"one_file_system,omitempty"`
 }
 
+// PathsConfig selects directories and files. Exclude patterns use the .gitignore syntax: a
+// pattern starting with / is anchored at the filesystem root, one with another slash at each
+// include path, and one without a slash matches a name at any depth; a trailing / matches
+// directories only, ** any number of directories, and ! re-includes. A .velbackupignore file
+// adds patterns for its directory and below. exclude_regex is matched against absolute paths.
 type PathsConfig struct {
-	Include        []string `mapstructure:"include" yaml:"include"`
-	Exclude        []string `mapstructure:"exclude" yaml:"exclude"`
-	FollowSymlinks bool     `mapstructure:"follow_symlinks" yaml:"follow_symlinks"`
+	Include          []string `mapstructure:"include" yaml:"include"`
+	Exclude          []string `mapstructure:"exclude" yaml:"exclude"`
+	ExcludeRegex     []string `mapstructure:"exclude_regex" yaml:"exclude_regex,omitempty"`
+	ExcludeIfPresent []string `mapstructure:"exclude_if_present" yaml:"exclude_if_present,omitempty"` // skip directories containing one of these files, e.g. .nobackup
+	MaxFileSizeMB    int      `mapstructure:"max_file_size_mb" yaml:"max_file_size_mb,omitempty"`     // skip larger files; omit = no limit
+	OneFileSystem    bool     `mapstructure:"one_file_system" yaml:"one_file_system,omitempty"`       // do not cross into other mounted filesystems
+	FollowSymlinks   bool     `mapstructure:"follow_symlinks" yaml:"follow_symlinks"`
 }
 
 type ScheduleConfig struct {
@@ -74,10 +192,93 @@ type ScheduleConfig struct {
 	JitterMinutes int    `mapstructure:"jitter_minutes" yaml:"jitter_minutes"`
 }
 
+// RetentionConfig is a grandfather-father-son policy: a backup is kept if it is one of the
+// KeepLast newest, or the newest backup of one of the KeepHourly/KeepDaily/... most recent
+// hours, days, ISO weeks, months or years that have a backup. Days, Weeks and Months are the
+// older names of KeepDaily, KeepWeekly and KeepMonthly.
 type RetentionConfig struct {
-	Days   int `mapstructure:"days" yaml:"days"`
-	Weeks  int `mapstructure:"weeks" yaml:"weeks"`
-	Months int `mapstructure:"months" yaml:"months"`
+	KeepLast    int `mapstructure:"keep_last" yaml:"keep_last,omitempty"`
+	KeepHourly  int `mapstructure:"keep_hourly" yaml:"keep_hourly,omitempty"`
+	KeepDaily   int `mapstructure:"keep_daily" yaml:"keep_daily,omitempty"`
+	KeepWeekly  int `mapstructure:"keep_weekly" yaml:"keep_weekly,omitempty"`
+	KeepMonthly int `mapstructure:"keep_monthly" yaml:"keep_monthly,omitempty"`
+	KeepYearly  int `mapstructure:"keep_yearly" yaml:"keep_yearly,omitempty"`
+
+	Days   int `mapstructure:"days" yaml:"days,omitempty"`
+	Weeks  int `mapstructure:"weeks" yaml:"weeks,omitempty"`
+	Months int `mapstructure:"months" yaml:"months,omitempty"`
+}
+
+const (
+	ChunkingFixed   = "fixed"
+	ChunkingFastCDC = "fastcdc"
+)
+
+// ChunkingConfig selects how incremental mode splits a job's stream into chunks.
+// Omitted sizes use the engine defaults (1 MiB / 4 MiB / 16 MiB for fastcdc).
+type ChunkingConfig struct {
+	Algorithm string `mapstructure:"algorithm" yaml:"algorithm"` // fastcdc | fixed; omit = fixed
+	MinSizeKB int    `mapstructure:"min_size_kb" yaml:"min_size_kb,omitempty"`
+	AvgSizeKB int    `mapstructure:"avg_size_kb" yaml:"avg_size_kb,omitempty"`
+	MaxSizeKB int    `mapstructure:"max_size_kb" yaml:"max_size_kb,omitempty"`
+}
+
+// Chunk sizes fastcdc uses for omitted chunking sizes.
+const (
+	DefaultFastCDCMinSizeKB = 1024
+	DefaultFastCDCAvgSizeKB = 4096
+	DefaultFastCDCMaxSizeKB = 16384
+)
+
+// FastCDCSizesKB returns the min, avg and max chunk sizes of c, with omitted sizes defaulted.
+func FastCDCSizesKB(c *ChunkingConfig) (min, avg, max int) {
+	min, avg, max = DefaultFastCDCMinSizeKB, DefaultFastCDCAvgSizeKB, DefaultFastCDCMaxSizeKB
+	if c == nil {
+		return min, avg, max
+	}
+	if c.MinSizeKB > 0 {
+		min = c.MinSizeKB
+	}
+	if c.AvgSizeKB > 0 {
+		avg = c.AvgSizeKB
+	}
+	if c.MaxSizeKB > 0 {
+		max = c.MaxSizeKB
+	}
+	return min, avg, max
+}
+
+// EnvEncryptionPassphrase supplies the encryption passphrase when passphrase_file is not set.
+const EnvEncryptionPassphrase = "VELBACKUPER_ENCRYPTION_PASSPHRASE"
+
+// EncryptionConfig enables client-side encryption. Set either an age X25519 key
+// (recipient and/or identity_file) or a passphrase (passphrase_file or VELBACKUPER_ENCRYPTION_PASSPHRASE).
+// A recipient alone can write archive backups but not read them; with identity_file, archives
+// are encrypted to both. Incremental mode needs identity_file or a passphrase because chunk IDs
+// and object keys are derived from the secret and the repository salt.
+type EncryptionConfig struct {
+	Enabled        bool   `mapstructure:"enabled" yaml:"enabled"`
+	Recipient      string `mapstructure:"recipient" yaml:"recipient,omitempty"`             // age1... public key
+	IdentityFile   string `mapstructure:"identity_file" yaml:"identity_file,omitempty"`     // age secret key file (AGE-SECRET-KEY-1...)
+	PassphraseFile string `mapstructure:"passphrase_file" yaml:"passphrase_file,omitempty"` // file holding the passphrase
+	// AllowPlaintext reads incremental objects that are not encrypted, such as snapshots written
+	// before encryption was enabled. Off, they are refused: anyone able to write to the bucket
+	// could otherwise replace encrypted objects with unauthenticated ones.
+	AllowPlaintext bool `mapstructure:"allow_plaintext" yaml:"allow_plaintext,omitempty"`
+}
+
+const (
+	CompressionNone = "none"
+	CompressionGzip = "gz"
+	CompressionZstd = "zst"
+)
+
+// CompressionConfig selects how archive mode compresses a job's tar stream.
+// Omitting the block keeps gzip level 6.
+type CompressionConfig struct {
+	Format  string `mapstructure:"format" yaml:"format"`             // none | gz | zst; omit = gz
+	Level   int    `mapstructure:"level" yaml:"level,omitempty"`     // gz 1-9, zst 1-22; 0 = codec default
+	Threads int    `mapstructure:"threads" yaml:"threads,omitempty"` // zst only; 0 = one per CPU
 }
 
 type NotificationsConfig struct {
@@ -129,6 +330,69 @@ func S3DisableRequestChecksums(s3 *S3Config) bool {
 	return *s3.DisableRequestChecksums
 }
 
+// S3ConditionalWrites returns whether the backend supports conditional PUT and DELETE. Default true.
+func S3ConditionalWrites(s3 *S3Config) bool {
+	if s3 == nil || s3.ConditionalWrites == nil {
+		return true
+	}
+	return *s3.ConditionalWrites
+}
+
+// DefaultMySQLTimeout is how long one mysqldump run of a job may take when timeout_minutes
+// is not set. PostgreSQL dumps use the same default.
+const DefaultMySQLTimeout = 30 * time.Minute
+
+// MySQLTimeout returns the dump timeout of m.
+func MySQLTimeout(m *MySQLJobConfig) time.Duration {
+	if m == nil || m.TimeoutMinutes <= 0 {
+		return DefaultMySQLTimeout
+	}
+	return time.Duration(m.TimeoutMinutes) * time.Minute
+}
+
+// PostgresTimeout returns the dump timeout of p.
+func PostgresTimeout(p *PostgresJobConfig) time.Duration {
+	if p == nil || p.TimeoutMinutes <= 0 {
+		return DefaultMySQLTimeout
+	}
+	return time.Duration(p.TimeoutMinutes) * time.Minute
+}
+
+// MaxFileSizeBytes converts max_file_size_mb to bytes; 0 means no limit.
+func MaxFileSizeBytes(mb int) int64 {
+	if mb <= 0 {
+		return 0
+	}
+	return int64(mb) << 20
+}
+
+// DefaultCommandTimeout is how long a dump command may run when timeout_minutes is not set.
+const DefaultCommandTimeout = 30 * time.Minute
+
+// CommandTimeout returns the timeout of c.
+func CommandTimeout(c *CommandConfig) time.Duration {
+	if c == nil || c.TimeoutMinutes <= 0 {
+		return DefaultCommandTimeout
+	}
+	return time.Duration(c.TimeoutMinutes) * time.Minute
+}
+
+// DefaultHookTimeout is how long a hook may run when timeout_seconds is not set.
+const DefaultHookTimeout = 5 * time.Minute
+
+// HookTimeout returns the timeout of h.
+func HookTimeout(h *HookConfig) time.Duration {
+	if h == nil || h.TimeoutSeconds <= 0 {
+		return DefaultHookTimeout
+	}
+	return time.Duration(h.TimeoutSeconds) * time.Second
+}
+
+// HookAbortsOnPreFailure returns whether a failing pre hook aborts the job. Default true.
+func HookAbortsOnPreFailure(h *HooksConfig) bool {
+	return h == nil || h.OnPreFailure != HookFailureContinue
+}
+
 func Unmarshal(v *viper.Viper) (*Config, error) {
 	var c Config
 	if err := v.Unmarshal(&c); err != nil {
@@ -131,6 +131,7 @@ func TestJobTemplate(t *testing.T) {
 	}{
 		{"web", "web", "myweb", false},
 		{"mysql", "mysql", "db", false},
+		{"postgres", "postgres", "pg", false},
 		{"files", "files", "data", false},
 		{"unknown", "invalid", "x", true},
 	}
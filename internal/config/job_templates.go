@@ -12,7 +12,8 @@ func JobTemplate(name, jobName string) *JobConfig {
 				Times:         2,
 				JitterMinutes: 15,
 			},
-			Retention: &RetentionConfig{Days: 7, Weeks: 0, Months: 0},
+			Retention: &RetentionConfig{KeepDaily: 7},
+			Chunking:  DefaultChunking(),
 		}
 	case "mysql":
 		return &JobConfig{
@@ -29,7 +30,25 @@ func JobTemplate(name, jobName string) *JobConfig {
 				Times:         1,
 				JitterMinutes: 30,
 			},
-			Retention: &RetentionConfig{Days: 7, Weeks: 0, Months: 0},
+			Retention: &RetentionConfig{KeepDaily: 7},
+			Chunking:  DefaultChunking(),
+		}
+	case "postgres":
+		return &JobConfig{
+			Name:    jobName,
+			Enabled: true,
+			Postgres: &PostgresJobConfig{
+				Enabled: true,
+				Globals: true,
+				User:    "postgres",
+			},
+			Schedule: &ScheduleConfig{
+				Period:        "day",
+				Times:         1,
+				JitterMinutes: 30,
+			},
+			Retention: &RetentionConfig{KeepDaily: 7},
+			Chunking:  DefaultChunking(),
 		}
 	case "files":
 		return &JobConfig{
@@ -45,7 +64,8 @@ func JobTemplate(name, jobName string) *JobConfig {
 				Times:         1,
 				JitterMinutes: 15,
 			},
-			Retention: &RetentionConfig{Days: 7, Weeks: 0, Months: 0},
+			Retention: &RetentionConfig{KeepDaily: 7},
+			Chunking:  DefaultChunking(),
 		}
 	default:
 		return nil
@@ -53,5 +73,11 @@ func JobTemplate(name, jobName string) *JobConfig {
 }
 
 func JobTemplateNames() []string {
-	return []string{"web", "mysql", "files"}
+	return []string{"web", "mysql", "postgres", "files"}
+}
+
+// DefaultChunking returns the chunking block written for new jobs: content-defined chunking
+// with the engine's default sizes.
+func DefaultChunking() *ChunkingConfig {
+	return &ChunkingConfig{Algorithm: ChunkingFastCDC}
 }
@@ -15,5 +15,8 @@ func NormalizePrefix(prefix string) string {
 	}
 
 	prefix = strings.Trim(prefix, "/")
+	if prefix == "" {
+		return ""
+	}
 	return path.Clean(prefix)
 }
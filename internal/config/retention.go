@@ -1,28 +1,126 @@
 package config
 
-import "time"
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
 
-func RetainUntil(now time.Time, r *RetentionConfig) time.Time {
+// RetentionDecision is the verdict of a retention policy for one backup point.
+type RetentionDecision struct {
+	Time time.Time
+	Keep bool
+	// Reasons lists the rules that keep the point ("daily 2025-02-26"), or why none did.
+	Reasons []string
+}
+
+// Reason returns Reasons as one line.
+func (d RetentionDecision) Reason() string {
+	return strings.Join(d.Reasons, ", ")
+}
+
+// retentionRule is one GFS bucket size.
+type retentionRule struct {
+	name   string
+	count  func(r *RetentionConfig) int
+	bucket func(t time.Time) string
+}
+
+var retentionRules = []retentionRule{
+	{"hourly", func(r *RetentionConfig) int { return r.KeepHourly }, func(t time.Time) string { return t.Format("2006-01-02 15h") }},
+	{"daily", func(r *RetentionConfig) int { return max(r.KeepDaily, r.Days) }, func(t time.Time) string { return t.Format("2006-01-02") }},
+	{"weekly", func(r *RetentionConfig) int { return max(r.KeepWeekly, r.Weeks) }, func(t time.Time) string {
+		y, w := t.ISOWeek()
+		return fmt.Sprintf("%d-W%02d", y, w)
+	}},
+	{"monthly", func(r *RetentionConfig) int { return max(r.KeepMonthly, r.Months) }, func(t time.Time) string { return t.Format("2006-01") }},
+	{"yearly", func(r *RetentionConfig) int { return r.KeepYearly }, func(t time.Time) string { return t.Format("2006") }},
+}
+
+// RetentionEnabled reports whether r would ever drop a backup. A nil or all-zero policy keeps
+// everything.
+func RetentionEnabled(r *RetentionConfig) bool {
 	if r == nil {
-		return time.Time{}
+		return false
 	}
-	days := r.Days
-	if r.Weeks*7 > days {
-		days = r.Weeks * 7
+	if r.KeepLast > 0 {
+		return true
 	}
-	if r.Months*30 > days {
-		days = r.Months * 30
+	for _, rule := range retentionRules {
+		if rule.count(r) > 0 {
+			return true
+		}
 	}
-	if days <= 0 {
-		return time.Time{}
+	return false
+}
+
+// PlanRetention applies r to the backup points and returns a decision for each, newest
+// first. A point is kept if it is one of the KeepLast newest, or the newest point in one of
+// the most recent hours, days, ISO weeks, months or years (up to each rule's count) that
+// have a point. Buckets are computed in UTC. With no policy every point is kept.
+func PlanRetention(points []time.Time, r *RetentionConfig) []RetentionDecision {
+	decisions := make([]RetentionDecision, len(points))
+	for i, t := range points {
+		decisions[i].Time = t.UTC()
 	}
-	return now.AddDate(0, 0, -days)
+	sort.SliceStable(decisions, func(i, j int) bool { return decisions[i].Time.After(decisions[j].Time) })
+
+	if !RetentionEnabled(r) {
+		for i := range decisions {
+			decisions[i].Keep = true
+			decisions[i].Reasons = []string{"no retention policy"}
+		}
+		return decisions
+	}
+
+	for i := range decisions {
+		if i < r.KeepLast {
+			decisions[i].Keep = true
+			decisions[i].Reasons = append(decisions[i].Reasons, fmt.Sprintf("last %d", r.KeepLast))
+		}
+	}
+	retained := make(map[string]bool) // "daily 2025-02-26" for every bucket a rule keeps
+	for _, rule := range retentionRules {
+		limit := rule.count(r)
+		if limit <= 0 {
+			continue
+		}
+		kept := 0
+		last := ""
+		for i := range decisions {
+			b := rule.bucket(decisions[i].Time)
+			if b == last {
+				continue // an older point in a bucket already counted
+			}
+			last = b
+			if kept >= limit {
+				break
+			}
+			kept++
+			label := rule.name + " " + b
+			retained[label] = true
+			decisions[i].Keep = true
+			decisions[i].Reasons = append(decisions[i].Reasons, label)
+		}
+	}
+	for i := range decisions {
+		if !decisions[i].Keep {
+			decisions[i].Reasons = []string{dropReason(decisions[i].Time, r, retained)}
+		}
+	}
+	return decisions
 }
 
-func IsExpired(backupTime, now time.Time, r *RetentionConfig) bool {
-	cutoff := RetainUntil(now, r)
-	if cutoff.IsZero() {
-		return false
+// dropReason explains why the point at t is not kept by any rule.
+func dropReason(t time.Time, r *RetentionConfig, retained map[string]bool) string {
+	for _, rule := range retentionRules {
+		if rule.count(r) <= 0 {
+			continue
+		}
+		if label := rule.name + " " + rule.bucket(t); retained[label] {
+			return "a newer backup is kept for " + label
+		}
 	}
-	return backupTime.Before(cutoff)
+	return "older than every retained bucket"
 }
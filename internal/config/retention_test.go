@@ -1,93 +1,123 @@
 package config
 
 import (
+	"strings"
 	"testing"
 	"time"
 )
 
-func TestRetainUntil(t *testing.T) {
-	now := time.Date(2025, 2, 26, 12, 0, 0, 0, time.UTC)
-
-	t.Run("nil retention returns zero", func(t *testing.T) {
-		got := RetainUntil(now, nil)
-		if !got.IsZero() {
-			t.Errorf("RetainUntil(nil) = %v, want zero", got)
-		}
-	})
-
-	t.Run("zero retention returns zero", func(t *testing.T) {
-		r := &RetentionConfig{Days: 0, Weeks: 0, Months: 0}
-		got := RetainUntil(now, r)
-		if !got.IsZero() {
-			t.Errorf("RetainUntil(zero) = %v, want zero", got)
-		}
-	})
+func ts(s string) time.Time {
+	t, err := time.Parse("20060102150405", s)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
 
-	t.Run("days only", func(t *testing.T) {
-		r := &RetentionConfig{Days: 30, Weeks: 0, Months: 0}
-		got := RetainUntil(now, r)
-		want := time.Date(2025, 1, 27, 12, 0, 0, 0, time.UTC)
-		if !got.Equal(want) {
-			t.Errorf("RetainUntil(days=30) = %v, want %v", got, want)
+func keptSet(decisions []RetentionDecision) map[string]bool {
+	out := make(map[string]bool)
+	for _, d := range decisions {
+		if d.Keep {
+			out[d.Time.Format("20060102150405")] = true
 		}
-	})
+	}
+	return out
+}
 
-	t.Run("weeks override when larger", func(t *testing.T) {
-		r := &RetentionConfig{Days: 7, Weeks: 2, Months: 0} // 14 days > 7
-		got := RetainUntil(now, r)
-		want := now.AddDate(0, 0, -14)
-		if !got.Equal(want) {
-			t.Errorf("RetainUntil(weeks=2) = %v, want %v", got, want)
+func TestPlanRetention_NoPolicyKeepsAll(t *testing.T) {
+	points := []time.Time{ts("20250101000000"), ts("20200101000000")}
+	for _, r := range []*RetentionConfig{nil, {}} {
+		for _, d := range PlanRetention(points, r) {
+			if !d.Keep {
+				t.Errorf("retention %+v dropped %v", r, d.Time)
+			}
 		}
-	})
+	}
+}
 
-	t.Run("months override when largest", func(t *testing.T) {
-		r := &RetentionConfig{Days: 30, Weeks: 4, Months: 2} // 60 days from months
-		got := RetainUntil(now, r)
-		want := now.AddDate(0, 0, -60)
-		if !got.Equal(want) {
-			t.Errorf("RetainUntil(months=2) = %v, want %v", got, want)
-		}
-	})
+func TestPlanRetention_NewestFirst(t *testing.T) {
+	got := PlanRetention([]time.Time{ts("20250101000000"), ts("20250103000000"), ts("20250102000000")}, &RetentionConfig{KeepLast: 1})
+	if len(got) != 3 || !got[0].Time.Equal(ts("20250103000000")) || !got[2].Time.Equal(ts("20250101000000")) {
+		t.Fatalf("order = %v", got)
+	}
+	if !got[0].Keep || got[1].Keep || got[2].Keep {
+		t.Errorf("keep-last 1 decisions = %+v", got)
+	}
 }
 
-func TestIsExpired(t *testing.T) {
-	now := time.Date(2025, 2, 26, 12, 0, 0, 0, time.UTC)
-	r := &RetentionConfig{Days: 30, Weeks: 0, Months: 0}
-	cutoff := now.AddDate(0, 0, -30)
+func TestPlanRetention_DailyPlusMonthly(t *testing.T) {
+	// A year of backups twice a day. days: 7, months: 12 must keep 7 dailies plus 12
+	// monthlies, not every backup of the last 360 days.
+	var points []time.Time
+	start := ts("20250101060000")
+	for d := 0; d < 365; d++ {
+		day := start.AddDate(0, 0, d)
+		points = append(points, day, day.Add(12*time.Hour))
+	}
+	got := PlanRetention(points, &RetentionConfig{Days: 7, Months: 12})
+	kept := keptSet(got)
 
-	t.Run("recent backup not expired", func(t *testing.T) {
-		recent := now.Add(-24 * time.Hour)
-		if IsExpired(recent, now, r) {
-			t.Error("recent backup should not be expired")
+	// 7 dailies (Dec 25-31 evenings); the newest is also December's monthly, so 7 + 11.
+	if len(kept) != 18 {
+		t.Errorf("kept %d points, want 18: %v", len(kept), kept)
+	}
+	for _, want := range []string{"20251231180000", "20251225180000", "20251130180000", "20250131180000"} {
+		if !kept[want] {
+			t.Errorf("%s not kept", want)
 		}
-	})
-
-	t.Run("old backup expired", func(t *testing.T) {
-		old := cutoff.Add(-time.Hour)
-		if !IsExpired(old, now, r) {
-			t.Error("old backup should be expired")
+	}
+	if kept["20251231060000"] {
+		t.Error("older backup of the same day kept")
+	}
+	for _, d := range got {
+		if d.Time.Equal(ts("20251231180000")) && d.Reason() != "daily 2025-12-31, monthly 2025-12" {
+			t.Errorf("newest reason = %q", d.Reason())
 		}
-	})
-
-	t.Run("nil retention never expired", func(t *testing.T) {
-		old := now.AddDate(-1, 0, 0)
-		if IsExpired(old, now, nil) {
-			t.Error("with nil retention, backup should not be expired")
+		if d.Time.Equal(ts("20251231060000")) && d.Reason() != "a newer backup is kept for daily 2025-12-31" {
+			t.Errorf("same-day reason = %q", d.Reason())
 		}
-	})
-
-	t.Run("backup exactly at cutoff not expired", func(t *testing.T) {
-		atCutoff := cutoff
-		if IsExpired(atCutoff, now, r) {
-			t.Error("backup at cutoff time should not be expired (retained)")
+		if d.Time.Equal(ts("20250615060000")) && d.Reason() != "a newer backup is kept for monthly 2025-06" {
+			t.Errorf("mid-year reason = %q", d.Reason())
 		}
-	})
+	}
+}
+
+func TestPlanRetention_BucketsSkipEmptyPeriods(t *testing.T) {
+	// keep_daily counts days that have a backup, not calendar days.
+	points := []time.Time{ts("20250301000000"), ts("20250201000000"), ts("20250101000000"), ts("20241201000000")}
+	got := PlanRetention(points, &RetentionConfig{KeepDaily: 3})
+	kept := keptSet(got)
+	if len(kept) != 3 || kept["20241201000000"] {
+		t.Errorf("kept = %v, want the three newest", kept)
+	}
+	if last := got[len(got)-1]; !strings.HasPrefix(last.Reason(), "older than") {
+		t.Errorf("oldest reason = %q", last.Reason())
+	}
+}
 
-	t.Run("backup just before cutoff expired", func(t *testing.T) {
-		justBefore := cutoff.Add(-time.Second)
-		if !IsExpired(justBefore, now, r) {
-			t.Error("backup just before cutoff should be expired")
+func TestPlanRetention_HourlyWeeklyYearly(t *testing.T) {
+	points := []time.Time{
+		ts("20250305103000"), ts("20250305100000"), // same hour
+		ts("20250305090000"),
+		ts("20250303000000"), // Monday of ISO week 10, same week as the above
+		ts("20250226000000"), // week 9
+		ts("20240601000000"),
+		ts("20230601000000"),
+	}
+	kept := keptSet(PlanRetention(points, &RetentionConfig{KeepHourly: 2, KeepWeekly: 2, KeepYearly: 3}))
+	want := map[string]bool{
+		"20250305103000": true, // hourly, weekly 2025-W10, yearly 2025
+		"20250305090000": true, // hourly
+		"20250226000000": true, // weekly 2025-W09
+		"20240601000000": true, // yearly 2024
+		"20230601000000": true, // yearly 2023
+	}
+	if len(kept) != len(want) {
+		t.Errorf("kept = %v, want %v", kept, want)
+	}
+	for k := range want {
+		if !kept[k] {
+			t.Errorf("%s not kept", k)
 		}
-	})
+	}
 }
@@ -3,6 +3,10 @@ package config
 import (
 	"errors"
 	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
 )
 
 var ErrInvalidMode = errors.New("invalid mode: must be exactly 'archive' or 'incremental'")
@@ -16,6 +20,14 @@ func Validate(cfg *Config) error {
 		if cfg.S3 != nil {
 			cfg.S3.Prefix = NormalizePrefix(cfg.S3.Prefix)
 		}
+		if err := validateEncryption(cfg.Mode, cfg.Encryption); err != nil {
+			return err
+		}
+		for i := range cfg.Jobs {
+			if err := validateJob(&cfg.Jobs[i]); err != nil {
+				return fmt.Errorf("job %q: %w", cfg.Jobs[i].Name, err)
+			}
+		}
 		return nil
 	case "":
 		return fmt.Errorf("%w (mode is required)", ErrInvalidMode)
@@ -23,3 +35,255 @@ func Validate(cfg *Config) error {
 		return fmt.Errorf("%w: got %q", ErrInvalidMode, cfg.Mode)
 	}
 }
+
+func validateJob(job *JobConfig) error {
+	if err := validateRetention(job.Retention); err != nil {
+		return err
+	}
+	if err := validateChunking(job.Chunking); err != nil {
+		return err
+	}
+	if err := validateMySQL(job.MySQL); err != nil {
+		return err
+	}
+	if err := validatePostgres(job.Postgres); err != nil {
+		return err
+	}
+	if job.Paths != nil {
+		if err := validateExcludes("paths", job.Paths.ExcludeRegex, job.Paths.ExcludeIfPresent, job.Paths.MaxFileSizeMB); err != nil {
+			return err
+		}
+	}
+	if job.Presets != nil {
+		if err := validateExcludes("presets", job.Presets.ExcludeRegex, job.Presets.ExcludeIfPresent, job.Presets.MaxFileSizeMB); err != nil {
+			return err
+		}
+	}
+	if err := validateCommands(job.Commands); err != nil {
+		return err
+	}
+	if err := validateHooks(job.Hooks); err != nil {
+		return err
+	}
+	if job.SpoolDir != "" && !filepath.IsAbs(job.SpoolDir) {
+		return fmt.Errorf("spool_dir must be an absolute path, got %q", job.SpoolDir)
+	}
+	return validateCompression(job.Compression)
+}
+
+func validateRetention(r *RetentionConfig) error {
+	if r == nil {
+		return nil
+	}
+	for _, n := range []int{r.KeepLast, r.KeepHourly, r.KeepDaily, r.KeepWeekly, r.KeepMonthly, r.KeepYearly, r.Days, r.Weeks, r.Months} {
+		if n < 0 {
+			return fmt.Errorf("retention counts must not be negative")
+		}
+	}
+	return nil
+}
+
+func validateMySQL(m *MySQLJobConfig) error {
+	if m == nil {
+		return nil
+	}
+	if m.Host != "" && m.Socket != "" {
+		return fmt.Errorf("mysql: set either host or socket, not both")
+	}
+	if m.Port < 0 || m.Port > 65535 {
+		return fmt.Errorf("mysql.port must be between 1 and 65535, got %d", m.Port)
+	}
+	if m.TimeoutMinutes < 0 {
+		return fmt.Errorf("mysql.timeout_minutes must not be negative")
+	}
+	for _, t := range m.ExcludeTables {
+		db, table, ok := strings.Cut(t, ".")
+		if !ok || db == "" || table == "" {
+			return fmt.Errorf("mysql.exclude_tables: %q is not of the form db.table", t)
+		}
+	}
+	if m.TLS != nil {
+		switch m.TLS.Mode {
+		case "", MySQLTLSDisabled, MySQLTLSPreferred, MySQLTLSRequired, MySQLTLSVerifyCA, MySQLTLSVerifyIdentity:
+		default:
+			return fmt.Errorf("mysql.tls.mode must be one of %s, %s, %s, %s or %s, got %q",
+				MySQLTLSDisabled, MySQLTLSPreferred, MySQLTLSRequired, MySQLTLSVerifyCA, MySQLTLSVerifyIdentity, m.TLS.Mode)
+		}
+	}
+	return nil
+}
+
+func validatePostgres(p *PostgresJobConfig) error {
+	if p == nil {
+		return nil
+	}
+	if p.Host != "" && p.Socket != "" {
+		return fmt.Errorf("postgres: set either host or socket, not both")
+	}
+	if p.Port < 0 || p.Port > 65535 {
+		return fmt.Errorf("postgres.port must be between 1 and 65535, got %d", p.Port)
+	}
+	if p.TimeoutMinutes < 0 {
+		return fmt.Errorf("postgres.timeout_minutes must not be negative")
+	}
+	return nil
+}
+
+func validateExcludes(section string, regex, markers []string, maxFileSizeMB int) error {
+	for _, expr := range regex {
+		if _, err := regexp.Compile(expr); err != nil {
+			return fmt.Errorf("%s.exclude_regex: %w", section, err)
+		}
+	}
+	for _, m := range markers {
+		if m == "" || strings.ContainsAny(m, `/\`) {
+			return fmt.Errorf("%s.exclude_if_present: %q is not a file name", section, m)
+		}
+	}
+	if maxFileSizeMB < 0 {
+		return fmt.Errorf("%s.max_file_size_mb must not be negative", section)
+	}
+	return nil
+}
+
+func validateCommands(commands []CommandConfig) error {
+	seen := make(map[string]bool, len(commands))
+	for i, c := range commands {
+		switch {
+		case c.Name == "" || c.Name == "." || c.Name == ".." || strings.ContainsAny(c.Name, `/\`):
+			return fmt.Errorf("commands[%d]: name must be a file name, got %q", i, c.Name)
+		case seen[c.Name]:
+			return fmt.Errorf("commands[%d]: name %q is used twice", i, c.Name)
+		case strings.TrimSpace(c.Command) == "":
+			return fmt.Errorf("commands[%d]: command is required", i)
+		case c.TimeoutMinutes < 0:
+			return fmt.Errorf("commands[%d].timeout_minutes must not be negative", i)
+		}
+		seen[c.Name] = true
+		for _, kv := range c.Env {
+			if k, _, ok := strings.Cut(kv, "="); !ok || k == "" {
+				return fmt.Errorf("commands[%d].env: %q is not of the form KEY=value", i, kv)
+			}
+		}
+		for _, code := range c.ExpectedExitCodes {
+			if code < 0 || code > 255 {
+				return fmt.Errorf("commands[%d].expected_exit_codes: %d is not an exit code", i, code)
+			}
+		}
+	}
+	return nil
+}
+
+func validateHooks(h *HooksConfig) error {
+	if h == nil {
+		return nil
+	}
+	switch h.OnPreFailure {
+	case "", HookFailureAbort, HookFailureContinue:
+	default:
+		return fmt.Errorf("hooks.on_pre_failure must be %q or %q, got %q", HookFailureAbort, HookFailureContinue, h.OnPreFailure)
+	}
+	for _, list := range []struct {
+		name  string
+		hooks []HookConfig
+	}{{"pre", h.Pre}, {"post", h.Post}, {"on_error", h.OnError}} {
+		for i, hook := range list.hooks {
+			if strings.TrimSpace(hook.Command) == "" {
+				return fmt.Errorf("hooks.%s[%d]: command is required", list.name, i)
+			}
+			if hook.TimeoutSeconds < 0 {
+				return fmt.Errorf("hooks.%s[%d].timeout_seconds must not be negative", list.name, i)
+			}
+			for _, kv := range hook.Env {
+				if k, _, ok := strings.Cut(kv, "="); !ok || k == "" {
+					return fmt.Errorf("hooks.%s[%d].env: %q is not of the form KEY=value", list.name, i, kv)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func validateChunking(c *ChunkingConfig) error {
+	if c == nil {
+		return nil
+	}
+	switch c.Algorithm {
+	case "", ChunkingFixed:
+		return nil
+	case ChunkingFastCDC:
+	default:
+		return fmt.Errorf("chunking.algorithm must be %q or %q, got %q", ChunkingFastCDC, ChunkingFixed, c.Algorithm)
+	}
+	if c.MinSizeKB < 0 || c.AvgSizeKB < 0 || c.MaxSizeKB < 0 {
+		return fmt.Errorf("chunking sizes must not be negative")
+	}
+	// Compare the sizes the chunker will use, so that e.g. a large avg_size_kb without
+	// max_size_kb is checked against the default maximum.
+	min, avg, max := FastCDCSizesKB(c)
+	if min > avg {
+		return fmt.Errorf("chunking.min_size_kb (%d) must not exceed avg_size_kb (%d)%s", min, avg, chunkingDefaultNote(c))
+	}
+	if avg > max {
+		return fmt.Errorf("chunking.avg_size_kb (%d) must not exceed max_size_kb (%d)%s", avg, max, chunkingDefaultNote(c))
+	}
+	return nil
+}
+
+// chunkingDefaultNote explains default sizes in a chunking error when some were omitted.
+func chunkingDefaultNote(c *ChunkingConfig) string {
+	if c.MinSizeKB > 0 && c.AvgSizeKB > 0 && c.MaxSizeKB > 0 {
+		return ""
+	}
+	return fmt.Sprintf("; omitted sizes default to %d / %d / %d", DefaultFastCDCMinSizeKB, DefaultFastCDCAvgSizeKB, DefaultFastCDCMaxSizeKB)
+}
+
+func validateCompression(c *CompressionConfig) error {
+	if c == nil {
+		return nil
+	}
+	format := c.Format
+	if format == "" {
+		format = CompressionGzip // as in archive.CompressionFromConfig
+	}
+	maxLevel := 0
+	switch format {
+	case CompressionNone:
+	case CompressionGzip:
+		maxLevel = 9
+	case CompressionZstd:
+		maxLevel = 22
+	default:
+		return fmt.Errorf("compression.format must be %q, %q or %q, got %q", CompressionNone, CompressionGzip, CompressionZstd, c.Format)
+	}
+	if c.Level < 0 || c.Level > maxLevel {
+		if maxLevel == 0 {
+			return fmt.Errorf("compression.level is not used with format %q", format)
+		}
+		return fmt.Errorf("compression.level for %q must be between 1 and %d, got %d", format, maxLevel, c.Level)
+	}
+	if c.Threads < 0 {
+		return fmt.Errorf("compression.threads must not be negative")
+	}
+	if c.Threads > 0 && format != CompressionZstd {
+		return fmt.Errorf("compression.threads is only supported with format %q", CompressionZstd)
+	}
+	return nil
+}
+
+func validateEncryption(mode string, e *EncryptionConfig) error {
+	if e == nil || !e.Enabled {
+		return nil
+	}
+	hasKey := e.Recipient != "" || e.IdentityFile != ""
+	if hasKey && e.PassphraseFile != "" {
+		return fmt.Errorf("encryption: set either recipient/identity_file or passphrase_file, not both")
+	}
+	if !hasKey && e.PassphraseFile == "" && os.Getenv(EnvEncryptionPassphrase) == "" {
+		return fmt.Errorf("encryption: enabled but no recipient, identity_file, passphrase_file or %s set", EnvEncryptionPassphrase)
+	}
+	if mode == ModeIncremental && e.Recipient != "" && e.IdentityFile == "" {
+		return fmt.Errorf("encryption: incremental mode needs identity_file (a recipient alone cannot derive chunk keys)")
+	}
+	return nil
+}
@@ -77,3 +77,244 @@ func TestValidate_NilS3NoPanic(t *testing.T) {
 		t.Errorf("Validate with nil S3 should succeed: %v", err)
 	}
 }
+
+func TestValidate_Chunking(t *testing.T) {
+	tests := []struct {
+		name     string
+		chunking *ChunkingConfig
+		wantErr  bool
+	}{
+		{"omitted", nil, false},
+		{"fixed", &ChunkingConfig{Algorithm: ChunkingFixed}, false},
+		{"fastcdc defaults", &ChunkingConfig{Algorithm: ChunkingFastCDC}, false},
+		{"fastcdc sizes", &ChunkingConfig{Algorithm: ChunkingFastCDC, MinSizeKB: 512, AvgSizeKB: 2048, MaxSizeKB: 8192}, false},
+		{"unknown algorithm", &ChunkingConfig{Algorithm: "rabin"}, true},
+		{"min above avg", &ChunkingConfig{Algorithm: ChunkingFastCDC, MinSizeKB: 4096, AvgSizeKB: 1024}, true},
+		{"avg above max", &ChunkingConfig{Algorithm: ChunkingFastCDC, AvgSizeKB: 4096, MaxSizeKB: 1024}, true},
+		{"avg above default max", &ChunkingConfig{Algorithm: ChunkingFastCDC, AvgSizeKB: 32768}, true},
+		{"large avg with max", &ChunkingConfig{Algorithm: ChunkingFastCDC, AvgSizeKB: 32768, MaxSizeKB: 65536}, false},
+		{"min above default avg", &ChunkingConfig{Algorithm: ChunkingFastCDC, MinSizeKB: 8192}, true},
+		{"max below default avg", &ChunkingConfig{Algorithm: ChunkingFastCDC, MaxSizeKB: 2048}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &Config{Mode: ModeIncremental, Jobs: []JobConfig{{Name: "j", Chunking: tt.chunking}}}
+			err := Validate(cfg)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Validate() err = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidate_Encryption(t *testing.T) {
+	t.Setenv(EnvEncryptionPassphrase, "")
+	tests := []struct {
+		name    string
+		mode    string
+		enc     *EncryptionConfig
+		wantErr bool
+	}{
+		{"omitted", ModeIncremental, nil, false},
+		{"disabled", ModeIncremental, &EncryptionConfig{}, false},
+		{"no key", ModeArchive, &EncryptionConfig{Enabled: true}, true},
+		{"passphrase file", ModeIncremental, &EncryptionConfig{Enabled: true, PassphraseFile: "/etc/velbackuper/pass"}, false},
+		{"identity", ModeIncremental, &EncryptionConfig{Enabled: true, IdentityFile: "/etc/velbackuper/key.txt"}, false},
+		{"recipient archive", ModeArchive, &EncryptionConfig{Enabled: true, Recipient: "age1xyz"}, false},
+		{"recipient incremental", ModeIncremental, &EncryptionConfig{Enabled: true, Recipient: "age1xyz"}, true},
+		{"key and passphrase", ModeArchive, &EncryptionConfig{Enabled: true, Recipient: "age1xyz", PassphraseFile: "/p"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Validate(&Config{Mode: tt.mode, Encryption: tt.enc})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Validate() err = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidate_Compression(t *testing.T) {
+	tests := []struct {
+		name        string
+		compression *CompressionConfig
+		wantErr     bool
+	}{
+		{"omitted", nil, false},
+		{"none", &CompressionConfig{Format: CompressionNone}, false},
+		{"level without format", &CompressionConfig{Level: 9}, false},
+		{"default format level too high", &CompressionConfig{Level: 10}, true},
+		{"threads without format", &CompressionConfig{Threads: 2}, true},
+		{"gz level", &CompressionConfig{Format: CompressionGzip, Level: 9}, false},
+		{"zst level threads", &CompressionConfig{Format: CompressionZstd, Level: 19, Threads: 4}, false},
+		{"unknown format", &CompressionConfig{Format: "bz2"}, true},
+		{"gz level too high", &CompressionConfig{Format: CompressionGzip, Level: 10}, true},
+		{"zst level too high", &CompressionConfig{Format: CompressionZstd, Level: 23}, true},
+		{"none with level", &CompressionConfig{Format: CompressionNone, Level: 3}, true},
+		{"gz threads", &CompressionConfig{Format: CompressionGzip, Threads: 2}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &Config{Mode: ModeArchive, Jobs: []JobConfig{{Name: "j", Compression: tt.compression}}}
+			err := Validate(cfg)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Validate() err = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidate_Retention(t *testing.T) {
+	ok := &Config{Mode: ModeArchive, Jobs: []JobConfig{{Name: "j", Retention: &RetentionConfig{KeepLast: 3, KeepDaily: 7, Months: 12}}}}
+	if err := Validate(ok); err != nil {
+		t.Errorf("Validate() err = %v", err)
+	}
+	bad := &Config{Mode: ModeArchive, Jobs: []JobConfig{{Name: "j", Retention: &RetentionConfig{KeepWeekly: -1}}}}
+	if err := Validate(bad); err == nil {
+		t.Error("Validate() accepted a negative retention count")
+	}
+}
+
+func TestValidate_SpoolDir(t *testing.T) {
+	ok := &Config{Mode: ModeArchive, Jobs: []JobConfig{{Name: "j", SpoolDir: "/var/tmp/velbackuper"}}}
+	if err := Validate(ok); err != nil {
+		t.Errorf("Validate() err = %v", err)
+	}
+	bad := &Config{Mode: ModeArchive, Jobs: []JobConfig{{Name: "j", SpoolDir: "tmp"}}}
+	if err := Validate(bad); err == nil {
+		t.Error("Validate() accepted a relative spool_dir")
+	}
+}
+
+func TestValidate_MySQL(t *testing.T) {
+	tests := []struct {
+		name    string
+		mysql   *MySQLJobConfig
+		wantErr bool
+	}{
+		{"omitted", nil, false},
+		{"remote", &MySQLJobConfig{Enabled: true, Host: "db.example.com", Port: 3307, User: "backup", PasswordFile: "/etc/velbackuper/mysql.pass",
+			TLS: &MySQLTLSConfig{Mode: MySQLTLSVerifyIdentity, CA: "/etc/ssl/ca.pem"}}, false},
+		{"filters", &MySQLJobConfig{Enabled: true, Databases: []string{"shop"}, ExcludeTables: []string{"shop.sessions"}}, false},
+		{"host and socket", &MySQLJobConfig{Host: "db", Socket: "/run/mysqld/mysqld.sock"}, true},
+		{"bad port", &MySQLJobConfig{Port: 70000}, true},
+		{"table without db", &MySQLJobConfig{ExcludeTables: []string{"sessions"}}, true},
+		{"unknown tls mode", &MySQLJobConfig{TLS: &MySQLTLSConfig{Mode: "always"}}, true},
+		{"negative timeout", &MySQLJobConfig{TimeoutMinutes: -1}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &Config{Mode: ModeArchive, Jobs: []JobConfig{{Name: "j", MySQL: tt.mysql}}}
+			err := Validate(cfg)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Validate() err = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidate_Postgres(t *testing.T) {
+	tests := []struct {
+		name     string
+		postgres *PostgresJobConfig
+		wantErr  bool
+	}{
+		{"omitted", nil, false},
+		{"socket", &PostgresJobConfig{Enabled: true, Globals: true, Socket: "/var/run/postgresql", User: "postgres"}, false},
+		{"remote", &PostgresJobConfig{Enabled: true, Host: "db.example.com", Port: 5432, PasswordFile: "/etc/velbackuper/pg.pass"}, false},
+		{"host and socket", &PostgresJobConfig{Host: "db", Socket: "/var/run/postgresql"}, true},
+		{"bad port", &PostgresJobConfig{Port: -1}, true},
+		{"negative timeout", &PostgresJobConfig{TimeoutMinutes: -5}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &Config{Mode: ModeArchive, Jobs: []JobConfig{{Name: "j", Postgres: tt.postgres}}}
+			err := Validate(cfg)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Validate() err = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidate_Hooks(t *testing.T) {
+	tests := []struct {
+		name    string
+		hooks   *HooksConfig
+		wantErr bool
+	}{
+		{"omitted", nil, false},
+		{"valid", &HooksConfig{
+			Pre:          []HookConfig{{Name: "maintenance on", Command: "wp maintenance-mode activate", TimeoutSeconds: 30}},
+			Post:         []HookConfig{{Command: "wp maintenance-mode deactivate", Env: []string{"WP_CLI_ALLOW_ROOT=1"}}},
+			OnPreFailure: HookFailureContinue,
+		}, false},
+		{"bad policy", &HooksConfig{OnPreFailure: "ignore"}, true},
+		{"empty command", &HooksConfig{Post: []HookConfig{{Name: "x", Command: " "}}}, true},
+		{"negative timeout", &HooksConfig{OnError: []HookConfig{{Command: "true", TimeoutSeconds: -1}}}, true},
+		{"bad env", &HooksConfig{Pre: []HookConfig{{Command: "true", Env: []string{"NOVALUE"}}}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &Config{Mode: ModeArchive, Jobs: []JobConfig{{Name: "j", Hooks: tt.hooks}}}
+			err := Validate(cfg)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Validate() err = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidate_Commands(t *testing.T) {
+	redis := CommandConfig{Name: "redis.rdb", Command: "redis-cli --rdb -"}
+	tests := []struct {
+		name     string
+		commands []CommandConfig
+		wantErr  bool
+	}{
+		{"omitted", nil, false},
+		{"valid", []CommandConfig{redis, {Name: "ldap.ldif", Command: "ldapsearch -LLL", User: "openldap",
+			Env: []string{"LDAPTLS_REQCERT=never"}, ExpectedExitCodes: []int{0, 4}, TimeoutMinutes: 5}}, false},
+		{"no name", []CommandConfig{{Command: "true"}}, true},
+		{"path in name", []CommandConfig{{Name: "../etc/passwd", Command: "true"}}, true},
+		{"duplicate name", []CommandConfig{redis, redis}, true},
+		{"no command", []CommandConfig{{Name: "x"}}, true},
+		{"negative timeout", []CommandConfig{{Name: "x", Command: "true", TimeoutMinutes: -1}}, true},
+		{"bad env", []CommandConfig{{Name: "x", Command: "true", Env: []string{"=v"}}}, true},
+		{"bad exit code", []CommandConfig{{Name: "x", Command: "true", ExpectedExitCodes: []int{256}}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &Config{Mode: ModeArchive, Jobs: []JobConfig{{Name: "j", Commands: tt.commands}}}
+			err := Validate(cfg)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Validate() err = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidate_Excludes(t *testing.T) {
+	tests := []struct {
+		name    string
+		job     JobConfig
+		wantErr bool
+	}{
+		{"paths", JobConfig{Paths: &PathsConfig{Include: []string{"/srv"}, Exclude: []string{"**/node_modules", "*.log"},
+			ExcludeRegex: []string{`\.sql\.gz$`}, ExcludeIfPresent: []string{".nobackup"}, MaxFileSizeMB: 512, OneFileSystem: true}}, false},
+		{"presets", JobConfig{Presets: &PresetsConfig{Nginx: true, Exclude: []string{"cache/"}, MaxFileSizeMB: 100}}, false},
+		{"bad regex", JobConfig{Paths: &PathsConfig{ExcludeRegex: []string{"(unclosed"}}}, true},
+		{"marker path", JobConfig{Presets: &PresetsConfig{ExcludeIfPresent: []string{"a/.nobackup"}}}, true},
+		{"negative size", JobConfig{Paths: &PathsConfig{MaxFileSizeMB: -1}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.job.Name = "j"
+			cfg := &Config{Mode: ModeArchive, Jobs: []JobConfig{tt.job}}
+			err := Validate(cfg)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Validate() err = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,313 @@
+// Package crypt implements client-side encryption of backup data.
+//
+// Archive streams are encrypted with age (https://age-encryption.org) to an X25519
+// recipient or a passphrase. Incremental objects (chunks, indexes, snapshots) are sealed
+// individually with XChaCha20-Poly1305 under a key derived from the identity or passphrase
+// and a random salt stored once per repository, and chunks are addressed by a keyed BLAKE3
+// hash so identical data still deduplicates without revealing plaintext hashes.
+package crypt
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"filippo.io/age"
+	"github.com/zeebo/blake3"
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/hkdf"
+	"golang.org/x/crypto/scrypt"
+
+	"VelBackuper/internal/config"
+)
+
+// ErrWrongKey is returned when data cannot be decrypted with the configured key.
+var ErrWrongKey = errors.New("decryption failed: wrong encryption key or corrupted data")
+
+// ErrNotEncrypted is returned when encryption is enabled and an incremental object is not
+// sealed, unless plaintext objects are allowed.
+var ErrNotEncrypted = errors.New("object is not encrypted; set encryption.allow_plaintext to read data written before encryption was enabled")
+
+// ArchiveSuffix is appended to the object key of encrypted archives.
+const ArchiveSuffix = ".age"
+
+// sealMagic prefixes every object sealed by Keyring.Seal.
+var sealMagic = []byte("VBE1")
+
+// keyContext separates the keys derived here from other uses of the same secret.
+const keyContext = "velbackuper encryption v1"
+
+// SaltSize is the size of the random salt an encrypted repository stores (see NewSalt).
+const SaltSize = 32
+
+// Keyring holds the keys for one repository. A nil *Keyring means encryption is disabled:
+// its methods pass data through unchanged and ChunkID is the plain BLAKE3 hash.
+type Keyring struct {
+	recipients []age.Recipient
+	identities []age.Identity
+	// passphrase or identity is the secret the keys for incremental objects are derived from by
+	// DeriveKeys; both are empty for recipient-only keyrings.
+	passphrase string
+	identity   string
+	sealKey    []byte // XChaCha20-Poly1305 key for incremental objects; nil until DeriveKeys
+	idKey      []byte // keyed BLAKE3 key for chunk IDs; nil until DeriveKeys
+	// allowPlaintext lets Open return objects without the sealed header unchanged.
+	allowPlaintext bool
+}
+
+// New builds a Keyring from cfg. It returns nil, nil when encryption is disabled. The keyring
+// encrypts archives right away; incremental objects need DeriveKeys first.
+func New(cfg *config.EncryptionConfig) (*Keyring, error) {
+	if cfg == nil || !cfg.Enabled {
+		return nil, nil
+	}
+	passphrase, err := readPassphrase(cfg)
+	if err != nil {
+		return nil, err
+	}
+	k := &Keyring{allowPlaintext: cfg.AllowPlaintext}
+
+	switch {
+	case passphrase != "":
+		r, err := age.NewScryptRecipient(passphrase)
+		if err != nil {
+			return nil, fmt.Errorf("encryption passphrase: %w", err)
+		}
+		id, err := age.NewScryptIdentity(passphrase)
+		if err != nil {
+			return nil, fmt.Errorf("encryption passphrase: %w", err)
+		}
+		k.recipients = []age.Recipient{r}
+		k.identities = []age.Identity{id}
+		k.passphrase = passphrase
+	default:
+		if cfg.IdentityFile != "" {
+			id, err := readIdentity(cfg.IdentityFile)
+			if err != nil {
+				return nil, err
+			}
+			k.identities = []age.Identity{id}
+			k.recipients = []age.Recipient{id.Recipient()}
+			k.identity = id.String()
+		}
+		if cfg.Recipient != "" {
+			r, err := age.ParseX25519Recipient(strings.TrimSpace(cfg.Recipient))
+			if err != nil {
+				return nil, fmt.Errorf("encryption recipient: %w", err)
+			}
+			// Added to the identity's own recipient, so archives stay readable on this host.
+			k.recipients = append(k.recipients, r)
+		}
+		if len(k.recipients) == 0 {
+			return nil, fmt.Errorf("encryption enabled but no recipient, identity_file or passphrase configured")
+		}
+	}
+
+	return k, nil
+}
+
+// NewSalt returns a random salt for a new encrypted repository.
+func NewSalt() ([]byte, error) {
+	salt := make([]byte, SaltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	return salt, nil
+}
+
+// DeriveKeys derives the keys for incremental objects from the secret and the salt of the
+// repository, so that repositories sharing a passphrase or identity share neither keys nor
+// chunk IDs. It does nothing for nil and recipient-only keyrings.
+func (k *Keyring) DeriveKeys(salt []byte) error {
+	if k == nil || (k.passphrase == "" && k.identity == "") {
+		return nil
+	}
+	if len(salt) < 16 {
+		return fmt.Errorf("encryption: repository salt is %d bytes, want at least 16", len(salt))
+	}
+	var master []byte
+	if k.passphrase != "" {
+		var err error
+		if master, err = scrypt.Key([]byte(k.passphrase), salt, 1<<15, 8, 1, 32); err != nil {
+			return fmt.Errorf("derive key from passphrase: %w", err)
+		}
+	} else {
+		master = make([]byte, 32)
+		if _, err := io.ReadFull(hkdf.New(sha256.New, []byte(k.identity), salt, []byte(keyContext)), master); err != nil {
+			return fmt.Errorf("derive key from identity: %w", err)
+		}
+	}
+	k.sealKey = make([]byte, chacha20poly1305.KeySize)
+	blake3.DeriveKey("velbackuper 2025 object encryption", master, k.sealKey)
+	k.idKey = make([]byte, 32)
+	blake3.DeriveKey("velbackuper 2025 chunk id", master, k.idKey)
+	return nil
+}
+
+// errNoSealKey explains why a keyring without derived keys cannot seal or open an object.
+func (k *Keyring) errNoSealKey() error {
+	if k.passphrase == "" && k.identity == "" {
+		return fmt.Errorf("encryption: incremental mode needs identity_file or a passphrase, not only a recipient")
+	}
+	return fmt.Errorf("encryption: keys not derived; the repository has no encryption salt yet")
+}
+
+func readPassphrase(cfg *config.EncryptionConfig) (string, error) {
+	if cfg.PassphraseFile != "" {
+		data, err := os.ReadFile(cfg.PassphraseFile)
+		if err != nil {
+			return "", fmt.Errorf("read passphrase file: %w", err)
+		}
+		return strings.TrimRight(string(data), "\r\n"), nil
+	}
+	return os.Getenv(config.EnvEncryptionPassphrase), nil
+}
+
+func readIdentity(path string) (*age.X25519Identity, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("read identity file: %w", err)
+	}
+	defer f.Close()
+	ids, err := age.ParseIdentities(f)
+	if err != nil {
+		return nil, fmt.Errorf("parse identity file %s: %w", path, err)
+	}
+	for _, id := range ids {
+		if x, ok := id.(*age.X25519Identity); ok {
+			return x, nil
+		}
+	}
+	return nil, fmt.Errorf("identity file %s has no X25519 identity", path)
+}
+
+// Enabled reports whether k encrypts data.
+func (k *Keyring) Enabled() bool {
+	return k != nil
+}
+
+// CanDecrypt reports whether k holds a secret able to decrypt data.
+func (k *Keyring) CanDecrypt() bool {
+	return k != nil && len(k.identities) > 0
+}
+
+// ChunkID returns the content address of a chunk: keyed BLAKE3 when encryption is enabled,
+// plain BLAKE3 otherwise. Both are 64 hex characters. An enabled keyring must have its keys
+// derived, or chunk IDs would silently reveal content hashes.
+func (k *Keyring) ChunkID(data []byte) string {
+	if k == nil {
+		sum := blake3.Sum256(data)
+		return hex.EncodeToString(sum[:])
+	}
+	if k.idKey == nil {
+		panic("crypt: ChunkID called before DeriveKeys")
+	}
+	h, _ := blake3.NewKeyed(k.idKey)
+	_, _ = h.Write(data)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// Seal encrypts an incremental object. aad binds the ciphertext to where it is stored
+// (e.g. the object key) so objects cannot be swapped.
+func (k *Keyring) Seal(plaintext, aad []byte) ([]byte, error) {
+	if k == nil {
+		return plaintext, nil
+	}
+	if k.sealKey == nil {
+		return nil, k.errNoSealKey()
+	}
+	aead, err := chacha20poly1305.NewX(k.sealKey)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]byte, len(sealMagic)+aead.NonceSize(), len(sealMagic)+aead.NonceSize()+len(plaintext)+aead.Overhead())
+	copy(out, sealMagic)
+	nonce := out[len(sealMagic):]
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(out, nonce, plaintext, aad), nil
+}
+
+// Open decrypts an object sealed by Seal. Objects without the sealed header are returned
+// unchanged when encryption is disabled or plaintext objects are allowed, and refused with
+// ErrNotEncrypted otherwise.
+func (k *Keyring) Open(data, aad []byte) ([]byte, error) {
+	if !IsSealed(data) {
+		if k != nil && !k.allowPlaintext {
+			return nil, ErrNotEncrypted
+		}
+		return data, nil
+	}
+	if k == nil || (k.passphrase == "" && k.identity == "") {
+		return nil, fmt.Errorf("object is encrypted; configure encryption with identity_file or a passphrase to read it")
+	}
+	if k.sealKey == nil {
+		return nil, k.errNoSealKey()
+	}
+	aead, err := chacha20poly1305.NewX(k.sealKey)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < len(sealMagic)+aead.NonceSize()+aead.Overhead() {
+		return nil, ErrWrongKey
+	}
+	nonce := data[len(sealMagic) : len(sealMagic)+aead.NonceSize()]
+	plain, err := aead.Open(nil, nonce, data[len(sealMagic)+aead.NonceSize():], aad)
+	if err != nil {
+		return nil, ErrWrongKey
+	}
+	return plain, nil
+}
+
+// IsSealed reports whether data starts with the header written by Seal.
+func IsSealed(data []byte) bool {
+	return bytes.HasPrefix(data, sealMagic)
+}
+
+// EncryptReader returns a reader of r encrypted as an age file. With a nil Keyring it returns r.
+func (k *Keyring) EncryptReader(r io.Reader) (io.Reader, error) {
+	if k == nil {
+		return r, nil
+	}
+	pr, pw := io.Pipe()
+	go func() {
+		w, err := age.Encrypt(pw, k.recipients...)
+		if err != nil {
+			_ = pw.CloseWithError(err)
+			return
+		}
+		if _, err := io.Copy(w, r); err != nil {
+			_ = pw.CloseWithError(err)
+			return
+		}
+		if err := w.Close(); err != nil {
+			_ = pw.CloseWithError(err)
+			return
+		}
+		_ = pw.Close()
+	}()
+	return pr, nil
+}
+
+// DecryptReader returns a reader of the plaintext of the age file r.
+func (k *Keyring) DecryptReader(r io.Reader) (io.Reader, error) {
+	if !k.CanDecrypt() {
+		return nil, fmt.Errorf("backup is encrypted; configure encryption with identity_file or a passphrase to read it")
+	}
+	dr, err := age.Decrypt(r, k.identities...)
+	if err != nil {
+		var noMatch *age.NoIdentityMatchError
+		if errors.As(err, &noMatch) {
+			return nil, ErrWrongKey
+		}
+		return nil, fmt.Errorf("decrypt: %w", err)
+	}
+	return dr, nil
+}
@@ -0,0 +1,251 @@
+package crypt
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"filippo.io/age"
+
+	"VelBackuper/internal/config"
+)
+
+func writeFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// testSalt stands in for the salt of the repository the keyrings of a test belong to.
+var testSalt = bytes.Repeat([]byte{0x5a}, SaltSize)
+
+func passphraseKeyring(t *testing.T, pass string) *Keyring {
+	t.Helper()
+	k, err := New(&config.EncryptionConfig{Enabled: true, PassphraseFile: writeFile(t, "pass", pass+"\n")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := k.DeriveKeys(testSalt); err != nil {
+		t.Fatal(err)
+	}
+	return k
+}
+
+func identityKeyring(t *testing.T) (*Keyring, *age.X25519Identity) {
+	t.Helper()
+	id, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	k, err := New(&config.EncryptionConfig{Enabled: true, IdentityFile: writeFile(t, "key.txt", "# test key\n"+id.String()+"\n")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := k.DeriveKeys(testSalt); err != nil {
+		t.Fatal(err)
+	}
+	return k, id
+}
+
+func TestNew_Disabled(t *testing.T) {
+	for _, cfg := range []*config.EncryptionConfig{nil, {Enabled: false, Recipient: "age1x"}} {
+		k, err := New(cfg)
+		if err != nil || k != nil {
+			t.Errorf("New(%+v) = %v, %v; want nil, nil", cfg, k, err)
+		}
+	}
+}
+
+func TestNew_PassphraseFromEnv(t *testing.T) {
+	t.Setenv(config.EnvEncryptionPassphrase, "from env")
+	k, err := New(&config.EncryptionConfig{Enabled: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := k.DeriveKeys(testSalt); err != nil {
+		t.Fatal(err)
+	}
+	if k.ChunkID([]byte("x")) != passphraseKeyring(t, "from env").ChunkID([]byte("x")) {
+		t.Error("env and file passphrases derive different keys")
+	}
+}
+
+func TestSealOpen(t *testing.T) {
+	k, _ := identityKeyring(t)
+	plain := []byte("chunk data")
+
+	sealed, err := k.Seal(plain, []byte("objects/ab/abcd"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(sealed, plain) {
+		t.Error("sealed object contains plaintext")
+	}
+	got, err := k.Open(sealed, []byte("objects/ab/abcd"))
+	if err != nil || !bytes.Equal(got, plain) {
+		t.Fatalf("Open = %q, %v", got, err)
+	}
+
+	if _, err := k.Open(sealed, []byte("objects/ab/other")); !errors.Is(err, ErrWrongKey) {
+		t.Errorf("Open with other aad err = %v, want ErrWrongKey", err)
+	}
+	other, _ := identityKeyring(t)
+	if _, err := other.Open(sealed, []byte("objects/ab/abcd")); !errors.Is(err, ErrWrongKey) {
+		t.Errorf("Open with other key err = %v, want ErrWrongKey", err)
+	}
+	var none *Keyring
+	if _, err := none.Open(sealed, nil); err == nil {
+		t.Error("Open without keyring should fail on encrypted data")
+	}
+
+	// Unsealed objects are refused unless plaintext is allowed for data written before
+	// encryption was enabled.
+	if _, err := k.Open([]byte(`{"job":"a"}`), nil); !errors.Is(err, ErrNotEncrypted) {
+		t.Errorf("Open(plaintext) err = %v, want ErrNotEncrypted", err)
+	}
+	k.allowPlaintext = true
+	if got, err := k.Open([]byte(`{"job":"a"}`), nil); err != nil || string(got) != `{"job":"a"}` {
+		t.Errorf("Open(legacy) with allow_plaintext = %q, %v", got, err)
+	}
+	if got, err := none.Open([]byte(`{"job":"a"}`), nil); err != nil || string(got) != `{"job":"a"}` {
+		t.Errorf("Open without keyring = %q, %v", got, err)
+	}
+}
+
+func TestChunkID(t *testing.T) {
+	data := []byte("same data")
+	var none *Keyring
+	a := passphraseKeyring(t, "one")
+	b := passphraseKeyring(t, "one")
+	c := passphraseKeyring(t, "two")
+
+	if a.ChunkID(data) != b.ChunkID(data) {
+		t.Error("same passphrase must give the same chunk IDs for deduplication")
+	}
+	if a.ChunkID(data) == c.ChunkID(data) || a.ChunkID(data) == none.ChunkID(data) {
+		t.Error("chunk IDs must depend on the key")
+	}
+	// Another repository with the same passphrase has its own salt and so its own keys.
+	otherRepo, err := New(&config.EncryptionConfig{Enabled: true, PassphraseFile: writeFile(t, "pass", "one\n")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	salt, err := NewSalt()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := otherRepo.DeriveKeys(salt); err != nil {
+		t.Fatal(err)
+	}
+	if otherRepo.ChunkID(data) == a.ChunkID(data) {
+		t.Error("chunk IDs must depend on the repository salt")
+	}
+	if len(a.ChunkID(data)) != 64 || len(none.ChunkID(data)) != 64 {
+		t.Error("chunk IDs must be 64 hex characters")
+	}
+}
+
+func TestEncryptDecryptReader(t *testing.T) {
+	plain := bytes.Repeat([]byte("archive stream "), 10000)
+	for name, k := range map[string]*Keyring{
+		"passphrase": passphraseKeyring(t, "secret"),
+		"identity":   func() *Keyring { k, _ := identityKeyring(t); return k }(),
+	} {
+		t.Run(name, func(t *testing.T) {
+			er, err := k.EncryptReader(bytes.NewReader(plain))
+			if err != nil {
+				t.Fatal(err)
+			}
+			enc, err := io.ReadAll(er)
+			if err != nil {
+				t.Fatal(err)
+			}
+			dr, err := k.DecryptReader(bytes.NewReader(enc))
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := io.ReadAll(dr)
+			if err != nil || !bytes.Equal(got, plain) {
+				t.Fatalf("round trip failed: %v", err)
+			}
+		})
+	}
+}
+
+func TestRecipientOnly(t *testing.T) {
+	_, id := identityKeyring(t)
+	k, err := New(&config.EncryptionConfig{Enabled: true, Recipient: id.Recipient().String()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	er, _ := k.EncryptReader(bytes.NewReader([]byte("backup")))
+	enc, _ := io.ReadAll(er)
+
+	if _, err := k.DecryptReader(bytes.NewReader(enc)); err == nil {
+		t.Error("recipient-only keyring must not decrypt")
+	}
+	if _, err := k.Seal([]byte("chunk"), nil); err == nil {
+		t.Error("recipient-only keyring cannot seal incremental objects")
+	}
+
+	reader, _ := identityKeyring(t)
+	if _, err := reader.DecryptReader(bytes.NewReader(enc)); !errors.Is(err, ErrWrongKey) {
+		t.Errorf("wrong identity err = %v, want ErrWrongKey", err)
+	}
+}
+
+func TestDeriveKeys(t *testing.T) {
+	k, err := New(&config.EncryptionConfig{Enabled: true, PassphraseFile: writeFile(t, "pass", "secret\n")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := k.Seal([]byte("chunk"), nil); err == nil || !strings.Contains(err.Error(), "salt") {
+		t.Errorf("Seal before DeriveKeys err = %v, want missing salt", err)
+	}
+	if err := k.DeriveKeys([]byte("short")); err == nil {
+		t.Error("DeriveKeys accepted a 5-byte salt")
+	}
+	if err := k.DeriveKeys(testSalt); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := k.Seal([]byte("chunk"), nil); err != nil {
+		t.Errorf("Seal after DeriveKeys: %v", err)
+	}
+}
+
+func TestIdentityWithRecipient(t *testing.T) {
+	_, other := identityKeyring(t)
+	id, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	k, err := New(&config.EncryptionConfig{
+		Enabled:      true,
+		IdentityFile: writeFile(t, "key.txt", id.String()+"\n"),
+		Recipient:    other.Recipient().String(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	er, _ := k.EncryptReader(bytes.NewReader([]byte("backup")))
+	enc, _ := io.ReadAll(er)
+
+	// Both the configured identity and the extra recipient can decrypt.
+	for name, ids := range map[string]age.Identity{"identity": id, "recipient": other} {
+		dr, err := age.Decrypt(bytes.NewReader(enc), ids)
+		if err != nil {
+			t.Errorf("%s cannot decrypt: %v", name, err)
+			continue
+		}
+		if got, _ := io.ReadAll(dr); string(got) != "backup" {
+			t.Errorf("%s decrypted %q", name, got)
+		}
+	}
+}
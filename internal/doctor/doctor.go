@@ -44,15 +44,16 @@ func Run(ctx context.Context, cfg *config.Config) []CheckResult {
 
 func checkS3(ctx context.Context, cfg *config.Config) (bool, string) {
 	client, err := s3.New(ctx, s3.Options{
-		Endpoint:                cfg.S3.Endpoint,
-		Region:                  cfg.S3.Region,
-		AccessKey:               cfg.S3.AccessKey,
-		SecretKey:               cfg.S3.SecretKey,
-		Bucket:                  cfg.S3.Bucket,
-		Prefix:                  cfg.S3.Prefix,
-		PathStyle:               config.S3PathStyle(cfg.S3),
-		DisableRequestChecksums: config.S3DisableRequestChecksums(cfg.S3),
-		InsecureSkipVerify:      cfg.S3.TLS != nil && cfg.S3.TLS.InsecureSkipVerify,
+		Endpoint:                 cfg.S3.Endpoint,
+		Region:                   cfg.S3.Region,
+		AccessKey:                cfg.S3.AccessKey,
+		SecretKey:                cfg.S3.SecretKey,
+		Bucket:                   cfg.S3.Bucket,
+		Prefix:                   cfg.S3.Prefix,
+		PathStyle:                config.S3PathStyle(cfg.S3),
+		DisableRequestChecksums:  config.S3DisableRequestChecksums(cfg.S3),
+		DisableConditionalWrites: !config.S3ConditionalWrites(cfg.S3),
+		InsecureSkipVerify:       cfg.S3.TLS != nil && cfg.S3.TLS.InsecureSkipVerify,
 	})
 	if err != nil {
 		return false, fmt.Sprintf("s3 client init failed: %v", err)
@@ -79,7 +80,7 @@ func checkLocalLock() (bool, string) {
 	if err := l.Release(context.Background()); err != nil {
 		return false, fmt.Sprintf("local lock release failed: %v", err)
 	}
-	return true, fmt.Sprintf("local lock dir accessible (%s)", lock.DefaultLockDir)
+	return true, fmt.Sprintf("local lock dir accessible (%s)", lock.Dir())
 }
 
 func checkDisk() (bool, string) {
@@ -2,7 +2,11 @@ package archive
 
 import (
 	"compress/gzip"
+	"fmt"
 	"io"
+	"strings"
+
+	"VelBackuper/internal/config"
 
 	"github.com/klauspost/compress/zstd"
 )
@@ -15,19 +19,92 @@ const (
 	FormatZstd CompressionFormat = "zst"
 )
 
-func NewCompressReader(r io.Reader, format CompressionFormat, level int) (io.Reader, error) {
+// DefaultCompression is used by jobs without a compression block.
+var DefaultCompression = CompressionOptions{Format: FormatGzip, Level: 6}
+
+// CompressionOptions selects how an archive stream is compressed. Level 0 uses the codec's
+// default (gzip 1-9, zstd 1-22); Threads applies to zstd only (0 = one per CPU).
+type CompressionOptions struct {
+	Format  CompressionFormat
+	Level   int
+	Threads int
+}
+
+// CompressionFromConfig returns the compression for a job; nil selects DefaultCompression,
+// and a block without a format DefaultCompression at its level.
+func CompressionFromConfig(c *config.CompressionConfig) CompressionOptions {
+	if c == nil {
+		return DefaultCompression
+	}
+	if c.Format == "" {
+		opts := DefaultCompression
+		if c.Level > 0 {
+			opts.Level = c.Level
+		}
+		return opts
+	}
+	opts := CompressionOptions{Format: CompressionFormat(c.Format), Level: c.Level, Threads: c.Threads}
+	if c.Format == config.CompressionNone {
+		opts.Format = FormatTar
+	}
+	return opts
+}
+
+// ManifestFormat is the archive format recorded in Manifest.Format, e.g. "tar.zst".
+func (f CompressionFormat) ManifestFormat() string {
+	return strings.TrimPrefix(formatExtension(f), ".")
+}
+
+// FormatFromManifest returns the compression of an archive from its Manifest.Format.
+func FormatFromManifest(format string) (CompressionFormat, error) {
 	switch format {
+	case "tar", "none":
+		return FormatTar, nil
+	case "tar.gz", "tgz", "gz":
+		return FormatGzip, nil
+	case "tar.zst", "zst":
+		return FormatZstd, nil
+	default:
+		return "", fmt.Errorf("unknown archive format %q", format)
+	}
+}
+
+func NewCompressReader(r io.Reader, format CompressionFormat, level int) (io.Reader, error) {
+	return Compress(r, CompressionOptions{Format: format, Level: level})
+}
+
+// Compress returns a reader of r compressed according to opts.
+func Compress(r io.Reader, opts CompressionOptions) (io.Reader, error) {
+	switch opts.Format {
 	case FormatTar:
 		return r, nil
 	case FormatGzip:
-		return newGzipReader(r, level)
+		return newGzipReader(r, opts.Level)
 	case FormatZstd:
-		return newZstdReader(r, level)
+		return newZstdReader(r, opts.Level, opts.Threads)
 	default:
 		return r, nil
 	}
 }
 
+// NewDecompressReader returns a reader of the tar stream inside an archive compressed with format.
+func NewDecompressReader(r io.Reader, format CompressionFormat) (io.ReadCloser, error) {
+	switch format {
+	case FormatTar:
+		return io.NopCloser(r), nil
+	case FormatGzip:
+		return gzip.NewReader(r)
+	case FormatZstd:
+		zr, err := zstd.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		return zr.IOReadCloser(), nil
+	default:
+		return nil, fmt.Errorf("unknown compression format %q", format)
+	}
+}
+
 func newGzipReader(r io.Reader, level int) (io.Reader, error) {
 	if level < 1 {
 		level = gzip.DefaultCompression
@@ -57,10 +134,17 @@ func newGzipReader(r io.Reader, level int) (io.Reader, error) {
 	return pr, nil
 }
 
-func newZstdReader(r io.Reader, _ int) (io.Reader, error) {
+func newZstdReader(r io.Reader, level, threads int) (io.Reader, error) {
+	zopts := []zstd.EOption{}
+	if level > 0 {
+		zopts = append(zopts, zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(level)))
+	}
+	if threads > 0 {
+		zopts = append(zopts, zstd.WithEncoderConcurrency(threads))
+	}
 	pr, pw := io.Pipe()
 	go func() {
-		zw, err := zstd.NewWriter(pw)
+		zw, err := zstd.NewWriter(pw, zopts...)
 		if err != nil {
 			_ = pw.CloseWithError(err)
 			return
@@ -3,9 +3,12 @@ package archive
 import (
 	"bytes"
 	"compress/gzip"
+	"fmt"
 	"io"
 	"testing"
 
+	"VelBackuper/internal/config"
+
 	"github.com/klauspost/compress/zstd"
 )
 
@@ -92,3 +95,63 @@ func TestNewCompressReader_Unknown_Identity(t *testing.T) {
 		t.Errorf("unknown format should pass through: got %q", out)
 	}
 }
+
+func TestCompress_ZstdHonoursLevel(t *testing.T) {
+	var input []byte
+	for i := 0; i < 20000; i++ {
+		input = append(input, []byte(fmt.Sprintf("INSERT INTO t VALUES (%d, 'row %d');\n", i, i%97))...)
+	}
+	size := func(level int) int {
+		r, err := Compress(bytes.NewReader(input), CompressionOptions{Format: FormatZstd, Level: level, Threads: 2})
+		if err != nil {
+			t.Fatal(err)
+		}
+		out, err := io.ReadAll(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		dr, err := NewDecompressReader(bytes.NewReader(out), FormatZstd)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer dr.Close()
+		got, err := io.ReadAll(dr)
+		if err != nil || !bytes.Equal(got, input) {
+			t.Fatalf("level %d roundtrip failed: %v", level, err)
+		}
+		return len(out)
+	}
+	if fast, best := size(1), size(19); best >= fast {
+		t.Errorf("level 19 output (%d bytes) not smaller than level 1 (%d bytes)", best, fast)
+	}
+}
+
+func TestCompressionFromConfig(t *testing.T) {
+	tests := []struct {
+		cfg  *config.CompressionConfig
+		want CompressionOptions
+	}{
+		{nil, DefaultCompression},
+		{&config.CompressionConfig{}, DefaultCompression},
+		{&config.CompressionConfig{Level: 9}, CompressionOptions{Format: FormatGzip, Level: 9}},
+		{&config.CompressionConfig{Format: config.CompressionNone}, CompressionOptions{Format: FormatTar}},
+		{&config.CompressionConfig{Format: config.CompressionZstd, Level: 9, Threads: 2}, CompressionOptions{Format: FormatZstd, Level: 9, Threads: 2}},
+	}
+	for _, tt := range tests {
+		if got := CompressionFromConfig(tt.cfg); got != tt.want {
+			t.Errorf("CompressionFromConfig(%+v) = %+v, want %+v", tt.cfg, got, tt.want)
+		}
+	}
+}
+
+func TestManifestFormat_RoundTrip(t *testing.T) {
+	for _, f := range []CompressionFormat{FormatTar, FormatGzip, FormatZstd} {
+		got, err := FormatFromManifest(f.ManifestFormat())
+		if err != nil || got != f {
+			t.Errorf("FormatFromManifest(%q) = %q, %v; want %q", f.ManifestFormat(), got, err, f)
+		}
+	}
+	if _, err := FormatFromManifest("tar.bz2"); err == nil {
+		t.Error("expected error for unknown format")
+	}
+}
@@ -5,17 +5,28 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"path"
+	"sort"
 
 	"VelBackuper/internal/s3"
 )
 
+// Manifest describes one archive backup. Size is the stored object's size; the checksums
+// are of the stored object too, so they can be checked without decrypting or decompressing.
+// Manifests written by older versions have only Job, Timestamp, Key, Host and Format.
 type Manifest struct {
-	Job       string `json:"job"`
-	Timestamp string `json:"timestamp"`
-	Key       string `json:"key"`
-	Size      int64  `json:"size"`
-	Host      string `json:"host"`
-	Format    string `json:"format"`
+	Job              string           `json:"job"`
+	Timestamp        string           `json:"timestamp"`
+	Key              string           `json:"key"`
+	Size             int64            `json:"size"`
+	Host             string           `json:"host"`
+	Format           string           `json:"format"`
+	Encrypted        bool             `json:"encrypted,omitempty"`
+	UncompressedSize int64            `json:"uncompressed_size,omitempty"`
+	SHA256           string           `json:"sha256,omitempty"`
+	BLAKE3           string           `json:"blake3,omitempty"`
+	FileCount        int              `json:"file_count,omitempty"`
+	Sources          map[string]int64 `json:"sources,omitempty"` // uncompressed bytes per source (mysql, presets, filesystem)
 }
 
 type LatestPointer struct {
@@ -68,3 +79,46 @@ func ReadManifestByKey(ctx context.Context, client Storage, manifestKey string)
 	}
 	return &m, nil
 }
+
+// ListBackups returns the timestamps of all backups of job that have a manifest, oldest first.
+func ListBackups(ctx context.Context, client Storage, job string) ([]string, error) {
+	keys, err := client.ListObjects(ctx, path.Join(s3.ManifestsPrefix, job)+"/", 0)
+	if err != nil {
+		return nil, err
+	}
+	var out []string
+	for _, k := range keys {
+		if ts, ok := timestampStringFromManifestKey(k, job); ok {
+			out = append(out, ts)
+		}
+	}
+	sort.Strings(out)
+	return out, nil
+}
+
+// PointLatest is the restore point alias for the newest backup of a job.
+const PointLatest = "latest"
+
+// ResolveManifest returns the manifest for point, which is either a backup timestamp
+// (YYYYMMDDHHMMSS) or PointLatest. "latest" is resolved through latest/<job>.json.
+func ResolveManifest(ctx context.Context, client Storage, job, point string) (*Manifest, error) {
+	ts := point
+	if point == "" || point == PointLatest {
+		latestTs, _, err := ReadLatest(ctx, client, job)
+		if err != nil {
+			return nil, fmt.Errorf("read latest pointer for job %s: %w", job, err)
+		}
+		if latestTs == "" {
+			return nil, fmt.Errorf("job %s has no latest backup", job)
+		}
+		ts = latestTs
+	}
+	m, err := ReadManifestByKey(ctx, client, s3.ManifestKey(job, ts))
+	if err != nil {
+		return nil, fmt.Errorf("backup %s not found for job %s: %w", ts, job, err)
+	}
+	if m.Key == "" {
+		return nil, fmt.Errorf("manifest for backup %s of job %s has no archive key", ts, job)
+	}
+	return m, nil
+}
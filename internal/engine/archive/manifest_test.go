@@ -0,0 +1,55 @@
+package archive
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"VelBackuper/internal/s3"
+)
+
+func TestResolveManifest(t *testing.T) {
+	ctx := context.Background()
+	older := Manifest{Job: "j", Timestamp: "20250101000000", Key: "archives/j/2025/01/01/a.tar.gz"}
+	newer := Manifest{Job: "j", Timestamp: "20250201000000", Key: "archives/j/2025/02/01/b.tar.gz"}
+	olderBody, _ := json.Marshal(older)
+	newerBody, _ := json.Marshal(newer)
+	latestBody, _ := json.Marshal(LatestPointer{Timestamp: newer.Timestamp, Key: newer.Key})
+	fake := &fakeStorage{objects: map[string][]byte{
+		s3.ManifestKey("j", older.Timestamp): olderBody,
+		s3.ManifestKey("j", newer.Timestamp): newerBody,
+		s3.LatestKey("j"):                    latestBody,
+	}}
+
+	t.Run("timestamp", func(t *testing.T) {
+		m, err := ResolveManifest(ctx, fake, "j", older.Timestamp)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if m.Key != older.Key {
+			t.Errorf("key = %q, want %q", m.Key, older.Key)
+		}
+	})
+
+	t.Run("latest alias", func(t *testing.T) {
+		m, err := ResolveManifest(ctx, fake, "j", PointLatest)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if m.Key != newer.Key {
+			t.Errorf("key = %q, want %q", m.Key, newer.Key)
+		}
+	})
+
+	t.Run("unknown point", func(t *testing.T) {
+		if _, err := ResolveManifest(ctx, fake, "j", "20240101000000"); err == nil {
+			t.Error("expected error for missing manifest")
+		}
+	})
+
+	t.Run("no latest pointer", func(t *testing.T) {
+		if _, err := ResolveManifest(ctx, fake, "other", PointLatest); err == nil {
+			t.Error("expected error when latest pointer is missing")
+		}
+	})
+}
@@ -7,58 +7,118 @@ import (
 	"time"
 
 	"VelBackuper/internal/config"
+	"VelBackuper/internal/engine"
 	"VelBackuper/internal/s3"
 )
 
 const timestampLayout = "20060102150405"
 
-func ApplyRetention(ctx context.Context, client Storage, job string, retention *config.RetentionConfig, now time.Time) (deleted int, err error) {
-	if retention == nil || config.RetainUntil(now, retention).IsZero() {
-		return 0, nil
+// RetentionOptions configures ApplyRetention.
+type RetentionOptions struct {
+	// DryRun computes the plan without deleting anything or touching the latest pointer.
+	DryRun bool
+}
+
+// RetentionResult reports the outcome of ApplyRetention.
+type RetentionResult struct {
+	Deleted int
+	// Decisions explains, newest first, why each backup was kept or deleted.
+	Decisions []config.RetentionDecision
+	// Manifests and Archives are the objects deleted, or with DryRun the objects that would be.
+	Manifests      []s3.ObjectInfo
+	Archives       []s3.ObjectInfo
+	BytesReclaimed int64
+}
+
+// ApplyRetention deletes the backups of job (archive and manifest) that retention does not
+// keep, and repoints latest/<job>.json if its backup was deleted.
+func ApplyRetention(ctx context.Context, client Storage, job string, retention *config.RetentionConfig, opts RetentionOptions) (RetentionResult, error) {
+	res, err := applyRetention(ctx, client, job, retention, opts)
+	if err != nil {
+		return res, &engine.Error{Op: engine.OpPrune, Job: job, Err: err}
+	}
+	return res, nil
+}
+
+func applyRetention(ctx context.Context, client Storage, job string, retention *config.RetentionConfig, opts RetentionOptions) (RetentionResult, error) {
+	var res RetentionResult
+	if !config.RetentionEnabled(retention) {
+		return res, nil
 	}
 
 	manifestPrefix := path.Join(s3.ManifestsPrefix, job) + "/"
-	manifestKeys, err := client.ListObjects(ctx, manifestPrefix, 0)
+	manifests, err := client.ListObjectInfo(ctx, manifestPrefix)
 	if err != nil {
-		return 0, err
+		return res, err
+	}
+	archives, err := client.ListObjectInfo(ctx, s3.ArchivesPrefixForJob(job))
+	if err != nil {
+		return res, err
+	}
+	archiveSizes := make(map[string]int64, len(archives))
+	for _, a := range archives {
+		archiveSizes[a.Key] = a.Size
 	}
 
-	deletedKeys := make(map[string]struct{})
-	for _, manifestKey := range manifestKeys {
-		ts, ok := parseTimestampFromManifestKey(manifestKey, job)
+	byTime := make(map[time.Time]s3.ObjectInfo, len(manifests))
+	var points []time.Time
+	for _, mi := range manifests {
+		ts, ok := parseTimestampFromManifestKey(mi.Key, job)
 		if !ok {
 			continue
 		}
-		if !config.IsExpired(ts, now, retention) {
+		byTime[ts] = mi
+		points = append(points, ts)
+	}
+	res.Decisions = config.PlanRetention(points, retention)
+
+	deletedKeys := make(map[string]struct{})
+	for _, d := range res.Decisions {
+		if d.Keep {
 			continue
 		}
-		m, err := ReadManifestByKey(ctx, client, manifestKey)
+		mi := byTime[d.Time]
+		m, err := ReadManifestByKey(ctx, client, mi.Key)
 		if err != nil {
-			return deleted, err
+			return res, err
+		}
+		res.Manifests = append(res.Manifests, mi)
+		res.BytesReclaimed += mi.Size
+		if m.Key != "" {
+			if size, ok := archiveSizes[m.Key]; ok {
+				res.Archives = append(res.Archives, s3.ObjectInfo{Key: m.Key, Size: size})
+				res.BytesReclaimed += size
+			}
+		}
+		if opts.DryRun {
+			continue
 		}
 		if m.Key != "" {
 			if err := client.DeleteObject(ctx, m.Key); err != nil {
-				return deleted, err
+				return res, err
 			}
 			deletedKeys[m.Key] = struct{}{}
 		}
-		if err := client.DeleteObject(ctx, manifestKey); err != nil {
-			return deleted, err
+		if err := client.DeleteObject(ctx, mi.Key); err != nil {
+			return res, err
 		}
-		deleted++
+		res.Deleted++
+	}
+	if opts.DryRun {
+		return res, nil
 	}
 
 	_, latestKey, err := ReadLatest(ctx, client, job)
 	if err != nil || latestKey == "" {
-		return deleted, nil
+		return res, nil
 	}
 	if _, removed := deletedKeys[latestKey]; !removed {
-		return deleted, nil
+		return res, nil
 	}
 
-	manifestKeys, err = client.ListObjects(ctx, manifestPrefix, 0)
+	manifestKeys, err := client.ListObjects(ctx, manifestPrefix, 0)
 	if err != nil {
-		return deleted, err
+		return res, err
 	}
 	var newestTs string
 	var newestKey string
@@ -71,15 +131,15 @@ func ApplyRetention(ctx context.Context, client Storage, job string, retention *
 			newestTs = tsStr
 			m, err := ReadManifestByKey(ctx, client, manifestKey)
 			if err != nil {
-				return deleted, err
+				return res, err
 			}
 			newestKey = m.Key
 		}
 	}
 	if newestTs != "" && newestKey != "" {
-		return deleted, WriteLatest(ctx, client, job, newestTs, newestKey)
+		return res, WriteLatest(ctx, client, job, newestTs, newestKey)
 	}
-	return deleted, client.DeleteObject(ctx, s3.LatestKey(job))
+	return res, client.DeleteObject(ctx, s3.LatestKey(job))
 }
 
 func parseTimestampFromManifestKey(manifestKey, job string) (time.Time, bool) {
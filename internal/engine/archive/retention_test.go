@@ -75,31 +75,30 @@ func TestApplyRetention_NilOrZero_NoCalls(t *testing.T) {
 	fake := &fakeStorage{listErr: errors.New("should not be called")}
 
 	t.Run("nil retention", func(t *testing.T) {
-		n, err := ApplyRetention(ctx, fake, "job", nil, time.Now())
+		res, err := ApplyRetention(ctx, fake, "job", nil, RetentionOptions{})
 		if err != nil {
 			t.Fatal(err)
 		}
-		if n != 0 {
-			t.Errorf("deleted = %d, want 0", n)
+		if res.Deleted != 0 {
+			t.Errorf("deleted = %d, want 0", res.Deleted)
 		}
 	})
 
 	t.Run("zero retention", func(t *testing.T) {
-		n, err := ApplyRetention(ctx, fake, "job", &config.RetentionConfig{Days: 0, Weeks: 0, Months: 0}, time.Now())
+		res, err := ApplyRetention(ctx, fake, "job", &config.RetentionConfig{}, RetentionOptions{})
 		if err != nil {
 			t.Fatal(err)
 		}
-		if n != 0 {
-			t.Errorf("deleted = %d, want 0", n)
+		if res.Deleted != 0 {
+			t.Errorf("deleted = %d, want 0", res.Deleted)
 		}
 	})
 }
 
 func TestApplyRetention_DeletesExpired_UpdatesLatest(t *testing.T) {
 	ctx := context.Background()
-	now := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
-	retention := &config.RetentionConfig{Days: 30, Weeks: 0, Months: 0}
-	// Cutoff = 2025-01-30 12:00. So 20250201000000 is kept, 20250101000000 is expired.
+	// One monthly: 20250201000000 is the newest backup of the newest month, 20250101000000 is dropped.
+	retention := &config.RetentionConfig{KeepMonthly: 1}
 
 	oldManifest := Manifest{Job: "job1", Timestamp: "20250101000000", Key: "archives/job1/2025/01/01/backup-h-20250101000000.tar.gz"}
 	newManifest := Manifest{Job: "job1", Timestamp: "20250201000000", Key: "archives/job1/2025/02/01/backup-h-20250201000000.tar.gz"}
@@ -121,12 +120,12 @@ func TestApplyRetention_DeletesExpired_UpdatesLatest(t *testing.T) {
 	latestBody, _ := json.Marshal(LatestPointer{Timestamp: "20250201000000", Key: "archives/job1/2025/02/01/backup-h-20250201000000.tar.gz"})
 	fake.objects[s3.LatestKey("job1")] = latestBody
 
-	deleted, err := ApplyRetention(ctx, fake, "job1", retention, now)
+	res, err := ApplyRetention(ctx, fake, "job1", retention, RetentionOptions{})
 	if err != nil {
 		t.Fatal(err)
 	}
-	if deleted != 1 {
-		t.Errorf("deleted = %d, want 1", deleted)
+	if res.Deleted != 1 {
+		t.Errorf("deleted = %d, want 1", res.Deleted)
 	}
 	if _, ok := fake.objects[s3.ManifestKey("job1", "20250101000000")]; ok {
 		t.Error("old manifest should be deleted")
@@ -145,8 +144,7 @@ func TestApplyRetention_DeletesExpired_UpdatesLatest(t *testing.T) {
 
 func TestApplyRetention_UpdatesLatestWhenCurrentDeleted(t *testing.T) {
 	ctx := context.Background()
-	now := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
-	retention := &config.RetentionConfig{Days: 30, Weeks: 0, Months: 0}
+	retention := &config.RetentionConfig{KeepLast: 1}
 
 	oldManifest := Manifest{Job: "j", Timestamp: "20250101000000", Key: "archives/j/2025/01/01/old.tar.gz"}
 	keptManifest := Manifest{Job: "j", Timestamp: "20250215000000", Key: "archives/j/2025/02/15/kept.tar.gz"}
@@ -165,16 +163,16 @@ func TestApplyRetention_UpdatesLatestWhenCurrentDeleted(t *testing.T) {
 			},
 		},
 	}
-	// Latest points to the old (expired) backup - will be deleted
+	// A stale latest pointer to the old backup, which is deleted
 	latestBody, _ := json.Marshal(LatestPointer{Timestamp: "20250101000000", Key: "archives/j/2025/01/01/old.tar.gz"})
 	fake.objects[s3.LatestKey("j")] = latestBody
 
-	deleted, err := ApplyRetention(ctx, fake, "j", retention, now)
+	res, err := ApplyRetention(ctx, fake, "j", retention, RetentionOptions{})
 	if err != nil {
 		t.Fatal(err)
 	}
-	if deleted != 1 {
-		t.Errorf("deleted = %d, want 1", deleted)
+	if res.Deleted != 1 {
+		t.Errorf("deleted = %d, want 1", res.Deleted)
 	}
 	// Latest should be updated to point to 20250215000000
 	latestJSON, ok := fake.objects[s3.LatestKey("j")]
@@ -190,33 +188,73 @@ func TestApplyRetention_UpdatesLatestWhenCurrentDeleted(t *testing.T) {
 	}
 }
 
-func TestApplyRetention_DeletesLatestWhenAllExpired(t *testing.T) {
+func TestApplyRetention_GFSKeepsNewestPerBucket(t *testing.T) {
 	ctx := context.Background()
-	now := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
-	retention := &config.RetentionConfig{Days: 7, Weeks: 0, Months: 0}
+	timestamps := []string{
+		"20250301120000", "20250301060000", // same day: only the newer is a daily
+		"20250228120000",
+		"20250215120000",
+		"20250131120000", "20250110120000", // January: only the 31st is a monthly
+		"20241231120000",
+	}
+	fake := &fakeStorage{objects: map[string][]byte{}, lists: map[string][]string{}}
+	for _, ts := range timestamps {
+		body, _ := json.Marshal(Manifest{Job: "j", Timestamp: ts, Key: "archives/j/" + ts + ".tar.gz"})
+		fake.objects[s3.ManifestKey("j", ts)] = body
+		fake.objects["archives/j/"+ts+".tar.gz"] = []byte("x")
+		fake.lists["manifests/j/"] = append(fake.lists["manifests/j/"], s3.ManifestKey("j", ts))
+	}
 
-	oldManifest := Manifest{Job: "j", Timestamp: "20250101000000", Key: "archives/j/2025/01/01/old.tar.gz"}
-	oldBody, _ := json.Marshal(oldManifest)
-	fake := &fakeStorage{
-		objects: map[string][]byte{
-			s3.ManifestKey("j", "20250101000000"): oldBody,
-		},
-		lists: map[string][]string{
-			"manifests/j/": {"manifests/j/20250101000000.json"},
-		},
+	res, err := ApplyRetention(ctx, fake, "j", &config.RetentionConfig{KeepDaily: 2, KeepMonthly: 3}, RetentionOptions{})
+	if err != nil {
+		t.Fatal(err)
 	}
-	latestBody, _ := json.Marshal(LatestPointer{Timestamp: "20250101000000", Key: "archives/j/2025/01/01/old.tar.gz"})
-	fake.objects[s3.LatestKey("j")] = latestBody
+	kept := map[string]bool{
+		"20250301120000": true, // daily 2025-03-01, monthly 2025-03
+		"20250228120000": true, // daily 2025-02-28, monthly 2025-02
+		"20250131120000": true, // monthly 2025-01
+	}
+	if res.Deleted != len(timestamps)-len(kept) {
+		t.Errorf("deleted = %d, want %d", res.Deleted, len(timestamps)-len(kept))
+	}
+	for _, d := range res.Decisions {
+		ts := d.Time.Format(timestampLayout)
+		if d.Keep != kept[ts] {
+			t.Errorf("%s keep = %v (%s), want %v", ts, d.Keep, d.Reason(), kept[ts])
+		}
+		if _, exists := fake.objects[s3.ManifestKey("j", ts)]; exists != kept[ts] {
+			t.Errorf("%s manifest exists = %v, want %v", ts, exists, kept[ts])
+		}
+	}
+}
+
+func TestApplyRetention_DryRunPlansWithoutDeleting(t *testing.T) {
+	ctx := context.Background()
+	fake := &fakeStorage{objects: map[string][]byte{}, lists: map[string][]string{}}
+	for _, ts := range []string{"20250201000000", "20250101000000"} {
+		key := "archives/j/2025/backup-" + ts + ".tar.gz"
+		body, _ := json.Marshal(Manifest{Job: "j", Timestamp: ts, Key: key})
+		fake.objects[s3.ManifestKey("j", ts)] = body
+		fake.objects[key] = bytes.Repeat([]byte("x"), 1000)
+		fake.lists["manifests/j/"] = append(fake.lists["manifests/j/"], s3.ManifestKey("j", ts))
+	}
+	before := len(fake.objects)
 
-	deleted, err := ApplyRetention(ctx, fake, "j", retention, now)
+	res, err := ApplyRetention(ctx, fake, "j", &config.RetentionConfig{KeepLast: 1}, RetentionOptions{DryRun: true})
 	if err != nil {
 		t.Fatal(err)
 	}
-	if deleted != 1 {
-		t.Errorf("deleted = %d, want 1", deleted)
+	if len(fake.objects) != before || res.Deleted != 0 {
+		t.Errorf("dry run deleted objects: %d -> %d, Deleted = %d", before, len(fake.objects), res.Deleted)
+	}
+	if len(res.Manifests) != 1 || res.Manifests[0].Key != s3.ManifestKey("j", "20250101000000") {
+		t.Errorf("Manifests = %+v", res.Manifests)
+	}
+	if len(res.Archives) != 1 || res.Archives[0].Key != "archives/j/2025/backup-20250101000000.tar.gz" || res.Archives[0].Size != 1000 {
+		t.Errorf("Archives = %+v", res.Archives)
 	}
-	if _, ok := fake.objects[s3.LatestKey("j")]; ok {
-		t.Error("latest pointer should be deleted when no backups remain")
+	if want := 1000 + res.Manifests[0].Size; res.BytesReclaimed != want {
+		t.Errorf("BytesReclaimed = %d, want %d", res.BytesReclaimed, want)
 	}
 }
 
@@ -245,6 +283,19 @@ func (f *fakeStorage) ListObjects(_ context.Context, prefix string, _ int32) ([]
 	return out, nil
 }
 
+func (f *fakeStorage) ListObjectInfo(_ context.Context, prefix string) ([]s3.ObjectInfo, error) {
+	if f.listErr != nil {
+		return nil, f.listErr
+	}
+	var out []s3.ObjectInfo
+	for k, b := range f.objects {
+		if strings.HasPrefix(k, prefix) {
+			out = append(out, s3.ObjectInfo{Key: k, Size: int64(len(b))})
+		}
+	}
+	return out, nil
+}
+
 func (f *fakeStorage) GetObject(_ context.Context, key string) (io.ReadCloser, error) {
 	b, ok := f.objects[key]
 	if !ok {
@@ -0,0 +1,125 @@
+package archive
+
+import (
+	"archive/tar"
+	"crypto/sha256"
+	"encoding/hex"
+	"hash"
+	"io"
+
+	"github.com/zeebo/blake3"
+)
+
+// StreamStats measures the uncompressed tar stream of an archive as it is read. Its values
+// are final once the stream has been read to EOF.
+type StreamStats struct {
+	size  int64
+	files int
+	done  chan struct{}
+}
+
+// UncompressedSize returns the number of bytes the collectors produced.
+func (s *StreamStats) UncompressedSize() int64 {
+	<-s.done
+	return s.size
+}
+
+// FileCount returns the number of regular files in the tar stream.
+func (s *StreamStats) FileCount() int {
+	<-s.done
+	return s.files
+}
+
+// measureStream returns a reader of r that records its size and file count in the returned
+// StreamStats. The tar stream is parsed on the side, so r is read only once.
+func measureStream(r io.Reader) (io.Reader, *StreamStats) {
+	stats := &StreamStats{done: make(chan struct{})}
+	pr, pw := io.Pipe()
+	go func() {
+		stats.files = countTarFiles(pr)
+		_, _ = io.Copy(io.Discard, pr)
+		close(stats.done)
+	}()
+	return &statsReader{r: io.TeeReader(r, pw), pw: pw, stats: stats}, stats
+}
+
+type statsReader struct {
+	r     io.Reader
+	pw    *io.PipeWriter
+	stats *StreamStats
+}
+
+func (s *statsReader) Read(p []byte) (int, error) {
+	n, err := s.r.Read(p)
+	s.stats.size += int64(n)
+	if err == io.EOF {
+		_ = s.pw.Close()
+	} else if err != nil {
+		_ = s.pw.CloseWithError(err)
+	}
+	return n, err
+}
+
+// countTarFiles counts regular files in r, which may hold several tar archives back to back.
+// Counting stops at the first data that is not tar.
+func countTarFiles(r io.Reader) int {
+	cr := &countingReader{r: r}
+	files := 0
+	for {
+		start := cr.n
+		tr := tar.NewReader(cr)
+		for {
+			hdr, err := tr.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return files
+			}
+			if hdr.Typeflag == tar.TypeReg || hdr.Typeflag == tar.TypeRegA {
+				files++
+			}
+		}
+		if cr.n == start {
+			return files
+		}
+	}
+}
+
+// countingReader counts the bytes read through it.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// hashingReader computes the size, SHA-256 and BLAKE3 of everything read through it.
+type hashingReader struct {
+	r      io.Reader
+	n      int64
+	sha    hash.Hash
+	blake3 *blake3.Hasher
+}
+
+func newHashingReader(r io.Reader) *hashingReader {
+	return &hashingReader{r: r, sha: sha256.New(), blake3: blake3.New()}
+}
+
+func (h *hashingReader) Read(p []byte) (int, error) {
+	n, err := h.r.Read(p)
+	if n > 0 {
+		h.n += int64(n)
+		_, _ = h.sha.Write(p[:n])
+		_, _ = h.blake3.Write(p[:n])
+	}
+	return n, err
+}
+
+func (h *hashingReader) sums() (sha256Hex, blake3Hex string) {
+	return hex.EncodeToString(h.sha.Sum(nil)), hex.EncodeToString(h.blake3.Sum(nil))
+}
@@ -0,0 +1,80 @@
+package archive
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"testing"
+
+	"github.com/zeebo/blake3"
+)
+
+func tarOf(t *testing.T, files map[string]string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	if err := tw.WriteHeader(&tar.Header{Name: "dir/", Typeflag: tar.TypeDir, Mode: 0o755}); err != nil {
+		t.Fatal(err)
+	}
+	for name, body := range files {
+		if err := tw.WriteHeader(&tar.Header{Name: name, Typeflag: tar.TypeReg, Mode: 0o644, Size: int64(len(body))}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(body)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestStream_Stats(t *testing.T) {
+	// Two archives back to back, as a composite collector writes them.
+	data := append(tarOf(t, map[string]string{"dir/a": "aaa", "dir/b": "bbbb"}), tarOf(t, map[string]string{"dir/c": "c"})...)
+	r, stats, err := Stream(context.Background(), &streamTestCollector{data: data}, "job", CompressionOptions{Format: FormatZstd})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.Copy(io.Discard, r); err != nil {
+		t.Fatal(err)
+	}
+	if got := stats.UncompressedSize(); got != int64(len(data)) {
+		t.Errorf("UncompressedSize = %d, want %d", got, len(data))
+	}
+	if got := stats.FileCount(); got != 3 {
+		t.Errorf("FileCount = %d, want 3", got)
+	}
+}
+
+func TestStream_StatsNonTar(t *testing.T) {
+	data := []byte("-- MySQL dump\nCREATE TABLE t (id int);\n")
+	r, stats, err := Stream(context.Background(), &streamTestCollector{data: data}, "job", CompressionOptions{Format: FormatTar})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.Copy(io.Discard, r); err != nil {
+		t.Fatal(err)
+	}
+	if stats.UncompressedSize() != int64(len(data)) || stats.FileCount() != 0 {
+		t.Errorf("stats = %d bytes, %d files", stats.UncompressedSize(), stats.FileCount())
+	}
+}
+
+func TestHashingReader(t *testing.T) {
+	data := bytes.Repeat([]byte("object"), 10000)
+	hr := newHashingReader(bytes.NewReader(data))
+	if _, err := io.Copy(io.Discard, hr); err != nil {
+		t.Fatal(err)
+	}
+	sha, b3 := hr.sums()
+	wantSHA := sha256.Sum256(data)
+	wantB3 := blake3.Sum256(data)
+	if hr.n != int64(len(data)) || sha != hex.EncodeToString(wantSHA[:]) || b3 != hex.EncodeToString(wantB3[:]) {
+		t.Errorf("got n=%d sha=%s blake3=%s", hr.n, sha, b3)
+	}
+}
@@ -19,10 +19,17 @@ func CollectToStream(ctx context.Context, c collector.Collector, jobName string)
 	return pr, nil
 }
 
-func Stream(ctx context.Context, c collector.Collector, jobName string, format CompressionFormat, compressionLevel int) (io.Reader, error) {
+// Stream collects the job's tar stream and compresses it. The returned StreamStats
+// describe the uncompressed stream once the compressed one has been fully read.
+func Stream(ctx context.Context, c collector.Collector, jobName string, compression CompressionOptions) (io.Reader, *StreamStats, error) {
 	raw, err := CollectToStream(ctx, c, jobName)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
-	return NewCompressReader(raw, format, compressionLevel)
+	measured, stats := measureStream(raw)
+	r, err := Compress(measured, compression)
+	if err != nil {
+		return nil, nil, err
+	}
+	return r, stats, nil
 }
@@ -29,7 +29,7 @@ func TestCollectToStream_ForwardsCollectorOutput(t *testing.T) {
 func TestStream_Tar_Passthrough(t *testing.T) {
 	data := []byte("raw tar data")
 	c := &streamTestCollector{data: data}
-	r, err := Stream(context.Background(), c, "job", FormatTar, 0)
+	r, _, err := Stream(context.Background(), c, "job", CompressionOptions{Format: FormatTar})
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -45,7 +45,7 @@ func TestStream_Tar_Passthrough(t *testing.T) {
 func TestStream_Gzip_Compresses(t *testing.T) {
 	data := []byte("hello stream gzip")
 	c := &streamTestCollector{data: data}
-	r, err := Stream(context.Background(), c, "job", FormatGzip, 6)
+	r, _, err := Stream(context.Background(), c, "job", CompressionOptions{Format: FormatGzip, Level: 6})
 	if err != nil {
 		t.Fatal(err)
 	}
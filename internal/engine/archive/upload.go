@@ -9,6 +9,8 @@ import (
 	"strings"
 	"time"
 
+	"VelBackuper/internal/crypt"
+	"VelBackuper/internal/engine"
 	"VelBackuper/internal/s3"
 )
 
@@ -18,6 +20,8 @@ const (
 
 type UploadOptions struct {
 	PartSizeMB int
+	// Keyring encrypts the stream with age before upload; the key then ends in ".age".
+	Keyring *crypt.Keyring
 }
 
 func ArchiveKey(job string, format CompressionFormat, at time.Time) (string, string) {
@@ -53,15 +57,35 @@ func sanitizeFilename(s string) string {
 	return sanitizeRe.ReplaceAllString(strings.TrimSpace(s), "_")
 }
 
-func Upload(ctx context.Context, client *s3.Client, job string, format CompressionFormat, stream io.Reader, opts UploadOptions) (key, backupID string, err error) {
+// UploadResult describes an uploaded archive object.
+type UploadResult struct {
+	Key      string
+	BackupID string
+	// Size, SHA256 and BLAKE3 describe the object as stored (compressed, and encrypted if enabled).
+	Size   int64
+	SHA256 string
+	BLAKE3 string
+}
+
+func Upload(ctx context.Context, client *s3.Client, job string, format CompressionFormat, stream io.Reader, opts UploadOptions) (UploadResult, error) {
 	at := time.Now()
-	key, backupID = ArchiveKey(job, format, at)
+	key, backupID := ArchiveKey(job, format, at)
+	if opts.Keyring.Enabled() {
+		key += crypt.ArchiveSuffix
+		var err error
+		if stream, err = opts.Keyring.EncryptReader(stream); err != nil {
+			return UploadResult{}, &engine.Error{Op: engine.OpBackup, Job: job, Err: fmt.Errorf("encrypt archive: %w", err)}
+		}
+	}
 	partSize := int64(opts.PartSizeMB) * 1024 * 1024
 	if partSize < s3.MinPartSizeBytes {
 		partSize = s3.MinPartSizeBytes
 	}
-	if err := client.UploadMultipart(ctx, key, stream, partSize); err != nil {
-		return "", "", fmt.Errorf("upload archive: %w", err)
+	hr := newHashingReader(stream)
+	if err := client.UploadMultipart(ctx, key, hr, partSize); err != nil {
+		return UploadResult{}, &engine.Error{Op: engine.OpBackup, Job: job, Err: fmt.Errorf("upload archive: %w", err)}
 	}
-	return key, backupID, nil
+	res := UploadResult{Key: key, BackupID: backupID, Size: hr.n}
+	res.SHA256, res.BLAKE3 = hr.sums()
+	return res, nil
 }
@@ -2,6 +2,7 @@ package engine
 
 import (
 	"context"
+	"fmt"
 )
 
 type Engine interface {
@@ -17,3 +18,25 @@ type ListEntry struct {
 	Timestamp string
 	Size      int64
 }
+
+// Operations reported by Error.
+const (
+	OpBackup = "backup"
+	OpPrune  = "prune"
+)
+
+// Error is returned by the archive and incremental engines when a backup or prune of a job
+// fails. Err is the cause, which may itself be an error of the s3, collector or lock package.
+type Error struct {
+	Op  string
+	Job string
+	Err error
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s of job %s: %v", e.Op, e.Job, e.Err)
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
@@ -0,0 +1,97 @@
+package incremental
+
+import (
+	"io"
+	"math/bits"
+)
+
+// gearTable maps each byte to a pseudo-random 64-bit value for the gear rolling hash.
+// It is generated from a fixed seed and must never change: chunk boundaries, and therefore
+// deduplication against existing snapshots, depend on it.
+var gearTable = func() [256]uint64 {
+	var t [256]uint64
+	state := uint64(0x5645_4c42_4143_4b31) // "VELBACK1"
+	for i := range t {
+		// splitmix64
+		state += 0x9e3779b97f4a7c15
+		z := state
+		z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+		z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+		t[i] = z ^ (z >> 31)
+	}
+	return t
+}()
+
+// fastCDCMasks returns the strict and relaxed boundary masks for an average chunk size
+// (FastCDC normalized chunking, level 2). Masks select the high bits of the gear hash,
+// which depend on the last 64 bytes of input.
+func fastCDCMasks(avg int64) (maskS, maskL uint64) {
+	n := bits.Len64(uint64(avg)) - 1
+	if n < 4 {
+		n = 4
+	}
+	strict := n + 2
+	relaxed := n - 2
+	if strict > 63 {
+		strict = 63
+	}
+	return ^uint64(0) << (64 - strict), ^uint64(0) << (64 - relaxed)
+}
+
+// fastCDCCut returns the length of the next chunk at the start of src.
+func fastCDCCut(src []byte, min, avg, max int64, maskS, maskL uint64) int {
+	n := int64(len(src))
+	if n <= min {
+		return int(n)
+	}
+	if n > max {
+		n = max
+	}
+	normal := avg
+	if n < normal {
+		normal = n
+	}
+	var fp uint64
+	i := min
+	for ; i < normal; i++ {
+		fp = (fp << 1) + gearTable[src[i]]
+		if fp&maskS == 0 {
+			return int(i + 1)
+		}
+	}
+	for ; i < n; i++ {
+		fp = (fp << 1) + gearTable[src[i]]
+		if fp&maskL == 0 {
+			return int(i + 1)
+		}
+	}
+	return int(n)
+}
+
+// readChunksFastCDC splits r at content-defined boundaries. Chunks passed to fn alias an
+// internal buffer of p.MaxSize bytes and are only valid until fn returns.
+func readChunksFastCDC(r io.Reader, p ChunkerParams, fn func(chunk []byte) error) error {
+	maskS, maskL := fastCDCMasks(p.AvgSize)
+	buf := make([]byte, p.MaxSize)
+	n := 0
+	eof := false
+	for {
+		if !eof && n < len(buf) {
+			m, err := io.ReadFull(r, buf[n:])
+			n += m
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				eof = true
+			} else if err != nil {
+				return err
+			}
+		}
+		if n == 0 {
+			return nil
+		}
+		cut := fastCDCCut(buf[:n], p.MinSize, p.AvgSize, p.MaxSize, maskS, maskL)
+		if err := fn(buf[:cut]); err != nil {
+			return err
+		}
+		n = copy(buf, buf[cut:n])
+	}
+}
@@ -0,0 +1,109 @@
+package incremental
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+
+	"VelBackuper/internal/config"
+)
+
+var testCDC = ChunkerParams{Algorithm: ChunkerFastCDC, MinSize: 16 * 1024, AvgSize: 64 * 1024, MaxSize: 256 * 1024}
+
+func splitHashes(t *testing.T, p ChunkerParams, data []byte) (hashes []string, sizes []int) {
+	t.Helper()
+	err := p.Split(bytes.NewReader(data), func(chunk []byte) error {
+		hashes = append(hashes, HashChunkHex(chunk))
+		sizes = append(sizes, len(chunk))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return hashes, sizes
+}
+
+func TestGearTable_Stable(t *testing.T) {
+	// Changing the table would move every chunk boundary and break dedup with existing snapshots.
+	if gearTable[0] != 0x72a5aa07c501e8c1 || gearTable[255] != 0x36ce49b5dfbbd6cc {
+		t.Fatalf("gear table changed: [0]=%#x [255]=%#x", gearTable[0], gearTable[255])
+	}
+}
+
+func TestFastCDC_SizesWithinBoundsAndCoverAllData(t *testing.T) {
+	data := make([]byte, 8*1024*1024)
+	rand.New(rand.NewSource(7)).Read(data)
+
+	_, sizes := splitHashes(t, testCDC, data)
+	total := 0
+	for i, n := range sizes {
+		total += n
+		if n > int(testCDC.MaxSize) {
+			t.Errorf("chunk %d size %d exceeds max", i, n)
+		}
+		if i < len(sizes)-1 && n < int(testCDC.MinSize) {
+			t.Errorf("chunk %d size %d below min", i, n)
+		}
+	}
+	if total != len(data) {
+		t.Errorf("total = %d, want %d", total, len(data))
+	}
+	avg := len(data) / len(sizes)
+	if avg < int(testCDC.MinSize) || avg > int(testCDC.MaxSize)/2 {
+		t.Errorf("average chunk size %d far from configured %d", avg, testCDC.AvgSize)
+	}
+}
+
+func TestFastCDC_InsertionKeepsLaterChunks(t *testing.T) {
+	data := make([]byte, 16*1024*1024)
+	rand.New(rand.NewSource(11)).Read(data)
+	shifted := append([]byte{data[0], 'X'}, data[1:]...)
+
+	before, _ := splitHashes(t, testCDC, data)
+	after, _ := splitHashes(t, testCDC, shifted)
+
+	known := make(map[string]bool, len(before))
+	for _, h := range before {
+		known[h] = true
+	}
+	shared := 0
+	for _, h := range after {
+		if known[h] {
+			shared++
+		}
+	}
+	if shared < len(before)-2 {
+		t.Errorf("only %d of %d chunks survive a one-byte insertion", shared, len(before))
+	}
+
+	// Fixed-size chunking loses every chunk after the insertion point.
+	fixedBefore, _ := splitHashes(t, FixedChunker(ChunkSizeMin), data)
+	fixedAfter, _ := splitHashes(t, FixedChunker(ChunkSizeMin), shifted)
+	for i := range fixedBefore {
+		if fixedBefore[i] == fixedAfter[i] {
+			t.Errorf("fixed chunk %d unexpectedly unchanged", i)
+		}
+	}
+}
+
+func TestChunkerParams_SplitRejectsInvalid(t *testing.T) {
+	bad := ChunkerParams{Algorithm: ChunkerFastCDC, MinSize: 10, AvgSize: 5, MaxSize: 20}
+	if err := bad.Split(bytes.NewReader([]byte("x")), func([]byte) error { return nil }); err == nil {
+		t.Error("expected error for min > avg")
+	}
+	unknown := ChunkerParams{Algorithm: "rabin"}
+	if err := unknown.Split(bytes.NewReader([]byte("x")), func([]byte) error { return nil }); err == nil {
+		t.Error("expected error for unknown algorithm")
+	}
+}
+
+func TestChunkerFromConfig(t *testing.T) {
+	if got := ChunkerFromConfig(nil, ChunkSizeMin); got != FixedChunker(ChunkSizeMin) {
+		t.Errorf("nil config = %+v, want fixed", got)
+	}
+	got := ChunkerFromConfig(&config.ChunkingConfig{Algorithm: config.ChunkingFastCDC, AvgSizeKB: 2048}, ChunkSizeMin)
+	want := ChunkerParams{Algorithm: ChunkerFastCDC, MinSize: DefaultCDCMinSize, AvgSize: 2 * 1024 * 1024, MaxSize: DefaultCDCMaxSize}
+	if got != want {
+		t.Errorf("fastcdc config = %+v, want %+v", got, want)
+	}
+}
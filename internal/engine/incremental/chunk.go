@@ -2,8 +2,11 @@ package incremental
 
 import (
 	"encoding/hex"
+	"fmt"
 	"io"
 
+	"VelBackuper/internal/config"
+
 	"github.com/zeebo/blake3"
 )
 
@@ -14,8 +17,77 @@ const (
 	ChunkSizeMax   = ChunkSizeMaxMB * 1024 * 1024
 
 	DefaultHashPrefixLen = 2
+
+	DefaultCDCMinSize = config.DefaultFastCDCMinSizeKB * 1024
+	DefaultCDCAvgSize = config.DefaultFastCDCAvgSizeKB * 1024
+	DefaultCDCMaxSize = config.DefaultFastCDCMaxSizeKB * 1024
 )
 
+// Chunker algorithms recorded in Index.Chunker.
+const (
+	ChunkerFixed   = "fixed"
+	ChunkerFastCDC = "fastcdc"
+)
+
+// ChunkerParams describes how a stream was split into chunks. Fixed-size chunking uses
+// MinSize == AvgSize == MaxSize.
+type ChunkerParams struct {
+	Algorithm string `json:"algorithm"`
+	MinSize   int64  `json:"min_size"`
+	AvgSize   int64  `json:"avg_size"`
+	MaxSize   int64  `json:"max_size"`
+}
+
+// FixedChunker returns parameters for fixed-size chunks, clamped to [ChunkSizeMin, ChunkSizeMax].
+func FixedChunker(chunkSize int64) ChunkerParams {
+	if chunkSize < ChunkSizeMin {
+		chunkSize = ChunkSizeMin
+	}
+	if chunkSize > ChunkSizeMax {
+		chunkSize = ChunkSizeMax
+	}
+	return ChunkerParams{Algorithm: ChunkerFixed, MinSize: chunkSize, AvgSize: chunkSize, MaxSize: chunkSize}
+}
+
+// FastCDCChunker returns FastCDC parameters; zero sizes take the package defaults.
+func FastCDCChunker(min, avg, max int64) ChunkerParams {
+	if min <= 0 {
+		min = DefaultCDCMinSize
+	}
+	if avg <= 0 {
+		avg = DefaultCDCAvgSize
+	}
+	if max <= 0 {
+		max = DefaultCDCMaxSize
+	}
+	return ChunkerParams{Algorithm: ChunkerFastCDC, MinSize: min, AvgSize: avg, MaxSize: max}
+}
+
+// ChunkerFromConfig returns the chunker for a job's chunking block. Jobs without one keep
+// fixed-size chunks of chunkSize so their existing chunks still deduplicate.
+func ChunkerFromConfig(c *config.ChunkingConfig, chunkSize int64) ChunkerParams {
+	if c == nil || c.Algorithm == "" || c.Algorithm == config.ChunkingFixed {
+		return FixedChunker(chunkSize)
+	}
+	return FastCDCChunker(int64(c.MinSizeKB)*1024, int64(c.AvgSizeKB)*1024, int64(c.MaxSizeKB)*1024)
+}
+
+// Split reads r and calls fn for each chunk according to p. Chunks alias an internal buffer
+// and are only valid until fn returns.
+func (p ChunkerParams) Split(r io.Reader, fn func(chunk []byte) error) error {
+	switch p.Algorithm {
+	case ChunkerFixed, "":
+		return ReadChunks(r, p.MaxSize, fn)
+	case ChunkerFastCDC:
+		if p.MinSize <= 0 || p.MinSize > p.AvgSize || p.AvgSize > p.MaxSize {
+			return fmt.Errorf("invalid fastcdc sizes min=%d avg=%d max=%d", p.MinSize, p.AvgSize, p.MaxSize)
+		}
+		return readChunksFastCDC(r, p, fn)
+	default:
+		return fmt.Errorf("unknown chunker %q", p.Algorithm)
+	}
+}
+
 func ReadChunks(r io.Reader, chunkSize int64, fn func(chunk []byte) error) error {
 	if chunkSize < ChunkSizeMin {
 		chunkSize = ChunkSizeMin
@@ -0,0 +1,99 @@
+package incremental
+
+import (
+	"bytes"
+	"fmt"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Codec identifies how a chunk object's payload is encoded.
+type Codec byte
+
+const (
+	CodecNone Codec = 0
+	CodecZstd Codec = 1
+)
+
+// chunkMagic starts every chunk object written with a codec header: the magic, then one
+// Codec byte, then the payload. Objects without it are raw chunks from older versions.
+var chunkMagic = []byte("VBC1")
+
+const chunkHeaderLen = 5
+
+var (
+	zstdOnce sync.Once
+	zstdEnc  *zstd.Encoder
+	zstdDec  *zstd.Decoder
+	zstdErr  error
+)
+
+// zstdCodec returns the shared encoder and decoder. EncodeAll and DecodeAll are safe for
+// concurrent use, so upload workers and restore share one of each. A 1 MiB window keeps
+// encoder state small next to the chunk buffers.
+func zstdCodec() (*zstd.Encoder, *zstd.Decoder, error) {
+	zstdOnce.Do(func() {
+		zstdEnc, zstdErr = zstd.NewWriter(nil, zstd.WithWindowSize(1<<20))
+		if zstdErr != nil {
+			return
+		}
+		zstdDec, zstdErr = zstd.NewReader(nil, zstd.WithDecoderConcurrency(0))
+	})
+	return zstdEnc, zstdDec, zstdErr
+}
+
+// EncodeChunk frames a plaintext chunk for storage, compressing it with codec, and appends
+// the result to dst[:0]. Chunks that do not shrink (already compressed data) are stored with
+// CodecNone.
+func EncodeChunk(dst, data []byte, codec Codec) ([]byte, error) {
+	// Room for the header plus zstd's worst case on incompressible input (a few bytes per
+	// 128 KiB block), so reused buffers are not regrown.
+	if need := chunkHeaderLen + len(data) + len(data)>>10 + 64; cap(dst) < need {
+		dst = make([]byte, 0, need)
+	}
+	out := append(dst[:0], chunkMagic...)
+	out = append(out, 0)
+	switch codec {
+	case CodecNone:
+	case CodecZstd:
+		enc, _, err := zstdCodec()
+		if err != nil {
+			return nil, err
+		}
+		compressed := enc.EncodeAll(data, out)
+		if len(compressed)-chunkHeaderLen < len(data) {
+			compressed[len(chunkMagic)] = byte(CodecZstd)
+			return compressed, nil
+		}
+		out = out[:chunkHeaderLen]
+	default:
+		return nil, fmt.Errorf("unknown chunk codec %d", codec)
+	}
+	out[len(chunkMagic)] = byte(CodecNone)
+	return append(out, data...), nil
+}
+
+// DecodeChunk returns the plaintext of a stored chunk object.
+func DecodeChunk(obj []byte) ([]byte, error) {
+	if len(obj) < chunkHeaderLen || !bytes.HasPrefix(obj, chunkMagic) {
+		return obj, nil
+	}
+	payload := obj[chunkHeaderLen:]
+	switch Codec(obj[len(chunkMagic)]) {
+	case CodecNone:
+		return payload, nil
+	case CodecZstd:
+		_, dec, err := zstdCodec()
+		if err != nil {
+			return nil, err
+		}
+		data, err := dec.DecodeAll(payload, nil)
+		if err != nil {
+			return nil, fmt.Errorf("decompress chunk: %w", err)
+		}
+		return data, nil
+	default:
+		return nil, fmt.Errorf("unknown chunk codec %d", obj[len(chunkMagic)])
+	}
+}
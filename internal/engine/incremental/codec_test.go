@@ -0,0 +1,81 @@
+package incremental
+
+import (
+	"bytes"
+	"context"
+	"math/rand"
+	"testing"
+)
+
+func mustDecodeChunk(t *testing.T, obj []byte) []byte {
+	t.Helper()
+	data, err := DecodeChunk(obj)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestEncodeChunk_RoundTrip(t *testing.T) {
+	text := bytes.Repeat([]byte("INSERT INTO t VALUES (1, 'abc');\n"), 1000)
+	random := make([]byte, 64*1024)
+	rand.New(rand.NewSource(3)).Read(random)
+
+	tests := []struct {
+		name      string
+		data      []byte
+		codec     Codec
+		wantCodec Codec
+	}{
+		{"compressible", text, CodecZstd, CodecZstd},
+		{"incompressible falls back", random, CodecZstd, CodecNone},
+		{"none", text, CodecNone, CodecNone},
+		{"empty", nil, CodecZstd, CodecNone},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			obj, err := EncodeChunk(nil, tt.data, tt.codec)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := Codec(obj[len(chunkMagic)]); got != tt.wantCodec {
+				t.Errorf("codec = %d, want %d", got, tt.wantCodec)
+			}
+			if tt.wantCodec == CodecZstd && len(obj) >= len(tt.data)/4 {
+				t.Errorf("compressed size %d for %d bytes of text", len(obj), len(tt.data))
+			}
+			if got := mustDecodeChunk(t, obj); !bytes.Equal(got, tt.data) {
+				t.Error("round trip differs")
+			}
+		})
+	}
+}
+
+func TestDecodeChunk_LegacyRaw(t *testing.T) {
+	raw := []byte("etc/nginx/nginx.conf\x00\x00\x00")
+	if got := mustDecodeChunk(t, raw); !bytes.Equal(got, raw) {
+		t.Errorf("legacy chunk = %q, want unchanged", got)
+	}
+	if _, err := DecodeChunk(append([]byte("VBC1\x09"), raw...)); err == nil {
+		t.Error("expected error for unknown codec")
+	}
+}
+
+func TestUploadChunk_DedupOnPlaintextHash(t *testing.T) {
+	ctx := context.Background()
+	s := newFakeStorage()
+	data := bytes.Repeat([]byte("config line\n"), 500)
+	hash := HashChunkHex(data)
+
+	if _, err := UploadChunk(ctx, s, hash, data, UploadOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	obj := s.objects[objectKeyForHash(hash, DefaultHashPrefixLen)]
+	if len(obj) >= len(data) {
+		t.Errorf("stored %d bytes for %d bytes of text, want compressed", len(obj), len(data))
+	}
+	uploaded, err := UploadChunk(ctx, s, hash, data, UploadOptions{})
+	if err != nil || uploaded {
+		t.Errorf("second upload = %v, %v; want skipped", uploaded, err)
+	}
+}
@@ -0,0 +1,118 @@
+package incremental
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"VelBackuper/internal/config"
+	"VelBackuper/internal/crypt"
+	"VelBackuper/internal/s3"
+)
+
+func testKeyring(t *testing.T, passphrase string) *crypt.Keyring {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "pass")
+	if err := os.WriteFile(path, []byte(passphrase+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	keys, err := crypt.New(&config.EncryptionConfig{Enabled: true, PassphraseFile: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := keys.DeriveKeys(bytes.Repeat([]byte{0x5a}, crypt.SaltSize)); err != nil {
+		t.Fatal(err)
+	}
+	return keys
+}
+
+func TestRun_Encrypted(t *testing.T) {
+	ctx := context.Background()
+	s := newFakeStorage()
+	keys := testKeyring(t, "correct horse")
+	stream, contents := testStream(t)
+
+	_, idx, snap, err := Run(ctx, s, "job1", bytes.NewReader(stream), RunOptions{Concurrency: 2, Keyring: keys})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for key, obj := range s.objects {
+		if !crypt.IsSealed(obj) {
+			t.Errorf("%s stored unencrypted", key)
+		}
+		if bytes.Contains(obj, contents["var/b.bin"][:64]) || bytes.Contains(obj, []byte("etc/a.conf")) {
+			t.Errorf("%s leaks plaintext", key)
+		}
+	}
+	var off int64
+	for _, ch := range idx.Chunks {
+		piece := stream[off : off+ch.Size]
+		if ch.Hash != keys.ChunkID(piece) || ch.Hash == HashChunkHex(piece) {
+			t.Errorf("chunk ID %s is not the keyed hash", ch.Hash)
+		}
+		off += ch.Size
+	}
+
+	client := &gcTestClient{mem: &fakeS3{objects: s.objects}}
+	got, err := ReadSnapshot(ctx, client, "job1", snap.Timestamp, keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got.Files) != len(snap.Files) {
+		t.Errorf("decrypted snapshot has %d files, want %d", len(got.Files), len(snap.Files))
+	}
+
+	_, err = ReadSnapshot(ctx, client, "job1", snap.Timestamp, testKeyring(t, "wrong"))
+	if !errors.Is(err, crypt.ErrWrongKey) {
+		t.Errorf("wrong key err = %v, want ErrWrongKey", err)
+	}
+	if _, err := ReadSnapshot(ctx, client, "job1", snap.Timestamp, nil); err == nil || !strings.Contains(err.Error(), "encrypted") {
+		t.Errorf("no key err = %v, want encrypted error", err)
+	}
+
+	// GC must decrypt snapshots and indexes to see which chunks are live.
+	objectsBefore := len(s.objects)
+	res, err := Prune(ctx, client, "job1", &config.RetentionConfig{KeepDaily: 30}, PruneOptions{Keyring: keys})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.DeletedObjects != 0 || len(s.objects) != objectsBefore {
+		t.Errorf("prune deleted live encrypted data: %+v", res)
+	}
+	if _, ok := s.objects[s3.SnapshotKey("job1", snap.Timestamp)]; !ok {
+		t.Error("snapshot deleted")
+	}
+}
+
+func TestReadSnapshot_RefusesPlaintextWhenEncrypted(t *testing.T) {
+	ctx := context.Background()
+	s := newFakeStorage()
+	stream, _ := testStream(t)
+	// A snapshot written without encryption, or planted by someone with write access to the bucket.
+	_, _, snap, err := Run(ctx, s, "job1", bytes.NewReader(stream), RunOptions{Concurrency: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &gcTestClient{mem: &fakeS3{objects: s.objects}}
+
+	if _, err := ReadSnapshot(ctx, client, "job1", snap.Timestamp, testKeyring(t, "correct horse")); !errors.Is(err, crypt.ErrNotEncrypted) {
+		t.Fatalf("plaintext snapshot err = %v, want ErrNotEncrypted", err)
+	}
+
+	path := filepath.Join(t.TempDir(), "pass")
+	if err := os.WriteFile(path, []byte("correct horse\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	migrating, err := crypt.New(&config.EncryptionConfig{Enabled: true, PassphraseFile: path, AllowPlaintext: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ReadSnapshot(ctx, client, "job1", snap.Timestamp, migrating); err != nil {
+		t.Errorf("plaintext snapshot with allow_plaintext: %v", err)
+	}
+}
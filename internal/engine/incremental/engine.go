@@ -2,9 +2,13 @@ package incremental
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"io"
 	"time"
 
+	"VelBackuper/internal/crypt"
+	"VelBackuper/internal/engine"
 	"VelBackuper/internal/lock"
 	"VelBackuper/internal/notifier"
 	"VelBackuper/internal/s3"
@@ -13,48 +17,69 @@ import (
 const timestampLayout = "20060102150405"
 
 type RunOptions struct {
+	// Chunker selects how the stream is split. When its Algorithm is empty, fixed-size
+	// chunks of ChunkSize are used.
+	Chunker       ChunkerParams
 	ChunkSize     int64
 	Concurrency   int
 	HashPrefixLen int
-	Notifier      notifier.Notifier
-	StrictNotify  bool
+	// Keyring encrypts chunks, the index and the snapshot; nil disables encryption.
+	Keyring      *crypt.Keyring
+	Notifier     notifier.Notifier
+	StrictNotify bool
 }
 
 func Run(ctx context.Context, store Storage, job string, r io.Reader, opts RunOptions) (backupID string, idx *Index, snap *Snapshot, err error) {
+	backupID, idx, snap, err = run(ctx, store, job, r, opts)
+	if err != nil {
+		err = &engine.Error{Op: engine.OpBackup, Job: job, Err: err}
+	}
+	return backupID, idx, snap, err
+}
+
+func run(ctx context.Context, store Storage, job string, r io.Reader, opts RunOptions) (backupID string, idx *Index, snap *Snapshot, err error) {
 	now := time.Now().UTC()
 	timestamp := now.Format(timestampLayout)
 
-	chunkSize := opts.ChunkSize
-	if chunkSize <= 0 {
-		chunkSize = ChunkSizeMin
+	chunker := opts.Chunker
+	if chunker.Algorithm == "" {
+		chunkSize := opts.ChunkSize
+		if chunkSize <= 0 {
+			chunkSize = ChunkSizeMin
+		}
+		chunker = FixedChunker(chunkSize)
 	}
 
-	var chunks []ChunkObject
-	var indexChunks []IndexChunk
-
-	err = ReadChunks(r, chunkSize, func(chunk []byte) error {
-		if len(chunk) == 0 {
-			return nil
-		}
-		hash := HashChunkHex(chunk)
-		chunks = append(chunks, ChunkObject{
-			Hash: hash,
-			Data: append([]byte(nil), chunk...),
-		})
-		indexChunks = append(indexChunks, IndexChunk{
-			Hash: hash,
-			Size: int64(len(chunk)),
-		})
-		return nil
-	})
-	if err != nil {
-		return "", nil, nil, err
+	// The stream is also parsed as tar on the side to record where each file's content
+	// lies, so that restore can rebuild files from the chunks.
+	pr, pw := io.Pipe()
+	type parsed struct {
+		spans []fileSpan
+		err   error
 	}
+	parsedCh := make(chan parsed, 1)
+	go func() {
+		spans, err := indexTarStream(pr)
+		_, _ = io.Copy(io.Discard, pr)
+		parsedCh <- parsed{spans, err}
+	}()
 
-	_, err = UploadChunks(ctx, store, chunks, UploadOptions{
+	indexChunks, _, err := streamChunks(ctx, store, io.TeeReader(r, pw), chunker, UploadOptions{
 		Concurrency:   opts.Concurrency,
 		HashPrefixLen: opts.HashPrefixLen,
+		Keyring:       opts.Keyring,
 	})
+	_ = pw.CloseWithError(err)
+	p := <-parsedCh
+	if err != nil {
+		return "", nil, nil, err
+	}
+	// A snapshot whose Files miss part of the stream would restore only those files, so a
+	// stream that is not a complete tar archive fails the backup.
+	if p.err != nil {
+		return "", nil, nil, fmt.Errorf("parse tar stream: %w", p.err)
+	}
+	files, err := attachChunks(p.spans, indexChunks)
 	if err != nil {
 		return "", nil, nil, err
 	}
@@ -62,9 +87,10 @@ func Run(ctx context.Context, store Storage, job string, r io.Reader, opts RunOp
 	index := &Index{
 		Job:       job,
 		Timestamp: timestamp,
+		Chunker:   &chunker,
 		Chunks:    indexChunks,
 	}
-	if err := WriteIndex(ctx, store.(*s3.Client), *index); err != nil {
+	if err := WriteIndex(ctx, store, *index, opts.Keyring); err != nil {
 		return "", nil, nil, err
 	}
 
@@ -72,9 +98,9 @@ func Run(ctx context.Context, store Storage, job string, r io.Reader, opts RunOp
 		Job:       job,
 		Timestamp: timestamp,
 		IndexKey:  s3.IndexKey(job, timestamp),
-		Files:     nil,
+		Files:     files,
 	}
-	if err := WriteSnapshot(ctx, store.(*s3.Client), *snapshot); err != nil {
+	if err := WriteSnapshot(ctx, store, *snapshot, opts.Keyring); err != nil {
 		return "", nil, nil, err
 	}
 
@@ -87,11 +113,11 @@ func RunWithS3Lock(ctx context.Context, client *s3.Client, job string, r io.Read
 		Name:   job,
 		TTL:    lockTTL,
 	})
-	if err != nil {
-		return "", nil, nil, err
+	if err == nil {
+		err = locker.Acquire(ctx)
 	}
-	if err := locker.Acquire(ctx); err != nil {
-		return "", nil, nil, err
+	if err != nil {
+		return "", nil, nil, &engine.Error{Op: engine.OpBackup, Job: job, Err: err}
 	}
 	defer func() {
 		_ = locker.Release(context.Background())
@@ -103,9 +129,14 @@ func RunWithS3Lock(ctx context.Context, client *s3.Client, job string, r io.Read
 		}
 	}
 
+	// Uploads stop once the lock is lost, before a garbage collection that took it over can
+	// miss the chunks of this snapshot.
+	lockedCtx, cancel := locker.Context(ctx)
 	start := time.Now()
-	backupID, idx, snap, err = Run(ctx, client, job, r, opts)
+	backupID, idx, snap, err = Run(lockedCtx, client, job, r, opts)
 	duration := time.Since(start)
+	err = lockLostError(lockedCtx, engine.OpBackup, job, err)
+	cancel()
 
 	if opts.Notifier != nil {
 		if err != nil {
@@ -116,7 +147,7 @@ func RunWithS3Lock(ctx context.Context, client *s3.Client, job string, r io.Read
 			return backupID, idx, snap, err
 		}
 
-		nErr := opts.Notifier.NotifySuccess(ctx, job, backupID, duration, 0)
+		nErr := opts.Notifier.NotifySuccess(ctx, job, backupID, duration, snapshotStats(idx, snap))
 		if nErr != nil && opts.StrictNotify {
 			return backupID, idx, snap, nErr
 		}
@@ -124,3 +155,27 @@ func RunWithS3Lock(ctx context.Context, client *s3.Client, job string, r io.Read
 
 	return backupID, idx, snap, err
 }
+
+// lockLostError returns the loss of the lock as the error of op when it is why op failed under
+// lockedCtx (see lock.S3Locker.Context), and err otherwise.
+func lockLostError(lockedCtx context.Context, op, job string, err error) error {
+	if cause := context.Cause(lockedCtx); err != nil && errors.Is(cause, lock.ErrLost) {
+		return &engine.Error{Op: op, Job: job, Err: cause}
+	}
+	return err
+}
+
+// snapshotStats summarises a snapshot for notifications. The stored size is not known here
+// since chunks shared with earlier snapshots are not uploaded again.
+func snapshotStats(idx *Index, snap *Snapshot) notifier.BackupStats {
+	var stats notifier.BackupStats
+	for _, ch := range idx.Chunks {
+		stats.UncompressedSize += ch.Size
+	}
+	for _, fe := range snap.Files {
+		if fe.FileMode().IsRegular() {
+			stats.FileCount++
+		}
+	}
+	return stats
+}
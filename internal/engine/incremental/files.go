@@ -0,0 +1,140 @@
+package incremental
+
+import (
+	"archive/tar"
+	"errors"
+	"io"
+	"os"
+	"strings"
+)
+
+// fileSpan is a tar member found in the backup stream. For regular files, offset is the
+// position of the member's content in the stream and entry.Size its length.
+type fileSpan struct {
+	entry  FileEntry
+	offset int64
+}
+
+// countingReader counts the bytes read through it.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// indexTarStream reads the collector's stream as a sequence of tar archives and returns a
+// span for every directory, symlink, hard link, FIFO, device and regular file. It stops at the first data that is not
+// a tar header and returns the spans found so far with the error; callers must drain r.
+func indexTarStream(r io.Reader) ([]fileSpan, error) {
+	cr := &countingReader{r: r}
+	var spans []fileSpan
+	for {
+		start := cr.n
+		tr := tar.NewReader(cr)
+		for {
+			hdr, err := tr.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return spans, err
+			}
+			if span, ok := spanForHeader(hdr, cr.n); ok {
+				spans = append(spans, span)
+			}
+		}
+		// Streams written by older versions hold several archives back to back; stop once a reader
+		// consumes nothing, which means the stream has ended.
+		if cr.n == start {
+			return spans, nil
+		}
+	}
+}
+
+// paxXattrPrefix starts the PAX record of an extended attribute.
+const paxXattrPrefix = "SCHILY.xattr."
+
+func spanForHeader(hdr *tar.Header, offset int64) (fileSpan, bool) {
+	info := hdr.FileInfo()
+	entry := FileEntry{
+		Path:    hdr.Name,
+		Mode:    uint32(info.Mode()),
+		ModTime: hdr.ModTime.UTC(),
+		UID:     hdr.Uid,
+		GID:     hdr.Gid,
+		Uname:   hdr.Uname,
+		Gname:   hdr.Gname,
+	}
+	switch hdr.Typeflag {
+	case tar.TypeReg, tar.TypeRegA:
+		entry.Size = hdr.Size
+	case tar.TypeDir, tar.TypeFifo:
+	case tar.TypeSymlink:
+		entry.Link = hdr.Linkname
+	case tar.TypeLink:
+		entry.Hardlink = hdr.Linkname
+	case tar.TypeChar, tar.TypeBlock:
+		entry.Devmajor, entry.Devminor = hdr.Devmajor, hdr.Devminor
+	default:
+		return fileSpan{}, false
+	}
+	for k, v := range hdr.PAXRecords {
+		if name, ok := strings.CutPrefix(k, paxXattrPrefix); ok {
+			if entry.Xattrs == nil {
+				entry.Xattrs = make(map[string][]byte)
+			}
+			entry.Xattrs[name] = []byte(v)
+		}
+	}
+	return fileSpan{entry: entry, offset: offset}, true
+}
+
+// attachChunks fills each span's FileChunk list from the ordered stream chunks.
+func attachChunks(spans []fileSpan, chunks []IndexChunk) ([]FileEntry, error) {
+	starts := make([]int64, len(chunks))
+	var pos int64
+	for i, ch := range chunks {
+		starts[i] = pos
+		pos += ch.Size
+	}
+	files := make([]FileEntry, 0, len(spans))
+	ci := 0
+	for _, sp := range spans {
+		fe := sp.entry
+		if fe.Size > 0 {
+			if sp.offset+fe.Size > pos {
+				return nil, errors.New("file extends past end of stream: " + fe.Path)
+			}
+			for ci > 0 && starts[ci] > sp.offset {
+				ci--
+			}
+			for ci < len(chunks)-1 && starts[ci]+chunks[ci].Size <= sp.offset {
+				ci++
+			}
+			remaining := fe.Size
+			off := sp.offset
+			for j := ci; remaining > 0; j++ {
+				inChunk := off - starts[j]
+				n := chunks[j].Size - inChunk
+				if n > remaining {
+					n = remaining
+				}
+				fe.Chunks = append(fe.Chunks, FileChunk{Hash: chunks[j].Hash, Offset: inChunk, Length: n})
+				off += n
+				remaining -= n
+			}
+		}
+		files = append(files, fe)
+	}
+	return files, nil
+}
+
+// FileMode returns the entry's mode as an os.FileMode.
+func (fe FileEntry) FileMode() os.FileMode {
+	return os.FileMode(fe.Mode)
+}
@@ -0,0 +1,215 @@
+package incremental
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"VelBackuper/internal/s3"
+)
+
+type tarMember struct {
+	hdr  tar.Header
+	body []byte
+}
+
+func buildTar(t *testing.T, members ...tarMember) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, m := range members {
+		hdr := m.hdr
+		hdr.Size = int64(len(m.body))
+		if err := tw.WriteHeader(&hdr); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write(m.body); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func testStream(t *testing.T) (stream []byte, contents map[string][]byte) {
+	mtime := time.Date(2025, 2, 26, 12, 0, 0, 0, time.UTC)
+	small := bytes.Repeat([]byte("a"), 100)
+	large := bytes.Repeat([]byte("0123456789"), 300)
+	first := buildTar(t,
+		tarMember{hdr: tar.Header{Name: "etc/", Typeflag: tar.TypeDir, Mode: 0o755, ModTime: mtime}},
+		tarMember{hdr: tar.Header{Name: "etc/a.conf", Typeflag: tar.TypeReg, Mode: 0o644, ModTime: mtime}, body: small},
+		tarMember{hdr: tar.Header{Name: "etc/link", Typeflag: tar.TypeSymlink, Linkname: "a.conf", Mode: 0o777, ModTime: mtime}},
+	)
+	// Collectors may emit several archives back to back.
+	second := buildTar(t,
+		tarMember{hdr: tar.Header{Name: "var/b.bin", Typeflag: tar.TypeReg, Mode: 0o600, ModTime: mtime}, body: large},
+		tarMember{hdr: tar.Header{Name: "var/empty", Typeflag: tar.TypeReg, Mode: 0o600, ModTime: mtime}},
+	)
+	return append(first, second...), map[string][]byte{"etc/a.conf": small, "var/b.bin": large, "var/empty": nil}
+}
+
+func TestIndexTarStream_AttachChunks(t *testing.T) {
+	stream, contents := testStream(t)
+
+	spans, err := indexTarStream(bytes.NewReader(stream))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Split into small fixed pieces so files straddle chunk boundaries.
+	const piece = 700
+	var chunks []IndexChunk
+	data := make(map[string][]byte)
+	for off := 0; off < len(stream); off += piece {
+		end := min(off+piece, len(stream))
+		h := HashChunkHex(stream[off:end])
+		chunks = append(chunks, IndexChunk{Hash: h, Size: int64(end - off)})
+		data[h] = stream[off:end]
+	}
+
+	files, err := attachChunks(spans, chunks)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 5 {
+		t.Fatalf("len(files) = %d, want 5: %+v", len(files), files)
+	}
+
+	byPath := make(map[string]FileEntry)
+	for _, fe := range files {
+		byPath[fe.Path] = fe
+	}
+	if fe := byPath["etc/"]; !fe.FileMode().IsDir() || fe.FileMode().Perm() != 0o755 {
+		t.Errorf("etc/ mode = %v", fe.FileMode())
+	}
+	if fe := byPath["etc/link"]; fe.FileMode()&os.ModeSymlink == 0 || fe.Link != "a.conf" {
+		t.Errorf("etc/link = %+v", fe)
+	}
+	for path, want := range contents {
+		fe, ok := byPath[path]
+		if !ok {
+			t.Errorf("missing entry %s", path)
+			continue
+		}
+		if fe.Size != int64(len(want)) {
+			t.Errorf("%s size = %d, want %d", path, fe.Size, len(want))
+		}
+		var got []byte
+		for _, fc := range fe.Chunks {
+			got = append(got, data[fc.Hash][fc.Offset:fc.Offset+fc.Length]...)
+		}
+		if !bytes.Equal(got, want) {
+			t.Errorf("%s content rebuilt from chunks differs", path)
+		}
+	}
+	if n := len(byPath["var/b.bin"].Chunks); n < 2 {
+		t.Errorf("var/b.bin spans %d chunks, want several", n)
+	}
+}
+
+func TestIndexTarStream_StopsAtNonTarData(t *testing.T) {
+	stream, _ := testStream(t)
+	stream = append(stream, []byte("-- MySQL dump 10.13\nCREATE TABLE t (id int);\n")...)
+
+	spans, err := indexTarStream(bytes.NewReader(stream))
+	if err == nil {
+		t.Error("expected error for trailing non-tar data")
+	}
+	if len(spans) != 5 {
+		t.Errorf("len(spans) = %d, want the 5 members before the bad data", len(spans))
+	}
+}
+
+func TestRun_FailsOnIncompleteTarStream(t *testing.T) {
+	stream, _ := testStream(t)
+	tests := map[string][]byte{
+		// Cut inside var/b.bin, after the members of the first archive.
+		"truncated": stream[:len(stream)-2048],
+		"corrupt":   append(append([]byte(nil), stream[:1536]...), bytes.Repeat([]byte{0xff}, 1024)...),
+	}
+	for name, data := range tests {
+		t.Run(name, func(t *testing.T) {
+			s := newFakeStorage()
+			_, _, _, err := Run(context.Background(), s, "job1", bytes.NewReader(data), RunOptions{})
+			if err == nil || !strings.Contains(err.Error(), "parse tar stream") {
+				t.Fatalf("Run = %v, want a tar parse error", err)
+			}
+			for key := range s.objects {
+				if strings.HasPrefix(key, s3.SnapshotsPrefix) {
+					t.Errorf("snapshot %s written for an incomplete stream", key)
+				}
+			}
+		})
+	}
+}
+
+func TestIndexTarStream_SpecialEntriesAndMetadata(t *testing.T) {
+	mtime := time.Date(2025, 2, 26, 12, 0, 0, 0, time.UTC)
+	stream := buildTar(t,
+		tarMember{hdr: tar.Header{Name: "etc/a.conf", Typeflag: tar.TypeReg, Mode: 0o640, ModTime: mtime, Uid: 33, Gid: 33, Uname: "www-data", Gname: "www-data",
+			PAXRecords: map[string]string{"SCHILY.xattr.security.capability": "\x01\x00", "SCHILY.xattr.system.posix_acl_access": "acl"}}, body: []byte("a")},
+		tarMember{hdr: tar.Header{Name: "etc/b.conf", Typeflag: tar.TypeLink, Linkname: "etc/a.conf", ModTime: mtime}},
+		tarMember{hdr: tar.Header{Name: "run/fifo", Typeflag: tar.TypeFifo, Mode: 0o600, ModTime: mtime}},
+		tarMember{hdr: tar.Header{Name: "dev/null", Typeflag: tar.TypeChar, Mode: 0o666, Devmajor: 1, Devminor: 3, ModTime: mtime}},
+	)
+	spans, err := indexTarStream(bytes.NewReader(stream))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(spans) != 4 {
+		t.Fatalf("len(spans) = %d, want 4", len(spans))
+	}
+	a, b, fifo, dev := spans[0].entry, spans[1].entry, spans[2].entry, spans[3].entry
+	if a.UID != 33 || a.Gname != "www-data" || string(a.Xattrs["security.capability"]) != "\x01\x00" || string(a.Xattrs["system.posix_acl_access"]) != "acl" {
+		t.Errorf("etc/a.conf = %+v", a)
+	}
+	if b.Hardlink != "etc/a.conf" || b.Size != 0 {
+		t.Errorf("etc/b.conf = %+v", b)
+	}
+	if fifo.FileMode()&os.ModeNamedPipe == 0 {
+		t.Errorf("run/fifo mode = %v", fifo.FileMode())
+	}
+	if dev.FileMode()&os.ModeCharDevice == 0 || dev.Devmajor != 1 || dev.Devminor != 3 {
+		t.Errorf("dev/null = %+v", dev)
+	}
+}
+
+func TestRun_WritesFileEntries(t *testing.T) {
+	ctx := context.Background()
+	s := newFakeStorage()
+	stream, contents := testStream(t)
+
+	_, _, snap, err := Run(ctx, s, "job1", bytes.NewReader(stream), RunOptions{Concurrency: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(snap.Files) != 5 {
+		t.Fatalf("len(snap.Files) = %d, want 5", len(snap.Files))
+	}
+
+	var stored Snapshot
+	if err := json.Unmarshal(s.objects[s3.SnapshotKey("job1", snap.Timestamp)], &stored); err != nil {
+		t.Fatal(err)
+	}
+	for _, fe := range stored.Files {
+		want, ok := contents[fe.Path]
+		if !ok {
+			continue
+		}
+		var got []byte
+		for _, fc := range fe.Chunks {
+			obj := mustDecodeChunk(t, s.objects[objectKeyForHash(fc.Hash, DefaultHashPrefixLen)])
+			got = append(got, obj[fc.Offset:fc.Offset+fc.Length]...)
+		}
+		if !bytes.Equal(got, want) {
+			t.Errorf("%s content rebuilt from stored chunks differs", fe.Path)
+		}
+	}
+}
@@ -1,108 +1,566 @@
 package incremental
 
 import (
+	"bytes"
 	"context"
-	"encoding/json"
+	"encoding/hex"
+	"fmt"
 	"io"
 	"path"
+	"slices"
 	"strings"
+	"sync"
 	"time"
 
 	"VelBackuper/internal/config"
+	"VelBackuper/internal/crypt"
+	"VelBackuper/internal/engine"
+	"VelBackuper/internal/lock"
 	"VelBackuper/internal/s3"
 )
 
+// GCResult reports the outcome of Prune. The Deleted counters count objects actually
+// deleted; the key lists hold the objects deleted or, with DryRun, those that would be.
 type GCResult struct {
+	Job              string
 	DeletedSnapshots int
 	DeletedIndexes   int
 	DeletedObjects   int
+	// Decisions explains, newest first, why each snapshot was kept or deleted.
+	Decisions []config.RetentionDecision
+
+	Snapshots      []s3.ObjectInfo
+	Indexes        []s3.ObjectInfo
+	Objects        []s3.ObjectInfo // orphan chunks
+	BytesReclaimed int64
+	// RecentObjects counts unreferenced chunks left in place because they are younger than
+	// the grace period.
+	RecentObjects int
+}
+
+// DefaultGCGracePeriod is used when PruneOptions.GracePeriod is zero.
+const DefaultGCGracePeriod = 24 * time.Hour
+
+// DefaultGCConcurrency is the number of snapshots and indexes read in parallel when
+// PruneOptions.Concurrency is zero.
+const DefaultGCConcurrency = 8
+
+// GC phases reported in GCProgress.
+const (
+	GCPhaseMark  = "mark"
+	GCPhaseSweep = "sweep"
+)
+
+// GCProgress reports how far Prune has got.
+type GCProgress struct {
+	Phase string
+	// Marked and MarkTotal count the snapshots whose chunks have been marked live.
+	Marked    int
+	MarkTotal int
+	// Scanned and Deleted count chunks listed and deleted by the sweep so far.
+	Scanned int
+	Deleted int
 }
 
 // gcStorage is the subset of S3 client methods used by Prune.
 type gcStorage interface {
 	ListObjects(ctx context.Context, prefix string, maxKeys int32) ([]string, error)
-	DeleteObject(ctx context.Context, key string) error
+	ListObjectInfo(ctx context.Context, prefix string) ([]s3.ObjectInfo, error)
+	WalkObjects(ctx context.Context, prefix string, fn func(s3.ObjectInfo) error) error
+	DeleteObjects(ctx context.Context, keys []string) error
 	GetObject(ctx context.Context, key string) (io.ReadCloser, error)
 }
 
-// Prune deletes expired snapshots and their indexes for a job according to retention,
-// then performs a mark-and-sweep GC over objects used by retained snapshots of this job.
+// PruneOptions configures Prune.
+type PruneOptions struct {
+	HashPrefixLen int
+	// Keyring decrypts snapshots and indexes of encrypted repositories; nil for plaintext ones.
+	Keyring *crypt.Keyring
+	// DryRun computes what would be deleted without deleting anything.
+	DryRun bool
+	// GracePeriod protects unreferenced chunks written less than this long ago, such as those
+	// of a backup still uploading. Zero means DefaultGCGracePeriod.
+	GracePeriod time.Duration
+	// Concurrency bounds parallel snapshot and index reads. Zero means DefaultGCConcurrency.
+	Concurrency int
+	// Progress, if set, is called from a single goroutine as marking and sweeping advance.
+	Progress func(GCProgress)
+}
+
+// PruneJob is one job pruned by PruneAll.
+type PruneJob struct {
+	Name      string
+	Retention *config.RetentionConfig
+}
+
+// PruneAllResult reports the outcome of PruneAll. Jobs holds, in the order of the jobs, what
+// retention removed from each: decisions, snapshots and indexes. Sweep holds the orphan chunks
+// of the shared object store, which belong to no single job: Objects, DeletedObjects,
+// RecentObjects and their BytesReclaimed.
+type PruneAllResult struct {
+	Jobs  []GCResult
+	Sweep GCResult
+}
+
+// Prune deletes the snapshots of job that retention does not keep, with their indexes,
+// then performs a mark-and-sweep GC over the shared object store. Chunks are live if any
+// retained snapshot of job or any snapshot of another job references them; unreferenced
+// chunks younger than the grace period are kept. Indexes are read in parallel and the
+// object store is listed page by page and deleted in batches of s3.MaxDeleteBatch. Prune
+// does no locking; use PruneWithS3Lock so that GC cannot race a backup uploading new chunks.
 // Any client that satisfies gcStorage (including *s3.Client) can be used.
-func Prune(ctx context.Context, client gcStorage, job string, retention *config.RetentionConfig, now time.Time, hashPrefixLen int) (GCResult, error) {
+func Prune(ctx context.Context, client gcStorage, job string, retention *config.RetentionConfig, opts PruneOptions) (GCResult, error) {
+	res, err := PruneAll(ctx, client, []PruneJob{{Name: job, Retention: retention}}, opts)
 	var result GCResult
+	if len(res.Jobs) > 0 {
+		result = res.Jobs[0]
+	}
+	result.Objects = res.Sweep.Objects
+	result.DeletedObjects = res.Sweep.DeletedObjects
+	result.RecentObjects = res.Sweep.RecentObjects
+	result.BytesReclaimed += res.Sweep.BytesReclaimed
+	return result, err
+}
+
+// PruneAll applies the retention of every job first and then runs one mark-and-sweep GC, in
+// which chunks are live if a retained snapshot of one of jobs or any snapshot of another job
+// references them. This way chunks freed by any of the jobs are found, and orphans are
+// reported and swept once. See Prune.
+func PruneAll(ctx context.Context, client gcStorage, jobs []PruneJob, opts PruneOptions) (PruneAllResult, error) {
+	var result PruneAllResult
+	grace := opts.GracePeriod
+	if grace <= 0 {
+		grace = DefaultGCGracePeriod
+	}
+	workers := opts.Concurrency
+	if workers <= 0 {
+		workers = DefaultGCConcurrency
+	}
+	progress := opts.Progress
+	if progress == nil {
+		progress = func(GCProgress) {}
+	}
 
-	snapPrefix := s3.SnapshotsPrefixForJob(job)
-	snapshotKeys, err := client.ListObjects(ctx, snapPrefix, 0)
+	plans := make([]retentionPlan, len(jobs))
+	names := make([]string, len(jobs))
+	for i, j := range jobs {
+		names[i] = j.Name
+		plan, err := planRetention(ctx, client, j, opts.Keyring, workers)
+		if err != nil {
+			return result, &engine.Error{Op: engine.OpPrune, Job: j.Name, Err: err}
+		}
+		plans[i] = plan
+		result.Jobs = append(result.Jobs, plan.result)
+	}
+	for i, plan := range plans {
+		if opts.DryRun || len(plan.doomed) == 0 {
+			continue
+		}
+		// Checked before every delete: ctx ends when the repository lock is lost.
+		if err := ctx.Err(); err != nil {
+			return result, &engine.Error{Op: engine.OpPrune, Job: jobs[i].Name, Err: err}
+		}
+		if err := client.DeleteObjects(ctx, plan.doomed); err != nil {
+			return result, &engine.Error{Op: engine.OpPrune, Job: jobs[i].Name, Err: err}
+		}
+		result.Jobs[i].DeletedSnapshots = len(plan.result.Snapshots)
+		result.Jobs[i].DeletedIndexes = plan.deletedIndexes
+	}
+
+	sweep, err := sweepOrphans(ctx, client, plans, opts, grace, workers, progress)
+	result.Sweep = sweep
 	if err != nil {
-		return result, err
+		return result, &engine.Error{Op: engine.OpPrune, Job: strings.Join(names, ", "), Err: err}
 	}
+	return result, nil
+}
 
-	liveHashes := make(map[string]struct{})
+// retentionPlan is what retention keeps and drops of one job.
+type retentionPlan struct {
+	job            string
+	result         GCResult
+	kept           []markTarget
+	doomed         []string // snapshot and index keys
+	deletedIndexes int
+}
 
-	for _, snapKey := range snapshotKeys {
-		ts, ok := snapshotTimeFromKey(snapKey)
+// planRetention reads the snapshots of j and decides which of them retention keeps.
+func planRetention(ctx context.Context, client gcStorage, j PruneJob, keys *crypt.Keyring, workers int) (retentionPlan, error) {
+	plan := retentionPlan{job: j.Name, result: GCResult{Job: j.Name}}
+	snapshots, err := client.ListObjectInfo(ctx, s3.SnapshotsPrefixForJob(j.Name))
+	if err != nil {
+		return plan, err
+	}
+	indexes, err := client.ListObjectInfo(ctx, path.Join(s3.IndexesPrefix, j.Name)+"/")
+	if err != nil {
+		return plan, err
+	}
+	indexSizes := make(map[string]int64, len(indexes))
+	for _, ix := range indexes {
+		indexSizes[ix.Key] = ix.Size
+	}
+
+	byTime := make(map[time.Time]s3.ObjectInfo, len(snapshots))
+	var points []time.Time
+	for _, si := range snapshots {
+		ts, ok := snapshotTimeFromKey(si.Key)
 		if !ok {
 			continue
 		}
-		expired := config.IsExpired(ts, now, retention)
+		byTime[ts] = si
+		points = append(points, ts)
+	}
+	result := &plan.result
+	result.Decisions = config.PlanRetention(points, j.Retention)
 
-		snap, err := readSnapshotForGC(ctx, client, snapKey)
-		if err != nil {
-			return result, err
-		}
+	snaps := make([]*Snapshot, len(result.Decisions))
+	err = forEachParallel(ctx, workers, len(snaps), func(i int) error {
+		snap, err := ReadSnapshotByKey(ctx, client, byTime[result.Decisions[i].Time].Key, keys)
+		snaps[i] = snap
+		return err
+	})
+	if err != nil {
+		return plan, err
+	}
 
-		if expired {
-			if err := client.DeleteObject(ctx, snapKey); err != nil {
-				return result, err
-			}
-			result.DeletedSnapshots++
-			if snap.IndexKey != "" {
-				if err := client.DeleteObject(ctx, snap.IndexKey); err != nil {
-					return result, err
-				}
-				result.DeletedIndexes++
-			}
+	for i, d := range result.Decisions {
+		si, snap := byTime[d.Time], snaps[i]
+		if d.Keep {
+			plan.kept = append(plan.kept, markTarget{indexKey: snap.IndexKey})
 			continue
 		}
-
-		if snap.IndexKey == "" {
-			continue
+		result.Snapshots = append(result.Snapshots, si)
+		result.BytesReclaimed += si.Size
+		plan.doomed = append(plan.doomed, si.Key)
+		if size, ok := indexSizes[snap.IndexKey]; ok {
+			result.Indexes = append(result.Indexes, s3.ObjectInfo{Key: snap.IndexKey, Size: size})
+			result.BytesReclaimed += size
 		}
-		idx, err := readIndexForGC(ctx, client, snap.IndexKey)
-		if err != nil {
-			return result, err
+		if snap.IndexKey != "" {
+			plan.doomed = append(plan.doomed, snap.IndexKey)
+			plan.deletedIndexes++
+		}
+	}
+	return plan, nil
+}
+
+// sweepOrphans marks the chunks of the snapshots plans keep and of every snapshot of other
+// jobs, then deletes the unmarked chunks older than grace.
+func sweepOrphans(ctx context.Context, client gcStorage, plans []retentionPlan, opts PruneOptions, grace time.Duration, workers int, progress func(GCProgress)) (GCResult, error) {
+	var result GCResult
+	var targets []markTarget
+	pruned := make([]string, len(plans))
+	for i, plan := range plans {
+		targets = append(targets, plan.kept...)
+		pruned[i] = s3.SnapshotsPrefixForJob(plan.job)
+	}
+	// Every other job shares the object store, so all of its snapshots keep chunks alive.
+	err := client.WalkObjects(ctx, s3.SnapshotsPrefix+"/", func(si s3.ObjectInfo) error {
+		if _, ok := snapshotTimeFromKey(si.Key); !ok {
+			return nil
 		}
-		for _, ch := range idx.Chunks {
-			if ch.Hash == "" {
-				continue
+		for _, prefix := range pruned {
+			if strings.HasPrefix(si.Key, prefix) {
+				return nil
 			}
-			liveHashes[ch.Hash] = struct{}{}
 		}
+		targets = append(targets, markTarget{snapshotKey: si.Key})
+		return nil
+	})
+	if err != nil {
+		return result, err
 	}
 
-	objectsPrefix := s3.ObjectsPrefix
-	objectKeys, err := client.ListObjects(ctx, objectsPrefix, 0)
+	live, err := markChunks(ctx, client, targets, opts.Keyring, workers, progress)
 	if err != nil {
 		return result, err
 	}
 
-	for _, key := range objectKeys {
-		hash := hashFromObjectKey(key)
-		if hash == "" {
-			continue
+	cutoff := time.Now().Add(-grace)
+	var batch []string
+	flush := func() error {
+		if !opts.DryRun && len(batch) > 0 {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			if err := client.DeleteObjects(ctx, batch); err != nil {
+				return err
+			}
+			result.DeletedObjects += len(batch)
 		}
-		if _, ok := liveHashes[hash]; ok {
-			continue
+		batch = batch[:0]
+		return nil
+	}
+	scanned := 0
+	err = client.WalkObjects(ctx, s3.ObjectsPrefix, func(obj s3.ObjectInfo) error {
+		scanned++
+		if scanned%s3.MaxDeleteBatch == 0 {
+			progress(GCProgress{Phase: GCPhaseSweep, Scanned: scanned, Deleted: result.DeletedObjects})
+		}
+		// Keys that are not a chunk hash are never deleted.
+		hash, ok := parseChunkHash(hashFromObjectKey(obj.Key))
+		if !ok || live.contains(hash) {
+			return nil
+		}
+		if obj.LastModified.After(cutoff) {
+			result.RecentObjects++
+			return nil
 		}
-		if err := client.DeleteObject(ctx, key); err != nil {
-			return result, err
+		result.Objects = append(result.Objects, obj)
+		result.BytesReclaimed += obj.Size
+		batch = append(batch, obj.Key)
+		if len(batch) == s3.MaxDeleteBatch {
+			return flush()
 		}
-		result.DeletedObjects++
+		return nil
+	})
+	if err == nil {
+		err = flush()
 	}
-
+	if err != nil {
+		return result, err
+	}
+	progress(GCProgress{Phase: GCPhaseSweep, Scanned: scanned, Deleted: result.DeletedObjects})
 	return result, nil
 }
 
+// PruneWithS3Lock runs Prune while holding the repository-wide S3 lock, which excludes
+// backups of every job for the duration of the GC. A dry run takes no lock.
+func PruneWithS3Lock(ctx context.Context, client *s3.Client, job string, retention *config.RetentionConfig, opts PruneOptions, lockTTL time.Duration) (GCResult, error) {
+	var res GCResult
+	err := withRepositoryLock(ctx, client, job, opts.DryRun, lockTTL, func(ctx context.Context) error {
+		var err error
+		res, err = Prune(ctx, client, job, retention, opts)
+		return err
+	})
+	return res, err
+}
+
+// PruneAllWithS3Lock runs PruneAll while holding the repository-wide S3 lock, like
+// PruneWithS3Lock.
+func PruneAllWithS3Lock(ctx context.Context, client *s3.Client, jobs []PruneJob, opts PruneOptions, lockTTL time.Duration) (PruneAllResult, error) {
+	names := make([]string, len(jobs))
+	for i, j := range jobs {
+		names[i] = j.Name
+	}
+	var res PruneAllResult
+	err := withRepositoryLock(ctx, client, strings.Join(names, ", "), opts.DryRun, lockTTL, func(ctx context.Context) error {
+		var err error
+		res, err = PruneAll(ctx, client, jobs, opts)
+		return err
+	})
+	return res, err
+}
+
+// withRepositoryLock calls fn under the repository-wide S3 lock, or without it for a dry run.
+// fn's context ends when the lock is lost, so that deletes stop: a backup may be uploading
+// chunks again.
+func withRepositoryLock(ctx context.Context, client *s3.Client, job string, dryRun bool, lockTTL time.Duration, fn func(context.Context) error) error {
+	if dryRun {
+		return fn(ctx)
+	}
+	locker, err := lock.NewS3(lock.S3Options{
+		Client:    client,
+		TTL:       lockTTL,
+		Exclusive: true,
+	})
+	if err == nil {
+		err = locker.Acquire(ctx)
+	}
+	if err != nil {
+		return &engine.Error{Op: engine.OpPrune, Job: job, Err: err}
+	}
+	defer func() {
+		_ = locker.Release(context.Background())
+	}()
+	lockedCtx, cancel := locker.Context(ctx)
+	defer cancel()
+	return lockLostError(lockedCtx, engine.OpPrune, job, fn(lockedCtx))
+}
+
+// markTarget is a snapshot whose chunks are live: either its index key is known, or the
+// snapshot has to be read first to find it.
+type markTarget struct {
+	snapshotKey string
+	indexKey    string
+}
+
+// markChunks reads the indexes of targets with a pool of workers and returns the set of
+// chunk hashes they reference.
+func markChunks(ctx context.Context, client gcStorage, targets []markTarget, keys *crypt.Keyring, workers int, progress func(GCProgress)) (*liveSet, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	next := make(chan markTarget)
+	found := make(chan []chunkHash)
+	errc := make(chan error, workers)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for t := range next {
+				hashes, err := indexHashes(ctx, client, t, keys)
+				if err != nil {
+					errc <- err
+					cancel()
+					return
+				}
+				select {
+				case found <- hashes:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+	}
+	go func() {
+		defer close(next)
+		for _, t := range targets {
+			select {
+			case next <- t:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	go func() {
+		wg.Wait()
+		close(found)
+	}()
+
+	live := &liveSet{}
+	marked := 0
+	for hashes := range found {
+		live.add(hashes)
+		marked++
+		progress(GCProgress{Phase: GCPhaseMark, Marked: marked, MarkTotal: len(targets)})
+	}
+	select {
+	case err := <-errc:
+		return nil, err
+	default:
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	live.compact()
+	return live, nil
+}
+
+// indexHashes returns the chunk hashes referenced by the index of t, decoded.
+func indexHashes(ctx context.Context, client gcStorage, t markTarget, keys *crypt.Keyring) ([]chunkHash, error) {
+	indexKey := t.indexKey
+	if t.snapshotKey != "" {
+		snap, err := ReadSnapshotByKey(ctx, client, t.snapshotKey, keys)
+		if err != nil {
+			return nil, fmt.Errorf("read snapshot %s: %w", t.snapshotKey, err)
+		}
+		indexKey = snap.IndexKey
+	}
+	if indexKey == "" {
+		return nil, nil
+	}
+	idx, err := ReadIndexByKey(ctx, client, indexKey, keys)
+	if err != nil {
+		return nil, err
+	}
+	hashes := make([]chunkHash, 0, len(idx.Chunks))
+	for _, ch := range idx.Chunks {
+		// A reference that does not decode cannot match a chunk the sweep would delete.
+		if h, ok := parseChunkHash(ch.Hash); ok {
+			hashes = append(hashes, h)
+		}
+	}
+	return hashes, nil
+}
+
+// chunkHash is a chunk ID in binary form: 32 bytes instead of a 64-byte hex string.
+type chunkHash [32]byte
+
+// parseChunkHash decodes a hex chunk ID. Shorter IDs are zero-padded, which can only make
+// the sweep keep an object, never delete a referenced one.
+func parseChunkHash(s string) (chunkHash, bool) {
+	var h chunkHash
+	if s == "" || hex.DecodedLen(len(s)) > len(h) {
+		return h, false
+	}
+	if _, err := hex.Decode(h[:], []byte(s)); err != nil {
+		return h, false
+	}
+	return h, true
+}
+
+func compareChunkHash(a, b chunkHash) int { return bytes.Compare(a[:], b[:]) }
+
+// liveSet holds referenced chunk hashes as a sorted slice without duplicates, which takes
+// far less memory than a map when there are millions of chunks. Hashes are appended as
+// indexes are read and the slice is compacted whenever it has doubled since the last time.
+type liveSet struct {
+	hashes    []chunkHash
+	compacted int
+}
+
+func (s *liveSet) add(hashes []chunkHash) {
+	s.hashes = append(s.hashes, hashes...)
+	if len(s.hashes) > 2*s.compacted+1<<16 {
+		s.compact()
+	}
+}
+
+func (s *liveSet) compact() {
+	slices.SortFunc(s.hashes, compareChunkHash)
+	s.hashes = slices.Compact(s.hashes)
+	s.compacted = len(s.hashes)
+}
+
+// contains reports whether hash is live. The set must have been compacted since the last add.
+func (s *liveSet) contains(hash chunkHash) bool {
+	_, ok := slices.BinarySearchFunc(s.hashes, hash, compareChunkHash)
+	return ok
+}
+
+// forEachParallel calls fn for 0..n-1 on up to workers goroutines and returns the first error.
+func forEachParallel(ctx context.Context, workers, n int, fn func(i int) error) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	next := make(chan int)
+	var (
+		wg       sync.WaitGroup
+		errOnce  sync.Once
+		firstErr error
+	)
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				if err := fn(i); err != nil {
+					errOnce.Do(func() {
+						firstErr = err
+						cancel()
+					})
+				}
+			}
+		}()
+	}
+send:
+	for i := 0; i < n; i++ {
+		select {
+		case next <- i:
+		case <-ctx.Done():
+			break send
+		}
+	}
+	close(next)
+	wg.Wait()
+	if firstErr != nil {
+		return firstErr
+	}
+	return ctx.Err()
+}
+
 func snapshotTimeFromKey(key string) (time.Time, bool) {
 	base := path.Base(key)
 	if base == "." || base == "/" {
@@ -127,29 +585,3 @@ func hashFromObjectKey(key string) string {
 	}
 	return parts[2]
 }
-
-func readSnapshotForGC(ctx context.Context, client gcStorage, key string) (*Snapshot, error) {
-	rc, err := client.GetObject(ctx, key)
-	if err != nil {
-		return nil, err
-	}
-	defer rc.Close()
-	var s Snapshot
-	if err := json.NewDecoder(rc).Decode(&s); err != nil {
-		return nil, err
-	}
-	return &s, nil
-}
-
-func readIndexForGC(ctx context.Context, client gcStorage, key string) (*Index, error) {
-	rc, err := client.GetObject(ctx, key)
-	if err != nil {
-		return nil, err
-	}
-	defer rc.Close()
-	var idx Index
-	if err := json.NewDecoder(rc).Decode(&idx); err != nil {
-		return nil, err
-	}
-	return &idx, nil
-}
@@ -3,9 +3,11 @@ package incremental
 import (
 	"bytes"
 	"context"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"io"
+	"sort"
 	"strings"
 	"testing"
 	"time"
@@ -15,11 +17,12 @@ import (
 )
 
 type fakeS3 struct {
-	objects map[string][]byte
+	objects  map[string][]byte
+	modified map[string]time.Time // unset keys are reported as written long ago
 }
 
 func newFakeS3() *fakeS3 {
-	return &fakeS3{objects: make(map[string][]byte)}
+	return &fakeS3{objects: make(map[string][]byte), modified: make(map[string]time.Time)}
 }
 
 func (f *fakeS3) Put(key string, v any) error {
@@ -38,8 +41,7 @@ func (f *fakeS3) Client() *s3.Client {
 
 func TestPrune_RemovesExpiredSnapshotsAndOrphans(t *testing.T) {
 	ctx := context.Background()
-	now := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
-	ret := &config.RetentionConfig{Days: 30}
+	ret := &config.RetentionConfig{KeepLast: 1}
 
 	mem := newFakeS3()
 
@@ -85,7 +87,7 @@ func TestPrune_RemovesExpiredSnapshotsAndOrphans(t *testing.T) {
 
 	wrap := &gcTestClient{mem: mem}
 
-	res, err := Prune(ctx, wrap, job, ret, now, DefaultHashPrefixLen)
+	res, err := Prune(ctx, wrap, job, ret, PruneOptions{HashPrefixLen: DefaultHashPrefixLen})
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -98,6 +100,9 @@ func TestPrune_RemovesExpiredSnapshotsAndOrphans(t *testing.T) {
 	if res.DeletedObjects != 1 {
 		t.Errorf("DeletedObjects=%d, want 1", res.DeletedObjects)
 	}
+	if len(res.Decisions) != 2 || !res.Decisions[0].Keep || res.Decisions[1].Keep {
+		t.Errorf("Decisions = %+v, want newest kept and oldest dropped", res.Decisions)
+	}
 
 	if _, ok := mem.objects[oldSnapKey]; ok {
 		t.Error("old snapshot should be deleted")
@@ -113,8 +118,235 @@ func TestPrune_RemovesExpiredSnapshotsAndOrphans(t *testing.T) {
 	}
 }
 
+func TestPrune_DryRunPlansWithoutDeleting(t *testing.T) {
+	ctx := context.Background()
+	mem := newFakeS3()
+	job := "job1"
+	for _, tc := range []struct{ ts, hash string }{{"20250101000000", "aaaa"}, {"20250215000000", "bbbb"}} {
+		idxKey := s3.IndexKey(job, tc.ts)
+		if err := mem.Put(s3.SnapshotKey(job, tc.ts), Snapshot{Job: job, Timestamp: tc.ts, IndexKey: idxKey}); err != nil {
+			t.Fatal(err)
+		}
+		if err := mem.Put(idxKey, Index{Job: job, Timestamp: tc.ts, Chunks: []IndexChunk{{Hash: tc.hash, Size: 1}}}); err != nil {
+			t.Fatal(err)
+		}
+		mem.objects[s3.ObjectKey(tc.hash[:2], tc.hash)] = []byte("chunk-" + tc.hash)
+	}
+	before := len(mem.objects)
+
+	res, err := Prune(ctx, &gcTestClient{mem: mem}, job, &config.RetentionConfig{KeepLast: 1}, PruneOptions{DryRun: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(mem.objects) != before || res.DeletedSnapshots+res.DeletedIndexes+res.DeletedObjects != 0 {
+		t.Fatalf("dry run deleted objects: %+v", res)
+	}
+	oldSnap, oldIdx, oldObj := s3.SnapshotKey(job, "20250101000000"), s3.IndexKey(job, "20250101000000"), s3.ObjectKey("aa", "aaaa")
+	if len(res.Snapshots) != 1 || res.Snapshots[0].Key != oldSnap {
+		t.Errorf("Snapshots = %+v, want %s", res.Snapshots, oldSnap)
+	}
+	if len(res.Indexes) != 1 || res.Indexes[0].Key != oldIdx {
+		t.Errorf("Indexes = %+v, want %s", res.Indexes, oldIdx)
+	}
+	if len(res.Objects) != 1 || res.Objects[0].Key != oldObj {
+		t.Errorf("Objects = %+v, want %s", res.Objects, oldObj)
+	}
+	want := int64(len(mem.objects[oldSnap]) + len(mem.objects[oldIdx]) + len(mem.objects[oldObj]))
+	if res.BytesReclaimed != want {
+		t.Errorf("BytesReclaimed = %d, want %d", res.BytesReclaimed, want)
+	}
+}
+
+// putSnapshot stores a snapshot of job at ts whose index references hashes, and the chunks.
+func putSnapshot(t *testing.T, mem *fakeS3, job, ts string, hashes ...string) {
+	t.Helper()
+	idxKey := s3.IndexKey(job, ts)
+	if err := mem.Put(s3.SnapshotKey(job, ts), Snapshot{Job: job, Timestamp: ts, IndexKey: idxKey}); err != nil {
+		t.Fatal(err)
+	}
+	idx := Index{Job: job, Timestamp: ts}
+	for _, h := range hashes {
+		idx.Chunks = append(idx.Chunks, IndexChunk{Hash: h, Size: 1})
+		mem.objects[s3.ObjectKey(h[:2], h)] = []byte("chunk-" + h)
+	}
+	if err := mem.Put(idxKey, idx); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPrune_KeepsChunksOfOtherJobs(t *testing.T) {
+	mem := newFakeS3()
+	putSnapshot(t, mem, "job1", "20250101000000", "aaaa", "cccc")
+	putSnapshot(t, mem, "job1", "20250215000000", "bbbb")
+	putSnapshot(t, mem, "job2", "20240101000000", "aaaa")
+
+	res, err := Prune(context.Background(), &gcTestClient{mem: mem}, "job1", &config.RetentionConfig{KeepLast: 1}, PruneOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := mem.objects[s3.ObjectKey("aa", "aaaa")]; !ok {
+		t.Error("chunk referenced by another job was deleted")
+	}
+	if _, ok := mem.objects[s3.ObjectKey("cc", "cccc")]; ok {
+		t.Error("orphan chunk was kept")
+	}
+	if _, ok := mem.objects[s3.SnapshotKey("job2", "20240101000000")]; !ok {
+		t.Error("snapshot of another job was deleted")
+	}
+	if res.DeletedObjects != 1 {
+		t.Errorf("DeletedObjects = %d, want 1", res.DeletedObjects)
+	}
+}
+
+func TestPruneAll_OneSweepOverKeptSnapshotsOfAllJobs(t *testing.T) {
+	mem := newFakeS3()
+	putSnapshot(t, mem, "job1", "20250101000000", "aaaa", "cccc")
+	putSnapshot(t, mem, "job1", "20250215000000", "bbbb")
+	putSnapshot(t, mem, "job2", "20240101000000", "aaaa")
+	putSnapshot(t, mem, "job2", "20250301000000", "dddd")
+	mem.objects[s3.ObjectKey("ee", "eeee")] = []byte("orphan already")
+	jobs := []PruneJob{
+		{Name: "job1", Retention: &config.RetentionConfig{KeepLast: 1}},
+		{Name: "job2", Retention: &config.RetentionConfig{KeepLast: 1}},
+	}
+	client := &gcTestClient{mem: mem}
+
+	plan, err := PruneAll(context.Background(), client, jobs, PruneOptions{DryRun: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(plan.Jobs) != 2 || plan.Jobs[0].Job != "job1" || plan.Jobs[1].Job != "job2" {
+		t.Fatalf("Jobs = %+v", plan.Jobs)
+	}
+	for _, jr := range plan.Jobs {
+		if len(jr.Snapshots) != 1 || len(jr.Indexes) != 1 || len(jr.Objects) != 0 {
+			t.Errorf("%s plan = %d snapshots, %d indexes, %d chunks; want 1, 1 and none", jr.Job, len(jr.Snapshots), len(jr.Indexes), len(jr.Objects))
+		}
+	}
+	// aaaa is only freed because both jobs drop their snapshot with it; eeee is listed once.
+	var swept []string
+	for _, o := range plan.Sweep.Objects {
+		swept = append(swept, hashFromObjectKey(o.Key))
+	}
+	sort.Strings(swept)
+	if fmt.Sprint(swept) != "[aaaa cccc eeee]" {
+		t.Errorf("sweep = %v, want [aaaa cccc eeee]", swept)
+	}
+	if len(mem.objects) != 13 {
+		t.Errorf("dry run deleted objects: %d left", len(mem.objects))
+	}
+
+	res, err := PruneAll(context.Background(), client, jobs, PruneOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Jobs[0].DeletedSnapshots != 1 || res.Jobs[1].DeletedSnapshots != 1 || res.Sweep.DeletedObjects != 3 {
+		t.Errorf("deleted %d + %d snapshots and %d chunks, want 1 + 1 and 3", res.Jobs[0].DeletedSnapshots, res.Jobs[1].DeletedSnapshots, res.Sweep.DeletedObjects)
+	}
+	for _, h := range []string{"bbbb", "dddd"} {
+		if _, ok := mem.objects[s3.ObjectKey(h[:2], h)]; !ok {
+			t.Errorf("live chunk %s deleted", h)
+		}
+	}
+}
+
+func TestPrune_GracePeriodKeepsRecentChunks(t *testing.T) {
+	mem := newFakeS3()
+	putSnapshot(t, mem, "job1", "20250215000000", "bbbb")
+	recent, old := s3.ObjectKey("dd", "dddd"), s3.ObjectKey("ee", "eeee")
+	mem.objects[recent] = []byte("uploading")
+	mem.objects[old] = []byte("orphan")
+	mem.modified[recent] = time.Now().Add(-time.Hour)
+	mem.modified[old] = time.Now().Add(-3 * time.Hour)
+
+	res, err := Prune(context.Background(), &gcTestClient{mem: mem}, "job1", &config.RetentionConfig{KeepLast: 1}, PruneOptions{GracePeriod: 2 * time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := mem.objects[recent]; !ok {
+		t.Error("chunk within the grace period was deleted")
+	}
+	if _, ok := mem.objects[old]; ok {
+		t.Error("chunk older than the grace period was kept")
+	}
+	if res.RecentObjects != 1 || res.DeletedObjects != 1 {
+		t.Errorf("RecentObjects = %d, DeletedObjects = %d, want 1 and 1", res.RecentObjects, res.DeletedObjects)
+	}
+}
+
+func TestPrune_DeletesOrphansInBatches(t *testing.T) {
+	mem := newFakeS3()
+	putSnapshot(t, mem, "job1", "20250215000000", "bbbb")
+	const orphans = 2*s3.MaxDeleteBatch + 5
+	for i := 0; i < orphans; i++ {
+		h := fmt.Sprintf("%08x", i)
+		mem.objects[s3.ObjectKey(h[:2], h)] = []byte("x")
+	}
+	client := &gcTestClient{mem: mem}
+
+	var last GCProgress
+	res, err := Prune(context.Background(), client, "job1", &config.RetentionConfig{KeepLast: 1}, PruneOptions{
+		Concurrency: 3,
+		Progress:    func(p GCProgress) { last = p },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.DeletedObjects != orphans {
+		t.Errorf("DeletedObjects = %d, want %d", res.DeletedObjects, orphans)
+	}
+	if want := []int{s3.MaxDeleteBatch, s3.MaxDeleteBatch, 5}; fmt.Sprint(client.batches) != fmt.Sprint(want) {
+		t.Errorf("DeleteObjects batches = %v, want %v", client.batches, want)
+	}
+	if last.Phase != GCPhaseSweep || last.Scanned != orphans+1 || last.Deleted != orphans {
+		t.Errorf("last progress = %+v", last)
+	}
+	if _, ok := mem.objects[s3.ObjectKey("bb", "bbbb")]; !ok {
+		t.Error("live object should be retained")
+	}
+}
+
+func TestLiveSet(t *testing.T) {
+	hash := func(n int) chunkHash {
+		h, ok := parseChunkHash(fmt.Sprintf("%064x", n))
+		if !ok {
+			t.Fatalf("parseChunkHash(%d) failed", n)
+		}
+		return h
+	}
+	var s liveSet
+	for i := 0; i < 3; i++ {
+		batch := make([]chunkHash, 0, 50000)
+		for j := 0; j < 50000; j++ {
+			batch = append(batch, hash((i*30000+j)%100000))
+		}
+		s.add(batch)
+	}
+	s.compact()
+	if len(s.hashes) != 100000 {
+		t.Errorf("len = %d, want 100000 distinct hashes", len(s.hashes))
+	}
+	if !s.contains(hash(0)) || !s.contains(hash(99999)) || s.contains(hash(100000)) {
+		t.Error("contains gave wrong answers")
+	}
+}
+
+func TestParseChunkHash(t *testing.T) {
+	full := HashChunkHex([]byte("chunk"))
+	h, ok := parseChunkHash(full)
+	if !ok || hex.EncodeToString(h[:]) != full {
+		t.Errorf("parseChunkHash(%s) = %x, %v", full, h, ok)
+	}
+	for _, bad := range []string{"", "xyz1", "abc", full + "00"} {
+		if _, ok := parseChunkHash(bad); ok {
+			t.Errorf("parseChunkHash(%q) accepted", bad)
+		}
+	}
+}
+
 type gcTestClient struct {
-	mem *fakeS3
+	mem     *fakeS3
+	batches []int // sizes of DeleteObjects calls
 }
 
 func (c *gcTestClient) ListObjects(_ context.Context, prefix string, _ int32) ([]string, error) {
@@ -127,8 +359,35 @@ func (c *gcTestClient) ListObjects(_ context.Context, prefix string, _ int32) ([
 	return keys, nil
 }
 
-func (c *gcTestClient) DeleteObject(_ context.Context, key string) error {
-	delete(c.mem.objects, key)
+func (c *gcTestClient) ListObjectInfo(_ context.Context, prefix string) ([]s3.ObjectInfo, error) {
+	var out []s3.ObjectInfo
+	for k, b := range c.mem.objects {
+		if strings.HasPrefix(k, prefix) {
+			out = append(out, s3.ObjectInfo{Key: k, Size: int64(len(b)), LastModified: c.mem.modified[k]})
+		}
+	}
+	return out, nil
+}
+
+func (c *gcTestClient) WalkObjects(ctx context.Context, prefix string, fn func(s3.ObjectInfo) error) error {
+	infos, _ := c.ListObjectInfo(ctx, prefix)
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Key < infos[j].Key })
+	for _, info := range infos {
+		if err := fn(info); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *gcTestClient) DeleteObjects(_ context.Context, keys []string) error {
+	if len(keys) > s3.MaxDeleteBatch {
+		return fmt.Errorf("batch of %d keys exceeds %d", len(keys), s3.MaxDeleteBatch)
+	}
+	c.batches = append(c.batches, len(keys))
+	for _, key := range keys {
+		delete(c.mem.objects, key)
+	}
 	return nil
 }
 
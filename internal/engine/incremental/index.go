@@ -1,11 +1,11 @@
 package incremental
 
 import (
-	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
 
+	"VelBackuper/internal/crypt"
 	"VelBackuper/internal/s3"
 )
 
@@ -14,34 +14,37 @@ type IndexChunk struct {
 	Size int64  `json:"size"`
 }
 
+// Index lists the chunks of a snapshot's stream in order. Chunker is nil for indexes written
+// before content-defined chunking, which always used fixed-size chunks.
 type Index struct {
-	Job       string       `json:"job"`
-	Timestamp string       `json:"timestamp"`
-	Chunks    []IndexChunk `json:"chunks"`
+	Job       string         `json:"job"`
+	Timestamp string         `json:"timestamp"`
+	Chunker   *ChunkerParams `json:"chunker,omitempty"`
+	Chunks    []IndexChunk   `json:"chunks"`
 }
 
-func WriteIndex(ctx context.Context, client *s3.Client, idx Index) error {
+// WriteIndex stores idx, encrypted with keys when encryption is enabled (keys may be nil).
+func WriteIndex(ctx context.Context, client Storage, idx Index, keys *crypt.Keyring) error {
 	key := s3.IndexKey(idx.Job, idx.Timestamp)
 	body, err := json.Marshal(idx)
 	if err != nil {
 		return fmt.Errorf("index marshal: %w", err)
 	}
-	return client.PutObject(ctx, key, bytes.NewReader(body), int64(len(body)))
+	return putSealed(ctx, client, key, body, keys)
 }
 
-func ReadIndex(ctx context.Context, client *s3.Client, job, timestamp string) (*Index, error) {
+func ReadIndex(ctx context.Context, client objectReader, job, timestamp string, keys *crypt.Keyring) (*Index, error) {
 	key := s3.IndexKey(job, timestamp)
-	return ReadIndexByKey(ctx, client, key)
+	return ReadIndexByKey(ctx, client, key, keys)
 }
 
-func ReadIndexByKey(ctx context.Context, client *s3.Client, key string) (*Index, error) {
-	rc, err := client.GetObject(ctx, key)
+func ReadIndexByKey(ctx context.Context, client objectReader, key string, keys *crypt.Keyring) (*Index, error) {
+	body, err := getSealed(ctx, client, key, keys)
 	if err != nil {
 		return nil, err
 	}
-	defer rc.Close()
 	var idx Index
-	if err := json.NewDecoder(rc).Decode(&idx); err != nil {
+	if err := json.Unmarshal(body, &idx); err != nil {
 		return nil, fmt.Errorf("index decode: %w", err)
 	}
 	return &idx, nil
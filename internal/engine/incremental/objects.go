@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"sync"
 
+	"VelBackuper/internal/crypt"
 	"VelBackuper/internal/s3"
 )
 
@@ -17,6 +18,8 @@ type ChunkObject struct {
 type UploadOptions struct {
 	Concurrency   int
 	HashPrefixLen int
+	// Keyring encrypts chunks and derives their IDs; nil stores plaintext chunks under their BLAKE3 hash.
+	Keyring *crypt.Keyring
 }
 
 type UploadResult struct {
@@ -31,19 +34,37 @@ func objectKeyForHash(hash string, prefixLen int) string {
 	return s3.ObjectKey(ObjectKeyPrefix(hash, prefixLen), hash)
 }
 
-func UploadChunk(ctx context.Context, store Storage, hash string, data []byte, prefixLen int) (uploaded bool, err error) {
-	key := objectKeyForHash(hash, prefixLen)
+// UploadChunk stores data under its ID unless an object with that ID already exists.
+// The chunk is zstd-compressed (see EncodeChunk), then sealed with opts.Keyring when
+// encryption is enabled. The ID is always computed from the plaintext.
+func UploadChunk(ctx context.Context, store Storage, hash string, data []byte, opts UploadOptions) (uploaded bool, err error) {
+	uploaded, _, err = uploadChunk(ctx, store, hash, data, nil, opts)
+	return uploaded, err
+}
+
+// uploadChunk is UploadChunk compressing into scratch, which is returned (possibly grown)
+// so callers can reuse it for the next chunk.
+func uploadChunk(ctx context.Context, store Storage, hash string, data, scratch []byte, opts UploadOptions) (uploaded bool, _ []byte, err error) {
+	key := objectKeyForHash(hash, opts.HashPrefixLen)
 	existsAt, err := store.HeadObject(ctx, key)
 	if err != nil {
-		return false, fmt.Errorf("head object %s: %w", key, err)
+		return false, scratch, fmt.Errorf("head object %s: %w", key, err)
 	}
 	if existsAt != nil {
-		return false, nil
+		return false, scratch, nil
+	}
+	scratch, err = EncodeChunk(scratch, data, CodecZstd)
+	if err != nil {
+		return false, scratch, fmt.Errorf("compress chunk %s: %w", hash, err)
+	}
+	body, err := opts.Keyring.Seal(scratch, []byte(hash))
+	if err != nil {
+		return false, scratch, fmt.Errorf("encrypt chunk %s: %w", hash, err)
 	}
-	if err := store.PutObject(ctx, key, bytes.NewReader(data), int64(len(data))); err != nil {
-		return false, fmt.Errorf("put object %s: %w", key, err)
+	if err := store.PutObject(ctx, key, bytes.NewReader(body), int64(len(body))); err != nil {
+		return false, scratch, fmt.Errorf("put object %s: %w", key, err)
 	}
-	return true, nil
+	return true, scratch, nil
 }
 
 func UploadChunks(ctx context.Context, store Storage, chunks []ChunkObject, opts UploadOptions) (UploadResult, error) {
@@ -51,7 +72,6 @@ func UploadChunks(ctx context.Context, store Storage, chunks []ChunkObject, opts
 	if concurrency <= 0 {
 		concurrency = 4
 	}
-	prefixLen := opts.HashPrefixLen
 
 	seen := make(map[string]struct{}, len(chunks))
 	var unique []ChunkObject
@@ -90,7 +110,7 @@ func UploadChunks(ctx context.Context, store Storage, chunks []ChunkObject, opts
 			}
 			defer func() { <-sem }()
 
-			uploaded, err := UploadChunk(ctx, store, c.Hash, c.Data, prefixLen)
+			uploaded, err := UploadChunk(ctx, store, c.Hash, c.Data, opts)
 			mu.Lock()
 			defer mu.Unlock()
 			if err != nil && firstErr == nil {
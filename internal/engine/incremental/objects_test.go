@@ -27,7 +27,7 @@ func TestUploadChunk_SkipsExisting(t *testing.T) {
 	key := objectKeyForHash(hash, DefaultHashPrefixLen)
 	s.setObject(key, []byte("exists"))
 
-	uploaded, err := UploadChunk(ctx, s, hash, []byte("newdata"), DefaultHashPrefixLen)
+	uploaded, err := UploadChunk(ctx, s, hash, []byte("newdata"), UploadOptions{HashPrefixLen: DefaultHashPrefixLen})
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -41,7 +41,7 @@ func TestUploadChunk_UploadsMissing(t *testing.T) {
 	s := newFakeStorage()
 
 	hash := "bbbbbbbb"
-	uploaded, err := UploadChunk(ctx, s, hash, []byte("data"), DefaultHashPrefixLen)
+	uploaded, err := UploadChunk(ctx, s, hash, []byte("data"), UploadOptions{HashPrefixLen: DefaultHashPrefixLen})
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -50,7 +50,7 @@ func TestUploadChunk_UploadsMissing(t *testing.T) {
 	}
 
 	key := objectKeyForHash(hash, DefaultHashPrefixLen)
-	if got := string(s.objects[key]); got != "data" {
+	if got := string(mustDecodeChunk(t, s.objects[key])); got != "data" {
 		t.Errorf("stored = %q, want %q", got, "data")
 	}
 }
@@ -157,7 +157,7 @@ func TestUploadChunks_StoresUnderPrefix(t *testing.T) {
 	if keys[0] != "objects/ab/abcd1234" {
 		t.Errorf("key=%q, want objects/ab/abcd1234", keys[0])
 	}
-	if !bytes.Equal(s.objects[keys[0]], []byte("z")) {
-		t.Errorf("value=%q, want z", s.objects[keys[0]])
+	if got := mustDecodeChunk(t, s.objects[keys[0]]); !bytes.Equal(got, []byte("z")) {
+		t.Errorf("value=%q, want z", got)
 	}
 }
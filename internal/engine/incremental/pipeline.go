@@ -0,0 +1,150 @@
+package incremental
+
+import (
+	"context"
+	"io"
+	"sync"
+)
+
+// pipelineChunk is one chunk moving through the read → hash → upload pipeline.
+type pipelineChunk struct {
+	seq  int
+	buf  []byte
+	hash string
+}
+
+// streamChunks splits r with chunker, hashes the chunks on opts.Concurrency workers and
+// uploads every chunk not already in the store. Chunks are copied into a fixed pool of
+// opts.Concurrency buffers that are recycled once a chunk has been uploaded, and each upload
+// worker compresses into one reused buffer, so memory stays around (2×Concurrency+1) ×
+// chunker.MaxSize however large the stream is. The returned index chunks are in source order.
+func streamChunks(ctx context.Context, store Storage, r io.Reader, chunker ChunkerParams, opts UploadOptions) ([]IndexChunk, UploadResult, error) {
+	concurrency := opts.Concurrency
+	if concurrency <= 0 {
+		concurrency = 4
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		mu       sync.Mutex
+		firstErr error
+		res      UploadResult
+		entries  = make(map[int]IndexChunk)
+		claimed  = make(map[string]struct{})
+	)
+	fail := func(err error) {
+		mu.Lock()
+		if firstErr == nil {
+			firstErr = err
+		}
+		mu.Unlock()
+		cancel()
+	}
+
+	// free holds the buffer pool; nil entries are allocated on first use.
+	free := make(chan []byte, concurrency)
+	for i := 0; i < concurrency; i++ {
+		free <- nil
+	}
+	hashCh := make(chan pipelineChunk, concurrency)
+	uploadCh := make(chan pipelineChunk, concurrency)
+
+	var hashWG sync.WaitGroup
+	for i := 0; i < concurrency; i++ {
+		hashWG.Add(1)
+		go func() {
+			defer hashWG.Done()
+			for c := range hashCh {
+				if ctx.Err() != nil {
+					continue
+				}
+				c.hash = opts.Keyring.ChunkID(c.buf)
+				select {
+				case uploadCh <- c:
+				case <-ctx.Done():
+				}
+			}
+		}()
+	}
+
+	var uploadWG sync.WaitGroup
+	for i := 0; i < concurrency; i++ {
+		uploadWG.Add(1)
+		go func() {
+			defer uploadWG.Done()
+			// Each worker compresses into its own scratch buffer, reused across chunks.
+			var scratch []byte
+			for c := range uploadCh {
+				if ctx.Err() != nil {
+					continue
+				}
+				mu.Lock()
+				_, dup := claimed[c.hash]
+				claimed[c.hash] = struct{}{}
+				mu.Unlock()
+
+				uploaded := false
+				if !dup {
+					var err error
+					uploaded, scratch, err = uploadChunk(ctx, store, c.hash, c.buf, scratch, opts)
+					if err != nil {
+						fail(err)
+						continue
+					}
+				}
+
+				mu.Lock()
+				if uploaded {
+					res.Uploaded++
+				} else {
+					res.Skipped++
+				}
+				entries[c.seq] = IndexChunk{Hash: c.hash, Size: int64(len(c.buf))}
+				mu.Unlock()
+				free <- c.buf
+			}
+		}()
+	}
+
+	seq := 0
+	readErr := chunker.Split(r, func(chunk []byte) error {
+		if len(chunk) == 0 {
+			return nil
+		}
+		var buf []byte
+		select {
+		case buf = <-free:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		if cap(buf) < len(chunk) {
+			buf = make([]byte, 0, max(int64(len(chunk)), chunker.MaxSize))
+		}
+		buf = append(buf[:0], chunk...)
+		select {
+		case hashCh <- pipelineChunk{seq: seq, buf: buf}:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		seq++
+		return nil
+	})
+	close(hashCh)
+	hashWG.Wait()
+	close(uploadCh)
+	uploadWG.Wait()
+
+	if firstErr != nil {
+		return nil, UploadResult{}, firstErr
+	}
+	if readErr != nil {
+		return nil, UploadResult{}, readErr
+	}
+
+	chunks := make([]IndexChunk, seq)
+	for i := range chunks {
+		chunks[i] = entries[i]
+	}
+	return chunks, res, nil
+}
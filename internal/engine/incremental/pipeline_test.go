@@ -0,0 +1,133 @@
+package incremental
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"math/rand"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestStreamChunks_IndexInSourceOrder(t *testing.T) {
+	ctx := context.Background()
+	s := newFakeStorage()
+
+	// Three distinct chunks followed by a repeat of the first one.
+	var data []byte
+	for _, b := range []byte{'a', 'b', 'c', 'a'} {
+		data = append(data, bytes.Repeat([]byte{b}, ChunkSizeMin)...)
+	}
+
+	chunks, res, err := streamChunks(ctx, s, bytes.NewReader(data), FixedChunker(ChunkSizeMin), UploadOptions{Concurrency: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(chunks) != 4 {
+		t.Fatalf("len(chunks) = %d, want 4", len(chunks))
+	}
+	for i, b := range []byte{'a', 'b', 'c', 'a'} {
+		want := HashChunkHex(bytes.Repeat([]byte{b}, ChunkSizeMin))
+		if chunks[i].Hash != want || chunks[i].Size != ChunkSizeMin {
+			t.Errorf("chunks[%d] = %+v, want hash of %q", i, chunks[i], b)
+		}
+	}
+	if res.Uploaded != 3 || res.Skipped != 1 {
+		t.Errorf("result = %+v, want Uploaded=3 Skipped=1", res)
+	}
+}
+
+func TestStreamChunks_PropagatesUploadError(t *testing.T) {
+	ctx := context.Background()
+	s := &failingStorage{err: errors.New("boom")}
+	data := io.LimitReader(rand.New(rand.NewSource(1)), 8*ChunkSizeMin)
+
+	_, _, err := streamChunks(ctx, s, data, FixedChunker(ChunkSizeMin), UploadOptions{Concurrency: 2})
+	if err == nil || !errors.Is(err, s.err) {
+		t.Fatalf("err = %v, want wrapped boom", err)
+	}
+}
+
+func TestRun_BoundedMemory(t *testing.T) {
+	ctx := context.Background()
+	const concurrency = 4
+	const streamSize = 256 * 1024 * 1024
+	s := &discardStorage{}
+	// One tar member whose header, content and end-of-archive blocks add up to streamSize.
+	var hdr bytes.Buffer
+	body := int64(streamSize - 3*512)
+	if err := tar.NewWriter(&hdr).WriteHeader(&tar.Header{Name: "big.bin", Typeflag: tar.TypeReg, Mode: 0o600, Size: body}); err != nil {
+		t.Fatal(err)
+	}
+	data := io.MultiReader(&hdr, io.LimitReader(rand.New(rand.NewSource(42)), body), bytes.NewReader(make([]byte, 2*512)))
+
+	runtime.GC()
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+
+	_, idx, _, err := Run(ctx, s, "big", data, RunOptions{ChunkSize: ChunkSizeMin, Concurrency: concurrency})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	runtime.ReadMemStats(&after)
+	allocated := after.TotalAlloc - before.TotalAlloc
+
+	if len(idx.Chunks) != streamSize/ChunkSizeMin {
+		t.Errorf("len(idx.Chunks) = %d, want %d", len(idx.Chunks), streamSize/ChunkSizeMin)
+	}
+	// The pool holds Concurrency buffers plus the reader's own buffer, and each upload worker
+	// has a compression buffer; allow some slack for bookkeeping but nowhere near the stream size.
+	limit := uint64((2*concurrency + 4) * ChunkSizeMin)
+	if allocated > limit {
+		t.Errorf("allocated %d bytes for a %d byte stream, want at most %d", allocated, streamSize, limit)
+	}
+	if got := s.uploaded(); got != streamSize/ChunkSizeMin {
+		t.Errorf("uploaded %d chunks, want %d", got, streamSize/ChunkSizeMin)
+	}
+}
+
+// discardStorage accepts every object without keeping its data.
+type discardStorage struct {
+	mu    sync.Mutex
+	count int
+}
+
+func (d *discardStorage) HeadObject(_ context.Context, _ string) (*time.Time, error) {
+	return nil, nil
+}
+
+func (d *discardStorage) PutObject(_ context.Context, key string, body io.Reader, _ int64) error {
+	if _, err := io.Copy(io.Discard, body); err != nil {
+		return err
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if hashFromObjectKey(key) != "" {
+		d.count++
+	}
+	return nil
+}
+
+func (d *discardStorage) uploaded() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.count
+}
+
+// failingStorage fails every upload with err.
+type failingStorage struct {
+	err error
+}
+
+func (f *failingStorage) HeadObject(_ context.Context, _ string) (*time.Time, error) {
+	return nil, nil
+}
+
+func (f *failingStorage) PutObject(_ context.Context, _ string, _ io.Reader, _ int64) error {
+	return f.err
+}
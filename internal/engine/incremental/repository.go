@@ -0,0 +1,110 @@
+package incremental
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"VelBackuper/internal/crypt"
+	"VelBackuper/internal/s3"
+)
+
+// repositorySettle is how long UnlockKeyring waits after an unconditional PUT of the
+// repository config before reading it back, so that a competing writer's PUT has landed.
+var repositorySettle = 2 * time.Second
+
+// RepositoryConfig is stored once per repository at s3.RepositoryConfigKey. Salt is random and
+// makes the keys of an encrypted repository differ from those of any other repository with
+// the same passphrase or identity.
+type RepositoryConfig struct {
+	Version int    `json:"version"`
+	Salt    []byte `json:"salt"`
+}
+
+// repositoryStore is the subset of S3 client methods needed to read and create the
+// repository config.
+type repositoryStore interface {
+	GetObjectWithInfo(ctx context.Context, key string) ([]byte, *s3.ObjectInfo, error)
+	PutObjectIfAbsent(ctx context.Context, key string, body []byte) (string, error)
+	Storage
+	ConditionalWrites() bool
+}
+
+// UnlockKeyring derives the keys of keys from the salt in the repository config. With create,
+// a repository without a config gets one with a new salt; without it such a repository holds
+// no encrypted objects yet and keys is left as is. keys may be nil.
+func UnlockKeyring(ctx context.Context, client repositoryStore, keys *crypt.Keyring, create bool) error {
+	if !keys.Enabled() {
+		return nil
+	}
+	cfg, err := readRepositoryConfig(ctx, client)
+	if err != nil {
+		return err
+	}
+	if cfg == nil && create {
+		if cfg, err = createRepositoryConfig(ctx, client); err != nil {
+			return err
+		}
+	}
+	if cfg == nil {
+		return nil
+	}
+	return keys.DeriveKeys(cfg.Salt)
+}
+
+func readRepositoryConfig(ctx context.Context, client repositoryStore) (*RepositoryConfig, error) {
+	data, _, err := client.GetObjectWithInfo(ctx, s3.RepositoryConfigKey())
+	if err != nil || data == nil {
+		return nil, err
+	}
+	var cfg RepositoryConfig
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("repository config: %w", err)
+	}
+	if cfg.Version != 1 {
+		return nil, fmt.Errorf("repository config: unsupported version %d", cfg.Version)
+	}
+	return &cfg, nil
+}
+
+// createRepositoryConfig writes a config with a new salt unless another process got there
+// first, and returns the config that is stored in the end.
+func createRepositoryConfig(ctx context.Context, client repositoryStore) (*RepositoryConfig, error) {
+	salt, err := crypt.NewSalt()
+	if err != nil {
+		return nil, err
+	}
+	body, err := json.Marshal(RepositoryConfig{Version: 1, Salt: salt})
+	if err != nil {
+		return nil, err
+	}
+	key := s3.RepositoryConfigKey()
+	conditional := client.ConditionalWrites()
+	if conditional {
+		_, err := client.PutObjectIfAbsent(ctx, key, body)
+		switch {
+		case errors.Is(err, s3.ErrConditionalUnsupported):
+			conditional = false
+		case err != nil && !errors.Is(err, s3.ErrPreconditionFailed):
+			return nil, err
+		}
+	}
+	if !conditional {
+		if err := client.PutObject(ctx, key, bytes.NewReader(body), int64(len(body))); err != nil {
+			return nil, err
+		}
+		select {
+		case <-time.After(repositorySettle):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	cfg, err := readRepositoryConfig(ctx, client)
+	if err == nil && cfg == nil {
+		err = fmt.Errorf("repository config %s missing after it was written", key)
+	}
+	return cfg, err
+}
@@ -0,0 +1,102 @@
+package incremental
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"VelBackuper/internal/config"
+	"VelBackuper/internal/crypt"
+	"VelBackuper/internal/s3"
+)
+
+// repoTestStore is a fakeStorage with the conditional calls of the S3 client.
+type repoTestStore struct {
+	*fakeStorage
+	conditional bool
+}
+
+func (r *repoTestStore) GetObjectWithInfo(_ context.Context, key string) ([]byte, *s3.ObjectInfo, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	data, ok := r.objects[key]
+	if !ok {
+		return nil, nil, nil
+	}
+	return data, &s3.ObjectInfo{Key: key, Size: int64(len(data))}, nil
+}
+
+func (r *repoTestStore) PutObjectIfAbsent(_ context.Context, key string, body []byte) (string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.objects[key]; ok {
+		return "", s3.ErrPreconditionFailed
+	}
+	r.objects[key] = append([]byte(nil), body...)
+	return "etag", nil
+}
+
+func (r *repoTestStore) ConditionalWrites() bool { return r.conditional }
+
+func unlockedKeyring(t *testing.T, store *repoTestStore, passphrase string, create bool) *crypt.Keyring {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "pass")
+	if err := os.WriteFile(path, []byte(passphrase+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	keys, err := crypt.New(&config.EncryptionConfig{Enabled: true, PassphraseFile: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := UnlockKeyring(context.Background(), store, keys, create); err != nil {
+		t.Fatal(err)
+	}
+	return keys
+}
+
+func TestUnlockKeyring_SaltPerRepository(t *testing.T) {
+	repositorySettle = 0
+	data := []byte("chunk")
+	repoA := &repoTestStore{fakeStorage: newFakeStorage(), conditional: true}
+	repoB := &repoTestStore{fakeStorage: newFakeStorage()}
+
+	// Restore, prune and verify do not create a salt.
+	readOnly := unlockedKeyring(t, repoA, "shared", false)
+	if len(repoA.objects) != 0 {
+		t.Fatal("read-only unlock wrote a repository config")
+	}
+	if _, err := readOnly.Seal(data, nil); err == nil {
+		t.Error("keyring without repository salt sealed data")
+	}
+
+	first := unlockedKeyring(t, repoA, "shared", true)
+	var cfg RepositoryConfig
+	if err := json.Unmarshal(repoA.objects[s3.RepositoryConfigKey()], &cfg); err != nil || len(cfg.Salt) != crypt.SaltSize {
+		t.Fatalf("repository config = %+v, %v", cfg, err)
+	}
+	second := unlockedKeyring(t, repoA, "shared", true)
+	if !bytes.Equal(repoA.objects[s3.RepositoryConfigKey()], mustJSON(t, cfg)) {
+		t.Error("second unlock replaced the salt")
+	}
+	if first.ChunkID(data) != second.ChunkID(data) {
+		t.Error("hosts of one repository derive different chunk IDs")
+	}
+
+	// Without conditional writes the config is put and read back.
+	other := unlockedKeyring(t, repoB, "shared", true)
+	if other.ChunkID(data) == first.ChunkID(data) {
+		t.Error("repositories with the same passphrase share chunk IDs")
+	}
+}
+
+func mustJSON(t *testing.T, v any) []byte {
+	t.Helper()
+	b, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
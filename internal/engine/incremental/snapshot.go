@@ -1,27 +1,45 @@
 package incremental
 
 import (
-	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
+	"path"
+	"sort"
+	"strings"
 	"time"
 
+	"VelBackuper/internal/crypt"
 	"VelBackuper/internal/s3"
 )
 
+// FileChunk is the part of a file's content stored in one chunk: Length bytes starting at
+// Offset within the chunk.
 type FileChunk struct {
 	Hash   string `json:"hash"`
 	Offset int64  `json:"offset"`
 	Length int64  `json:"length"`
 }
 
+// FileEntry is one file, directory, symlink, FIFO or device of a snapshot. Mode holds
+// os.FileMode bits, including the type bits. A regular file's content is the concatenation of
+// its Chunks, unless Hardlink names an earlier entry holding the same file.
 type FileEntry struct {
-	Path    string      `json:"path"`
-	Mode    uint32      `json:"mode"`
-	Size    int64       `json:"size"`
-	ModTime time.Time   `json:"mod_time"`
-	Chunks  []FileChunk `json:"chunks"`
+	Path     string      `json:"path"`
+	Mode     uint32      `json:"mode"`
+	Size     int64       `json:"size"`
+	ModTime  time.Time   `json:"mod_time"`
+	Link     string      `json:"link,omitempty"`
+	Hardlink string      `json:"hardlink,omitempty"`
+	Chunks   []FileChunk `json:"chunks"`
+
+	UID      int               `json:"uid,omitempty"`
+	GID      int               `json:"gid,omitempty"`
+	Uname    string            `json:"uname,omitempty"`
+	Gname    string            `json:"gname,omitempty"`
+	Devmajor int64             `json:"devmajor,omitempty"`
+	Devminor int64             `json:"devminor,omitempty"`
+	Xattrs   map[string][]byte `json:"xattrs,omitempty"` // including POSIX ACLs (system.posix_acl_*)
 }
 
 type Snapshot struct {
@@ -31,29 +49,74 @@ type Snapshot struct {
 	Files     []FileEntry `json:"files"`
 }
 
-func WriteSnapshot(ctx context.Context, client *s3.Client, s Snapshot) error {
+// WriteSnapshot stores s, encrypted with keys when encryption is enabled (keys may be nil).
+func WriteSnapshot(ctx context.Context, client Storage, s Snapshot, keys *crypt.Keyring) error {
 	key := s3.SnapshotKey(s.Job, s.Timestamp)
 	body, err := json.Marshal(s)
 	if err != nil {
 		return fmt.Errorf("snapshot marshal: %w", err)
 	}
-	return client.PutObject(ctx, key, bytes.NewReader(body), int64(len(body)))
+	return putSealed(ctx, client, key, body, keys)
 }
 
-func ReadSnapshot(ctx context.Context, client *s3.Client, job, timestamp string) (*Snapshot, error) {
+func ReadSnapshot(ctx context.Context, client objectReader, job, timestamp string, keys *crypt.Keyring) (*Snapshot, error) {
 	key := s3.SnapshotKey(job, timestamp)
-	return ReadSnapshotByKey(ctx, client, key)
+	return ReadSnapshotByKey(ctx, client, key, keys)
 }
 
-func ReadSnapshotByKey(ctx context.Context, client *s3.Client, key string) (*Snapshot, error) {
-	rc, err := client.GetObject(ctx, key)
+func ReadSnapshotByKey(ctx context.Context, client objectReader, key string, keys *crypt.Keyring) (*Snapshot, error) {
+	body, err := getSealed(ctx, client, key, keys)
 	if err != nil {
 		return nil, err
 	}
-	defer rc.Close()
 	var s Snapshot
-	if err := json.NewDecoder(rc).Decode(&s); err != nil {
+	if err := json.Unmarshal(body, &s); err != nil {
 		return nil, fmt.Errorf("snapshot decode: %w", err)
 	}
 	return &s, nil
 }
+
+// ListSnapshots returns the timestamps of all snapshots of job, oldest first.
+func ListSnapshots(ctx context.Context, client gcStorage, job string) ([]string, error) {
+	keys, err := client.ListObjects(ctx, s3.SnapshotsPrefixForJob(job), 0)
+	if err != nil {
+		return nil, err
+	}
+	var out []string
+	for _, k := range keys {
+		ts := strings.TrimSuffix(path.Base(k), ".json")
+		if len(ts) == len(timestampLayout) {
+			out = append(out, ts)
+		}
+	}
+	sort.Strings(out)
+	return out, nil
+}
+
+// LatestSnapshot returns the timestamp of the newest snapshot of job, or "" if there is none.
+func LatestSnapshot(ctx context.Context, client gcStorage, job string) (string, error) {
+	all, err := ListSnapshots(ctx, client, job)
+	if err != nil || len(all) == 0 {
+		return "", err
+	}
+	return all[len(all)-1], nil
+}
+
+// PointLatest is the restore point alias for the newest snapshot of a job.
+const PointLatest = "latest"
+
+// ResolveSnapshot returns the snapshot timestamp for point, which is either a timestamp
+// (YYYYMMDDHHMMSS) or PointLatest.
+func ResolveSnapshot(ctx context.Context, client gcStorage, job, point string) (string, error) {
+	if point != "" && point != PointLatest {
+		return point, nil
+	}
+	ts, err := LatestSnapshot(ctx, client, job)
+	if err != nil {
+		return "", fmt.Errorf("list snapshots for job %s: %w", job, err)
+	}
+	if ts == "" {
+		return "", fmt.Errorf("job %s has no snapshots", job)
+	}
+	return ts, nil
+}
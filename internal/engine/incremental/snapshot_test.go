@@ -0,0 +1,51 @@
+package incremental
+
+import (
+	"context"
+	"testing"
+
+	"VelBackuper/internal/s3"
+)
+
+func TestLatestSnapshot(t *testing.T) {
+	ctx := context.Background()
+	mem := newFakeS3()
+	mem.objects[s3.SnapshotKey("job1", "20250101000000")] = []byte("{}")
+	mem.objects[s3.SnapshotKey("job1", "20250301000000")] = []byte("{}")
+	mem.objects[s3.SnapshotKey("job1", "20250201000000")] = []byte("{}")
+	mem.objects[s3.SnapshotKey("job10", "20260101000000")] = []byte("{}")
+	mem.objects["snapshots/job1/notes.txt"] = []byte("x")
+
+	got, err := LatestSnapshot(ctx, &gcTestClient{mem: mem}, "job1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != "20250301000000" {
+		t.Errorf("LatestSnapshot = %q, want 20250301000000", got)
+	}
+
+	got, err = LatestSnapshot(ctx, &gcTestClient{mem: mem}, "none")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != "" {
+		t.Errorf("LatestSnapshot(none) = %q, want empty", got)
+	}
+}
+
+func TestResolveSnapshot(t *testing.T) {
+	ctx := context.Background()
+	mem := newFakeS3()
+	mem.objects[s3.SnapshotKey("job1", "20250101000000")] = []byte("{}")
+	client := &gcTestClient{mem: mem}
+
+	if got, err := ResolveSnapshot(ctx, client, "job1", "20240101000000"); err != nil || got != "20240101000000" {
+		t.Errorf("ResolveSnapshot(timestamp) = %q, %v", got, err)
+	}
+	if got, err := ResolveSnapshot(ctx, client, "job1", PointLatest); err != nil || got != "20250101000000" {
+		t.Errorf("ResolveSnapshot(latest) = %q, %v", got, err)
+	}
+	if _, err := ResolveSnapshot(ctx, client, "empty", PointLatest); err == nil {
+		t.Error("expected error for job without snapshots")
+	}
+}
@@ -1,12 +1,46 @@
 package incremental
 
 import (
+	"bytes"
 	"context"
 	"io"
 	"time"
+
+	"VelBackuper/internal/crypt"
+	"VelBackuper/internal/s3"
 )
 
 type Storage interface {
 	HeadObject(ctx context.Context, key string) (*time.Time, error)
 	PutObject(ctx context.Context, key string, body io.Reader, contentLength int64) error
 }
+
+// objectReader is the subset of S3 client methods needed to read objects back.
+type objectReader interface {
+	GetObject(ctx context.Context, key string) (io.ReadCloser, error)
+}
+
+// putSealed stores body under key, sealed with keys when encryption is enabled.
+// The key is bound to the ciphertext so objects cannot be swapped.
+func putSealed(ctx context.Context, client Storage, key string, body []byte, keys *crypt.Keyring) error {
+	body, err := keys.Seal(body, []byte(key))
+	if err != nil {
+		return err
+	}
+	return client.PutObject(ctx, key, bytes.NewReader(body), int64(len(body)))
+}
+
+// getSealed reads the object at key and decrypts it if it was sealed.
+func getSealed(ctx context.Context, client objectReader, key string, keys *crypt.Keyring) ([]byte, error) {
+	rc, err := client.GetObject(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	data, err := io.ReadAll(rc)
+	rc.Close()
+	if err != nil {
+		// The body is part of the response; failing to read it is a failed request.
+		return nil, &s3.Error{Op: "GetObject", Key: key, Err: err}
+	}
+	return keys.Open(data, []byte(key))
+}
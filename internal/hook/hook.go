@@ -0,0 +1,166 @@
+// Package hook runs the commands a job configures around its backup, such as putting a site
+// into maintenance mode before the backup and taking it out afterwards.
+package hook
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"VelBackuper/internal/proc"
+)
+
+// Phases a hook runs in.
+const (
+	PhasePre     = "pre"
+	PhasePost    = "post"
+	PhaseOnError = "on_error"
+)
+
+// Job states passed to hooks in VELBACKUPER_STATUS.
+const (
+	StatusRunning = "running" // pre hooks: the backup has not started yet
+	StatusSuccess = "success"
+	StatusFailed  = "failed"
+)
+
+// Environment variables that describe the job to a hook.
+const (
+	EnvJob      = "VELBACKUPER_JOB"
+	EnvBackupID = "VELBACKUPER_BACKUP_ID" // empty for pre hooks and when no backup was written
+	EnvMode     = "VELBACKUPER_MODE"
+	EnvPhase    = "VELBACKUPER_HOOK"
+	EnvStatus   = "VELBACKUPER_STATUS"
+	EnvError    = "VELBACKUPER_ERROR" // the job error, if it failed
+)
+
+// outputLimit is how much of a hook's output is kept for logs and notifications.
+const outputLimit = 4096
+
+// waitDelay is how long a hook's output is still read after it was killed on timeout, in case
+// it left children behind that hold the pipes open.
+const waitDelay = 5 * time.Second
+
+// Hook is one command, run with /bin/sh -c.
+type Hook struct {
+	Name    string // empty = Command
+	Command string
+	Timeout time.Duration // 0 = no timeout
+	Env     []string      // KEY=value, added to the job variables
+}
+
+// Info describes the job a hook runs for.
+type Info struct {
+	Job      string
+	BackupID string
+	Mode     string
+	Status   string
+	Err      error
+}
+
+// Error is returned when a hook fails or times out. Output is what the hook wrote to stdout
+// and stderr, up to 4 KiB.
+type Error struct {
+	Phase  string
+	Hook   string
+	Output string
+	Err    error
+}
+
+func (e *Error) Error() string {
+	msg := fmt.Sprintf("%s hook %q: %v", e.Phase, e.Hook, e.Err)
+	if e.Output != "" {
+		msg += ": " + e.Output
+	}
+	return msg
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Run runs h in phase for the job described by info and returns its combined stdout and
+// stderr, trimmed and cut to 4 KiB. A non-zero exit or a timeout returns an *Error.
+func Run(ctx context.Context, phase string, h Hook, info Info) (string, error) {
+	runCtx := ctx
+	if h.Timeout > 0 {
+		var cancel context.CancelFunc
+		runCtx, cancel = context.WithTimeout(ctx, h.Timeout)
+		defer cancel()
+	}
+
+	var out outputBuffer
+	cmd := exec.CommandContext(runCtx, "/bin/sh", "-c", h.Command)
+	cmd.Env = append(os.Environ(), environment(phase, info)...)
+	cmd.Env = append(cmd.Env, h.Env...)
+	cmd.Stdout = &out
+	cmd.Stderr = &out
+	cmd.WaitDelay = waitDelay
+	proc.KillGroupOnCancel(cmd)
+	err := cmd.Run()
+
+	output := out.String()
+	if err == nil {
+		return output, nil
+	}
+	if errors.Is(runCtx.Err(), context.DeadlineExceeded) && ctx.Err() == nil {
+		err = fmt.Errorf("timed out after %s", h.Timeout)
+	} else if ctx.Err() != nil {
+		err = ctx.Err()
+	}
+	return output, &Error{Phase: phase, Hook: h.DisplayName(), Output: output, Err: err}
+}
+
+// DisplayName returns the name of h for logs: its Name, or else its command.
+func (h Hook) DisplayName() string {
+	if h.Name != "" {
+		return h.Name
+	}
+	return h.Command
+}
+
+func environment(phase string, info Info) []string {
+	env := []string{
+		EnvJob + "=" + info.Job,
+		EnvBackupID + "=" + info.BackupID,
+		EnvMode + "=" + info.Mode,
+		EnvPhase + "=" + phase,
+		EnvStatus + "=" + info.Status,
+	}
+	if info.Err != nil {
+		env = append(env, EnvError+"="+info.Err.Error())
+	}
+	return env
+}
+
+// outputBuffer keeps the first outputLimit bytes written to it and discards the rest. It is
+// shared by stdout and stderr, which exec.Cmd writes from one goroutine when they are the
+// same writer.
+type outputBuffer struct {
+	buf       bytes.Buffer
+	truncated bool
+}
+
+func (b *outputBuffer) Write(p []byte) (int, error) {
+	room := outputLimit - b.buf.Len()
+	if room < len(p) {
+		b.truncated = true
+	}
+	if room > 0 {
+		b.buf.Write(p[:min(len(p), room)])
+	}
+	return len(p), nil
+}
+
+func (b *outputBuffer) String() string {
+	s := strings.TrimSpace(b.buf.String())
+	if b.truncated {
+		s += "\n[output truncated]"
+	}
+	return s
+}
@@ -0,0 +1,59 @@
+package hook
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRun_PassesJobEnvironment(t *testing.T) {
+	h := Hook{
+		Command: `echo "$VELBACKUPER_JOB $VELBACKUPER_BACKUP_ID $VELBACKUPER_MODE $VELBACKUPER_HOOK $VELBACKUPER_STATUS $VELBACKUPER_ERROR $EXTRA"`,
+		Env:     []string{"EXTRA=yes"},
+	}
+	info := Info{Job: "web", BackupID: "20240101T000000Z", Mode: "archive", Status: StatusFailed, Err: errors.New("boom")}
+	out, err := Run(context.Background(), PhaseOnError, h, info)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "web 20240101T000000Z archive on_error failed boom yes"; out != want {
+		t.Errorf("output = %q, want %q", out, want)
+	}
+}
+
+func TestRun_FailureIncludesOutput(t *testing.T) {
+	h := Hook{Name: "flush redis", Command: "echo flushing; echo 'connection refused' >&2; exit 3"}
+	out, err := Run(context.Background(), PhasePre, h, Info{Job: "web"})
+	var he *Error
+	if !errors.As(err, &he) {
+		t.Fatalf("Run = %v, want *Error", err)
+	}
+	if he.Phase != PhasePre || he.Hook != "flush redis" {
+		t.Errorf("error = %+v", he)
+	}
+	if out != "flushing\nconnection refused" || !strings.Contains(err.Error(), "connection refused") {
+		t.Errorf("output = %q, error = %v", out, err)
+	}
+}
+
+func TestRun_Timeout(t *testing.T) {
+	h := Hook{Command: "sleep 10", Timeout: 100 * time.Millisecond}
+	start := time.Now()
+	_, err := Run(context.Background(), PhasePost, h, Info{Job: "web"})
+	if err == nil || !strings.Contains(err.Error(), "timed out after 100ms") {
+		t.Fatalf("Run = %v, want a timeout", err)
+	}
+	if d := time.Since(start); d > 5*time.Second {
+		t.Errorf("Run took %s", d)
+	}
+}
+
+func TestOutputBuffer_Truncates(t *testing.T) {
+	var b outputBuffer
+	b.Write([]byte(strings.Repeat("x", outputLimit+10)))
+	if s := b.String(); !strings.HasSuffix(s, "[output truncated]") || len(s) > outputLimit+20 {
+		t.Errorf("String() has %d bytes, ends %q", len(s), s[len(s)-20:])
+	}
+}
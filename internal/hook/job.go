@@ -0,0 +1,20 @@
+package hook
+
+import (
+	"VelBackuper/internal/config"
+)
+
+// FromConfig builds the hooks of one phase of a job, in config order.
+func FromConfig(hooks []config.HookConfig) []Hook {
+	list := make([]Hook, 0, len(hooks))
+	for i := range hooks {
+		h := &hooks[i]
+		list = append(list, Hook{
+			Name:    h.Name,
+			Command: h.Command,
+			Timeout: config.HookTimeout(h),
+			Env:     h.Env,
+		})
+	}
+	return list
+}
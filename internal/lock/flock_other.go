@@ -0,0 +1,18 @@
+//go:build !unix
+
+package lock
+
+import (
+	"errors"
+	"os"
+)
+
+var errWouldBlock = errors.New("lock is held by another process")
+
+var errNoFlock = errors.New("local locks need flock(2), which this platform lacks")
+
+func flockExclusive(*os.File) error { return errNoFlock }
+
+func flockShared(*os.File) error { return errNoFlock }
+
+func funlock(*os.File) error { return nil }
@@ -0,0 +1,36 @@
+//go:build unix
+
+package lock
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+var errWouldBlock = errors.New("lock is held by another process")
+
+func flockExclusive(f *os.File) error {
+	return flock(f, syscall.LOCK_EX|syscall.LOCK_NB)
+}
+
+func flockShared(f *os.File) error {
+	return flock(f, syscall.LOCK_SH|syscall.LOCK_NB)
+}
+
+func funlock(f *os.File) error {
+	return flock(f, syscall.LOCK_UN)
+}
+
+func flock(f *os.File, how int) error {
+	for {
+		err := syscall.Flock(int(f.Fd()), how)
+		if err == syscall.EINTR {
+			continue
+		}
+		if err == syscall.EWOULDBLOCK {
+			return errWouldBlock
+		}
+		return err
+	}
+}
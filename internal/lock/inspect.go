@@ -0,0 +1,102 @@
+package lock
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"VelBackuper/internal/s3"
+)
+
+// S3LockInfo describes a lock object found in S3.
+type S3LockInfo struct {
+	Key string
+	// Name is the job name, or RepositoryLockName for the repository-wide lock.
+	Name string
+	// Owner is nil for locks written by versions that did not record one.
+	Owner *Owner
+	// Modified is the last write of the lock, renewed by every heartbeat.
+	Modified time.Time
+	ETag     string
+}
+
+// Age is the time since the lock was last written.
+func (i *S3LockInfo) Age() time.Duration {
+	return time.Since(i.Modified)
+}
+
+// Stale reports whether other runs would take the lock over, given their TTL.
+func (i *S3LockInfo) Stale(ttl time.Duration) bool {
+	return ttl > 0 && i.Age() >= ttl
+}
+
+// ListS3Locks returns every lock under locks/, job locks and the repository lock alike.
+func ListS3Locks(ctx context.Context, client *s3.Client) ([]S3LockInfo, error) {
+	objects, err := client.ListObjectInfo(ctx, lockKeyPrefix)
+	if err != nil {
+		return nil, fmt.Errorf("list locks: %w", err)
+	}
+	var out []S3LockInfo
+	for _, o := range objects {
+		if !strings.HasSuffix(o.Key, ".lock") {
+			continue
+		}
+		info, err := readS3Lock(ctx, client, o.Key)
+		if err != nil {
+			return nil, err
+		}
+		if info != nil {
+			out = append(out, *info)
+		}
+	}
+	return out, nil
+}
+
+// InspectS3Lock returns the S3 lock of job, or of the repository if job is empty. It returns
+// nil if the lock is not held.
+func InspectS3Lock(ctx context.Context, client *s3.Client, job string) (*S3LockInfo, error) {
+	key := s3.RepositoryLockKey()
+	if job != "" {
+		key = s3.LockKey(job)
+	}
+	return readS3Lock(ctx, client, key)
+}
+
+// BreakS3Lock deletes the lock described by info, as long as it has not been rewritten since
+// it was read, so that a lock refreshed by a live run or taken by a new one is left alone.
+func BreakS3Lock(ctx context.Context, client *s3.Client, info *S3LockInfo) error {
+	if client.ConditionalWrites() && info.ETag != "" {
+		err := client.DeleteObjectIfMatch(ctx, info.Key, info.ETag)
+		if errors.Is(err, s3.ErrPreconditionFailed) {
+			return wrapError(info.Key, fmt.Errorf("lock %s changed since it was read (refreshed by its owner or taken by a new run); not broken", info.Key))
+		}
+		if !errors.Is(err, s3.ErrConditionalUnsupported) {
+			return wrapError(info.Key, err)
+		}
+	}
+	return wrapError(info.Key, client.DeleteObject(ctx, info.Key))
+}
+
+func readS3Lock(ctx context.Context, client *s3.Client, key string) (*S3LockInfo, error) {
+	data, obj, err := client.GetObjectWithInfo(ctx, key)
+	if err != nil {
+		return nil, fmt.Errorf("read lock %s: %w", key, err)
+	}
+	if data == nil {
+		return nil, nil
+	}
+	info := &S3LockInfo{Key: key, Name: lockName(key), Modified: obj.LastModified, ETag: obj.ETag}
+	if o, ok := parseOwner(data); ok {
+		info.Owner = &o
+	}
+	return info, nil
+}
+
+func lockName(key string) string {
+	if key == s3.RepositoryLockKey() {
+		return RepositoryLockName
+	}
+	return strings.TrimSuffix(strings.TrimPrefix(key, lockKeyPrefix), ".lock")
+}
@@ -0,0 +1,79 @@
+package lock
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestListAndInspectS3Locks(t *testing.T) {
+	ctx := context.Background()
+	f := &fakeS3Server{}
+	client := newFakeClient(t, f, true)
+
+	job, _ := NewS3(S3Options{Client: client, Name: "job1", TTL: time.Hour, RunID: "run-a"})
+	if err := job.Acquire(ctx); err != nil {
+		t.Fatal(err)
+	}
+	defer job.Release(ctx)
+
+	locks, err := ListS3Locks(ctx, client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(locks) != 1 || locks[0].Name != "job1" || locks[0].Owner == nil || locks[0].Owner.RunID != "run-a" {
+		t.Fatalf("ListS3Locks = %+v", locks)
+	}
+	if locks[0].Stale(time.Hour) {
+		t.Error("fresh lock reported stale")
+	}
+
+	info, err := InspectS3Lock(ctx, client, "job1")
+	if err != nil || info == nil || info.Key != "locks/job1.lock" {
+		t.Fatalf("InspectS3Lock = %+v, %v", info, err)
+	}
+	if info, err := InspectS3Lock(ctx, client, "other"); err != nil || info != nil {
+		t.Fatalf("InspectS3Lock(other) = %+v, %v", info, err)
+	}
+	if info, err := InspectS3Lock(ctx, client, ""); err != nil || info != nil {
+		t.Fatalf("InspectS3Lock(repository) = %+v, %v", info, err)
+	}
+}
+
+func TestBreakS3Lock_LeavesRefreshedLockAlone(t *testing.T) {
+	ctx := context.Background()
+	f := &fakeS3Server{}
+	client := newFakeClient(t, f, true)
+
+	job, _ := NewS3(S3Options{Client: client, Name: "job1", TTL: time.Hour, Heartbeat: time.Hour})
+	if err := job.Acquire(ctx); err != nil {
+		t.Fatal(err)
+	}
+	info, err := InspectS3Lock(ctx, client, "job1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The owner writes a heartbeat after the lock was read: breaking must not go ahead.
+	job.mu.Lock()
+	err = job.refresh(ctx)
+	job.mu.Unlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := BreakS3Lock(ctx, client, info); err == nil {
+		t.Fatal("BreakS3Lock removed a lock refreshed after inspection")
+	}
+
+	info, _ = InspectS3Lock(ctx, client, "job1")
+	if err := BreakS3Lock(ctx, client, info); err != nil {
+		t.Fatal(err)
+	}
+	if info, _ := InspectS3Lock(ctx, client, "job1"); info != nil {
+		t.Fatal("lock still present after break")
+	}
+	// The broken owner notices on release and does not fail silently.
+	if err := job.Release(ctx); err == nil {
+		t.Error("Release of a broken lock succeeded")
+	}
+}
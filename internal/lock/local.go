@@ -2,7 +2,10 @@ package lock
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 	"sync"
@@ -11,37 +14,67 @@ import (
 
 const DefaultLockDir = "/var/run/velbackuper"
 
+// LockDirEnv overrides DefaultLockDir, e.g. for running without root.
+const LockDirEnv = "VELBACKUPER_LOCK_DIR"
+
+// Dir returns the directory holding local lock files.
+func Dir() string {
+	if d := os.Getenv(LockDirEnv); d != "" {
+		return d
+	}
+	return DefaultLockDir
+}
+
+// acquireRetries and acquireRetryDelay let Acquire ride out the instant a status probe holds
+// a shared lock on the file.
+const (
+	acquireRetries    = 5
+	acquireRetryDelay = 20 * time.Millisecond
+)
+
+// LocalLocker is an exclusive flock(2) on a file in the lock directory. The kernel drops the
+// lock when the holder exits, so a crashed run never leaves a stale lock behind. While held,
+// the file contains the Owner as JSON; the file is truncated, not removed, on release so that
+// every process always locks the same inode.
 type LocalLocker struct {
-	path string
-	ttl  time.Duration
-	file *os.File
-	mu   sync.Mutex
-	held bool
+	path  string
+	runID string
+	file  *os.File
+	mu    sync.Mutex
+	held  bool
 }
 
 type LocalOptions struct {
+	// Dir defaults to Dir().
 	Dir  string
 	Name string
-	TTL  time.Duration
+	// RunID identifies this run in the lock owner; a random ID is used if empty.
+	RunID string
 }
 
 func NewLocal(opts LocalOptions) (*LocalLocker, error) {
-	dir := opts.Dir
-	if dir == "" {
-		dir = DefaultLockDir
+	runID := opts.RunID
+	if runID == "" {
+		runID = NewRunID()
 	}
-	name := opts.Name
-	if name == "" {
-		name = "default"
+	return &LocalLocker{path: localLockPath(opts.Dir, opts.Name), runID: runID}, nil
+}
+
+func localLockPath(dir, name string) string {
+	if dir == "" {
+		dir = Dir()
 	}
-	if filepath.Base(name) != name {
+	if name == "" || filepath.Base(name) != name {
 		name = "default"
 	}
-	path := filepath.Join(dir, name+".lock")
-	return &LocalLocker{path: path, ttl: opts.TTL}, nil
+	return filepath.Join(dir, name+".lock")
 }
 
 func (l *LocalLocker) Acquire(ctx context.Context) error {
+	return wrapError(l.path, l.acquire(ctx))
+}
+
+func (l *LocalLocker) acquire(ctx context.Context) error {
 	l.mu.Lock()
 	defer l.mu.Unlock()
 	if l.held {
@@ -51,44 +84,43 @@ func (l *LocalLocker) Acquire(ctx context.Context) error {
 	if err := os.MkdirAll(filepath.Dir(l.path), 0755); err != nil {
 		return fmt.Errorf("create lock dir: %w", err)
 	}
-
-	tryAcquire := func() (*os.File, error) {
-		return os.OpenFile(l.path, os.O_CREATE|os.O_EXCL|os.O_RDWR, 0640)
-	}
-
-	file, err := tryAcquire()
+	file, err := os.OpenFile(l.path, os.O_CREATE|os.O_RDWR, 0640)
 	if err != nil {
-		if !os.IsExist(err) {
-			return fmt.Errorf("create lock file: %w", err)
-		}
-		if l.ttl <= 0 {
-			return fmt.Errorf("lock file exists: %s (another process may be running)", l.path)
-		}
-		info, statErr := os.Stat(l.path)
-		if statErr != nil {
-			return fmt.Errorf("lock file exists and stat failed: %w", statErr)
-		}
-		if time.Since(info.ModTime()) < l.ttl {
-			return fmt.Errorf("lock file exists: %s (held by another process)", l.path)
+		return fmt.Errorf("open lock file: %w", err)
+	}
+	for attempt := 0; ; attempt++ {
+		err = flockExclusive(file)
+		if !errors.Is(err, errWouldBlock) || attempt == acquireRetries {
+			break
 		}
-		if removeErr := os.Remove(l.path); removeErr != nil {
-			return fmt.Errorf("stale lock file exists, remove failed: %w", removeErr)
+		select {
+		case <-time.After(acquireRetryDelay):
+		case <-ctx.Done():
+			_ = file.Close()
+			return ctx.Err()
 		}
-		file, err = tryAcquire()
-		if err != nil {
-			return fmt.Errorf("retry acquire after stale remove: %w", err)
+	}
+	if errors.Is(err, errWouldBlock) {
+		owner, ok := readOwner(file)
+		_ = file.Close()
+		if ok {
+			return fmt.Errorf("lock file %s is held by %s", l.path, owner)
 		}
+		return fmt.Errorf("lock file %s is held by another process", l.path)
 	}
-
-	if _, err := file.WriteString(fmt.Sprintf("%d\n", os.Getpid())); err != nil {
+	if err != nil {
 		_ = file.Close()
-		_ = os.Remove(l.path)
-		return fmt.Errorf("write lock file: %w", err)
+		return fmt.Errorf("lock %s: %w", l.path, err)
+	}
+
+	body, err := json.Marshal(currentOwner(l.runID))
+	if err == nil {
+		err = writeOwner(file, body)
 	}
-	if err := file.Sync(); err != nil {
+	if err != nil {
+		_ = funlock(file)
 		_ = file.Close()
-		_ = os.Remove(l.path)
-		return fmt.Errorf("sync lock file: %w", err)
+		return fmt.Errorf("write lock file: %w", err)
 	}
 
 	l.file = file
@@ -103,18 +135,71 @@ func (l *LocalLocker) Release(ctx context.Context) error {
 		return nil
 	}
 	var errs []error
-	if l.file != nil {
-		if err := l.file.Close(); err != nil {
-			errs = append(errs, err)
-		}
-		l.file = nil
+	if err := l.file.Truncate(0); err != nil {
+		errs = append(errs, err)
+	}
+	if err := funlock(l.file); err != nil {
+		errs = append(errs, err)
 	}
-	if err := os.Remove(l.path); err != nil && !os.IsNotExist(err) {
+	if err := l.file.Close(); err != nil {
 		errs = append(errs, err)
 	}
+	l.file = nil
 	l.held = false
 	if len(errs) > 0 {
-		return fmt.Errorf("release lock: %v", errs)
+		return wrapError(l.path, fmt.Errorf("release lock: %v", errs))
 	}
 	return nil
 }
+
+// LocalState describes a local lock as seen by another process.
+type LocalState struct {
+	Running bool
+	// Owner is set when Running and the holder recorded itself in the lock file.
+	Owner *Owner
+}
+
+// InspectLocal reports whether the local lock name in dir (Dir() if empty) is held, and by
+// whom. It probes with a momentary shared flock, which a concurrent Acquire retries past.
+func InspectLocal(dir, name string) (LocalState, error) {
+	file, err := os.Open(localLockPath(dir, name))
+	if errors.Is(err, os.ErrNotExist) {
+		return LocalState{}, nil
+	}
+	if err != nil {
+		return LocalState{}, err
+	}
+	defer file.Close()
+
+	err = flockShared(file)
+	if err == nil {
+		_ = funlock(file)
+		return LocalState{}, nil
+	}
+	if !errors.Is(err, errWouldBlock) {
+		return LocalState{}, err
+	}
+	state := LocalState{Running: true}
+	if owner, ok := readOwner(file); ok {
+		state.Owner = &owner
+	}
+	return state, nil
+}
+
+func writeOwner(file *os.File, body []byte) error {
+	if err := file.Truncate(0); err != nil {
+		return err
+	}
+	if _, err := file.WriteAt(append(body, '\n'), 0); err != nil {
+		return err
+	}
+	return file.Sync()
+}
+
+func readOwner(file *os.File) (Owner, bool) {
+	data, err := io.ReadAll(io.NewSectionReader(file, 0, 1<<16))
+	if err != nil {
+		return Owner{}, false
+	}
+	return parseOwner(data)
+}
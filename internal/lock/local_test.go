@@ -0,0 +1,69 @@
+package lock
+
+import (
+	"context"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestLocalLocker_ExcludesAndReportsOwner(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	a, _ := NewLocal(LocalOptions{Dir: dir, Name: "job1", RunID: "run-a"})
+	b, _ := NewLocal(LocalOptions{Dir: dir, Name: "job1", RunID: "run-b"})
+	if err := a.Acquire(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Acquire(ctx); err == nil || !strings.Contains(err.Error(), "run run-a") {
+		t.Fatalf("second Acquire = %v, want an error naming the owner", err)
+	}
+
+	st, err := InspectLocal(dir, "job1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !st.Running || st.Owner == nil || st.Owner.PID != os.Getpid() || st.Owner.Started.IsZero() {
+		t.Fatalf("InspectLocal while held = %+v", st)
+	}
+
+	if err := a.Release(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if st, err := InspectLocal(dir, "job1"); err != nil || st.Running {
+		t.Fatalf("InspectLocal after release = %+v, %v", st, err)
+	}
+	if err := b.Acquire(ctx); err != nil {
+		t.Fatalf("Acquire after release: %v", err)
+	}
+	_ = b.Release(ctx)
+}
+
+func TestLocalLocker_ReleasedWhenHolderDies(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	a, _ := NewLocal(LocalOptions{Dir: dir, Name: "job1"})
+	if err := a.Acquire(ctx); err != nil {
+		t.Fatal(err)
+	}
+	// Closing the descriptor is what the kernel does when the process exits.
+	_ = a.file.Close()
+
+	if st, err := InspectLocal(dir, "job1"); err != nil || st.Running {
+		t.Fatalf("InspectLocal after holder died = %+v, %v", st, err)
+	}
+	b, _ := NewLocal(LocalOptions{Dir: dir, Name: "job1"})
+	if err := b.Acquire(ctx); err != nil {
+		t.Fatalf("Acquire after holder died: %v", err)
+	}
+	_ = b.Release(ctx)
+}
+
+func TestInspectLocal_NoLockFile(t *testing.T) {
+	st, err := InspectLocal(t.TempDir(), "never-run")
+	if err != nil || st.Running {
+		t.Fatalf("InspectLocal = %+v, %v", st, err)
+	}
+}
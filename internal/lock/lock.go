@@ -1,8 +1,75 @@
 package lock
 
-import "context"
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+)
 
 type Locker interface {
 	Acquire(ctx context.Context) error
 	Release(ctx context.Context) error
 }
+
+// Error is returned when a lock cannot be acquired, released or broken. Lock is the lock
+// file path or S3 key. The message of Err already names the lock and, where known, its owner.
+type Error struct {
+	Lock string
+	Err  error
+}
+
+func (e *Error) Error() string {
+	return e.Err.Error()
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// wrapError returns err as an *Error for lock, or nil if err is nil.
+func wrapError(lock string, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &Error{Lock: lock, Err: err}
+}
+
+// Owner identifies the process holding a lock. S3 locks store it as JSON in the lock object.
+type Owner struct {
+	Host      string    `json:"host"`
+	PID       int       `json:"pid"`
+	RunID     string    `json:"run_id"`
+	Started   time.Time `json:"started"`
+	Heartbeat time.Time `json:"heartbeat,omitempty"`
+}
+
+func (o Owner) String() string {
+	return fmt.Sprintf("pid %d on %s (run %s, since %s)", o.PID, o.Host, o.RunID, o.Started.Format(time.RFC3339))
+}
+
+func currentOwner(runID string) Owner {
+	host, _ := os.Hostname()
+	return Owner{Host: host, PID: os.Getpid(), RunID: runID, Started: time.Now().UTC()}
+}
+
+// parseOwner decodes a lock body. Locks written by older versions hold only a timestamp.
+func parseOwner(data []byte) (Owner, bool) {
+	var o Owner
+	if err := json.Unmarshal(data, &o); err != nil || o.RunID == "" {
+		return Owner{}, false
+	}
+	return o, true
+}
+
+// NewRunID returns a random identifier for one run of the tool.
+func NewRunID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Sprintf("%d-%d", os.Getpid(), time.Now().UnixNano())
+	}
+	return hex.EncodeToString(b)
+}
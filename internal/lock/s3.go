@@ -1,7 +1,10 @@
 package lock
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"strings"
 	"sync"
@@ -12,19 +15,60 @@ import (
 
 const lockKeyPrefix = "locks/"
 
+// RepositoryLockName is the name S3LockInfo gives the repository-wide lock.
+const RepositoryLockName = "repository"
+
+// fallbackSettle is how long Acquire waits after an unconditional PUT before reading the
+// lock back, so that a competing writer's PUT has landed and one of the two wins.
+const fallbackSettle = 2 * time.Second
+
+var (
+	// errLockExists is returned internally when the lock object is already present.
+	errLockExists = errors.New("lock object exists")
+	// ErrLost means a held lock was replaced or removed by another process, or could not be
+	// refreshed for so long that others may treat it as left behind.
+	ErrLost = errors.New("lock lost")
+)
+
+// S3Locker is a lock stored as an object under locks/. It is created with a conditional PUT
+// (If-None-Match: *) so that only one process can win, holds an Owner as JSON, is rewritten
+// periodically while held so that it does not go stale, and is only deleted on Release if
+// it is still ours (If-Match on its ETag). On backends without conditional requests it
+// falls back to put, wait, and read back.
 type S3Locker struct {
-	client *s3.Client
-	name   string
-	ttl    time.Duration
-	key    string
-	mu     sync.Mutex
-	held   bool
+	client    *s3.Client
+	name      string
+	ttl       time.Duration
+	interval  time.Duration
+	key       string
+	exclusive bool
+	runID     string
+
+	mu          sync.Mutex
+	held        bool
+	conditional bool
+	owner       Owner
+	etag        string
+	refreshed   time.Time // last successful write of the lock
+	lost        error
+	lostCh      chan struct{} // closed when lost is set
+	stop        context.CancelFunc
+	done        chan struct{}
 }
 
 type S3Options struct {
 	Client *s3.Client
 	Name   string
-	TTL    time.Duration
+	// TTL is how long a lock may go without a heartbeat before others treat it as left behind
+	// by a crashed process. Zero means a lock never goes stale.
+	TTL time.Duration
+	// Heartbeat is how often a held lock is rewritten. Zero means TTL/3.
+	Heartbeat time.Duration
+	// RunID identifies this run in the lock owner; a random ID is used if empty.
+	RunID string
+	// Exclusive takes the repository-wide lock instead of a job lock. It is only granted
+	// while no job lock is held, and job locks are refused while it is held. Name is ignored.
+	Exclusive bool
 }
 
 func NewS3(opts S3Options) (*S3Locker, error) {
@@ -39,54 +83,338 @@ func NewS3(opts S3Options) (*S3Locker, error) {
 		name = "default"
 	}
 	key := lockKeyPrefix + name + ".lock"
+	if opts.Exclusive {
+		name = RepositoryLockName
+		key = s3.RepositoryLockKey()
+	}
+	interval := opts.Heartbeat
+	if interval <= 0 {
+		interval = opts.TTL / 3
+	}
+	runID := opts.RunID
+	if runID == "" {
+		runID = NewRunID()
+	}
 	return &S3Locker{
-		client: opts.Client,
-		name:   name,
-		ttl:    opts.TTL,
-		key:    key,
+		client:      opts.Client,
+		name:        name,
+		ttl:         opts.TTL,
+		interval:    interval,
+		key:         key,
+		exclusive:   opts.Exclusive,
+		runID:       runID,
+		conditional: opts.Client.ConditionalWrites(),
 	}, nil
 }
 
 func (l *S3Locker) Acquire(ctx context.Context) error {
+	return wrapError(l.key, l.acquire(ctx))
+}
+
+func (l *S3Locker) acquire(ctx context.Context) error {
 	l.mu.Lock()
 	defer l.mu.Unlock()
 	if l.held {
 		return fmt.Errorf("s3 lock already held by this process")
 	}
 
-	lastMod, err := l.client.HeadObject(ctx, l.key)
+	l.owner = currentOwner(l.runID)
+	body, err := json.Marshal(l.owner)
 	if err != nil {
-		return fmt.Errorf("s3 lock head: %w", err)
+		return fmt.Errorf("s3 lock: %w", err)
 	}
-	if lastMod != nil {
-		if l.ttl <= 0 {
-			return fmt.Errorf("s3 lock already held: %s (another process may be running)", l.key)
+	for attempt := 0; ; attempt++ {
+		err := l.create(ctx, body)
+		if err == nil {
+			break
 		}
-		if time.Since(*lastMod) < l.ttl {
-			return fmt.Errorf("s3 lock already held: %s (held by another process)", l.key)
+		if !errors.Is(err, errLockExists) || attempt > 0 {
+			return err
 		}
-		if err := l.client.DeleteObject(ctx, l.key); err != nil {
-			return fmt.Errorf("s3 lock stale but delete failed: %w", err)
+		if err := l.removeStale(ctx); err != nil {
+			return err
 		}
 	}
-
-	body := time.Now().UTC().Format(time.RFC3339)
-	if err := l.client.PutObject(ctx, l.key, strings.NewReader(body), int64(len(body))); err != nil {
-		return fmt.Errorf("s3 lock put: %w", err)
+	// Both sides write their own lock before looking for the other, so of two racing
+	// processes at least one sees the other and backs off.
+	if err := l.checkConflict(ctx); err != nil {
+		_ = l.deleteOwn(context.Background())
+		return err
 	}
+
 	l.held = true
+	l.lost = nil
+	l.lostCh = make(chan struct{})
+	l.refreshed = time.Now()
+	if l.interval > 0 {
+		hbCtx, cancel := context.WithCancel(context.Background())
+		l.stop = cancel
+		l.done = make(chan struct{})
+		go l.heartbeat(hbCtx, l.done)
+	}
 	return nil
 }
 
 func (l *S3Locker) Release(ctx context.Context) error {
 	l.mu.Lock()
-	defer l.mu.Unlock()
 	if !l.held {
+		l.mu.Unlock()
 		return nil
 	}
-	if err := l.client.DeleteObject(ctx, l.key); err != nil {
-		return fmt.Errorf("s3 lock release: %w", err)
+	stop, done := l.stop, l.done
+	l.stop, l.done = nil, nil
+	l.mu.Unlock()
+	if stop != nil {
+		stop()
+		<-done
 	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
 	l.held = false
+	if l.lost != nil {
+		return wrapError(l.key, fmt.Errorf("s3 lock release: %w", l.lost))
+	}
+	if err := l.deleteOwn(ctx); err != nil {
+		return wrapError(l.key, fmt.Errorf("s3 lock release: %w", err))
+	}
+	return nil
+}
+
+// create writes body to the lock key unless the lock already exists.
+func (l *S3Locker) create(ctx context.Context, body []byte) error {
+	if l.conditional {
+		etag, err := l.client.PutObjectIfAbsent(ctx, l.key, body)
+		switch {
+		case err == nil:
+			l.etag = etag
+			return nil
+		case errors.Is(err, s3.ErrPreconditionFailed):
+			return errLockExists
+		case !errors.Is(err, s3.ErrConditionalUnsupported):
+			return fmt.Errorf("s3 lock put: %w", err)
+		}
+		l.conditional = false
+	}
+
+	existing, _, err := l.client.GetObjectWithInfo(ctx, l.key)
+	if err != nil {
+		return fmt.Errorf("s3 lock get: %w", err)
+	}
+	if existing != nil {
+		return errLockExists
+	}
+	if err := l.client.PutObject(ctx, l.key, bytes.NewReader(body), int64(len(body))); err != nil {
+		return fmt.Errorf("s3 lock put: %w", err)
+	}
+	select {
+	case <-time.After(fallbackSettle):
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	// Without conditional writes the last of two racing PUTs wins; whoever reads back
+	// someone else's owner has lost.
+	data, info, err := l.client.GetObjectWithInfo(ctx, l.key)
+	if err != nil {
+		return fmt.Errorf("s3 lock get: %w", err)
+	}
+	if data == nil || !bytes.Equal(data, body) {
+		return fmt.Errorf("s3 lock already held: %s (%s)", l.key, describeOwner(data))
+	}
+	l.etag = info.ETag
+	return nil
+}
+
+// removeStale deletes the existing lock if it has outlived the TTL, and otherwise reports
+// who holds it.
+func (l *S3Locker) removeStale(ctx context.Context) error {
+	data, info, err := l.client.GetObjectWithInfo(ctx, l.key)
+	if err != nil {
+		return fmt.Errorf("s3 lock get: %w", err)
+	}
+	if data == nil {
+		return nil // released in the meantime
+	}
+	if l.ttl <= 0 {
+		return fmt.Errorf("s3 lock already held: %s (%s; another process may be running)", l.key, describeOwner(data))
+	}
+	if !l.stale(info.LastModified) {
+		return fmt.Errorf("s3 lock already held: %s (%s)", l.key, describeOwner(data))
+	}
+	if l.conditional {
+		err := l.client.DeleteObjectIfMatch(ctx, l.key, info.ETag)
+		if errors.Is(err, s3.ErrPreconditionFailed) {
+			return fmt.Errorf("s3 lock already held: %s (taken over by another process)", l.key)
+		}
+		if !errors.Is(err, s3.ErrConditionalUnsupported) {
+			if err != nil {
+				return fmt.Errorf("s3 lock stale but delete failed: %w", err)
+			}
+			return nil
+		}
+	}
+	if err := l.client.DeleteObject(ctx, l.key); err != nil {
+		return fmt.Errorf("s3 lock stale but delete failed: %w", err)
+	}
+	return nil
+}
+
+// deleteOwn deletes the lock object if it still holds our owner.
+func (l *S3Locker) deleteOwn(ctx context.Context) error {
+	if l.conditional {
+		err := l.client.DeleteObjectIfMatch(ctx, l.key, l.etag)
+		if errors.Is(err, s3.ErrPreconditionFailed) {
+			return fmt.Errorf("%s is no longer held by this process", l.key)
+		}
+		if !errors.Is(err, s3.ErrConditionalUnsupported) {
+			return err
+		}
+	}
+	if err := l.checkOwned(ctx); err != nil {
+		return err
+	}
+	return l.client.DeleteObject(ctx, l.key)
+}
+
+// checkOwned fails unless the lock object holds our run ID.
+func (l *S3Locker) checkOwned(ctx context.Context) error {
+	data, _, err := l.client.GetObjectWithInfo(ctx, l.key)
+	if err != nil {
+		return err
+	}
+	if data == nil {
+		return fmt.Errorf("%s no longer exists", l.key)
+	}
+	if o, ok := parseOwner(data); !ok || o.RunID != l.runID {
+		return fmt.Errorf("%s is now held by %s", l.key, describeOwner(data))
+	}
+	return nil
+}
+
+// Lost returns a channel that is closed when the lock acquired last is lost (see ErrLost),
+// or nil before the first Acquire. Work done under the lock must stop then: another process
+// may already hold it.
+func (l *S3Locker) Lost() <-chan struct{} {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.lostCh
+}
+
+// Context returns a copy of ctx that is cancelled when the lock is lost, with the *Error
+// describing the loss as its cause (see context.Cause).
+func (l *S3Locker) Context(ctx context.Context) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancelCause(ctx)
+	lost := l.Lost()
+	go func() {
+		select {
+		case <-lost:
+			l.mu.Lock()
+			err := l.lost
+			l.mu.Unlock()
+			cancel(wrapError(l.key, err))
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, func() { cancel(nil) }
+}
+
+// heartbeat rewrites the lock every interval until ctx is cancelled or the lock is lost.
+// Refreshes failing for longer than the TTL minus one interval lose the lock too, since
+// others may treat it as stale by the next tick.
+func (l *S3Locker) heartbeat(ctx context.Context, done chan struct{}) {
+	defer close(done)
+	ticker := time.NewTicker(l.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		l.mu.Lock()
+		err := l.refresh(ctx)
+		switch {
+		case err == nil:
+			l.refreshed = time.Now()
+		case errors.Is(err, ErrLost):
+			l.lost = err
+		case ctx.Err() == nil && l.ttl > 0 && time.Since(l.refreshed) >= l.ttl-l.interval:
+			l.lost = fmt.Errorf("%w: %s not refreshed since %s: %v", ErrLost, l.key, l.refreshed.UTC().Format(time.RFC3339), err)
+		}
+		lost := l.lost != nil
+		if lost {
+			close(l.lostCh)
+		}
+		l.mu.Unlock()
+		if lost {
+			return
+		}
+	}
+}
+
+// refresh rewrites the lock with a new heartbeat time, which also renews its LastModified.
+// Transient errors are returned as is and retried on the next tick.
+func (l *S3Locker) refresh(ctx context.Context) error {
+	owner := l.owner
+	owner.Heartbeat = time.Now().UTC()
+	body, err := json.Marshal(owner)
+	if err != nil {
+		return err
+	}
+	if l.conditional {
+		etag, err := l.client.PutObjectIfMatch(ctx, l.key, body, l.etag)
+		if errors.Is(err, s3.ErrPreconditionFailed) {
+			return fmt.Errorf("%w: %s was changed by another process", ErrLost, l.key)
+		}
+		if !errors.Is(err, s3.ErrConditionalUnsupported) {
+			if err == nil {
+				l.etag = etag
+			}
+			return err
+		}
+		l.conditional = false
+	}
+	if err := l.checkOwned(ctx); err != nil {
+		return fmt.Errorf("%w: %v", ErrLost, err)
+	}
+	return l.client.PutObject(ctx, l.key, bytes.NewReader(body), int64(len(body)))
+}
+
+// checkConflict fails if a live lock excludes this one: any job lock for the repository
+// lock, the repository lock for a job lock.
+func (l *S3Locker) checkConflict(ctx context.Context) error {
+	if !l.exclusive {
+		lastMod, err := l.client.HeadObject(ctx, s3.RepositoryLockKey())
+		if err != nil {
+			return fmt.Errorf("s3 lock head: %w", err)
+		}
+		if lastMod != nil && !l.stale(*lastMod) {
+			return fmt.Errorf("s3 lock: repository is locked by garbage collection (%s)", s3.RepositoryLockKey())
+		}
+		return nil
+	}
+	locks, err := l.client.ListObjectInfo(ctx, lockKeyPrefix)
+	if err != nil {
+		return fmt.Errorf("s3 lock list: %w", err)
+	}
+	for _, o := range locks {
+		if o.Key == l.key || !strings.HasSuffix(o.Key, ".lock") || l.stale(o.LastModified) {
+			continue
+		}
+		return fmt.Errorf("s3 lock: %s is held (a backup may be running)", o.Key)
+	}
 	return nil
 }
+
+// stale reports whether a lock last written at mod has outlived the TTL.
+func (l *S3Locker) stale(mod time.Time) bool {
+	return l.ttl > 0 && time.Since(mod) >= l.ttl
+}
+
+// describeOwner formats the owner stored in a lock body for error messages.
+func describeOwner(data []byte) string {
+	if o, ok := parseOwner(data); ok {
+		return "held by " + o.String()
+	}
+	return "owner unknown"
+}
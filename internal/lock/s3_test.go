@@ -0,0 +1,365 @@
+package lock
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"VelBackuper/internal/s3"
+)
+
+// fakeS3Server is an in-memory, path-style S3 endpoint with just enough of the API for
+// locks: GET, HEAD, PUT and DELETE with If-None-Match / If-Match, and ListObjectsV2.
+type fakeS3Server struct {
+	mu      sync.Mutex
+	objects map[string]fakeObject
+	// noConditional makes conditional requests fail with 501, like backends that lack them.
+	noConditional bool
+	// denyWrites makes every PUT fail with 403.
+	denyWrites bool
+}
+
+type fakeObject struct {
+	data []byte
+	etag string
+	mod  time.Time
+}
+
+func (f *fakeS3Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	// Path is /<bucket>/<key>.
+	key := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/"), "/", 2)
+	if len(key) == 1 || key[1] == "" {
+		f.list(w, r.URL.Query().Get("prefix"))
+		return
+	}
+	k := key[1]
+	obj, exists := f.objects[k]
+	ifMatch, ifNoneMatch := r.Header.Get("If-Match"), r.Header.Get("If-None-Match")
+	if (ifMatch != "" || (ifNoneMatch != "" && r.Method == http.MethodPut)) && f.noConditional {
+		s3Error(w, http.StatusNotImplemented, "NotImplemented")
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		if !exists {
+			s3Error(w, http.StatusNotFound, "NoSuchKey")
+			return
+		}
+		w.Header().Set("ETag", obj.etag)
+		w.Header().Set("Last-Modified", obj.mod.UTC().Format(http.TimeFormat))
+		w.Header().Set("Content-Length", fmt.Sprint(len(obj.data)))
+		if r.Method == http.MethodGet {
+			_, _ = w.Write(obj.data)
+		}
+	case http.MethodPut:
+		if f.denyWrites {
+			s3Error(w, http.StatusForbidden, "AccessDenied")
+			return
+		}
+		if (ifNoneMatch == "*" && exists) || (ifMatch != "" && (!exists || ifMatch != obj.etag)) {
+			s3Error(w, http.StatusPreconditionFailed, "PreconditionFailed")
+			return
+		}
+		data, _ := io.ReadAll(r.Body)
+		sum := md5.Sum(data)
+		obj = fakeObject{data: data, etag: `"` + hex.EncodeToString(sum[:]) + `"`, mod: time.Now()}
+		f.objects[k] = obj
+		w.Header().Set("ETag", obj.etag)
+	case http.MethodDelete:
+		if ifMatch != "" && (!exists || ifMatch != obj.etag) {
+			s3Error(w, http.StatusPreconditionFailed, "PreconditionFailed")
+			return
+		}
+		delete(f.objects, k)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func (f *fakeS3Server) list(w http.ResponseWriter, prefix string) {
+	var keys []string
+	for k := range f.objects {
+		if strings.HasPrefix(k, prefix) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	var b strings.Builder
+	b.WriteString(`<ListBucketResult xmlns="http://s3.amazonaws.com/doc/2006-03-01/"><IsTruncated>false</IsTruncated>`)
+	fmt.Fprintf(&b, "<KeyCount>%d</KeyCount>", len(keys))
+	for _, k := range keys {
+		o := f.objects[k]
+		fmt.Fprintf(&b, "<Contents><Key>%s</Key><LastModified>%s</LastModified><ETag>%s</ETag><Size>%d</Size></Contents>",
+			k, o.mod.UTC().Format("2006-01-02T15:04:05.000Z"), o.etag, len(o.data))
+	}
+	b.WriteString(`</ListBucketResult>`)
+	w.Header().Set("Content-Type", "application/xml")
+	_, _ = io.WriteString(w, b.String())
+}
+
+func s3Error(w http.ResponseWriter, status int, code string) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	fmt.Fprintf(w, "<Error><Code>%s</Code><Message>%s</Message></Error>", code, code)
+}
+
+func newFakeClient(t *testing.T, f *fakeS3Server, conditional bool) *s3.Client {
+	t.Helper()
+	f.objects = make(map[string]fakeObject)
+	srv := httptest.NewServer(f)
+	t.Cleanup(srv.Close)
+	client, err := s3.New(context.Background(), s3.Options{
+		Endpoint:                 srv.URL,
+		AccessKey:                "test",
+		SecretKey:                "test",
+		Bucket:                   "bucket",
+		PathStyle:                true,
+		DisableRequestChecksums:  true,
+		DisableConditionalWrites: !conditional,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client
+}
+
+func TestS3Locker_SecondAcquireFailsWithOwner(t *testing.T) {
+	ctx := context.Background()
+	f := &fakeS3Server{}
+	client := newFakeClient(t, f, true)
+
+	a, _ := NewS3(S3Options{Client: client, Name: "job1", TTL: time.Hour, RunID: "run-a"})
+	b, _ := NewS3(S3Options{Client: client, Name: "job1", TTL: time.Hour, RunID: "run-b"})
+	if err := a.Acquire(ctx); err != nil {
+		t.Fatal(err)
+	}
+	err := b.Acquire(ctx)
+	if err == nil || !strings.Contains(err.Error(), "run run-a") {
+		t.Fatalf("second Acquire = %v, want an error naming the owner", err)
+	}
+	var le *Error
+	if !errors.As(err, &le) || le.Lock != "locks/job1.lock" {
+		t.Errorf("second Acquire = %#v, want a *lock.Error for locks/job1.lock", err)
+	}
+
+	var owner Owner
+	if err := json.Unmarshal(f.objects["locks/job1.lock"].data, &owner); err != nil {
+		t.Fatal(err)
+	}
+	if owner.RunID != "run-a" || owner.PID == 0 || owner.Host == "" {
+		t.Errorf("owner = %+v", owner)
+	}
+
+	if err := a.Release(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Acquire(ctx); err != nil {
+		t.Fatalf("Acquire after release: %v", err)
+	}
+	_ = b.Release(ctx)
+}
+
+func TestS3Locker_ReleaseKeepsLockTakenOver(t *testing.T) {
+	ctx := context.Background()
+	f := &fakeS3Server{}
+	client := newFakeClient(t, f, true)
+
+	a, _ := NewS3(S3Options{Client: client, Name: "job1", TTL: time.Hour, RunID: "run-a"})
+	if err := a.Acquire(ctx); err != nil {
+		t.Fatal(err)
+	}
+	// Someone else breaks the lock and takes it.
+	f.mu.Lock()
+	delete(f.objects, "locks/job1.lock")
+	f.mu.Unlock()
+	b, _ := NewS3(S3Options{Client: client, Name: "job1", TTL: time.Hour, RunID: "run-b"})
+	if err := b.Acquire(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := a.Release(ctx); err == nil {
+		t.Error("Release of a lock taken over by another process succeeded")
+	}
+	if _, ok := f.objects["locks/job1.lock"]; !ok {
+		t.Fatal("Release deleted another process's lock")
+	}
+	if err := b.Release(ctx); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestS3Locker_StaleLockIsTakenOver(t *testing.T) {
+	ctx := context.Background()
+	f := &fakeS3Server{}
+	client := newFakeClient(t, f, true)
+
+	a, _ := NewS3(S3Options{Client: client, Name: "job1", TTL: time.Hour, Heartbeat: time.Hour, RunID: "run-a"})
+	if err := a.Acquire(ctx); err != nil {
+		t.Fatal(err)
+	}
+	f.mu.Lock()
+	o := f.objects["locks/job1.lock"]
+	o.mod = time.Now().Add(-2 * time.Hour)
+	f.objects["locks/job1.lock"] = o
+	f.mu.Unlock()
+
+	b, _ := NewS3(S3Options{Client: client, Name: "job1", TTL: time.Hour, RunID: "run-b"})
+	if err := b.Acquire(ctx); err != nil {
+		t.Fatalf("Acquire over stale lock: %v", err)
+	}
+	_ = b.Release(ctx)
+}
+
+func TestS3Locker_HeartbeatRefreshesLock(t *testing.T) {
+	ctx := context.Background()
+	f := &fakeS3Server{}
+	client := newFakeClient(t, f, true)
+
+	l, _ := NewS3(S3Options{Client: client, Name: "job1", TTL: time.Hour, Heartbeat: 20 * time.Millisecond})
+	if err := l.Acquire(ctx); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		f.mu.Lock()
+		data := f.objects["locks/job1.lock"].data
+		f.mu.Unlock()
+		var owner Owner
+		if json.Unmarshal(data, &owner) == nil && !owner.Heartbeat.IsZero() {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("lock was not refreshed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	// Release must still match the ETag of the latest heartbeat.
+	if err := l.Release(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := f.objects["locks/job1.lock"]; ok {
+		t.Error("lock not deleted on release")
+	}
+}
+
+func TestS3Locker_FallbackWithoutConditionalWrites(t *testing.T) {
+	if testing.Short() {
+		t.Skip("waits for the fallback settle delay")
+	}
+	ctx := context.Background()
+	f := &fakeS3Server{noConditional: true}
+	client := newFakeClient(t, f, true) // the backend rejects them although the config allows them
+
+	a, _ := NewS3(S3Options{Client: client, Name: "job1", TTL: time.Hour, RunID: "run-a"})
+	b, _ := NewS3(S3Options{Client: client, Name: "job1", TTL: time.Hour, RunID: "run-b"})
+	if err := a.Acquire(ctx); err != nil {
+		t.Fatalf("Acquire: %v", err)
+	}
+	if err := b.Acquire(ctx); err == nil {
+		t.Fatal("second Acquire succeeded")
+	}
+	if err := a.Release(ctx); err != nil {
+		t.Fatalf("Release: %v", err)
+	}
+	if _, ok := f.objects["locks/job1.lock"]; ok {
+		t.Error("lock not deleted on release")
+	}
+}
+
+func TestS3Locker_RepositoryLockExcludesJobLocks(t *testing.T) {
+	ctx := context.Background()
+	f := &fakeS3Server{}
+	client := newFakeClient(t, f, true)
+
+	job, _ := NewS3(S3Options{Client: client, Name: "job1", TTL: time.Hour})
+	repo, _ := NewS3(S3Options{Client: client, TTL: time.Hour, Exclusive: true})
+	if err := job.Acquire(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.Acquire(ctx); err == nil {
+		t.Fatal("repository lock granted while a job lock is held")
+	}
+	if _, ok := f.objects[s3.RepositoryLockKey()]; ok {
+		t.Error("refused repository lock was left behind")
+	}
+	_ = job.Release(ctx)
+	if err := repo.Acquire(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := job.Acquire(ctx); err == nil {
+		t.Fatal("job lock granted while the repository lock is held")
+	}
+	_ = repo.Release(ctx)
+}
+
+func TestS3Locker_ContextCancelledWhenLockIsTakenOver(t *testing.T) {
+	ctx := context.Background()
+	f := &fakeS3Server{}
+	client := newFakeClient(t, f, true)
+
+	l, _ := NewS3(S3Options{Client: client, Name: "job1", TTL: time.Hour, Heartbeat: 20 * time.Millisecond, RunID: "run-a"})
+	if err := l.Acquire(ctx); err != nil {
+		t.Fatal(err)
+	}
+	lockedCtx, cancel := l.Context(ctx)
+	defer cancel()
+
+	// lock break (which can race a heartbeat here, so the object is removed directly),
+	// followed by another run taking the lock.
+	f.mu.Lock()
+	delete(f.objects, "locks/job1.lock")
+	f.mu.Unlock()
+	other, _ := NewS3(S3Options{Client: client, Name: "job1", TTL: time.Hour, Heartbeat: time.Hour, RunID: "run-b"})
+	if err := other.Acquire(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case <-lockedCtx.Done():
+	case <-time.After(2 * time.Second):
+		t.Fatal("context not cancelled after the lock was taken over")
+	}
+	if cause := context.Cause(lockedCtx); !errors.Is(cause, ErrLost) {
+		t.Errorf("cause = %v, want ErrLost", cause)
+	}
+	if err := l.Release(ctx); err == nil {
+		t.Error("Release of a lost lock succeeded")
+	}
+	if _, ok := f.objects["locks/job1.lock"]; !ok {
+		t.Error("Release deleted the lock of the other run")
+	}
+}
+
+func TestS3Locker_LostWhenRefreshKeepsFailing(t *testing.T) {
+	ctx := context.Background()
+	f := &fakeS3Server{}
+	client := newFakeClient(t, f, true)
+
+	l, _ := NewS3(S3Options{Client: client, Name: "job1", TTL: 200 * time.Millisecond, Heartbeat: 20 * time.Millisecond})
+	if err := l.Acquire(ctx); err != nil {
+		t.Fatal(err)
+	}
+	f.mu.Lock()
+	f.denyWrites = true
+	f.mu.Unlock()
+
+	select {
+	case <-l.Lost():
+	case <-time.After(2 * time.Second):
+		t.Fatal("lock not reported lost while refreshes failed past the TTL")
+	}
+	_ = l.Release(ctx)
+}
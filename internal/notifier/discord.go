@@ -152,7 +152,7 @@ func (d *DiscordNotifier) NotifyStart(ctx context.Context, jobName, backupID str
 	return d.send(ctx, embed, "")
 }
 
-func (d *DiscordNotifier) NotifySuccess(ctx context.Context, jobName, backupID string, duration time.Duration, size int64) error {
+func (d *DiscordNotifier) NotifySuccess(ctx context.Context, jobName, backupID string, duration time.Duration, stats BackupStats) error {
 	if !d.allowed("success") {
 		return nil
 	}
@@ -165,9 +165,20 @@ func (d *DiscordNotifier) NotifySuccess(ctx context.Context, jobName, backupID s
 			{Name: "Job", Value: jobName, Inline: true},
 			{Name: "Backup ID", Value: backupID, Inline: true},
 			{Name: "Duration", Value: duration.String(), Inline: true},
-			{Name: "Size", Value: fmt.Sprintf("%d bytes", size), Inline: true},
 		},
 	}
+	if stats.Size > 0 {
+		embed.Fields = append(embed.Fields, discordField{Name: "Size", Value: FormatBytes(stats.Size), Inline: true})
+	}
+	if stats.UncompressedSize > 0 {
+		embed.Fields = append(embed.Fields, discordField{Name: "Uncompressed", Value: FormatBytes(stats.UncompressedSize), Inline: true})
+	}
+	if stats.FileCount > 0 {
+		embed.Fields = append(embed.Fields, discordField{Name: "Files", Value: fmt.Sprintf("%d", stats.FileCount), Inline: true})
+	}
+	if stats.SHA256 != "" {
+		embed.Fields = append(embed.Fields, discordField{Name: "SHA-256", Value: "`" + stats.SHA256 + "`"})
+	}
 	return d.send(ctx, embed, "")
 }
 
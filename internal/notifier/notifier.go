@@ -7,9 +7,17 @@ import (
 
 type Notifier interface {
 	NotifyStart(ctx context.Context, jobName, backupID string) error
-	NotifySuccess(ctx context.Context, jobName, backupID string, duration time.Duration, size int64) error
+	NotifySuccess(ctx context.Context, jobName, backupID string, duration time.Duration, stats BackupStats) error
 	NotifyWarning(ctx context.Context, jobName, backupID, message string) error
 	NotifyError(ctx context.Context, jobName, backupID string, err error) error
 	NotifyPrune(ctx context.Context, jobName string, retained, deleted int) error
 	NotifyRestore(ctx context.Context, jobName, pointID, targetDir string) error
 }
+
+// BackupStats summarises a finished backup. Zero fields are unknown and not reported.
+type BackupStats struct {
+	Size             int64 // bytes stored
+	UncompressedSize int64
+	FileCount        int
+	SHA256           string
+}
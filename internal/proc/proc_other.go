@@ -0,0 +1,10 @@
+//go:build !unix
+
+// Package proc holds process handling shared by hooks and command sources.
+package proc
+
+import "os/exec"
+
+// KillGroupOnCancel is a no-op here: only the process itself is killed when its context
+// ends, and cmd.WaitDelay bounds the wait for any children it left running.
+func KillGroupOnCancel(cmd *exec.Cmd) {}
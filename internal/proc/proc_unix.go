@@ -0,0 +1,22 @@
+//go:build unix
+
+// Package proc holds process handling shared by hooks and command sources.
+package proc
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// KillGroupOnCancel makes cmd run in its own process group and kill the whole group when its
+// context ends, so a timeout also stops the commands a shell started. Other SysProcAttr
+// settings, such as a Credential, are kept.
+func KillGroupOnCancel(cmd *exec.Cmd) {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Setpgid = true
+	cmd.Cancel = func() error {
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+}
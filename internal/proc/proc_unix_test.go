@@ -0,0 +1,37 @@
+//go:build unix
+
+package proc
+
+import (
+	"context"
+	"os/exec"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestKillGroupOnCancel(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	// The shell waits for a background sleep that holds stdout open; only killing the group
+	// ends both before the sleep is over.
+	cmd := exec.CommandContext(ctx, "/bin/sh", "-c", "sleep 10 & wait")
+	cmd.SysProcAttr = &syscall.SysProcAttr{Noctty: true}
+	cmd.Stdout = new(discard)
+	KillGroupOnCancel(cmd)
+	if !cmd.SysProcAttr.Noctty || !cmd.SysProcAttr.Setpgid {
+		t.Errorf("SysProcAttr = %+v, want Setpgid added to the existing settings", cmd.SysProcAttr)
+	}
+
+	start := time.Now()
+	if err := cmd.Run(); err == nil {
+		t.Fatal("Run succeeded, want it killed")
+	}
+	if d := time.Since(start); d > 5*time.Second {
+		t.Errorf("Run took %s; the background sleep survived the kill", d)
+	}
+}
+
+type discard struct{}
+
+func (discard) Write(p []byte) (int, error) { return len(p), nil }
@@ -2,38 +2,64 @@ package restore
 
 import (
 	"archive/tar"
-	"compress/gzip"
 	"context"
 	"fmt"
 	"io"
-	"os"
 	"path"
-	"path/filepath"
 	"strings"
 
+	"VelBackuper/internal/crypt"
+	"VelBackuper/internal/engine/archive"
 	"VelBackuper/internal/s3"
-
-	"github.com/klauspost/compress/zstd"
 )
 
 type ArchiveRestoreOptions struct {
 	MysqlOnly bool
 	DryRun    bool
+	// NumericOwner gives restored files the stored uid and gid instead of looking up the stored
+	// user and group names. NoOwner keeps the restoring user as owner. Owners are only changed
+	// when running as root.
+	NumericOwner bool
+	NoOwner      bool
+	// Keyring decrypts archives uploaded with encryption enabled (key ending in ".age").
+	Keyring *crypt.Keyring
+}
+
+// RestoreArchive extracts the archive described by m into targetDir. The decoder is chosen
+// from m.Format; manifests that predate it fall back to the key's suffix.
+func RestoreArchive(ctx context.Context, client *s3.Client, m *archive.Manifest, targetDir string, opts ArchiveRestoreOptions) error {
+	if err := restoreArchive(ctx, client, m, targetDir, opts); err != nil {
+		return &Error{Job: m.Job, Point: m.Timestamp, Err: err}
+	}
+	return nil
 }
 
-func RestoreArchive(ctx context.Context, client *s3.Client, key, targetDir string, opts ArchiveRestoreOptions) error {
+func restoreArchive(ctx context.Context, client *s3.Client, m *archive.Manifest, targetDir string, opts ArchiveRestoreOptions) error {
+	key := m.Key
+	format, err := archiveFormat(m)
+	if err != nil {
+		return err
+	}
 	rc, err := client.GetObject(ctx, key)
 	if err != nil {
 		return fmt.Errorf("get archive %s: %w", key, err)
 	}
 	defer rc.Close()
 
-	r, err := decompressStream(rc, key)
+	var r io.Reader = rc
+	if m.Encrypted || strings.HasSuffix(key, crypt.ArchiveSuffix) {
+		if r, err = opts.Keyring.DecryptReader(rc); err != nil {
+			return fmt.Errorf("decrypt %s: %w", key, err)
+		}
+	}
+	dr, err := archive.NewDecompressReader(r, format)
 	if err != nil {
 		return fmt.Errorf("decompress %s: %w", key, err)
 	}
-	tr := tar.NewReader(r)
+	defer dr.Close()
+	tr := tar.NewReader(dr)
 
+	x := newExtractor(targetDir, opts)
 	for {
 		hdr, err := tr.Next()
 		if err == io.EOF {
@@ -42,79 +68,25 @@ func RestoreArchive(ctx context.Context, client *s3.Client, key, targetDir strin
 		if err != nil {
 			return fmt.Errorf("read tar %s: %w", key, err)
 		}
-		if err := restoreTarEntry(tr, hdr, targetDir, opts); err != nil {
+		if err := x.restoreTarEntry(tr, hdr); err != nil {
 			return err
 		}
 	}
-	return nil
+	return x.finish()
 }
 
-func decompressStream(r io.Reader, key string) (io.Reader, error) {
-	lower := strings.ToLower(key)
-	switch {
-	case strings.HasSuffix(lower, ".tar.gz"), strings.HasSuffix(lower, ".tgz"), strings.HasSuffix(lower, ".gz"):
-		gr, err := gzip.NewReader(r)
-		if err != nil {
-			return nil, err
-		}
-		return gr, nil
-	case strings.HasSuffix(lower, ".tar.zst"), strings.HasSuffix(lower, ".zst"):
-		zr, err := zstd.NewReader(r)
-		if err != nil {
-			return nil, err
-		}
-		return zr, nil
-	default:
-		return r, nil
+func archiveFormat(m *archive.Manifest) (archive.CompressionFormat, error) {
+	if m.Format != "" {
+		return archive.FormatFromManifest(m.Format)
 	}
-}
-
-func restoreTarEntry(tr *tar.Reader, hdr *tar.Header, targetDir string, opts ArchiveRestoreOptions) error {
-	name := cleanTarName(hdr.Name)
-	if name == "" {
-		return nil
-	}
-	if opts.MysqlOnly && !strings.HasPrefix(name, "mysql/") {
-		return nil
-	}
-
-	dstPath := filepath.Join(targetDir, filepath.FromSlash(name))
-
-	switch hdr.Typeflag {
-	case tar.TypeDir:
-		if opts.DryRun {
-			return nil
-		}
-		return os.MkdirAll(dstPath, os.FileMode(hdr.Mode))
-	case tar.TypeReg, tar.TypeRegA:
-		if opts.DryRun {
-			_, _ = io.Copy(io.Discard, tr)
-			return nil
-		}
-		if err := os.MkdirAll(filepath.Dir(dstPath), 0o755); err != nil {
-			return err
-		}
-		f, err := os.OpenFile(dstPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, os.FileMode(hdr.Mode))
-		if err != nil {
-			return err
-		}
-		if _, err := io.Copy(f, tr); err != nil {
-			_ = f.Close()
-			return err
-		}
-		return f.Close()
-	case tar.TypeSymlink:
-		if opts.DryRun {
-			return nil
-		}
-		if err := os.MkdirAll(filepath.Dir(dstPath), 0o755); err != nil {
-			return err
-		}
-
-		_ = os.Remove(dstPath)
-		return os.Symlink(hdr.Linkname, dstPath)
+	lower := strings.TrimSuffix(strings.ToLower(m.Key), crypt.ArchiveSuffix)
+	switch {
+	case strings.HasSuffix(lower, ".gz"), strings.HasSuffix(lower, ".tgz"):
+		return archive.FormatGzip, nil
+	case strings.HasSuffix(lower, ".zst"):
+		return archive.FormatZstd, nil
 	default:
-		return nil
+		return archive.FormatTar, nil
 	}
 }
 
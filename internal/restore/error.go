@@ -0,0 +1,19 @@
+package restore
+
+import "fmt"
+
+// Error is returned when restoring a backup point fails, whatever the cause: a missing or
+// unreadable object, a corrupt archive or chunk, or a target that cannot be written.
+type Error struct {
+	Job   string
+	Point string
+	Err   error
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("backup %s of job %q: %v", e.Point, e.Job, e.Err)
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
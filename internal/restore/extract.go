@@ -0,0 +1,256 @@
+package restore
+
+import (
+	"archive/tar"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/user"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// paxXattrPrefix starts the PAX record of an extended attribute.
+const paxXattrPrefix = "SCHILY.xattr."
+
+// metadata is what is applied to a restored entry besides its content.
+type metadata struct {
+	uid, gid     int
+	uname, gname string
+	mode         os.FileMode // permission bits plus setuid, setgid and sticky
+	modTime      time.Time
+	xattrs       map[string][]byte
+	symlink      bool
+}
+
+func headerMetadata(hdr *tar.Header) metadata {
+	m := metadata{
+		uid:     hdr.Uid,
+		gid:     hdr.Gid,
+		uname:   hdr.Uname,
+		gname:   hdr.Gname,
+		mode:    hdr.FileInfo().Mode() & (fs.ModePerm | fs.ModeSetuid | fs.ModeSetgid | fs.ModeSticky),
+		modTime: hdr.ModTime,
+		symlink: hdr.Typeflag == tar.TypeSymlink,
+	}
+	for k, v := range hdr.PAXRecords {
+		if name, ok := strings.CutPrefix(k, paxXattrPrefix); ok {
+			if m.xattrs == nil {
+				m.xattrs = make(map[string][]byte)
+			}
+			m.xattrs[name] = []byte(v)
+		}
+	}
+	return m
+}
+
+// extractor writes entries under targetDir and applies their owner (when running as root),
+// mode, extended attributes and mtime. Directories get theirs in finish, after their contents
+// were written, so that a read-only directory can be filled and keeps its mtime.
+type extractor struct {
+	targetDir string
+	opts      ArchiveRestoreOptions
+	dirs      []pendingDir
+	ids       map[string]int // resolved "u:name" and "g:name"; -1 = unknown here
+}
+
+type pendingDir struct {
+	path string
+	meta metadata
+}
+
+func newExtractor(targetDir string, opts ArchiveRestoreOptions) *extractor {
+	return &extractor{targetDir: targetDir, opts: opts, ids: make(map[string]int)}
+}
+
+func (x *extractor) restoreTarEntry(tr *tar.Reader, hdr *tar.Header) error {
+	name := cleanTarName(hdr.Name)
+	if name == "" {
+		return nil
+	}
+	if x.opts.MysqlOnly && !strings.HasPrefix(name, "mysql/") {
+		return nil
+	}
+	if x.opts.DryRun {
+		if hdr.Typeflag == tar.TypeReg || hdr.Typeflag == tar.TypeRegA {
+			_, _ = io.Copy(io.Discard, tr)
+		}
+		return nil
+	}
+
+	dstPath := filepath.Join(x.targetDir, filepath.FromSlash(name))
+	meta := headerMetadata(hdr)
+
+	switch hdr.Typeflag {
+	case tar.TypeDir:
+		// Writable until finish sets the stored mode.
+		if err := os.MkdirAll(dstPath, meta.mode.Perm()|0o700); err != nil {
+			return err
+		}
+		x.dirs = append(x.dirs, pendingDir{path: dstPath, meta: meta})
+		return nil
+	case tar.TypeReg, tar.TypeRegA:
+		if err := x.writeFile(dstPath, tr); err != nil {
+			return err
+		}
+	case tar.TypeLink:
+		if err := x.link(dstPath, hdr.Linkname); err != nil {
+			return err
+		}
+		return nil // the target's metadata is already applied to the shared inode
+	case tar.TypeSymlink:
+		if err := x.prepare(dstPath); err != nil {
+			return err
+		}
+		if err := os.Symlink(hdr.Linkname, dstPath); err != nil {
+			return err
+		}
+	case tar.TypeFifo, tar.TypeChar, tar.TypeBlock:
+		if err := x.prepare(dstPath); err != nil {
+			return err
+		}
+		if err := mknod(dstPath, hdr.Typeflag, hdr.Devmajor, hdr.Devminor); err != nil {
+			return fmt.Errorf("create %s: %w", name, err)
+		}
+	default:
+		return nil
+	}
+	return x.apply(dstPath, meta)
+}
+
+// prepare creates the parent directories of path and removes what is in the way.
+func (x *extractor) prepare(path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+func (x *extractor) writeFile(path string, r io.Reader) error {
+	if err := x.prepare(path); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_EXCL, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// link makes path a hard link to target, an archive name restored earlier.
+func (x *extractor) link(path, target string) error {
+	name := cleanTarName(target)
+	if name == "" {
+		return fmt.Errorf("hard link %s: invalid target %q", path, target)
+	}
+	if err := x.prepare(path); err != nil {
+		return err
+	}
+	if err := os.Link(filepath.Join(x.targetDir, filepath.FromSlash(name)), path); err != nil {
+		return fmt.Errorf("hard link to %s: %w", name, err)
+	}
+	return nil
+}
+
+// apply sets the owner, mode, extended attributes and mtime of path, in that order: changing
+// the owner clears setuid bits and file capabilities, and the rest changes the ctime only.
+func (x *extractor) apply(path string, m metadata) error {
+	if !x.opts.NoOwner && os.Geteuid() == 0 {
+		uid, gid := x.owner(m)
+		if err := os.Lchown(path, uid, gid); err != nil {
+			return err
+		}
+	}
+	if !m.symlink {
+		if err := os.Chmod(path, m.mode); err != nil {
+			return err
+		}
+	}
+	names := make([]string, 0, len(m.xattrs))
+	for name := range m.xattrs {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	for _, name := range names {
+		if err := setXattr(path, name, m.xattrs[name]); err != nil {
+			return fmt.Errorf("set xattr %s on %s: %w", name, path, err)
+		}
+	}
+	if m.modTime.IsZero() {
+		return nil
+	}
+	if m.symlink {
+		return lchtimes(path, m.modTime)
+	}
+	return os.Chtimes(path, m.modTime, m.modTime)
+}
+
+// owner returns the uid and gid to give an entry: those of its user and group names on this
+// host, falling back to the stored IDs, or the stored IDs alone with NumericOwner.
+func (x *extractor) owner(m metadata) (int, int) {
+	uid, gid := m.uid, m.gid
+	if x.opts.NumericOwner {
+		return uid, gid
+	}
+	if m.uname != "" {
+		uid = x.lookupID("u:"+m.uname, uid, func() (string, error) {
+			u, err := user.Lookup(m.uname)
+			if err != nil {
+				return "", err
+			}
+			return u.Uid, nil
+		})
+	}
+	if m.gname != "" {
+		gid = x.lookupID("g:"+m.gname, gid, func() (string, error) {
+			g, err := user.LookupGroup(m.gname)
+			if err != nil {
+				return "", err
+			}
+			return g.Gid, nil
+		})
+	}
+	return uid, gid
+}
+
+// lookupID resolves a user or group name once; names unknown here resolve to -1 and fall back.
+func (x *extractor) lookupID(key string, fallback int, lookup func() (string, error)) int {
+	id, ok := x.ids[key]
+	if !ok {
+		id = -1
+		if s, err := lookup(); err == nil {
+			if n, err := strconv.Atoi(s); err == nil {
+				id = n
+			}
+		}
+		x.ids[key] = id
+	}
+	if id < 0 {
+		return fallback
+	}
+	return id
+}
+
+// finish applies the metadata of the restored directories, deepest first.
+func (x *extractor) finish() error {
+	for i := len(x.dirs) - 1; i >= 0; i-- {
+		d := x.dirs[i]
+		if err := x.apply(d.path, d.meta); err != nil {
+			return err
+		}
+	}
+	x.dirs = nil
+	return nil
+}
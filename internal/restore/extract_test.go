@@ -0,0 +1,104 @@
+package restore
+
+import (
+	"archive/tar"
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestExtractor_RestoresMetadataAndLinks(t *testing.T) {
+	mtime := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	entries := []struct {
+		hdr  tar.Header
+		body string
+	}{
+		{tar.Header{Name: "etc/letsencrypt/", Typeflag: tar.TypeDir, Mode: 0o500, ModTime: mtime}, ""},
+		{tar.Header{Name: "etc/letsencrypt/cert.pem", Typeflag: tar.TypeReg, Mode: 0o640, ModTime: mtime}, "PEM"},
+		{tar.Header{Name: "etc/letsencrypt/live.pem", Typeflag: tar.TypeLink, Linkname: "etc/letsencrypt/cert.pem", ModTime: mtime}, ""},
+		{tar.Header{Name: "etc/letsencrypt/current", Typeflag: tar.TypeSymlink, Linkname: "cert.pem", Mode: 0o777, ModTime: mtime}, ""},
+		{tar.Header{Name: "etc/letsencrypt/pipe", Typeflag: tar.TypeFifo, Mode: 0o600, ModTime: mtime}, ""},
+	}
+	for _, e := range entries {
+		hdr := e.hdr
+		hdr.Size = int64(len(e.body))
+		if err := tw.WriteHeader(&hdr); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := io.WriteString(tw, e.body); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	target := t.TempDir()
+	x := newExtractor(target, ArchiveRestoreOptions{NoOwner: true})
+	tr := tar.NewReader(&buf)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if runtime.GOOS != "linux" && hdr.Typeflag == tar.TypeFifo {
+			continue
+		}
+		if err := x.restoreTarEntry(tr, hdr); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := x.finish(); err != nil {
+		t.Fatal(err)
+	}
+	dir := filepath.Join(target, "etc", "letsencrypt")
+	t.Cleanup(func() { _ = os.Chmod(dir, 0o700) })
+
+	info, err := os.Stat(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0o500 || !info.ModTime().Equal(mtime) {
+		t.Errorf("directory mode %v, mtime %v; want 0500 and %v (set after its contents)", info.Mode().Perm(), info.ModTime(), mtime)
+	}
+	cert, err := os.Stat(filepath.Join(dir, "cert.pem"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cert.Mode().Perm() != 0o640 || !cert.ModTime().Equal(mtime) {
+		t.Errorf("cert.pem mode %v, mtime %v", cert.Mode().Perm(), cert.ModTime())
+	}
+	live, err := os.Stat(filepath.Join(dir, "live.pem"))
+	if err != nil || !os.SameFile(cert, live) {
+		t.Errorf("live.pem is not a hard link of cert.pem (err %v)", err)
+	}
+	if link, err := os.Readlink(filepath.Join(dir, "current")); err != nil || link != "cert.pem" {
+		t.Errorf("symlink = %q, %v", link, err)
+	}
+	if runtime.GOOS == "linux" {
+		if fi, err := os.Lstat(filepath.Join(dir, "pipe")); err != nil || fi.Mode()&os.ModeNamedPipe == 0 || fi.Mode().Perm() != 0o600 {
+			t.Errorf("pipe = %v, %v", fi, err)
+		}
+	}
+}
+
+func TestExtractor_Owner(t *testing.T) {
+	x := newExtractor(t.TempDir(), ArchiveRestoreOptions{})
+	m := metadata{uid: 1234, gid: 5678, uname: "root", gname: "no-such-group-velbackuper"}
+	if uid, gid := x.owner(m); uid != 0 || gid != 5678 {
+		t.Errorf("owner = %d:%d, want the uid of root and the stored gid", uid, gid)
+	}
+	x.opts.NumericOwner = true
+	if uid, gid := x.owner(m); uid != 1234 || gid != 5678 {
+		t.Errorf("numeric owner = %d:%d, want 1234:5678", uid, gid)
+	}
+}
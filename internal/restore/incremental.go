@@ -1,114 +1,341 @@
 package restore
 
 import (
+	"archive/tar"
 	"context"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 
+	"VelBackuper/internal/crypt"
 	"VelBackuper/internal/engine/incremental"
 	"VelBackuper/internal/s3"
 )
 
+// objectStore is the subset of S3 client methods needed to read a snapshot and its chunks.
+type objectStore interface {
+	HeadObject(ctx context.Context, key string) (*time.Time, error)
+	GetObject(ctx context.Context, key string) (io.ReadCloser, error)
+}
+
 type IncrementalRestoreOptions struct {
 	DryRun       bool
 	VerifyChunks bool
+	// Paths restricts the restore to these snapshot paths (files or directories).
+	// Only the chunks holding the selected files are downloaded. Empty = everything.
+	Paths []string
+	// Keyring decrypts snapshots, indexes and chunks of encrypted repositories.
+	Keyring *crypt.Keyring
+	// NumericOwner and NoOwner work as in ArchiveRestoreOptions.
+	NumericOwner bool
+	NoOwner      bool
+}
+
+// RestoreIncremental rebuilds the snapshot of job at timestamp under targetDir.
+func RestoreIncremental(ctx context.Context, client objectStore, job, timestamp, targetDir string, opts IncrementalRestoreOptions) error {
+	if err := restoreIncremental(ctx, client, job, timestamp, targetDir, opts); err != nil {
+		return &Error{Job: job, Point: timestamp, Err: err}
+	}
+	return nil
 }
 
-func RestoreIncremental(ctx context.Context, client *s3.Client, job, timestamp, targetDir string, opts IncrementalRestoreOptions) error {
+func restoreIncremental(ctx context.Context, client objectStore, job, timestamp, targetDir string, opts IncrementalRestoreOptions) error {
 	if targetDir == "" {
 		return fmt.Errorf("targetDir is required")
 	}
-
-	snap, err := incremental.ReadSnapshot(ctx, client, job, timestamp)
+	snap, err := incremental.ReadSnapshot(ctx, client, job, timestamp, opts.Keyring)
 	if err != nil {
 		return fmt.Errorf("read snapshot: %w", err)
 	}
 	if snap.IndexKey == "" {
 		return fmt.Errorf("snapshot has no index_key")
 	}
-
-	idx, err := incremental.ReadIndexByKey(ctx, client, snap.IndexKey)
+	idx, err := incremental.ReadIndexByKey(ctx, client, snap.IndexKey, opts.Keyring)
 	if err != nil {
 		return fmt.Errorf("read index: %w", err)
 	}
 
-	chunkData := make(map[string][]byte, len(idx.Chunks))
-	for _, ch := range idx.Chunks {
-		if ch.Hash == "" {
-			continue
-		}
-		if _, ok := chunkData[ch.Hash]; ok {
-			continue
-		}
-		prefix := incremental.ObjectKeyPrefix(ch.Hash, incremental.DefaultHashPrefixLen)
-		key := s3.ObjectKey(prefix, ch.Hash)
-		rc, err := client.GetObject(ctx, key)
-		if err != nil {
-			return fmt.Errorf("get chunk %s: %w", key, err)
-		}
-		data, err := io.ReadAll(rc)
-		rc.Close()
-		if err != nil {
-			return fmt.Errorf("read chunk %s: %w", key, err)
-		}
-		if opts.VerifyChunks {
-			if got := incremental.HashChunkHex(data); got != ch.Hash {
-				return fmt.Errorf("chunk hash mismatch for %s: got %s, want %s", key, got, ch.Hash)
-			}
+	chunks := &chunkFetcher{client: client, keys: opts.Keyring, verify: opts.VerifyChunks}
+	x := newExtractor(targetDir, ArchiveRestoreOptions{DryRun: opts.DryRun, NumericOwner: opts.NumericOwner, NoOwner: opts.NoOwner})
+	if len(snap.Files) == 0 {
+		// Snapshots written before per-file entries existed: the chunks are the
+		// collector's tar stream, so extract it as a whole.
+		if err := restoreChunkStream(ctx, chunks, idx, x, opts.Paths); err != nil {
+			return err
 		}
-		chunkData[ch.Hash] = data
+		return x.finish()
 	}
 
+	var byPath map[string]incremental.FileEntry
 	for _, fe := range snap.Files {
 		rel := cleanRelativePath(fe.Path)
-		if rel == "" {
+		if rel == "" || !selectedPath(rel, opts.Paths) {
 			continue
 		}
-		fullPath := filepath.Join(targetDir, rel)
-
-		if opts.DryRun {
-			if err := os.MkdirAll(filepath.Dir(fullPath), 0o755); err != nil {
-				return err
+		if target := cleanRelativePath(fe.Hardlink); target != "" && !selectedPath(target, opts.Paths) {
+			// The file the link shares is not restored, so restore its content here instead.
+			if byPath == nil {
+				byPath = make(map[string]incremental.FileEntry, len(snap.Files))
+				for _, e := range snap.Files {
+					byPath[cleanRelativePath(e.Path)] = e
+				}
 			}
-			continue
+			src, ok := byPath[target]
+			if !ok {
+				return fmt.Errorf("restore %s: hard link target %s is not in the snapshot", rel, target)
+			}
+			src.Path = fe.Path
+			fe = src
+		}
+		if err := x.restoreFileEntry(ctx, chunks, fe, rel); err != nil {
+			return fmt.Errorf("restore %s: %w", rel, err)
 		}
+	}
+	return x.finish()
+}
+
+func fileEntryMetadata(fe incremental.FileEntry) metadata {
+	mode := fe.FileMode()
+	return metadata{
+		uid:     fe.UID,
+		gid:     fe.GID,
+		uname:   fe.Uname,
+		gname:   fe.Gname,
+		mode:    mode & (os.ModePerm | os.ModeSetuid | os.ModeSetgid | os.ModeSticky),
+		modTime: fe.ModTime,
+		xattrs:  fe.Xattrs,
+		symlink: mode&os.ModeSymlink != 0,
+	}
+}
+
+func (x *extractor) restoreFileEntry(ctx context.Context, chunks *chunkFetcher, fe incremental.FileEntry, rel string) error {
+	fullPath := filepath.Join(x.targetDir, rel)
+	mode := fe.FileMode()
+	meta := fileEntryMetadata(fe)
+	dryRun := x.opts.DryRun
+	switch {
+	case fe.Hardlink != "":
+		if dryRun {
+			return nil
+		}
+		return x.link(fullPath, fe.Hardlink)
+	case mode.IsDir():
+		if dryRun {
+			return nil
+		}
+		if err := os.MkdirAll(fullPath, meta.mode.Perm()|0o700); err != nil {
+			return err
+		}
+		x.dirs = append(x.dirs, pendingDir{path: fullPath, meta: meta})
+		return nil
+	case mode&os.ModeSymlink != 0:
+		if dryRun {
+			return nil
+		}
+		if err := x.prepare(fullPath); err != nil {
+			return err
+		}
+		if err := os.Symlink(fe.Link, fullPath); err != nil {
+			return err
+		}
+		return x.apply(fullPath, meta)
+	case mode&(os.ModeNamedPipe|os.ModeDevice) != 0:
+		if dryRun {
+			return nil
+		}
+		typeflag := byte(tar.TypeFifo)
+		switch {
+		case mode&os.ModeCharDevice != 0:
+			typeflag = tar.TypeChar
+		case mode&os.ModeDevice != 0:
+			typeflag = tar.TypeBlock
+		}
+		if err := x.prepare(fullPath); err != nil {
+			return err
+		}
+		if err := mknod(fullPath, typeflag, fe.Devmajor, fe.Devminor); err != nil {
+			return err
+		}
+		return x.apply(fullPath, meta)
+	case !mode.IsRegular():
+		return nil
+	}
 
-		if err := os.MkdirAll(filepath.Dir(fullPath), 0o755); err != nil {
+	var w io.Writer = io.Discard
+	var f *os.File
+	if !dryRun {
+		if err := x.prepare(fullPath); err != nil {
+			return err
+		}
+		var err error
+		f, err = os.OpenFile(fullPath, os.O_CREATE|os.O_WRONLY|os.O_EXCL, 0o600)
+		if err != nil {
 			return err
 		}
-		f, err := os.OpenFile(fullPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, os.FileMode(fe.Mode))
+		w = f
+	}
+	for _, fc := range fe.Chunks {
+		data, err := chunks.get(ctx, fc.Hash)
 		if err != nil {
+			closeQuietly(f)
+			return err
+		}
+		if fc.Offset < 0 || fc.Length < 0 || fc.Offset+fc.Length > int64(len(data)) {
+			closeQuietly(f)
+			return fmt.Errorf("invalid chunk range for hash %s", fc.Hash)
+		}
+		if _, err := w.Write(data[fc.Offset : fc.Offset+fc.Length]); err != nil {
+			closeQuietly(f)
 			return err
 		}
+	}
+	if f == nil {
+		return nil
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return x.apply(fullPath, meta)
+}
 
-		for _, fc := range fe.Chunks {
-			data, ok := chunkData[fc.Hash]
-			if !ok {
-				_ = f.Close()
-				return fmt.Errorf("missing chunk data for hash %s", fc.Hash)
+// restoreChunkStream extracts the index's chunk stream as one or more consecutive tar archives.
+func restoreChunkStream(ctx context.Context, chunks *chunkFetcher, idx *incremental.Index, x *extractor, paths []string) error {
+	r := &chunkStreamReader{ctx: ctx, chunks: chunks, index: idx.Chunks}
+	for {
+		start := r.read
+		tr := tar.NewReader(r)
+		for {
+			hdr, err := tr.Next()
+			if err == io.EOF {
+				break
 			}
-			if fc.Offset < 0 || fc.Length < 0 || int(fc.Offset+fc.Length) > len(data) {
-				_ = f.Close()
-				return fmt.Errorf("invalid chunk range for hash %s", fc.Hash)
+			if err != nil {
+				return fmt.Errorf("read chunk stream: %w", err)
 			}
-			if fc.Length == 0 {
+			if name := cleanTarName(hdr.Name); name == "" || !selectedPath(name, paths) {
 				continue
 			}
-			if _, err := f.Write(data[fc.Offset : fc.Offset+fc.Length]); err != nil {
-				_ = f.Close()
+			if err := x.restoreTarEntry(tr, hdr); err != nil {
 				return err
 			}
 		}
+		if r.read == start {
+			return nil
+		}
+	}
+}
 
-		if err := f.Close(); err != nil {
-			return err
+// chunkFetcher downloads chunks by hash, caching the most recent one: consecutive files
+// of a snapshot usually share a chunk.
+type chunkFetcher struct {
+	client   objectStore
+	keys     *crypt.Keyring
+	verify   bool
+	lastHash string
+	lastData []byte
+}
+
+func (c *chunkFetcher) get(ctx context.Context, hash string) ([]byte, error) {
+	if hash == c.lastHash && c.lastData != nil {
+		return c.lastData, nil
+	}
+	key := chunkObjectKey(hash)
+	rc, err := c.client.GetObject(ctx, key)
+	if err != nil {
+		return nil, fmt.Errorf("get chunk %s: %w", key, err)
+	}
+	data, err := io.ReadAll(rc)
+	rc.Close()
+	if err != nil {
+		return nil, fmt.Errorf("read chunk %s: %w", key, err)
+	}
+	if data, err = openChunk(c.keys, hash, data, c.verify); err != nil {
+		return nil, fmt.Errorf("chunk %s: %w", key, err)
+	}
+	c.lastHash, c.lastData = hash, data
+	return data, nil
+}
+
+func chunkObjectKey(hash string) string {
+	return s3.ObjectKey(incremental.ObjectKeyPrefix(hash, incremental.DefaultHashPrefixLen), hash)
+}
+
+// openChunk decrypts and decodes a stored chunk object. With verify it also checks that the
+// plaintext hashes to hash.
+func openChunk(keys *crypt.Keyring, hash string, obj []byte, verify bool) ([]byte, error) {
+	// Chunks uploaded before encryption was enabled are plaintext under their plain BLAKE3 hash;
+	// Open refuses them unless encryption.allow_plaintext is set.
+	ids := keys
+	if !crypt.IsSealed(obj) {
+		ids = nil
+	}
+	data, err := keys.Open(obj, []byte(hash))
+	if err != nil {
+		return nil, fmt.Errorf("decrypt: %w", err)
+	}
+	if data, err = incremental.DecodeChunk(data); err != nil {
+		return nil, fmt.Errorf("decode: %w", err)
+	}
+	if verify {
+		if got := ids.ChunkID(data); got != hash {
+			return nil, fmt.Errorf("hash mismatch: got %s", got)
 		}
 	}
+	return data, nil
+}
 
-	return nil
+// chunkStreamReader reads the concatenation of an index's chunks.
+type chunkStreamReader struct {
+	ctx    context.Context
+	chunks *chunkFetcher
+	index  []incremental.IndexChunk
+	next   int
+	buf    []byte
+	read   int64
+}
+
+func (r *chunkStreamReader) Read(p []byte) (int, error) {
+	for len(r.buf) == 0 {
+		if r.next >= len(r.index) {
+			return 0, io.EOF
+		}
+		data, err := r.chunks.get(r.ctx, r.index[r.next].Hash)
+		if err != nil {
+			return 0, err
+		}
+		r.next++
+		r.buf = data
+	}
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	r.read += int64(n)
+	return n, nil
+}
+
+// selectedPath reports whether rel is one of paths or lies below one of them.
+func selectedPath(rel string, paths []string) bool {
+	if len(paths) == 0 {
+		return true
+	}
+	for _, p := range paths {
+		p = cleanRelativePath(p)
+		if p == "" {
+			continue
+		}
+		if rel == p || strings.HasPrefix(rel, p+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+func closeQuietly(f *os.File) {
+	if f != nil {
+		_ = f.Close()
+	}
 }
 
 func cleanRelativePath(p string) string {
@@ -0,0 +1,149 @@
+package restore
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"VelBackuper/internal/engine/incremental"
+)
+
+// backupTestTree stores a small tar stream as a snapshot of job and returns it.
+func backupTestTree(t *testing.T, store *memStore, job string) *incremental.Snapshot {
+	t.Helper()
+	mtime := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	entries := []struct {
+		hdr  tar.Header
+		body string
+	}{
+		{tar.Header{Name: "srv/", Typeflag: tar.TypeDir, Mode: 0o755, ModTime: mtime}, ""},
+		{tar.Header{Name: "srv/a.txt", Typeflag: tar.TypeReg, Mode: 0o640, ModTime: mtime}, "shared content"},
+		{tar.Header{Name: "srv/b.txt", Typeflag: tar.TypeLink, Linkname: "srv/a.txt", ModTime: mtime}, ""},
+		{tar.Header{Name: "srv/current", Typeflag: tar.TypeSymlink, Linkname: "a.txt", Mode: 0o777, ModTime: mtime}, ""},
+		{tar.Header{Name: "etc/app.conf", Typeflag: tar.TypeReg, Mode: 0o600, ModTime: mtime}, "listen 80\n"},
+	}
+	for _, e := range entries {
+		hdr := e.hdr
+		hdr.Size = int64(len(e.body))
+		if err := tw.WriteHeader(&hdr); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := io.WriteString(tw, e.body); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	_, _, snap, err := incremental.Run(context.Background(), store, job, &buf, incremental.RunOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return snap
+}
+
+func readTestFile(t *testing.T, path string) string {
+	t.Helper()
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+func TestRestoreIncremental_FileEntries(t *testing.T) {
+	store := newMemStore()
+	snap := backupTestTree(t, store, "web")
+	if len(snap.Files) == 0 {
+		t.Fatal("snapshot has no file entries")
+	}
+
+	target := t.TempDir()
+	err := RestoreIncremental(context.Background(), store, "web", snap.Timestamp, target, IncrementalRestoreOptions{VerifyChunks: true, NoOwner: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := readTestFile(t, filepath.Join(target, "srv", "a.txt")); got != "shared content" {
+		t.Errorf("srv/a.txt = %q", got)
+	}
+	if got := readTestFile(t, filepath.Join(target, "etc", "app.conf")); got != "listen 80\n" {
+		t.Errorf("etc/app.conf = %q", got)
+	}
+	a, err := os.Stat(filepath.Join(target, "srv", "a.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.Stat(filepath.Join(target, "srv", "b.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !os.SameFile(a, b) {
+		t.Error("srv/b.txt is not a hard link to srv/a.txt")
+	}
+	if a.Mode().Perm() != 0o640 {
+		t.Errorf("srv/a.txt mode %v, want 0640", a.Mode().Perm())
+	}
+	if link, err := os.Readlink(filepath.Join(target, "srv", "current")); err != nil || link != "a.txt" {
+		t.Errorf("srv/current -> %q, %v; want a symlink to a.txt", link, err)
+	}
+}
+
+func TestRestoreIncremental_PathSelectsHardlinkWithoutItsTarget(t *testing.T) {
+	store := newMemStore()
+	snap := backupTestTree(t, store, "web")
+
+	target := t.TempDir()
+	err := RestoreIncremental(context.Background(), store, "web", snap.Timestamp, target, IncrementalRestoreOptions{Paths: []string{"/srv/b.txt"}, NoOwner: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := readTestFile(t, filepath.Join(target, "srv", "b.txt")); got != "shared content" {
+		t.Errorf("srv/b.txt = %q, want the content of its excluded link target", got)
+	}
+	for _, rel := range []string{"srv/a.txt", "srv/current", "etc"} {
+		if _, err := os.Lstat(filepath.Join(target, rel)); !os.IsNotExist(err) {
+			t.Errorf("%s restored although not selected (err %v)", rel, err)
+		}
+	}
+}
+
+func TestRestoreIncremental_LegacySnapshotWithoutFiles(t *testing.T) {
+	ctx := context.Background()
+	store := newMemStore()
+	snap := backupTestTree(t, store, "web")
+
+	// Snapshots from before per-file entries only point at the index of the tar stream.
+	legacy := incremental.Snapshot{Job: "web", Timestamp: "20200101000000", IndexKey: snap.IndexKey}
+	if err := incremental.WriteSnapshot(ctx, store, legacy, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	target := t.TempDir()
+	if err := RestoreIncremental(ctx, store, "web", legacy.Timestamp, target, IncrementalRestoreOptions{NoOwner: true}); err != nil {
+		t.Fatal(err)
+	}
+	if got := readTestFile(t, filepath.Join(target, "srv", "b.txt")); got != "shared content" {
+		t.Errorf("srv/b.txt = %q", got)
+	}
+	if got := readTestFile(t, filepath.Join(target, "etc", "app.conf")); got != "listen 80\n" {
+		t.Errorf("etc/app.conf = %q", got)
+	}
+
+	partial := t.TempDir()
+	if err := RestoreIncremental(ctx, store, "web", legacy.Timestamp, partial, IncrementalRestoreOptions{Paths: []string{"etc"}, NoOwner: true}); err != nil {
+		t.Fatal(err)
+	}
+	if got := readTestFile(t, filepath.Join(partial, "etc", "app.conf")); got != "listen 80\n" {
+		t.Errorf("etc/app.conf = %q", got)
+	}
+	if _, err := os.Lstat(filepath.Join(partial, "srv")); !os.IsNotExist(err) {
+		t.Errorf("srv restored although not selected (err %v)", err)
+	}
+}
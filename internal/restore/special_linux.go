@@ -0,0 +1,28 @@
+//go:build linux
+
+package restore
+
+import (
+	"archive/tar"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// mknod creates a FIFO or a character or block device. The mode is set later by apply.
+func mknod(path string, typeflag byte, major, minor int64) error {
+	switch typeflag {
+	case tar.TypeFifo:
+		return unix.Mkfifo(path, 0o600)
+	case tar.TypeChar:
+		return unix.Mknod(path, unix.S_IFCHR|0o600, int(unix.Mkdev(uint32(major), uint32(minor))))
+	default:
+		return unix.Mknod(path, unix.S_IFBLK|0o600, int(unix.Mkdev(uint32(major), uint32(minor))))
+	}
+}
+
+// lchtimes sets the mtime of a symlink itself.
+func lchtimes(path string, mtime time.Time) error {
+	tv := unix.NsecToTimeval(mtime.UnixNano())
+	return unix.Lutimes(path, []unix.Timeval{tv, tv})
+}
@@ -0,0 +1,18 @@
+//go:build !linux
+
+package restore
+
+import (
+	"errors"
+	"time"
+)
+
+// mknod is only implemented on Linux; elsewhere FIFOs and devices cannot be restored.
+func mknod(path string, typeflag byte, major, minor int64) error {
+	return errors.New("FIFOs and devices cannot be created on this platform")
+}
+
+// lchtimes is only implemented on Linux; elsewhere symlinks keep the time they were created.
+func lchtimes(path string, mtime time.Time) error {
+	return nil
+}
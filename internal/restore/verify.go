@@ -0,0 +1,320 @@
+package restore
+
+import (
+	"archive/tar"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+
+	"VelBackuper/internal/crypt"
+	"VelBackuper/internal/engine/archive"
+	"VelBackuper/internal/engine/incremental"
+	"VelBackuper/internal/s3"
+)
+
+// DefaultVerifyConcurrency is the number of chunks checked in parallel when
+// VerifyOptions.Concurrency is zero.
+const DefaultVerifyConcurrency = 8
+
+// VerifyOptions configures VerifyIncremental.
+type VerifyOptions struct {
+	// Deep downloads every chunk and checks its content hash; otherwise only existence is checked.
+	Deep        bool
+	Concurrency int
+	Keyring     *crypt.Keyring
+}
+
+// Problem is a stored object that failed verification.
+type Problem struct {
+	Key    string
+	Reason string
+	// Snapshots lists the incremental snapshots that depend on the object.
+	Snapshots []string
+}
+
+func (p Problem) String() string {
+	if len(p.Snapshots) == 0 {
+		return p.Key + ": " + p.Reason
+	}
+	return fmt.Sprintf("%s: %s (snapshots %s)", p.Key, p.Reason, strings.Join(p.Snapshots, ", "))
+}
+
+// ArchiveReport is the result of verifying one archive backup.
+type ArchiveReport struct {
+	Timestamp string
+	Key       string
+	Size      int64 // bytes read from S3
+	FileCount int
+	Problems  []Problem
+}
+
+func (r *ArchiveReport) OK() bool { return len(r.Problems) == 0 }
+
+// IncrementalReport is the result of verifying a set of snapshots of one job.
+type IncrementalReport struct {
+	Snapshots []string
+	Chunks    int // distinct chunks checked
+	Problems  []Problem
+}
+
+func (r *IncrementalReport) OK() bool { return len(r.Problems) == 0 }
+
+// Failed returns the snapshots affected by at least one problem.
+func (r *IncrementalReport) Failed() []string {
+	seen := make(map[string]bool)
+	var out []string
+	for _, p := range r.Problems {
+		for _, ts := range p.Snapshots {
+			if !seen[ts] {
+				seen[ts] = true
+				out = append(out, ts)
+			}
+		}
+	}
+	return out
+}
+
+// VerifyArchive reads the archive described by m end to end without writing anything: it
+// decrypts and decompresses the stream, reads every tar entry, and compares the stored size,
+// SHA-256 and file count with the manifest. Unreadable or mismatching data is reported as a
+// Problem; the error is for failures to talk to S3.
+func VerifyArchive(ctx context.Context, client *s3.Client, m *archive.Manifest, keys *crypt.Keyring) (*ArchiveReport, error) {
+	rep := &ArchiveReport{Timestamp: m.Timestamp, Key: m.Key}
+	addProblem := func(format string, args ...any) {
+		rep.Problems = append(rep.Problems, Problem{Key: m.Key, Reason: fmt.Sprintf(format, args...)})
+	}
+
+	format, err := archiveFormat(m)
+	if err != nil {
+		addProblem("%v", err)
+		return rep, nil
+	}
+	mod, err := client.HeadObject(ctx, m.Key)
+	if err != nil {
+		return nil, fmt.Errorf("head archive %s: %w", m.Key, err)
+	}
+	if mod == nil {
+		addProblem("archive object is missing")
+		return rep, nil
+	}
+	rc, err := client.GetObject(ctx, m.Key)
+	if err != nil {
+		return nil, fmt.Errorf("get archive %s: %w", m.Key, err)
+	}
+	defer rc.Close()
+
+	sum := sha256.New()
+	body := &byteCounter{r: io.TeeReader(rc, sum)}
+	files, readErr := readArchive(body, m, format, keys)
+	if readErr != nil {
+		addProblem("%v", readErr)
+	}
+	rep.FileCount = files
+	// Read whatever the decoder left so the checksum covers the whole object.
+	if _, err := io.Copy(io.Discard, body); err != nil {
+		return nil, fmt.Errorf("read archive %s: %w", m.Key, err)
+	}
+	rep.Size = body.n
+
+	if m.Size > 0 && rep.Size != m.Size {
+		addProblem("size %d does not match manifest size %d", rep.Size, m.Size)
+	}
+	if got := hex.EncodeToString(sum.Sum(nil)); m.SHA256 != "" && got != m.SHA256 {
+		addProblem("SHA-256 %s does not match manifest %s", got, m.SHA256)
+	}
+	if readErr == nil && m.FileCount > 0 && files != m.FileCount {
+		addProblem("archive holds %d files, manifest records %d", files, m.FileCount)
+	}
+	return rep, nil
+}
+
+// readArchive decodes the stored archive r and reads every entry, returning the number of
+// regular files.
+func readArchive(r io.Reader, m *archive.Manifest, format archive.CompressionFormat, keys *crypt.Keyring) (int, error) {
+	var err error
+	if m.Encrypted || strings.HasSuffix(m.Key, crypt.ArchiveSuffix) {
+		if r, err = keys.DecryptReader(r); err != nil {
+			return 0, fmt.Errorf("decrypt: %w", err)
+		}
+	}
+	dr, err := archive.NewDecompressReader(r, format)
+	if err != nil {
+		return 0, fmt.Errorf("decompress: %w", err)
+	}
+	defer dr.Close()
+
+	files := 0
+	tr := tar.NewReader(dr)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return files, fmt.Errorf("read tar: %w", err)
+		}
+		if hdr.Typeflag == tar.TypeReg || hdr.Typeflag == tar.TypeRegA {
+			files++
+		}
+		if _, err := io.Copy(io.Discard, tr); err != nil {
+			return files, fmt.Errorf("read %s: %w", hdr.Name, err)
+		}
+	}
+	// Decompressors check their trailing checksum at end of stream.
+	if _, err := io.Copy(io.Discard, dr); err != nil {
+		return files, fmt.Errorf("read archive trailer: %w", err)
+	}
+	return files, nil
+}
+
+// VerifyIncremental checks that every chunk referenced by the given snapshots of job exists
+// and, with opts.Deep, that its content matches its hash. Each problem lists the snapshots
+// that cannot be fully restored because of it.
+func VerifyIncremental(ctx context.Context, client objectStore, job string, timestamps []string, opts VerifyOptions) (*IncrementalReport, error) {
+	rep := &IncrementalReport{Snapshots: timestamps}
+
+	users := make(map[string][]string) // chunk hash -> snapshots using it
+	var hashes []string
+	for _, ts := range timestamps {
+		snapKey := s3.SnapshotKey(job, ts)
+		snap, err := incremental.ReadSnapshotByKey(ctx, client, snapKey, opts.Keyring)
+		if err != nil {
+			if storageFailure(err) {
+				return nil, fmt.Errorf("read snapshot %s: %w", snapKey, err)
+			}
+			rep.Problems = append(rep.Problems, Problem{Key: snapKey, Reason: fmt.Sprintf("unreadable snapshot: %v", err), Snapshots: []string{ts}})
+			continue
+		}
+		idx, err := incremental.ReadIndexByKey(ctx, client, snap.IndexKey, opts.Keyring)
+		if err != nil {
+			if storageFailure(err) {
+				return nil, fmt.Errorf("read index %s: %w", snap.IndexKey, err)
+			}
+			rep.Problems = append(rep.Problems, Problem{Key: snap.IndexKey, Reason: fmt.Sprintf("unreadable index: %v", err), Snapshots: []string{ts}})
+			continue
+		}
+		for _, ch := range idx.Chunks {
+			s := users[ch.Hash]
+			if len(s) == 0 {
+				hashes = append(hashes, ch.Hash)
+			}
+			if len(s) == 0 || s[len(s)-1] != ts {
+				users[ch.Hash] = append(s, ts)
+			}
+		}
+	}
+	rep.Chunks = len(hashes)
+
+	reasons, err := checkChunks(ctx, client, hashes, opts)
+	if err != nil {
+		return nil, err
+	}
+	for i, hash := range hashes {
+		if reasons[i] != "" {
+			rep.Problems = append(rep.Problems, Problem{Key: chunkObjectKey(hash), Reason: reasons[i], Snapshots: users[hash]})
+		}
+	}
+	return rep, nil
+}
+
+// storageFailure reports whether err is a failed request rather than a problem with the
+// stored data: a missing object or one that does not decrypt or decode is a Problem, while a
+// network, auth or backend error says nothing about the repository and is returned instead.
+func storageFailure(err error) bool {
+	var se *s3.Error
+	return (errors.As(err, &se) && !s3.IsNotFound(err)) || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded)
+}
+
+// checkChunks checks hashes in parallel and returns a problem description per hash ("" when
+// the chunk is fine).
+func checkChunks(ctx context.Context, client objectStore, hashes []string, opts VerifyOptions) ([]string, error) {
+	workers := opts.Concurrency
+	if workers <= 0 {
+		workers = DefaultVerifyConcurrency
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	reasons := make([]string, len(hashes))
+	next := make(chan int)
+	var (
+		wg       sync.WaitGroup
+		errOnce  sync.Once
+		firstErr error
+	)
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				reason, err := checkChunk(ctx, client, hashes[i], opts)
+				if err != nil {
+					errOnce.Do(func() {
+						firstErr = err
+						cancel()
+					})
+					continue
+				}
+				reasons[i] = reason
+			}
+		}()
+	}
+send:
+	for i := range hashes {
+		select {
+		case next <- i:
+		case <-ctx.Done():
+			break send
+		}
+	}
+	close(next)
+	wg.Wait()
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	return reasons, ctx.Err()
+}
+
+func checkChunk(ctx context.Context, client objectStore, hash string, opts VerifyOptions) (string, error) {
+	key := chunkObjectKey(hash)
+	mod, err := client.HeadObject(ctx, key)
+	if err != nil {
+		return "", fmt.Errorf("head chunk %s: %w", key, err)
+	}
+	if mod == nil {
+		return "missing", nil
+	}
+	if !opts.Deep {
+		return "", nil
+	}
+	rc, err := client.GetObject(ctx, key)
+	if err != nil {
+		return "", fmt.Errorf("get chunk %s: %w", key, err)
+	}
+	data, err := io.ReadAll(rc)
+	rc.Close()
+	if err != nil {
+		return "", fmt.Errorf("read chunk %s: %w", key, err)
+	}
+	if _, err := openChunk(opts.Keyring, hash, data, true); err != nil {
+		return "corrupt: " + err.Error(), nil
+	}
+	return "", nil
+}
+
+// byteCounter counts the bytes read through it.
+type byteCounter struct {
+	r io.Reader
+	n int64
+}
+
+func (c *byteCounter) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
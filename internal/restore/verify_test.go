@@ -0,0 +1,209 @@
+package restore
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"VelBackuper/internal/engine/incremental"
+	"VelBackuper/internal/s3"
+)
+
+// memStore is an in-memory bucket for the incremental engine and for restore and verify.
+type memStore struct {
+	mu      sync.Mutex
+	objects map[string][]byte
+	getErrs map[string]error // GetObject fails with these
+}
+
+func newMemStore() *memStore {
+	return &memStore{objects: make(map[string][]byte)}
+}
+
+func (m *memStore) HeadObject(_ context.Context, key string) (*time.Time, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.objects[key]; ok {
+		tm := time.Unix(0, 0).UTC()
+		return &tm, nil
+	}
+	return nil, nil
+}
+
+func (m *memStore) PutObject(_ context.Context, key string, body io.Reader, _ int64) error {
+	b, err := io.ReadAll(body)
+	if err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.objects[key] = b
+	return nil
+}
+
+func (m *memStore) GetObject(_ context.Context, key string) (io.ReadCloser, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.getErrs[key]; err != nil {
+		return nil, err
+	}
+	b, ok := m.objects[key]
+	if !ok {
+		return nil, fmt.Errorf("NoSuchKey: %s", key)
+	}
+	return io.NopCloser(bytes.NewReader(b)), nil
+}
+
+func (m *memStore) set(key string, data []byte) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if data == nil {
+		delete(m.objects, key)
+		return
+	}
+	m.objects[key] = data
+}
+
+// putChunkSnapshot stores chunks and a snapshot of job at ts whose index lists them in order,
+// and returns the chunk hashes.
+func putChunkSnapshot(t *testing.T, store *memStore, job, ts string, chunks ...string) []string {
+	t.Helper()
+	ctx := context.Background()
+	idx := incremental.Index{Job: job, Timestamp: ts}
+	var hashes []string
+	for _, c := range chunks {
+		hash := incremental.HashChunkHex([]byte(c))
+		if _, err := incremental.UploadChunk(ctx, store, hash, []byte(c), incremental.UploadOptions{}); err != nil {
+			t.Fatal(err)
+		}
+		idx.Chunks = append(idx.Chunks, incremental.IndexChunk{Hash: hash, Size: int64(len(c))})
+		hashes = append(hashes, hash)
+	}
+	if err := incremental.WriteIndex(ctx, store, idx, nil); err != nil {
+		t.Fatal(err)
+	}
+	snap := incremental.Snapshot{Job: job, Timestamp: ts, IndexKey: s3.IndexKey(job, ts)}
+	if err := incremental.WriteSnapshot(ctx, store, snap, nil); err != nil {
+		t.Fatal(err)
+	}
+	return hashes
+}
+
+func TestVerifyIncremental_MissingChunk(t *testing.T) {
+	ctx := context.Background()
+	store := newMemStore()
+	first := putChunkSnapshot(t, store, "web", "20250101000000", "alpha", "shared")
+	putChunkSnapshot(t, store, "web", "20250102000000", "shared", "gamma")
+	points := []string{"20250101000000", "20250102000000"}
+
+	rep, err := VerifyIncremental(ctx, store, "web", points, VerifyOptions{Concurrency: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !rep.OK() || rep.Chunks != 3 {
+		t.Fatalf("intact repository: OK = %v, Chunks = %d, problems %v; want OK and 3 distinct chunks", rep.OK(), rep.Chunks, rep.Problems)
+	}
+
+	store.set(chunkObjectKey(first[0]), nil)
+	rep, err = VerifyIncremental(ctx, store, "web", points, VerifyOptions{Concurrency: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rep.Problems) != 1 || rep.Problems[0].Key != chunkObjectKey(first[0]) || rep.Problems[0].Reason != "missing" {
+		t.Fatalf("problems = %v, want alpha missing", rep.Problems)
+	}
+	if got := fmt.Sprint(rep.Failed()); got != "[20250101000000]" {
+		t.Errorf("Failed() = %s, want only the snapshot using alpha", got)
+	}
+
+	store.set(chunkObjectKey(first[1]), nil)
+	rep, err = VerifyIncremental(ctx, store, "web", points, VerifyOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := fmt.Sprint(rep.Failed()); got != "[20250101000000 20250102000000]" {
+		t.Errorf("Failed() = %s, want both snapshots after losing the shared chunk", got)
+	}
+}
+
+func TestVerifyIncremental_DeepDetectsHashMismatch(t *testing.T) {
+	ctx := context.Background()
+	store := newMemStore()
+	hashes := putChunkSnapshot(t, store, "web", "20250101000000", "alpha", "beta")
+	points := []string{"20250101000000"}
+
+	rep, err := VerifyIncremental(ctx, store, "web", points, VerifyOptions{Deep: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !rep.OK() {
+		t.Fatalf("deep check of intact chunks: problems %v", rep.Problems)
+	}
+
+	tampered, err := incremental.EncodeChunk(nil, []byte("tampered"), incremental.CodecZstd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.set(chunkObjectKey(hashes[0]), tampered)
+	store.set(chunkObjectKey(hashes[1]), []byte{0x56, 0x42, 0x43, 0x31, 0x01, 0xff}) // chunk header, then garbage
+
+	rep, err = VerifyIncremental(ctx, store, "web", points, VerifyOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !rep.OK() {
+		t.Errorf("existence check read chunk content: problems %v", rep.Problems)
+	}
+
+	rep, err = VerifyIncremental(ctx, store, "web", points, VerifyOptions{Deep: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rep.Problems) != 2 {
+		t.Fatalf("problems = %v, want both chunks", rep.Problems)
+	}
+	byKey := make(map[string]string)
+	for _, p := range rep.Problems {
+		byKey[p.Key] = p.Reason
+	}
+	if r := byKey[chunkObjectKey(hashes[0])]; !strings.HasPrefix(r, "corrupt: hash mismatch") {
+		t.Errorf("alpha: %q, want a hash mismatch", r)
+	}
+	if r := byKey[chunkObjectKey(hashes[1])]; !strings.HasPrefix(r, "corrupt: ") {
+		t.Errorf("beta: %q, want an undecodable chunk", r)
+	}
+}
+
+func TestVerifyIncremental_RequestFailureIsAnError(t *testing.T) {
+	ctx := context.Background()
+	store := newMemStore()
+	putChunkSnapshot(t, store, "web", "20250101000000", "alpha")
+	putChunkSnapshot(t, store, "web", "20250102000000", "beta")
+	points := []string{"20250101000000", "20250102000000"}
+
+	snapKey := s3.SnapshotKey("web", "20250102000000")
+	store.getErrs = map[string]error{snapKey: &s3.Error{Op: "GetObject", Key: snapKey, Err: errors.New("connection reset by peer")}}
+	_, err := VerifyIncremental(ctx, store, "web", points, VerifyOptions{})
+	var se *s3.Error
+	if !errors.As(err, &se) {
+		t.Fatalf("VerifyIncremental = %v, want the S3 error rather than a problem", err)
+	}
+
+	// A snapshot that is gone or does not decode is a problem of that snapshot only.
+	store.getErrs = nil
+	store.set(snapKey, nil)
+	store.set(s3.IndexKey("web", "20250101000000"), []byte("{not json"))
+	rep, err := VerifyIncremental(ctx, store, "web", points, VerifyOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := fmt.Sprint(rep.Failed()); len(rep.Problems) != 2 || got != "[20250101000000 20250102000000]" {
+		t.Errorf("problems = %v, want the unreadable index and the missing snapshot", rep.Problems)
+	}
+}
@@ -0,0 +1,25 @@
+//go:build linux
+
+package restore
+
+import (
+	"errors"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// setXattr sets an extended attribute of path, not following a symlink; POSIX ACLs are set
+// through system.posix_acl_access and system.posix_acl_default. Attributes the target
+// filesystem does not support, and ones only root may set when not running as root, are
+// skipped.
+func setXattr(path, name string, value []byte) error {
+	err := unix.Lsetxattr(path, name, value, 0)
+	switch {
+	case errors.Is(err, unix.ENOTSUP):
+		return nil
+	case errors.Is(err, unix.EPERM) && os.Geteuid() != 0:
+		return nil
+	}
+	return err
+}
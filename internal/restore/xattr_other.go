@@ -0,0 +1,8 @@
+//go:build !linux
+
+package restore
+
+// setXattr is only implemented on Linux; elsewhere extended attributes are not restored.
+func setXattr(path, name string, value []byte) error {
+	return nil
+}
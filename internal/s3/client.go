@@ -1,6 +1,7 @@
 package s3
 
 import (
+	"bytes"
 	"context"
 	"crypto/tls"
 	"errors"
@@ -35,12 +36,49 @@ type Options struct {
 	PathStyle               bool // true = path-style (MinIO), false = virtual-hosted (AWS, some S3)
 	DisableRequestChecksums bool // set true for S3-compatible backends that reject default CRC/SHA checksum headers (e.g. Ceph, some proxies)
 	InsecureSkipVerify      bool
+	// DisableConditionalWrites stops callers such as the S3 lock from relying on
+	// If-None-Match / If-Match requests, for backends that ignore or reject them.
+	DisableConditionalWrites bool
+}
+
+// Error is returned when a request to the S3 backend fails. Op is the API operation and Key
+// the object key or listing prefix, relative to the client's prefix.
+type Error struct {
+	Op  string
+	Key string
+	Err error
+}
+
+func (e *Error) Error() string {
+	if e.Key == "" {
+		return fmt.Sprintf("s3 %s: %v", e.Op, e.Err)
+	}
+	return fmt.Sprintf("s3 %s %s: %v", e.Op, e.Key, e.Err)
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// IsNotFound reports whether err is the backend's answer that an object does not exist.
+func IsNotFound(err error) bool {
+	var re *awshttp.ResponseError
+	return errors.As(err, &re) && re.HTTPStatusCode() == http.StatusNotFound
+}
+
+// wrapError returns err as an *Error, or nil if err is nil.
+func wrapError(op, key string, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &Error{Op: op, Key: key, Err: err}
 }
 
 type Client struct {
-	client *s3.Client
-	bucket string
-	prefix string
+	client      *s3.Client
+	bucket      string
+	prefix      string
+	conditional bool
 }
 
 func New(ctx context.Context, opts Options) (*Client, error) {
@@ -89,9 +127,10 @@ func New(ctx context.Context, opts Options) (*Client, error) {
 	client := s3.NewFromConfig(cfg, s3Opts)
 
 	return &Client{
-		client: client,
-		bucket: opts.Bucket,
-		prefix: strings.Trim(opts.Prefix, "/"),
+		client:      client,
+		bucket:      opts.Bucket,
+		prefix:      strings.Trim(opts.Prefix, "/"),
+		conditional: !opts.DisableConditionalWrites,
 	}, nil
 }
 
@@ -133,7 +172,7 @@ func (c *Client) PutObject(ctx context.Context, key string, body io.Reader, cont
 		Body:          body,
 		ContentLength: aws.Int64(contentLength),
 	})
-	return err
+	return wrapError("PutObject", key, err)
 }
 
 func (c *Client) GetObject(ctx context.Context, key string) (io.ReadCloser, error) {
@@ -143,7 +182,7 @@ func (c *Client) GetObject(ctx context.Context, key string) (io.ReadCloser, erro
 		Key:    aws.String(fullKey),
 	})
 	if err != nil {
-		return nil, err
+		return nil, wrapError("GetObject", key, err)
 	}
 	return out.Body, nil
 }
@@ -154,9 +193,104 @@ func (c *Client) DeleteObject(ctx context.Context, key string) error {
 		Bucket: aws.String(c.bucket),
 		Key:    aws.String(fullKey),
 	})
+	return wrapError("DeleteObject", key, err)
+}
+
+// ErrPreconditionFailed is returned by conditional requests whose condition does not hold:
+// the object already exists (PutObjectIfAbsent) or its ETag has changed (If-Match).
+var ErrPreconditionFailed = errors.New("s3: precondition failed")
+
+// ErrConditionalUnsupported is returned when the backend rejects conditional request headers.
+var ErrConditionalUnsupported = errors.New("s3: conditional requests not supported by backend")
+
+// ConditionalWrites reports whether callers may use the conditional methods.
+func (c *Client) ConditionalWrites() bool {
+	return c.conditional
+}
+
+// PutObjectIfAbsent creates key only if it does not exist (If-None-Match: *) and returns the
+// new ETag.
+func (c *Client) PutObjectIfAbsent(ctx context.Context, key string, body []byte) (string, error) {
+	out, err := c.client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:        aws.String(c.bucket),
+		Key:           aws.String(c.Key(key)),
+		Body:          bytes.NewReader(body),
+		ContentLength: aws.Int64(int64(len(body))),
+		IfNoneMatch:   aws.String("*"),
+	})
+	if err != nil {
+		return "", wrapError("PutObject", key, conditionalError(err))
+	}
+	return aws.ToString(out.ETag), nil
+}
+
+// PutObjectIfMatch overwrites key only if its ETag is still etag and returns the new ETag.
+func (c *Client) PutObjectIfMatch(ctx context.Context, key string, body []byte, etag string) (string, error) {
+	out, err := c.client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:        aws.String(c.bucket),
+		Key:           aws.String(c.Key(key)),
+		Body:          bytes.NewReader(body),
+		ContentLength: aws.Int64(int64(len(body))),
+		IfMatch:       aws.String(etag),
+	})
+	if err != nil {
+		return "", wrapError("PutObject", key, conditionalError(err))
+	}
+	return aws.ToString(out.ETag), nil
+}
+
+// DeleteObjectIfMatch deletes key only if its ETag is still etag.
+func (c *Client) DeleteObjectIfMatch(ctx context.Context, key, etag string) error {
+	_, err := c.client.DeleteObject(ctx, &s3.DeleteObjectInput{
+		Bucket:  aws.String(c.bucket),
+		Key:     aws.String(c.Key(key)),
+		IfMatch: aws.String(etag),
+	})
+	return wrapError("DeleteObject", key, conditionalError(err))
+}
+
+// conditionalError maps the HTTP status of a failed conditional request to
+// ErrPreconditionFailed or ErrConditionalUnsupported.
+func conditionalError(err error) error {
+	var re *awshttp.ResponseError
+	if err == nil || !errors.As(err, &re) {
+		return err
+	}
+	switch re.HTTPStatusCode() {
+	case http.StatusPreconditionFailed, http.StatusConflict:
+		// 409 is a concurrent conditional write to the same key; the other writer won.
+		return fmt.Errorf("%w: %v", ErrPreconditionFailed, err)
+	case http.StatusNotImplemented:
+		return fmt.Errorf("%w: %v", ErrConditionalUnsupported, err)
+	}
 	return err
 }
 
+// GetObjectWithInfo reads a small object whole and returns it with its ETag and modification
+// time. It returns nil data and a nil error if the object does not exist.
+func (c *Client) GetObjectWithInfo(ctx context.Context, key string) ([]byte, *ObjectInfo, error) {
+	out, err := c.client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(c.bucket),
+		Key:    aws.String(c.Key(key)),
+	})
+	if err != nil {
+		if IsNotFound(err) {
+			return nil, nil, nil
+		}
+		return nil, nil, wrapError("GetObject", key, err)
+	}
+	defer out.Body.Close()
+	data, err := io.ReadAll(out.Body)
+	if err != nil {
+		return nil, nil, wrapError("GetObject", key, err)
+	}
+	info := &ObjectInfo{Key: key, Size: int64(len(data)), ETag: aws.ToString(out.ETag)}
+	if out.LastModified != nil {
+		info.LastModified = *out.LastModified
+	}
+	return data, info, nil
+}
+
 func (c *Client) HeadObject(ctx context.Context, key string) (*time.Time, error) {
 	fullKey := c.Key(key)
 	out, err := c.client.HeadObject(ctx, &s3.HeadObjectInput{
@@ -164,31 +298,34 @@ func (c *Client) HeadObject(ctx context.Context, key string) (*time.Time, error)
 		Key:    aws.String(fullKey),
 	})
 	if err != nil {
-		var re *awshttp.ResponseError
-		if errors.As(err, &re) && re.HTTPStatusCode() == 404 {
+		if IsNotFound(err) {
 			return nil, nil
 		}
-		return nil, err
+		return nil, wrapError("HeadObject", key, err)
 	}
 	return out.LastModified, nil
 }
 
+// ListObjects lists the keys under prefix. maxKeys limits the result; zero lists everything.
 func (c *Client) ListObjects(ctx context.Context, prefix string, maxKeys int32) ([]string, error) {
 	fullPrefix := c.Key(prefix)
 	if fullPrefix != "" && !strings.HasSuffix(fullPrefix, "/") {
 		fullPrefix += "/"
 	}
 	input := &s3.ListObjectsV2Input{
-		Bucket:  aws.String(c.bucket),
-		Prefix:  aws.String(fullPrefix),
-		MaxKeys: aws.Int32(maxKeys),
+		Bucket: aws.String(c.bucket),
+		Prefix: aws.String(fullPrefix),
+	}
+	// MaxKeys 0 asks some servers (AWS among them) for an empty page, so only send a limit.
+	if maxKeys > 0 {
+		input.MaxKeys = aws.Int32(maxKeys)
 	}
 	var keys []string
 	paginator := s3.NewListObjectsV2Paginator(c.client, input)
 	for paginator.HasMorePages() {
 		page, err := paginator.NextPage(ctx)
 		if err != nil {
-			return nil, err
+			return nil, wrapError("ListObjectsV2", prefix, err)
 		}
 		for _, obj := range page.Contents {
 			if obj.Key != nil {
@@ -196,12 +333,96 @@ func (c *Client) ListObjects(ctx context.Context, prefix string, maxKeys int32)
 			}
 		}
 		if maxKeys > 0 && int32(len(keys)) >= maxKeys {
-			break
+			return keys[:maxKeys], nil
 		}
 	}
 	return keys, nil
 }
 
+// ObjectInfo describes a listed object. Key is relative to the client's prefix.
+type ObjectInfo struct {
+	Key          string
+	Size         int64
+	LastModified time.Time
+	ETag         string
+}
+
+// ListObjectInfo lists every object under prefix with its size and modification time.
+func (c *Client) ListObjectInfo(ctx context.Context, prefix string) ([]ObjectInfo, error) {
+	var out []ObjectInfo
+	err := c.WalkObjects(ctx, prefix, func(info ObjectInfo) error {
+		out = append(out, info)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// WalkObjects calls fn for every object under prefix, one listing page at a time, so that
+// the whole listing is never held in memory. An error from fn stops the walk.
+func (c *Client) WalkObjects(ctx context.Context, prefix string, fn func(ObjectInfo) error) error {
+	fullPrefix := c.Key(prefix)
+	if fullPrefix != "" && !strings.HasSuffix(fullPrefix, "/") {
+		fullPrefix += "/"
+	}
+	input := &s3.ListObjectsV2Input{
+		Bucket: aws.String(c.bucket),
+		Prefix: aws.String(fullPrefix),
+	}
+	paginator := s3.NewListObjectsV2Paginator(c.client, input)
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return wrapError("ListObjectsV2", prefix, err)
+		}
+		for _, obj := range page.Contents {
+			if obj.Key == nil {
+				continue
+			}
+			info := ObjectInfo{Key: c.relativeKey(*obj.Key), Size: aws.ToInt64(obj.Size), ETag: aws.ToString(obj.ETag)}
+			if obj.LastModified != nil {
+				info.LastModified = *obj.LastModified
+			}
+			if err := fn(info); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// MaxDeleteBatch is the most keys S3 accepts in one DeleteObjects request.
+const MaxDeleteBatch = 1000
+
+// DeleteObjects deletes keys with as few DeleteObjects requests as possible. Keys that are
+// already gone are not an error; the first key the server refuses to delete is.
+func (c *Client) DeleteObjects(ctx context.Context, keys []string) error {
+	for len(keys) > 0 {
+		n := min(len(keys), MaxDeleteBatch)
+		batch := keys[:n]
+		keys = keys[n:]
+
+		ids := make([]types.ObjectIdentifier, len(batch))
+		for i, k := range batch {
+			ids[i] = types.ObjectIdentifier{Key: aws.String(c.Key(k))}
+		}
+		out, err := c.client.DeleteObjects(ctx, &s3.DeleteObjectsInput{
+			Bucket: aws.String(c.bucket),
+			Delete: &types.Delete{Objects: ids, Quiet: aws.Bool(true)},
+		})
+		if err != nil {
+			return wrapError("DeleteObjects", batch[0], err)
+		}
+		if len(out.Errors) > 0 {
+			e := out.Errors[0]
+			return wrapError("DeleteObjects", c.relativeKey(aws.ToString(e.Key)), fmt.Errorf("%s: %s (%d of %d keys failed)", aws.ToString(e.Code), aws.ToString(e.Message), len(out.Errors), len(batch)))
+		}
+	}
+	return nil
+}
+
 func (c *Client) Client() *s3.Client {
 	return c.client
 }
@@ -219,7 +440,7 @@ func (c *Client) CreateBucket(ctx context.Context) error {
 		if strings.Contains(err.Error(), "BucketAlreadyExists") || strings.Contains(err.Error(), "BucketAlreadyOwnedByYou") {
 			return nil
 		}
-		return err
+		return wrapError("CreateBucket", "", err)
 	}
 	return nil
 }
@@ -0,0 +1,44 @@
+package s3
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestIsNotFound(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/xml")
+		if r.URL.Path == "/bucket/missing" {
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = w.Write([]byte(`<Error><Code>NoSuchKey</Code><Message>not found</Message></Error>`))
+			return
+		}
+		w.WriteHeader(http.StatusForbidden)
+		_, _ = w.Write([]byte(`<Error><Code>AccessDenied</Code><Message>denied</Message></Error>`))
+	}))
+	t.Cleanup(srv.Close)
+	client, err := New(context.Background(), Options{
+		Endpoint:                srv.URL,
+		AccessKey:               "test",
+		SecretKey:               "test",
+		Bucket:                  "bucket",
+		PathStyle:               true,
+		DisableRequestChecksums: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = client.GetObject(context.Background(), "missing")
+	var se *Error
+	if !errors.As(err, &se) || !IsNotFound(err) {
+		t.Errorf("GetObject(missing) = %v, want a not-found *Error", err)
+	}
+	_, err = client.GetObject(context.Background(), "denied")
+	if !errors.As(err, &se) || IsNotFound(err) {
+		t.Errorf("GetObject(denied) = %v, want an *Error that is not not-found", err)
+	}
+}
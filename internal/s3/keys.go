@@ -43,6 +43,19 @@ func LockKey(job string) string {
 	return path.Join(LocksPrefix, job+".lock")
 }
 
+// RepositoryConfigKey holds settings shared by every job of a repository, such as the salt
+// encryption keys are derived with.
+func RepositoryConfigKey() string {
+	return "repository.json"
+}
+
+// RepositoryLockKey is the lock held by operations that must not overlap a backup of any
+// job, such as garbage collection. It sits in a subdirectory of LocksPrefix so that it cannot
+// clash with a job lock.
+func RepositoryLockKey() string {
+	return path.Join(LocksPrefix, "repository", "exclusive.lock")
+}
+
 func ParseArchiveKey(relativeKey string) (job, yyyy, mm, dd, filename string) {
 	relativeKey = strings.Trim(relativeKey, "/")
 	parts := strings.Split(relativeKey, "/")
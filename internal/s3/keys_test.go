@@ -60,6 +60,17 @@ func TestLockKey(t *testing.T) {
 	}
 }
 
+func TestRepositoryLockKey(t *testing.T) {
+	got := RepositoryLockKey()
+	if got != "locks/repository/exclusive.lock" {
+		t.Errorf("RepositoryLockKey = %q", got)
+	}
+	// Job names cannot contain "/", so no job lock can have this key.
+	if got == LockKey("repository") {
+		t.Errorf("RepositoryLockKey clashes with a job lock")
+	}
+}
+
 func TestParseArchiveKey(t *testing.T) {
 	key := "archives/web-prod/2025/02/26/backup-host-123.tar.gz"
 	job, yyyy, mm, dd, filename := ParseArchiveKey(key)
@@ -76,7 +87,7 @@ func TestParseArchiveKey_Invalid(t *testing.T) {
 }
 
 func TestParseArchiveKey_TooShort(t *testing.T) {
-	job, yyyy, mm, dd, filename := ParseArchiveKey("archives/job/2025")
+	job, _, _, _, filename := ParseArchiveKey("archives/job/2025")
 	if job != "" || filename != "" {
 		t.Errorf("ParseArchiveKey(too short) should return empty job/filename: %q,%q", job, filename)
 	}
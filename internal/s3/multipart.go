@@ -22,7 +22,7 @@ func (c *Client) UploadMultipart(ctx context.Context, key string, body io.Reader
 		Key:    aws.String(fullKey),
 	})
 	if err != nil {
-		return fmt.Errorf("create multipart upload: %w", err)
+		return wrapError("CreateMultipartUpload", key, err)
 	}
 	uploadID := createOut.UploadId
 	defer func() {
@@ -61,7 +61,7 @@ func (c *Client) UploadMultipart(ctx context.Context, key string, body io.Reader
 			ContentLength: aws.Int64(int64(n)),
 		})
 		if err != nil {
-			return fmt.Errorf("upload part %d: %w", partNumber, err)
+			return wrapError("UploadPart", key, fmt.Errorf("part %d: %w", partNumber, err))
 		}
 		completed = append(completed, types.CompletedPart{
 			ETag:       uploadOut.ETag,
@@ -87,7 +87,7 @@ func (c *Client) UploadMultipart(ctx context.Context, key string, body io.Reader
 		},
 	})
 	if err != nil {
-		return fmt.Errorf("complete multipart upload: %w", err)
+		return wrapError("CompleteMultipartUpload", key, err)
 	}
 	uploadID = nil
 	return nil
@@ -6,6 +6,7 @@ import (
 	"context"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 
@@ -54,15 +55,16 @@ func TestMinIO_ArchiveUploadListRestorePrune(t *testing.T) {
 
 	jobName := "it-job"
 	c := collector.NewFilesystemCollector(collector.PathsOpts{Include: []string{srcDir}})
-	stream, err := archiveEngine.Stream(ctx, c, jobName, archiveEngine.FormatGzip, 6)
+	stream, _, err := archiveEngine.Stream(ctx, c, jobName, archiveEngine.CompressionOptions{Format: archiveEngine.FormatGzip, Level: 6})
 	if err != nil {
 		t.Fatalf("Stream: %v", err)
 	}
 
-	archiveKey, backupID, err := archiveEngine.Upload(ctx, client, jobName, archiveEngine.FormatGzip, stream, archiveEngine.UploadOptions{PartSizeMB: 5})
+	up, err := archiveEngine.Upload(ctx, client, jobName, archiveEngine.FormatGzip, stream, archiveEngine.UploadOptions{PartSizeMB: 5})
 	if err != nil {
 		t.Fatalf("Upload: %v", err)
 	}
+	archiveKey, backupID := up.Key, up.BackupID
 	if archiveKey == "" || backupID == "" {
 		t.Fatal("Upload returned empty key or backupID")
 	}
@@ -71,9 +73,10 @@ func TestMinIO_ArchiveUploadListRestorePrune(t *testing.T) {
 	if host == "" {
 		host = "localhost"
 	}
-	if err := archiveEngine.WriteManifest(ctx, client, archiveEngine.Manifest{
+	manifest := archiveEngine.Manifest{
 		Job: jobName, Timestamp: backupID, Key: archiveKey, Host: host, Format: "tar.gz",
-	}); err != nil {
+	}
+	if err := archiveEngine.WriteManifest(ctx, client, manifest); err != nil {
 		t.Fatalf("WriteManifest: %v", err)
 	}
 	if err := archiveEngine.WriteLatest(ctx, client, jobName, backupID, archiveKey); err != nil {
@@ -96,7 +99,7 @@ func TestMinIO_ArchiveUploadListRestorePrune(t *testing.T) {
 	}
 
 	restoreDir := t.TempDir()
-	if err := restore.RestoreArchive(ctx, client, archiveKey, restoreDir, restore.ArchiveRestoreOptions{}); err != nil {
+	if err := restore.RestoreArchive(ctx, client, &manifest, restoreDir, restore.ArchiveRestoreOptions{}); err != nil {
 		t.Fatalf("RestoreArchive: %v", err)
 	}
 	helloPath := filepath.Join(restoreDir, filepath.Base(srcDir), "hello.txt")
@@ -116,6 +119,20 @@ func TestMinIO_ArchiveUploadListRestorePrune(t *testing.T) {
 		t.Errorf("restored nested.txt = %q, want nested", data2)
 	}
 
+	manifest.Size, manifest.SHA256 = up.Size, up.SHA256
+	rep, err := restore.VerifyArchive(ctx, client, &manifest, nil)
+	if err != nil {
+		t.Fatalf("VerifyArchive: %v", err)
+	}
+	if !rep.OK() || rep.FileCount != 2 {
+		t.Errorf("VerifyArchive = %+v, want OK with 2 files", rep)
+	}
+	tampered := manifest
+	tampered.SHA256 = strings.Repeat("0", 64)
+	if rep, err := restore.VerifyArchive(ctx, client, &tampered, nil); err != nil || rep.OK() {
+		t.Errorf("VerifyArchive with wrong checksum = %+v, %v; want a problem", rep, err)
+	}
+
 	oldTS := "20000101120000"
 	oldArchiveKey := s3.ArchiveObjectKey(jobName, "2000", "01", "01", "backup-old-20000101120000.tar.gz")
 	if err := archiveEngine.WriteManifest(ctx, client, archiveEngine.Manifest{
@@ -123,13 +140,12 @@ func TestMinIO_ArchiveUploadListRestorePrune(t *testing.T) {
 	}); err != nil {
 		t.Fatalf("WriteManifest old: %v", err)
 	}
-	now := time.Now().UTC()
-	retention := &config.RetentionConfig{Days: 7}
-	deleted, err := archiveEngine.ApplyRetention(ctx, client, jobName, retention, now)
+	retention := &config.RetentionConfig{KeepLast: 1}
+	res, err := archiveEngine.ApplyRetention(ctx, client, jobName, retention, archiveEngine.RetentionOptions{})
 	if err != nil {
 		t.Fatalf("ApplyRetention: %v", err)
 	}
-	if deleted < 1 {
-		t.Errorf("ApplyRetention: deleted = %d, want at least 1 (old manifest)", deleted)
+	if res.Deleted < 1 {
+		t.Errorf("ApplyRetention: deleted = %d, want at least 1 (old manifest)", res.Deleted)
 	}
 }
@@ -0,0 +1,70 @@
+//go:build integration
+
+package integration
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"VelBackuper/internal/lock"
+	"VelBackuper/internal/s3"
+)
+
+func TestMinIO_RepositoryLockExcludesJobLocks(t *testing.T) {
+	endpoint, accessKey, secretKey, bucket := getMinIOEnv()
+	prefix := "integration-test/lock-" + time.Now().Format("20060102150405")
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	client, err := s3.New(ctx, s3.Options{
+		Endpoint:           endpoint,
+		Region:             "us-east-1",
+		AccessKey:          accessKey,
+		SecretKey:          secretKey,
+		Bucket:             bucket,
+		Prefix:             prefix,
+		PathStyle:          true,
+		InsecureSkipVerify: true,
+	})
+	if err != nil {
+		t.Fatalf("s3.New: %v", err)
+	}
+	if err := client.CreateBucket(ctx); err != nil {
+		t.Fatalf("CreateBucket: %v", err)
+	}
+
+	job, err := lock.NewS3(lock.S3Options{Client: client, Name: "it-job", TTL: time.Minute})
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo, err := lock.NewS3(lock.S3Options{Client: client, TTL: time.Minute, Exclusive: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := job.Acquire(ctx); err != nil {
+		t.Fatalf("job Acquire: %v", err)
+	}
+	if err := repo.Acquire(ctx); err == nil {
+		t.Fatal("repository lock granted while a job lock is held")
+	}
+	if err := job.Release(ctx); err != nil {
+		t.Fatalf("job Release: %v", err)
+	}
+
+	if err := repo.Acquire(ctx); err != nil {
+		t.Fatalf("repository Acquire: %v", err)
+	}
+	if err := job.Acquire(ctx); err == nil {
+		t.Fatal("job lock granted while the repository lock is held")
+	}
+	if err := repo.Release(ctx); err != nil {
+		t.Fatalf("repository Release: %v", err)
+	}
+	if err := job.Acquire(ctx); err != nil {
+		t.Fatalf("job Acquire after repository release: %v", err)
+	}
+	_ = job.Release(ctx)
+}